@@ -1,3 +1,5 @@
 module github.com/tuomas-lb/emganography
 
 go 1.25.1
+
+require golang.org/x/image v0.25.0
@@ -1,5 +1,22 @@
 package bitstream
 
+import (
+	"math/bits"
+	"slices"
+)
+
+// BitOrder is the order a byte's bits are converted in
+type BitOrder uint8
+
+const (
+	// MSBFirst puts each byte's most significant bit first, as BytesToBits
+	// and BitsToBytes do and the framing and ECC layers use throughout
+	MSBFirst BitOrder = iota
+	// LSBFirst puts each byte's least significant bit first, as some
+	// external tools and reference ECC implementations expect
+	LSBFirst
+)
+
 // BytesToBits converts a byte slice to a boolean slice representing bits.
 // Each byte is converted to 8 bits, MSB first.
 func BytesToBits(data []byte) []bool {
@@ -8,10 +25,16 @@ func BytesToBits(data []byte) []bool {
 	}
 	bits := make([]bool, len(data)*8)
 	for i, b := range data {
-		offset := i * 8
-		for j := 0; j < 8; j++ {
-			bits[offset+j] = (b>>(7-j))&1 == 1
-		}
+		// Unpack a byte at a time; the 3-index slice drops the bounds checks
+		out := bits[i*8 : i*8+8 : i*8+8]
+		out[0] = b&0x80 != 0
+		out[1] = b&0x40 != 0
+		out[2] = b&0x20 != 0
+		out[3] = b&0x10 != 0
+		out[4] = b&0x08 != 0
+		out[5] = b&0x04 != 0
+		out[6] = b&0x02 != 0
+		out[7] = b&0x01 != 0
 	}
 	return bits
 }
@@ -22,16 +45,68 @@ func BitsToBytes(bits []bool) []byte {
 	if len(bits) == 0 {
 		return nil
 	}
-	byteCount := (len(bits) + 7) / 8
-	bytes := make([]byte, byteCount)
-	for i, bit := range bits {
-		if bit {
-			byteIdx := i / 8
-			bitIdx := 7 - (i % 8)
-			bytes[byteIdx] |= 1 << bitIdx
+	bytes := make([]byte, (len(bits)+7)/8)
+	BitsToBytesInto(bytes, bits)
+	return bytes
+}
+
+// BitsToBytesInto packs bits into dst like BitsToBytes, without allocating,
+// and returns the number of bytes written, (len(bits)+7)/8
+// It panics if dst is shorter than that, like hex.Encode
+func BitsToBytesInto(dst []byte, bits []bool) int {
+	n := (len(bits) + 7) / 8
+	_ = dst[:n]
+	full := len(bits) / 8
+	for i := 0; i < full; i++ {
+		in := bits[i*8 : i*8+8 : i*8+8]
+		dst[i] = bit(in[0])<<7 | bit(in[1])<<6 | bit(in[2])<<5 | bit(in[3])<<4 |
+			bit(in[4])<<3 | bit(in[5])<<2 | bit(in[6])<<1 | bit(in[7])
+	}
+	if full < n {
+		// Trailing partial byte, zero padded
+		var b byte
+		for j, v := range bits[full*8:] {
+			b |= bit(v) << (7 - j)
 		}
+		dst[full] = b
 	}
-	return bytes
+	return n
+}
+
+// BytesToBitsOrder is BytesToBits with the given bit order
+func BytesToBitsOrder(data []byte, order BitOrder) []bool {
+	out := BytesToBits(data)
+	if order == LSBFirst {
+		for i := 0; i < len(out); i += 8 {
+			slices.Reverse(out[i : i+8])
+		}
+	}
+	return out
+}
+
+// BitsToBytesOrder is BitsToBytes with the given bit order; trailing bits
+// fill the last byte in that order, the rest of it being zero
+func BitsToBytesOrder(bits []bool, order BitOrder) []byte {
+	out := BitsToBytes(bits)
+	if order == LSBFirst {
+		reverseBits(out)
+	}
+	return out
+}
+
+// reverseBits reverses the bit order of each byte of data in place
+func reverseBits(data []byte) {
+	for i, b := range data {
+		data[i] = bits.Reverse8(b)
+	}
+}
+
+// bit returns 1 for true and 0 for false
+func bit(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
 }
 
 
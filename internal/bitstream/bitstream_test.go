@@ -1,6 +1,8 @@
 package bitstream
 
 import (
+	"bytes"
+	"math/rand/v2"
 	"reflect"
 	"testing"
 )
@@ -108,3 +110,176 @@ func TestRoundTrip(t *testing.T) {
 
 
 
+
+func TestByteIterator(t *testing.T) {
+	data := []byte{0xA5, 0x3C, 0xFF}
+	if got := Collect(NewByteIterator(data)); !reflect.DeepEqual(got, BytesToBits(data)) {
+		t.Errorf("ByteIterator: expected %v, got %v", BytesToBits(data), got)
+	}
+	if got := Collect(NewByteIterator(nil)); got != nil {
+		t.Errorf("expected no bits from empty data, got %v", got)
+	}
+}
+
+func TestConcat(t *testing.T) {
+	head := []bool{true, false, true}
+	tail := []byte{0x81}
+	it := Concat(NewSliceIterator(head), NewSliceIterator(nil), NewByteIterator(tail))
+
+	expected := append(append([]bool{}, head...), BytesToBits(tail)...)
+	if got := Collect(it); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	if _, ok := it.NextBit(); ok {
+		t.Error("expected the iterator to stay exhausted")
+	}
+}
+
+// referenceBytesToBits is the bit-at-a-time BytesToBits the unrolled one
+// replaced, kept to check and benchmark it against
+func referenceBytesToBits(data []byte) []bool {
+	bits := make([]bool, len(data)*8)
+	for i, b := range data {
+		for j := 0; j < 8; j++ {
+			bits[i*8+j] = (b>>(7-j))&1 == 1
+		}
+	}
+	return bits
+}
+
+// referenceBitsToBytes is the bit-at-a-time BitsToBytes the byte-at-a-time
+// one replaced
+func referenceBitsToBytes(bits []bool) []byte {
+	bytes := make([]byte, (len(bits)+7)/8)
+	for i, v := range bits {
+		if v {
+			bytes[i/8] |= 1 << (7 - i%8)
+		}
+	}
+	return bytes
+}
+
+func TestConversions_MatchReference(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	for n := 1; n < 80; n++ {
+		bits := make([]bool, n)
+		for i := range bits {
+			bits[i] = rng.IntN(2) == 1
+		}
+		want := referenceBitsToBytes(bits)
+		if got := BitsToBytes(bits); !bytes.Equal(got, want) {
+			t.Fatalf("BitsToBytes of %d bits: expected %x, got %x", n, want, got)
+		}
+
+		// Into a larger, dirty buffer: only the packed bytes are written
+		dst := bytes.Repeat([]byte{0xAA}, len(want)+2)
+		if written := BitsToBytesInto(dst, bits); written != len(want) {
+			t.Fatalf("BitsToBytesInto of %d bits wrote %d bytes, expected %d", n, written, len(want))
+		}
+		if !bytes.Equal(dst[:len(want)], want) || !bytes.Equal(dst[len(want):], []byte{0xAA, 0xAA}) {
+			t.Fatalf("BitsToBytesInto of %d bits: got %x", n, dst)
+		}
+
+		data := want
+		if got := BytesToBits(data); !reflect.DeepEqual(got, referenceBytesToBits(data)) {
+			t.Fatalf("BytesToBits of %x differs from the reference", data)
+		}
+	}
+}
+
+func TestBitsToBytesInto_ShortBuffer(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a buffer too short for the bits")
+		}
+	}()
+	BitsToBytesInto(make([]byte, 1), make([]bool, 9))
+}
+
+// benchmarkPayload is a 64 KiB payload and its bits, for the conversion
+// benchmarks (run with -benchmem to see the allocations)
+func benchmarkPayload() ([]byte, []bool) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	data := make([]byte, 64<<10)
+	for i := range data {
+		data[i] = uint8(rng.IntN(256))
+	}
+	return data, referenceBytesToBits(data)
+}
+
+func BenchmarkBytesToBits(b *testing.B) {
+	data, _ := benchmarkPayload()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BytesToBits(data)
+	}
+}
+
+func BenchmarkBytesToBits_Reference(b *testing.B) {
+	data, _ := benchmarkPayload()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		referenceBytesToBits(data)
+	}
+}
+
+func BenchmarkBitsToBytes(b *testing.B) {
+	data, bits := benchmarkPayload()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BitsToBytes(bits)
+	}
+}
+
+func BenchmarkBitsToBytesInto(b *testing.B) {
+	data, bits := benchmarkPayload()
+	dst := make([]byte, len(data))
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		BitsToBytesInto(dst, bits)
+	}
+}
+
+func BenchmarkBitsToBytes_Reference(b *testing.B) {
+	data, bits := benchmarkPayload()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		referenceBitsToBytes(bits)
+	}
+}
+
+func TestBitOrder(t *testing.T) {
+	msb := []bool{true, false, false, false, false, false, false, false}
+	lsb := []bool{false, false, false, false, false, false, false, true}
+	if got := BytesToBitsOrder([]byte{0x80}, MSBFirst); !reflect.DeepEqual(got, msb) {
+		t.Errorf("MSBFirst 0x80: expected %v, got %v", msb, got)
+	}
+	if got := BytesToBitsOrder([]byte{0x80}, LSBFirst); !reflect.DeepEqual(got, lsb) {
+		t.Errorf("LSBFirst 0x80: expected %v, got %v", lsb, got)
+	}
+	// A partial byte fills from its first bit in either order
+	if got := BitsToBytesOrder([]bool{true}, MSBFirst); !bytes.Equal(got, []byte{0x80}) {
+		t.Errorf("MSBFirst single bit: expected 80, got %x", got)
+	}
+	if got := BitsToBytesOrder([]bool{true}, LSBFirst); !bytes.Equal(got, []byte{0x01}) {
+		t.Errorf("LSBFirst single bit: expected 01, got %x", got)
+	}
+
+	data := []byte{0x12, 0x80, 0x01, 0xA5, 0xFF, 0x00}
+	for _, order := range []BitOrder{MSBFirst, LSBFirst} {
+		if got := BitsToBytesOrder(BytesToBitsOrder(data, order), order); !bytes.Equal(got, data) {
+			t.Errorf("order %d round trip: expected %x, got %x", order, data, got)
+		}
+	}
+	if got := BytesToBitsOrder(data, MSBFirst); !reflect.DeepEqual(got, BytesToBits(data)) {
+		t.Error("MSBFirst differs from BytesToBits")
+	}
+	if got := BytesToBitsOrder(nil, LSBFirst); got != nil {
+		t.Errorf("expected no bits from empty data, got %v", got)
+	}
+}
@@ -0,0 +1,84 @@
+package bitstream
+
+// Iterator yields a bit sequence one bit at a time, so a consumer can pull
+// bits on demand instead of materializing them all in a []bool
+type Iterator interface {
+	// NextBit returns the next bit, with ok false once the sequence is exhausted
+	NextBit() (bit bool, ok bool)
+}
+
+// SliceIterator iterates over the bits of a []bool
+type SliceIterator struct {
+	bits []bool
+	pos  int
+}
+
+// NewSliceIterator returns an Iterator over bits
+func NewSliceIterator(bits []bool) *SliceIterator {
+	return &SliceIterator{bits: bits}
+}
+
+// NextBit returns the next bit of the slice
+func (s *SliceIterator) NextBit() (bool, bool) {
+	if s.pos >= len(s.bits) {
+		return false, false
+	}
+	s.pos++
+	return s.bits[s.pos-1], true
+}
+
+// ByteIterator iterates over the bits of a byte slice, MSB first, yielding
+// the same sequence as BytesToBits
+type ByteIterator struct {
+	data []byte
+	pos  int
+}
+
+// NewByteIterator returns an Iterator over the bits of data
+func NewByteIterator(data []byte) *ByteIterator {
+	return &ByteIterator{data: data}
+}
+
+// NextBit returns the next bit of the data
+func (b *ByteIterator) NextBit() (bool, bool) {
+	if b.pos >= len(b.data)*8 {
+		return false, false
+	}
+	bit := (b.data[b.pos/8]>>(7-b.pos%8))&1 == 1
+	b.pos++
+	return bit, true
+}
+
+// Concat returns an Iterator yielding the bits of each iterator in turn
+func Concat(iterators ...Iterator) Iterator {
+	return &concatIterator{iterators: iterators}
+}
+
+// concatIterator implements Concat
+type concatIterator struct {
+	iterators []Iterator
+}
+
+// NextBit returns the next bit of the current iterator, moving on to the
+// next one when it's exhausted
+func (c *concatIterator) NextBit() (bool, bool) {
+	for len(c.iterators) > 0 {
+		if bit, ok := c.iterators[0].NextBit(); ok {
+			return bit, true
+		}
+		c.iterators = c.iterators[1:]
+	}
+	return false, false
+}
+
+// Collect drains it into a slice
+func Collect(it Iterator) []bool {
+	var bits []bool
+	for {
+		bit, ok := it.NextBit()
+		if !ok {
+			return bits
+		}
+		bits = append(bits, bit)
+	}
+}
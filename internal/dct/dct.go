@@ -1,59 +1,81 @@
 package dct
 
-import "math"
+import (
+	"math"
+	"sync"
+)
 
-// Precomputed cosine table for 8x8 DCT
-// cosTable[i][j] = cos((2*i+1)*j*pi/16) for i,j in [0,7]
-var cosTable [8][8]float64
+// basisTable holds the normalized cosine basis for one block size, built
+// on first use
+// cos[i*n+j] = C(j) * cos((2*i+1)*j*pi/(2*n)) for i,j in [0,n-1]
+// where C(0) = sqrt(1/n) and C(j) = sqrt(2/n) for j>0, so the transform
+// loops below are pure multiply-accumulate
+type basisTable struct {
+	once sync.Once
+	cos  []float64
+}
 
-func init() {
-	// Precompute cosine values for 8x8 DCT
-	for i := 0; i < 8; i++ {
-		for j := 0; j < 8; j++ {
-			cosTable[i][j] = math.Cos(float64(2*i+1) * float64(j) * math.Pi / 16.0)
-		}
+// bases caches a *basisTable per block size, so tables for different sizes
+// coexist and each is built once even under concurrent first use
+var bases sync.Map
+
+// basis returns the normalized cosine basis for n x n blocks
+func basis(n int) []float64 {
+	t, ok := bases.Load(n)
+	if !ok {
+		t, _ = bases.LoadOrStore(n, new(basisTable))
 	}
+	table := t.(*basisTable)
+	table.once.Do(func() {
+		table.cos = make([]float64, n*n)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				c := math.Sqrt(2.0 / float64(n))
+				if j == 0 {
+					c = math.Sqrt(1.0 / float64(n))
+				}
+				table.cos[i*n+j] = c * math.Cos(float64(2*i+1)*float64(j)*math.Pi/float64(2*n))
+			}
+		}
+	})
+	return table.cos
 }
 
+// cosTable8 returns the N=8 basis as an array, letting DCT8x8 and IDCT8x8
+// index it without bounds checks
+var cosTable8 = sync.OnceValue(func() *[64]float64 {
+	return (*[64]float64)(basis(8))
+})
+
 // DCT8x8 performs a 2D DCT on an 8x8 block
 // Uses DCT-II formula: X[k] = C(k) * sum(n=0 to N-1) x[n] * cos((2n+1)kπ/(2N))
 // where C(0) = sqrt(1/N), C(k) = sqrt(2/N) for k>0
 // src and dst are 64-element arrays representing 8x8 blocks in row-major order
 func DCT8x8(src *[64]float64, dst *[64]float64) {
+	cosTable := cosTable8()
+
 	// First, apply 1D DCT to each row
 	var temp [64]float64
 	for row := 0; row < 8; row++ {
 		for freq := 0; freq < 8; freq++ {
 			sum := 0.0
 			for col := 0; col < 8; col++ {
-				sum += src[row*8+col] * cosTable[col][freq]
+				sum += src[row*8+col] * cosTable[col*8+freq]
 			}
-			// Normalization: C(freq) = sqrt(1/8) for freq=0, sqrt(2/8) for freq>0
-			c := math.Sqrt(2.0 / 8.0) // = 0.5
-			if freq == 0 {
-				c = math.Sqrt(1.0 / 8.0) // = 1/(2*sqrt(2)) ≈ 0.353553
-			}
-			temp[row*8+freq] = c * sum
+			temp[row*8+freq] = sum
 		}
 	}
 
 	// Then, apply 1D DCT to each column (transform along rows for each column frequency)
 	// temp[row*8+colFreq] contains row DCT results
-	// We now transform along the row dimension for each column frequency
 	for colFreq := 0; colFreq < 8; colFreq++ {
 		for rowFreq := 0; rowFreq < 8; rowFreq++ {
 			sum := 0.0
 			for row := 0; row < 8; row++ {
-				// temp[row*8+colFreq] is the value at row, column frequency colFreq
-				sum += temp[row*8+colFreq] * cosTable[row][rowFreq]
-			}
-			// Normalization: C(rowFreq) = sqrt(1/8) for rowFreq=0, sqrt(2/8) for rowFreq>0
-			c := math.Sqrt(2.0 / 8.0) // = 0.5
-			if rowFreq == 0 {
-				c = math.Sqrt(1.0 / 8.0) // = 1/(2*sqrt(2)) ≈ 0.353553
+				sum += temp[row*8+colFreq] * cosTable[row*8+rowFreq]
 			}
 			// Output: dst[rowFreq*8+colFreq] - row frequency first, then column frequency
-			dst[rowFreq*8+colFreq] = c * sum
+			dst[rowFreq*8+colFreq] = sum
 		}
 	}
 }
@@ -66,18 +88,13 @@ func DCT8x8(src *[64]float64, dst *[64]float64) {
 func IDCT8x8(src *[64]float64, dst *[64]float64) {
 	// First, apply 1D IDCT along columns (inverse transform along column frequency dimension)
 	// This transforms each column from frequency domain back to spatial domain
+	cosTable := cosTable8()
 	var temp [64]float64
 	for colFreq := 0; colFreq < 8; colFreq++ {
 		for row := 0; row < 8; row++ {
 			sum := 0.0
 			for rowFreq := 0; rowFreq < 8; rowFreq++ {
-				// src[rowFreq*8+colFreq] - DCT coefficient at (rowFreq, colFreq)
-				// Normalization: C(rowFreq) = sqrt(1/8) for rowFreq=0, sqrt(2/8) for rowFreq>0
-				c := math.Sqrt(2.0 / 8.0) // = 0.5
-				if rowFreq == 0 {
-					c = math.Sqrt(1.0 / 8.0) // = 1/(2*sqrt(2)) ≈ 0.353553
-				}
-				sum += c * src[rowFreq*8+colFreq] * cosTable[row][rowFreq]
+				sum += src[rowFreq*8+colFreq] * cosTable[row*8+rowFreq]
 			}
 			temp[row*8+colFreq] = sum
 		}
@@ -89,15 +106,71 @@ func IDCT8x8(src *[64]float64, dst *[64]float64) {
 		for col := 0; col < 8; col++ {
 			sum := 0.0
 			for colFreq := 0; colFreq < 8; colFreq++ {
-				// temp[row*8+colFreq] contains intermediate results
-				// Normalization: C(colFreq) = sqrt(1/8) for colFreq=0, sqrt(2/8) for colFreq>0
-				c := math.Sqrt(2.0 / 8.0) // = 0.5
-				if colFreq == 0 {
-					c = math.Sqrt(1.0 / 8.0) // = 1/(2*sqrt(2)) ≈ 0.353553
-				}
-				sum += c * temp[row*8+colFreq] * cosTable[col][colFreq]
+				sum += temp[row*8+colFreq] * cosTable[col*8+colFreq]
 			}
 			dst[row*8+col] = sum
 		}
 	}
 }
+
+// DCTNxN performs a 2D DCT on an n x n block, like DCT8x8 for any n
+// src and dst are n*n-element slices in row-major order; 8x8 blocks use DCT8x8
+func DCTNxN(n int, src, dst []float64) {
+	if n == 8 {
+		DCT8x8((*[64]float64)(src), (*[64]float64)(dst))
+		return
+	}
+
+	cos := basis(n)
+	temp := make([]float64, n*n)
+	for row := 0; row < n; row++ {
+		for freq := 0; freq < n; freq++ {
+			sum := 0.0
+			for col := 0; col < n; col++ {
+				sum += src[row*n+col] * cos[col*n+freq]
+			}
+			temp[row*n+freq] = sum
+		}
+	}
+
+	for colFreq := 0; colFreq < n; colFreq++ {
+		for rowFreq := 0; rowFreq < n; rowFreq++ {
+			sum := 0.0
+			for row := 0; row < n; row++ {
+				sum += temp[row*n+colFreq] * cos[row*n+rowFreq]
+			}
+			dst[rowFreq*n+colFreq] = sum
+		}
+	}
+}
+
+// IDCTNxN performs a 2D inverse DCT on an n x n block, like IDCT8x8 for any n
+// src and dst are n*n-element slices in row-major order; 8x8 blocks use IDCT8x8
+func IDCTNxN(n int, src, dst []float64) {
+	if n == 8 {
+		IDCT8x8((*[64]float64)(src), (*[64]float64)(dst))
+		return
+	}
+
+	cos := basis(n)
+	temp := make([]float64, n*n)
+	for colFreq := 0; colFreq < n; colFreq++ {
+		for row := 0; row < n; row++ {
+			sum := 0.0
+			for rowFreq := 0; rowFreq < n; rowFreq++ {
+				sum += src[rowFreq*n+colFreq] * cos[row*n+rowFreq]
+			}
+			temp[row*n+colFreq] = sum
+		}
+	}
+
+	for row := 0; row < n; row++ {
+		for col := 0; col < n; col++ {
+			sum := 0.0
+			for colFreq := 0; colFreq < n; colFreq++ {
+				sum += temp[row*n+colFreq] * cos[col*n+colFreq]
+			}
+			dst[row*n+col] = sum
+		}
+	}
+}
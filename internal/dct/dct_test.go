@@ -0,0 +1,239 @@
+package dct
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// referenceDCT8x8 is a direct (unfactored) 2D DCT-II used to check DCT8x8
+func referenceDCT8x8(src *[64]float64, dst *[64]float64) {
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			sum := 0.0
+			for y := 0; y < 8; y++ {
+				for x := 0; x < 8; x++ {
+					sum += src[y*8+x] *
+						math.Cos(float64(2*y+1)*float64(u)*math.Pi/16.0) *
+						math.Cos(float64(2*x+1)*float64(v)*math.Pi/16.0)
+				}
+			}
+			cu, cv := math.Sqrt(2.0/8.0), math.Sqrt(2.0/8.0)
+			if u == 0 {
+				cu = math.Sqrt(1.0 / 8.0)
+			}
+			if v == 0 {
+				cv = math.Sqrt(1.0 / 8.0)
+			}
+			dst[u*8+v] = cu * cv * sum
+		}
+	}
+}
+
+// randomBlock returns a block of pixel values centered around zero
+func randomBlock(r *rand.Rand) [64]float64 {
+	var block [64]float64
+	for i := range block {
+		block[i] = r.Float64()*255 - 128
+	}
+	return block
+}
+
+func TestDCT8x8_MatchesReference(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20; i++ {
+		src := randomBlock(r)
+		var got, want [64]float64
+		DCT8x8(&src, &got)
+		referenceDCT8x8(&src, &want)
+
+		for j := range got {
+			if math.Abs(got[j]-want[j]) > 1e-9 {
+				t.Fatalf("coefficient %d: expected %f, got %f", j, want[j], got[j])
+			}
+		}
+	}
+}
+
+func TestDCT8x8_RoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 20; i++ {
+		src := randomBlock(r)
+		var coeffs, back [64]float64
+		DCT8x8(&src, &coeffs)
+		IDCT8x8(&coeffs, &back)
+
+		for j := range src {
+			if math.Abs(src[j]-back[j]) > 1e-9 {
+				t.Fatalf("sample %d: expected %f, got %f", j, src[j], back[j])
+			}
+		}
+	}
+}
+
+// referenceDCTNxN is a direct 2D DCT-II for n x n blocks used to check DCTNxN
+func referenceDCTNxN(n int, src, dst []float64) {
+	scale := func(k int) float64 {
+		if k == 0 {
+			return math.Sqrt(1.0 / float64(n))
+		}
+		return math.Sqrt(2.0 / float64(n))
+	}
+	for u := 0; u < n; u++ {
+		for v := 0; v < n; v++ {
+			sum := 0.0
+			for y := 0; y < n; y++ {
+				for x := 0; x < n; x++ {
+					sum += src[y*n+x] *
+						math.Cos(float64(2*y+1)*float64(u)*math.Pi/float64(2*n)) *
+						math.Cos(float64(2*x+1)*float64(v)*math.Pi/float64(2*n))
+				}
+			}
+			dst[u*n+v] = scale(u) * scale(v) * sum
+		}
+	}
+}
+
+func TestDCTNxN_MatchesReferenceAndRoundTrips(t *testing.T) {
+	r := rand.New(rand.NewSource(5))
+	for _, n := range []int{4, 8, 16} {
+		src := make([]float64, n*n)
+		for i := range src {
+			src[i] = r.Float64()*255 - 128
+		}
+		got := make([]float64, n*n)
+		want := make([]float64, n*n)
+		back := make([]float64, n*n)
+		DCTNxN(n, src, got)
+		referenceDCTNxN(n, src, want)
+		IDCTNxN(n, got, back)
+
+		for j := range src {
+			if math.Abs(got[j]-want[j]) > 1e-9 {
+				t.Fatalf("n=%d coefficient %d: expected %f, got %f", n, j, want[j], got[j])
+			}
+			if math.Abs(src[j]-back[j]) > 1e-9 {
+				t.Fatalf("n=%d sample %d: expected %f, got %f", n, j, src[j], back[j])
+			}
+		}
+	}
+}
+
+func TestBasis_IndependentPerBlockSize(t *testing.T) {
+	// Build the tables for several sizes concurrently, each goroutine
+	// round-tripping a block through its own size
+	sizes := []int{2, 4, 8, 12, 16, 32}
+	var wg sync.WaitGroup
+	errs := make([]error, len(sizes))
+	for i, n := range sizes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(n)))
+			src := make([]float64, n*n)
+			for j := range src {
+				src[j] = r.Float64()*255 - 128
+			}
+			coeffs := make([]float64, n*n)
+			back := make([]float64, n*n)
+			DCTNxN(n, src, coeffs)
+			IDCTNxN(n, coeffs, back)
+			for j := range src {
+				if math.Abs(src[j]-back[j]) > 1e-9 {
+					errs[i] = fmt.Errorf("n=%d sample %d: expected %f, got %f", n, j, src[j], back[j])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, n := range sizes {
+		cos := basis(n)
+		if len(cos) != n*n {
+			t.Fatalf("n=%d: expected %d basis entries, got %d", n, n*n, len(cos))
+		}
+		// Rows of the orthonormal basis are orthonormal
+		for a := 0; a < n; a++ {
+			for b := 0; b < n; b++ {
+				dot := 0.0
+				for j := 0; j < n; j++ {
+					dot += cos[a*n+j] * cos[b*n+j]
+				}
+				want := 0.0
+				if a == b {
+					want = 1
+				}
+				if math.Abs(dot-want) > 1e-12 {
+					t.Fatalf("n=%d rows %d and %d: expected dot product %g, got %g", n, a, b, want, dot)
+				}
+			}
+		}
+	}
+	if &basis(8)[0] != &cosTable8()[0] {
+		t.Error("expected DCT8x8 to use the cached N=8 basis")
+	}
+}
+
+func BenchmarkDCT8x8(b *testing.B) {
+	src := randomBlock(rand.New(rand.NewSource(3)))
+	var dst [64]float64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		DCT8x8(&src, &dst)
+	}
+}
+
+func BenchmarkDCT8x8_Reference(b *testing.B) {
+	src := randomBlock(rand.New(rand.NewSource(3)))
+	var dst [64]float64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		referenceDCT8x8(&src, &dst)
+	}
+}
+
+func BenchmarkIDCT8x8(b *testing.B) {
+	src := randomBlock(rand.New(rand.NewSource(4)))
+	var dst [64]float64
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		IDCT8x8(&src, &dst)
+	}
+}
+
+func TestZigZagOrder_MatchesJPEG(t *testing.T) {
+	// Scan position of each coefficient in natural order (T.81 Figure A.6)
+	scanPosition := [64]int{
+		0, 1, 5, 6, 14, 15, 27, 28,
+		2, 4, 7, 13, 16, 26, 29, 42,
+		3, 8, 12, 17, 25, 30, 41, 43,
+		9, 11, 18, 24, 31, 40, 44, 53,
+		10, 19, 23, 32, 39, 45, 52, 54,
+		20, 22, 33, 38, 46, 51, 55, 60,
+		21, 34, 37, 47, 50, 56, 59, 61,
+		35, 36, 48, 49, 57, 58, 62, 63,
+	}
+	order := ZigZagOrder()
+	for natural, scan := range scanPosition {
+		if order[scan] != natural {
+			t.Errorf("scan position %d: expected index %d, got %d", scan, natural, order[scan])
+		}
+		row, col := ZigZagIndex(scan)
+		if row != natural/8 || col != natural%8 {
+			t.Errorf("ZigZagIndex(%d): expected (%d,%d), got (%d,%d)", scan, natural/8, natural%8, row, col)
+		}
+	}
+
+	// The third AC coefficient is the first of the third row
+	if row, col := ZigZagIndex(3); row != 2 || col != 0 {
+		t.Errorf("ZigZagIndex(3): expected (2,0), got (%d,%d)", row, col)
+	}
+}
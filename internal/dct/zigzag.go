@@ -0,0 +1,28 @@
+package dct
+
+// zigZag maps JPEG zig-zag scan positions to natural (row-major) indices of
+// an 8x8 block (ITU T.81 Figure A.6)
+var zigZag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// ZigZagOrder returns the JPEG zig-zag scan order of an 8x8 block: element
+// i is the row-major index (row*8+col) of the i-th coefficient scanned, so
+// element 0 is the DC coefficient and element 3 the third AC coefficient
+func ZigZagOrder() [64]int {
+	return zigZag
+}
+
+// ZigZagIndex returns the (row, col) position of the coefficient at zig-zag
+// scan position i (0-63) of an 8x8 block
+func ZigZagIndex(i int) (row, col int) {
+	k := zigZag[i]
+	return k / 8, k % 8
+}
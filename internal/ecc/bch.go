@@ -0,0 +1,118 @@
+package ecc
+
+import (
+	"github.com/tuomas-lb/emganography/internal/bitstream"
+)
+
+const (
+	// bchN is the BCH(15,7) codeword length in bits
+	bchN = 15
+	// bchK is the number of data bits per BCH(15,7) codeword
+	bchK = 7
+	// bchGenerator is the generator polynomial x^8 + x^7 + x^6 + x^4 + 1,
+	// the product of the minimal polynomials of α and α^3 in GF(16)
+	bchGenerator = 0x1D1
+)
+
+// bchCorrections maps each syndrome of BCH(15,7) to the error pattern of
+// weight 2 or less producing it; the code's minimum distance of 5 makes
+// those syndromes unique
+var bchCorrections = func() map[uint16]uint16 {
+	table := make(map[uint16]uint16, 1+bchN+bchN*(bchN-1)/2)
+	table[0] = 0
+	for i := 0; i < bchN; i++ {
+		table[bchRemainder(1<<i)] = 1 << i
+		for j := i + 1; j < bchN; j++ {
+			table[bchRemainder(1<<i|1<<j)] = 1<<i | 1<<j
+		}
+	}
+	return table
+}()
+
+// BCH157 implements the BCH(15,7) code: each group of 7 data bits is encoded
+// as a 15-bit systematic codeword, and decoding corrects up to two bit errors
+// per codeword, at a rate (7/15) between repetition-3 and Hamming(7,4)
+// Codeword layout (MSB first): d1..d7 followed by the 8 parity bits
+type BCH157 struct{}
+
+// bchRemainder returns the remainder of the polynomial v (bit i is the
+// coefficient of x^i) divided by the generator
+func bchRemainder(v uint16) uint16 {
+	for bit := bchN - 1; bit >= bchN-bchK; bit-- {
+		if v&(1<<bit) != 0 {
+			v ^= bchGenerator << (bit - (bchN - bchK))
+		}
+	}
+	return v
+}
+
+// ExpansionBits returns the encoded length of dataBits: a 15-bit codeword per
+// group of 7, the last zero-padded
+func (b *BCH157) ExpansionBits(dataBits int) int {
+	return (dataBits + bchK - 1) / bchK * bchN
+}
+
+// EncodeFrame encodes a frame into a bitstream using BCH(15,7)
+// If the bit count isn't a multiple of 7, the final group is zero-padded
+func (b *BCH157) EncodeFrame(frame []byte) ([]bool, error) {
+	dataBits := bitstream.BytesToBits(frame)
+
+	groupCount := (len(dataBits) + bchK - 1) / bchK
+	encodedBits := make([]bool, 0, groupCount*bchN)
+	for g := 0; g < groupCount; g++ {
+		// Gather the group, zero-padding past the end of the data
+		var data uint16
+		for i := 0; i < bchK; i++ {
+			data <<= 1
+			if idx := g*bchK + i; idx < len(dataBits) && dataBits[idx] {
+				data |= 1
+			}
+		}
+
+		// Systematic: data * x^8 plus the remainder, a multiple of the generator
+		shifted := data << (bchN - bchK)
+		codeword := shifted | bchRemainder(shifted)
+		for bit := bchN - 1; bit >= 0; bit-- {
+			encodedBits = append(encodedBits, codeword&(1<<bit) != 0)
+		}
+	}
+
+	return encodedBits, nil
+}
+
+// DecodeFrame decodes a BCH(15,7) bitstream, correcting up to two bit errors
+// per codeword by syndrome lookup; codewords with more errors are passed
+// through uncorrected (the frame CRC catches them)
+// Trailing bits that don't form a complete codeword are ignored, and the
+// decoded data is trimmed to whole bytes so zero padding is dropped
+func (b *BCH157) DecodeFrame(bits []bool) ([]byte, error) {
+	codewordCount := len(bits) / bchN
+	if codewordCount == 0 {
+		return nil, ErrInsufficientBits
+	}
+
+	decodedBits := make([]bool, 0, codewordCount*bchK)
+	for i := 0; i < codewordCount; i++ {
+		var codeword uint16
+		for _, bit := range bits[i*bchN : (i+1)*bchN] {
+			codeword <<= 1
+			if bit {
+				codeword |= 1
+			}
+		}
+
+		// The syndrome is the remainder, zero for a valid codeword
+		codeword ^= bchCorrections[bchRemainder(codeword)]
+
+		for bit := bchN - 1; bit >= bchN-bchK; bit-- {
+			decodedBits = append(decodedBits, codeword&(1<<bit) != 0)
+		}
+	}
+
+	// Trim padding so only whole bytes remain
+	byteCount := len(decodedBits) / 8
+	if byteCount == 0 {
+		return nil, ErrInsufficientBits
+	}
+	return bitstream.BitsToBytes(decodedBits[:byteCount*8]), nil
+}
@@ -0,0 +1,99 @@
+package ecc
+
+import (
+	"math/rand/v2"
+	"reflect"
+	"testing"
+)
+
+func TestBCH157_EncodeDecode(t *testing.T) {
+	b := &BCH157{}
+
+	original := []byte{0x12, 0x34, 0xAB}
+	encoded, err := b.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// 24 data bits = 4 groups of 7 (last zero-padded) = 60 code bits
+	if len(encoded) != 60 {
+		t.Errorf("expected encoded length 60, got %d", len(encoded))
+	}
+
+	decoded, err := b.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("round trip failed: expected %v, got %v", original, decoded)
+	}
+}
+
+func TestBCH157_CodewordsAreGeneratorMultiples(t *testing.T) {
+	for data := uint16(0); data < 1<<bchK; data++ {
+		shifted := data << (bchN - bchK)
+		if codeword := shifted | bchRemainder(shifted); bchRemainder(codeword) != 0 {
+			t.Errorf("data %#x: codeword %#x has nonzero syndrome", data, codeword)
+		}
+	}
+	// Every pattern of weight <= 2 has its own syndrome
+	if want := 1 + bchN + bchN*(bchN-1)/2; len(bchCorrections) != want {
+		t.Errorf("expected %d distinct syndromes, got %d", want, len(bchCorrections))
+	}
+}
+
+func TestBCH157_TwoErrorsPerCodeword(t *testing.T) {
+	b := &BCH157{}
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	original := make([]byte, 64)
+	for i := range original {
+		original[i] = byte(rng.IntN(256))
+	}
+	encoded, err := b.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	for trial := 0; trial < 100; trial++ {
+		corrupted := make([]bool, len(encoded))
+		copy(corrupted, encoded)
+		for block := 0; block < len(corrupted)/bchN; block++ {
+			first := rng.IntN(bchN)
+			second := (first + 1 + rng.IntN(bchN-1)) % bchN
+			corrupted[block*bchN+first] = !corrupted[block*bchN+first]
+			corrupted[block*bchN+second] = !corrupted[block*bchN+second]
+		}
+
+		decoded, err := b.DecodeFrame(corrupted)
+		if err != nil {
+			t.Fatalf("DecodeFrame failed: %v", err)
+		}
+		if !reflect.DeepEqual(original, decoded) {
+			t.Fatalf("trial %d: error correction failed", trial)
+		}
+	}
+}
+
+func TestBCH157_TrimsTrailingBits(t *testing.T) {
+	b := &BCH157{}
+
+	original := []byte{0xC3}
+	encoded, err := b.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// A partial trailing codeword is ignored
+	decoded, err := b.DecodeFrame(append(encoded, true, false, true))
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("expected %v, got %v", original, decoded)
+	}
+
+	if _, err := b.DecodeFrame(encoded[:bchN-1]); err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits, got %v", err)
+	}
+}
@@ -1,6 +1,12 @@
 package ecc
 
-import "errors"
+import (
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/tuomas-lb/emganography/internal/bitstream"
+)
 
 // Scheme represents an error correction code scheme
 type Scheme interface {
@@ -11,12 +17,94 @@ type Scheme interface {
 	DecodeFrame(bits []bool) ([]byte, error)
 }
 
+// SoftScheme is optionally implemented by a Scheme that can use soft
+// decisions: one value per bit whose sign is the bit (positive means 1) and
+// whose magnitude is the confidence in it
+type SoftScheme interface {
+	// DecodeFrameSoft decodes soft bit values into a frame (header + payload)
+	DecodeFrameSoft(soft []float64) ([]byte, error)
+}
+
+// StreamScheme is optionally implemented by a Scheme that can encode a frame
+// lazily, yielding the same bits as EncodeFrame without materializing them
+type StreamScheme interface {
+	// EncodeFrameStream returns an iterator over the encoded bits of frame
+	// and the number of bits it yields
+	EncodeFrameStream(frame []byte) (bitstream.Iterator, int, error)
+}
+
+// ExpansionScheme is optionally implemented by a Scheme that can report its
+// encoded length without encoding anything
+type ExpansionScheme interface {
+	// ExpansionBits returns the number of bits EncodeFrame produces for a
+	// frame of dataBits bits (a multiple of 8), including any padding
+	ExpansionBits(dataBits int) int
+}
+
+// EncodedBits returns the number of bits scheme encodes a frame of
+// frameBytes bytes into, using its ExpansionBits if it implements
+// ExpansionScheme and encoding a zero frame of that size otherwise
+func EncodedBits(scheme Scheme, frameBytes int) (int, error) {
+	if s, ok := scheme.(ExpansionScheme); ok {
+		return s.ExpansionBits(frameBytes * 8), nil
+	}
+	_, count, err := EncodeStream(scheme, make([]byte, frameBytes))
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// EncodeStream encodes frame with scheme as an iterator, using its
+// EncodeFrameStream if it implements StreamScheme and EncodeFrame otherwise
+// Returns the iterator and the number of bits it yields
+func EncodeStream(scheme Scheme, frame []byte) (bitstream.Iterator, int, error) {
+	if s, ok := scheme.(StreamScheme); ok {
+		return s.EncodeFrameStream(frame)
+	}
+	bits, err := scheme.EncodeFrame(frame)
+	if err != nil {
+		return nil, 0, err
+	}
+	return bitstream.NewSliceIterator(bits), len(bits), nil
+}
+
+// DecodeSoft decodes soft bit values with scheme, using its DecodeFrameSoft
+// if it implements SoftScheme and thresholding at zero otherwise
+func DecodeSoft(scheme Scheme, soft []float64) ([]byte, error) {
+	if s, ok := scheme.(SoftScheme); ok {
+		return s.DecodeFrameSoft(soft)
+	}
+	return scheme.DecodeFrame(HardDecisions(soft))
+}
+
+// HardDecisions thresholds soft bit values at zero
+func HardDecisions(soft []float64) []bool {
+	bits := make([]bool, len(soft))
+	for i, v := range soft {
+		bits[i] = v > 0
+	}
+	return bits
+}
+
 // ECCScheme is an enum for different ECC schemes
 type ECCScheme uint8
 
 const (
 	// ECCSchemeRepetition3 uses repetition-3 encoding (each bit repeated 3 times)
 	ECCSchemeRepetition3 ECCScheme = 1
+	// ECCSchemeHamming74 uses Hamming(7,4) encoding (4 data bits per 7-bit codeword)
+	ECCSchemeHamming74 ECCScheme = 2
+	// ECCSchemeReedSolomon uses Reed-Solomon encoding over GF(256) (byte-level burst correction)
+	ECCSchemeReedSolomon ECCScheme = 3
+	// ECCSchemeRepetition5 uses repetition-5 encoding (each bit repeated 5 times)
+	ECCSchemeRepetition5 ECCScheme = 4
+	// ECCSchemeRepetition3Interleaved uses repetition-3 encoding with a block
+	// interleave, spreading the copies of each bit to survive burst errors
+	ECCSchemeRepetition3Interleaved ECCScheme = 5
+	// ECCSchemeBCH157 uses BCH(15,7) encoding (7 data bits per 15-bit
+	// codeword, correcting two bit errors per codeword)
+	ECCSchemeBCH157 ECCScheme = 6
 )
 
 var (
@@ -24,17 +112,74 @@ var (
 	ErrUnsupportedScheme = errors.New("unsupported ECC scheme")
 	// ErrInsufficientBits indicates there are not enough bits to decode
 	ErrInsufficientBits = errors.New("insufficient bits for decoding")
+	// ErrReservedScheme indicates an attempt to register a scheme under a built-in or zero ID
+	ErrReservedScheme = errors.New("ECC scheme ID is reserved")
+	// ErrNilFactory indicates RegisterScheme was called without a factory
+	ErrNilFactory = errors.New("nil ECC scheme factory")
 )
 
-// GetScheme returns a Scheme implementation for the given ECCScheme
-func GetScheme(scheme ECCScheme) (Scheme, error) {
-	switch scheme {
-	case ECCSchemeRepetition3:
-		return &Repetition3{}, nil
-	default:
-		return nil, ErrUnsupportedScheme
+// registry maps scheme IDs to factories; built-ins are registered in init
+var registry = struct {
+	sync.RWMutex
+	factories map[ECCScheme]func() Scheme
+}{factories: make(map[ECCScheme]func() Scheme)}
+
+func init() {
+	registry.factories[ECCSchemeRepetition3] = func() Scheme { return &Repetition3{} }
+	registry.factories[ECCSchemeHamming74] = func() Scheme { return &Hamming74{} }
+	registry.factories[ECCSchemeReedSolomon] = func() Scheme { return NewReedSolomon() }
+	registry.factories[ECCSchemeRepetition5] = func() Scheme { return &RepetitionN{n: 5} }
+	registry.factories[ECCSchemeRepetition3Interleaved] = func() Scheme { return &InterleavedRepetition3{} }
+	registry.factories[ECCSchemeBCH157] = func() Scheme { return &BCH157{} }
+}
+
+// IsReserved reports whether id is zero or a built-in scheme ID
+func IsReserved(id ECCScheme) bool {
+	switch id {
+	case 0, ECCSchemeRepetition3, ECCSchemeHamming74, ECCSchemeReedSolomon, ECCSchemeRepetition5, ECCSchemeRepetition3Interleaved, ECCSchemeBCH157:
+		return true
 	}
+	return false
 }
 
+// RegisterScheme makes a custom scheme available under id, so GetScheme
+// (and therefore embedding and extraction) can use it
+// Built-in IDs and zero are reserved and can't be overridden; registering
+// a custom ID again replaces its factory
+func RegisterScheme(id ECCScheme, factory func() Scheme) error {
+	if IsReserved(id) {
+		return ErrReservedScheme
+	}
+	if factory == nil {
+		return ErrNilFactory
+	}
 
+	registry.Lock()
+	defer registry.Unlock()
+	registry.factories[id] = factory
+	return nil
+}
 
+// RegisteredSchemes returns the IDs of every available scheme, built-in and
+// registered, in ascending order
+func RegisteredSchemes() []ECCScheme {
+	registry.RLock()
+	defer registry.RUnlock()
+	ids := make([]ECCScheme, 0, len(registry.factories))
+	for id := range registry.factories {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// GetScheme returns a Scheme implementation for the given ECCScheme
+func GetScheme(scheme ECCScheme) (Scheme, error) {
+	registry.RLock()
+	factory, ok := registry.factories[scheme]
+	registry.RUnlock()
+	if !ok {
+		return nil, ErrUnsupportedScheme
+	}
+	return factory(), nil
+}
@@ -0,0 +1,112 @@
+package ecc
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/tuomas-lb/emganography/internal/bitstream"
+)
+
+// identityScheme embeds frame bits unprotected
+type identityScheme struct{}
+
+func (identityScheme) EncodeFrame(frame []byte) ([]bool, error) {
+	return bitstream.BytesToBits(frame), nil
+}
+
+func (identityScheme) DecodeFrame(bits []bool) ([]byte, error) {
+	return bitstream.BitsToBytes(bits), nil
+}
+
+func TestGetScheme_BuiltIns(t *testing.T) {
+	for _, id := range []ECCScheme{ECCSchemeRepetition3, ECCSchemeHamming74, ECCSchemeReedSolomon, ECCSchemeRepetition5, ECCSchemeRepetition3Interleaved, ECCSchemeBCH157} {
+		if _, err := GetScheme(id); err != nil {
+			t.Errorf("GetScheme(%d) failed: %v", id, err)
+		}
+	}
+	if _, err := GetScheme(0xF0); !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("expected ErrUnsupportedScheme for unregistered ID, got %v", err)
+	}
+}
+
+func TestRegisterScheme(t *testing.T) {
+	factory := func() Scheme { return identityScheme{} }
+
+	for _, id := range []ECCScheme{0, ECCSchemeRepetition3, ECCSchemeRepetition5} {
+		if err := RegisterScheme(id, factory); !errors.Is(err, ErrReservedScheme) {
+			t.Errorf("RegisterScheme(%d): expected ErrReservedScheme, got %v", id, err)
+		}
+	}
+	if err := RegisterScheme(0xF1, nil); !errors.Is(err, ErrNilFactory) {
+		t.Errorf("expected ErrNilFactory, got %v", err)
+	}
+
+	if err := RegisterScheme(0xF1, factory); err != nil {
+		t.Fatalf("RegisterScheme failed: %v", err)
+	}
+	scheme, err := GetScheme(0xF1)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	if _, ok := scheme.(identityScheme); !ok {
+		t.Errorf("expected identityScheme, got %T", scheme)
+	}
+
+	// Built-ins are unaffected
+	if scheme, _ := GetScheme(ECCSchemeRepetition3); scheme == nil {
+		t.Error("built-in scheme missing after registration")
+	} else if _, ok := scheme.(*Repetition3); !ok {
+		t.Errorf("expected *Repetition3, got %T", scheme)
+	}
+
+	ids := RegisteredSchemes()
+	if !slices.IsSorted(ids) || !slices.Contains(ids, ECCSchemeBCH157) || !slices.Contains(ids, 0xF1) {
+		t.Errorf("expected sorted IDs with built-in and registered schemes, got %v", ids)
+	}
+}
+
+func TestExpansionBits_MatchesEncoding(t *testing.T) {
+	for _, id := range []ECCScheme{ECCSchemeRepetition3, ECCSchemeHamming74, ECCSchemeReedSolomon, ECCSchemeRepetition5, ECCSchemeRepetition3Interleaved, ECCSchemeBCH157} {
+		scheme, err := GetScheme(id)
+		if err != nil {
+			t.Fatalf("GetScheme(%d) failed: %v", id, err)
+		}
+		expansion, ok := scheme.(ExpansionScheme)
+		if !ok {
+			t.Errorf("scheme %d (%T) doesn't implement ExpansionScheme", id, scheme)
+			continue
+		}
+		for n := 0; n <= 100; n++ {
+			bits, err := scheme.EncodeFrame(make([]byte, n))
+			if err != nil {
+				t.Fatalf("scheme %d: EncodeFrame(%d bytes) failed: %v", id, n, err)
+			}
+			if got := expansion.ExpansionBits(n * 8); got != len(bits) {
+				t.Errorf("scheme %d, %d bytes: ExpansionBits = %d, EncodeFrame produced %d", id, n, got, len(bits))
+			}
+		}
+	}
+
+	// Exact values for the documented rates
+	rep3, _ := GetScheme(ECCSchemeRepetition3)
+	hamming, _ := GetScheme(ECCSchemeHamming74)
+	for _, tc := range []struct {
+		scheme   Scheme
+		dataBits int
+		want     int
+	}{
+		{rep3, 144, 432},
+		{hamming, 144, 252},
+		{hamming, 8, 14},
+	} {
+		if got := tc.scheme.(ExpansionScheme).ExpansionBits(tc.dataBits); got != tc.want {
+			t.Errorf("%T.ExpansionBits(%d) = %d, expected %d", tc.scheme, tc.dataBits, got, tc.want)
+		}
+	}
+
+	// Schemes without the method are measured by encoding
+	if got, err := EncodedBits(identityScheme{}, 5); err != nil || got != 40 {
+		t.Errorf("EncodedBits(identity, 5) = %d, %v; expected 40", got, err)
+	}
+}
@@ -0,0 +1,83 @@
+package ecc
+
+import (
+	"github.com/tuomas-lb/emganography/internal/bitstream"
+)
+
+// Hamming74 implements Hamming(7,4) error correction coding
+// Each group of 4 data bits is encoded as a 7-bit codeword
+// Codeword layout (positions 1-7): p1 p2 d1 p3 d2 d3 d4
+// Decoding corrects any single bit error per codeword using the syndrome
+type Hamming74 struct{}
+
+// ExpansionBits returns the encoded length of dataBits: a 7-bit codeword per
+// group of 4, the last zero-padded
+func (h *Hamming74) ExpansionBits(dataBits int) int {
+	return (dataBits + 3) / 4 * 7
+}
+
+// EncodeFrame encodes a frame into a bitstream using Hamming(7,4)
+// If the bit count isn't a multiple of 4, the final group is zero-padded
+func (h *Hamming74) EncodeFrame(frame []byte) ([]bool, error) {
+	dataBits := bitstream.BytesToBits(frame)
+
+	groupCount := (len(dataBits) + 3) / 4
+	encodedBits := make([]bool, 0, groupCount*7)
+	var group [4]bool
+	for g := 0; g < groupCount; g++ {
+		// Fill the group, zero-padding past the end of the data
+		for i := 0; i < 4; i++ {
+			idx := g*4 + i
+			group[i] = idx < len(dataBits) && dataBits[idx]
+		}
+		d1, d2, d3, d4 := group[0], group[1], group[2], group[3]
+
+		p1 := d1 != d2 != d4
+		p2 := d1 != d3 != d4
+		p3 := d2 != d3 != d4
+
+		encodedBits = append(encodedBits, p1, p2, d1, p3, d2, d3, d4)
+	}
+
+	return encodedBits, nil
+}
+
+// DecodeFrame decodes a Hamming(7,4) bitstream, correcting single bit errors
+// Trailing bits that don't form a complete codeword are ignored, and the
+// decoded data is trimmed to whole bytes so zero padding is dropped
+func (h *Hamming74) DecodeFrame(bits []bool) ([]byte, error) {
+	codewordCount := len(bits) / 7
+	if codewordCount == 0 {
+		return nil, ErrInsufficientBits
+	}
+
+	decodedBits := make([]bool, 0, codewordCount*4)
+	var c [7]bool
+	for i := 0; i < codewordCount; i++ {
+		copy(c[:], bits[i*7:i*7+7])
+
+		// Syndrome gives the 1-based position of a single bit error (0 = no error)
+		syndrome := 0
+		if c[0] != c[2] != c[4] != c[6] {
+			syndrome |= 1
+		}
+		if c[1] != c[2] != c[5] != c[6] {
+			syndrome |= 2
+		}
+		if c[3] != c[4] != c[5] != c[6] {
+			syndrome |= 4
+		}
+		if syndrome != 0 {
+			c[syndrome-1] = !c[syndrome-1]
+		}
+
+		decodedBits = append(decodedBits, c[2], c[4], c[5], c[6])
+	}
+
+	// Trim padding so only whole bytes remain
+	byteCount := len(decodedBits) / 8
+	if byteCount == 0 {
+		return nil, ErrInsufficientBits
+	}
+	return bitstream.BitsToBytes(decodedBits[:byteCount*8]), nil
+}
@@ -0,0 +1,100 @@
+package ecc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHamming74_EncodeDecode(t *testing.T) {
+	h := &Hamming74{}
+
+	original := []byte{0x12, 0x34, 0xAB}
+	encoded, err := h.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// 24 data bits = 6 groups of 4 = 42 code bits
+	if len(encoded) != 42 {
+		t.Errorf("expected encoded length 42, got %d", len(encoded))
+	}
+
+	decoded, err := h.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("round trip failed: expected %v, got %v", original, decoded)
+	}
+}
+
+func TestHamming74_SingleErrorPerBlock(t *testing.T) {
+	h := &Hamming74{}
+
+	original := []byte{0xDE, 0xAD, 0xBE, 0xEF}
+	encoded, err := h.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// Corrupt every possible position, one bit per 7-bit codeword
+	for pos := 0; pos < 7; pos++ {
+		corrupted := make([]bool, len(encoded))
+		copy(corrupted, encoded)
+		for block := 0; block < len(corrupted)/7; block++ {
+			idx := block*7 + pos
+			corrupted[idx] = !corrupted[idx]
+		}
+
+		decoded, err := h.DecodeFrame(corrupted)
+		if err != nil {
+			t.Fatalf("DecodeFrame failed: %v", err)
+		}
+		if !reflect.DeepEqual(original, decoded) {
+			t.Errorf("position %d: error correction failed: expected %v, got %v", pos, original, decoded)
+		}
+	}
+}
+
+func TestHamming74_TrimsTrailingBits(t *testing.T) {
+	h := &Hamming74{}
+
+	original := []byte{0x5A}
+	encoded, err := h.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// Extra codewords and a partial codeword past the data should be ignored
+	extra, _ := h.EncodeFrame([]byte{0x00})
+	encoded = append(encoded, extra[:7]...)
+	encoded = append(encoded, true, false, true)
+
+	decoded, err := h.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("expected %v, got %v", original, decoded)
+	}
+}
+
+func TestHamming74_InsufficientBits(t *testing.T) {
+	h := &Hamming74{}
+
+	_, err := h.DecodeFrame([]bool{true, false, true, true, false, false})
+	if err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits, got %v", err)
+	}
+}
+
+func TestGetScheme_Hamming74(t *testing.T) {
+	scheme, err := GetScheme(ECCSchemeHamming74)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	if _, ok := scheme.(*Hamming74); !ok {
+		t.Errorf("expected *Hamming74, got %T", scheme)
+	}
+}
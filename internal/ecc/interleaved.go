@@ -0,0 +1,76 @@
+package ecc
+
+import (
+	"github.com/tuomas-lb/emganography/internal/bitstream"
+)
+
+// interleaveSpan is the number of data bits interleaved together (6 bytes)
+// It divides the 18-byte frame header, so a header decodes from a prefix of
+// the stream the same way whatever the length of the frame behind it
+const interleaveSpan = 48
+
+// InterleavedRepetition3 implements repetition-3 coding with a block
+// interleave, so the three copies of a bit are interleaveSpan positions apart
+// Each span of data bits is written out three times in a row, which lets
+// majority voting correct any single burst of up to interleaveSpan
+// consecutive flipped bits, where plain repetition-3 loses a bit to any two
+// adjacent flips
+type InterleavedRepetition3 struct{}
+
+// ExpansionBits returns the encoded length of dataBits: every span, short or
+// not, is written three times
+func (r *InterleavedRepetition3) ExpansionBits(dataBits int) int {
+	return dataBits * 3
+}
+
+// EncodeFrame encodes a frame into a bitstream, repeating each span of data
+// bits three times; a short final span is repeated at its own length
+func (r *InterleavedRepetition3) EncodeFrame(frame []byte) ([]bool, error) {
+	dataBits := bitstream.BytesToBits(frame)
+
+	encodedBits := make([]bool, 0, len(dataBits)*3)
+	for start := 0; start < len(dataBits); start += interleaveSpan {
+		span := dataBits[start:min(start+interleaveSpan, len(dataBits))]
+		for range 3 {
+			encodedBits = append(encodedBits, span...)
+		}
+	}
+
+	return encodedBits, nil
+}
+
+// DecodeFrame decodes a bitstream by de-interleaving each span and taking a
+// majority vote over the three copies of every bit
+// A stream ending partway through a span decodes its remainder as a short
+// span, and the decoded data is trimmed to whole bytes, as Repetition3 does
+// Returns ErrInsufficientBits if the stream holds less than one byte
+func (r *InterleavedRepetition3) DecodeFrame(bits []bool) ([]byte, error) {
+	soft := make([]float64, len(bits))
+	for i, bit := range bits {
+		if bit {
+			soft[i] = 1
+		} else {
+			soft[i] = -1
+		}
+	}
+	return r.DecodeFrameSoft(soft)
+}
+
+// DecodeFrameSoft decodes soft bit values by de-interleaving each span and
+// summing the three copies of every bit
+// A trailing partial byte is dropped as in DecodeFrame
+func (r *InterleavedRepetition3) DecodeFrameSoft(soft []float64) ([]byte, error) {
+	if len(soft) < 3*8 {
+		return nil, ErrInsufficientBits
+	}
+
+	decodedBits := make([]bool, 0, len(soft)/3)
+	for start := 0; start < len(soft); start += 3 * interleaveSpan {
+		n := min(interleaveSpan, (len(soft)-start)/3)
+		for i := 0; i < n; i++ {
+			decodedBits = append(decodedBits, soft[start+i]+soft[start+n+i]+soft[start+2*n+i] > 0)
+		}
+	}
+
+	return bitstream.BitsToBytes(decodedBits[:len(decodedBits)/8*8]), nil
+}
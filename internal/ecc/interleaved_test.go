@@ -0,0 +1,147 @@
+package ecc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestInterleavedRepetition3_EncodeDecode(t *testing.T) {
+	r := &InterleavedRepetition3{}
+
+	// 20 bytes: two full spans and a short final one
+	original := []byte("interleaved frame!!!")
+	encoded, err := r.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	if len(encoded) != len(original)*8*3 {
+		t.Errorf("expected encoded length %d, got %d", len(original)*8*3, len(encoded))
+	}
+
+	decoded, err := r.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("round trip failed: expected %q, got %q", original, decoded)
+	}
+}
+
+func TestInterleavedRepetition3_BurstError(t *testing.T) {
+	original := []byte("burst errors hit adjacent blocks")
+
+	// Flip a run of consecutive bits, as a damaged strip of blocks would
+	burst := func(bits []bool) {
+		for i := 100; i < 100+interleaveSpan; i++ {
+			bits[i] = !bits[i]
+		}
+	}
+
+	plain := &Repetition3{}
+	encoded, err := plain.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	burst(encoded)
+	decoded, err := plain.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if reflect.DeepEqual(original, decoded) {
+		t.Fatal("expected plain repetition-3 to be defeated by the burst")
+	}
+
+	interleaved := &InterleavedRepetition3{}
+	encoded, err = interleaved.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	burst(encoded)
+	decoded, err = interleaved.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("burst not corrected: expected %q, got %q", original, decoded)
+	}
+}
+
+func TestInterleavedRepetition3_HeaderPrefix(t *testing.T) {
+	r := &InterleavedRepetition3{}
+
+	// The first 18 bytes must decode from the first 18*8*3 bits alone
+	frame := []byte("eighteen byte hdr|followed by a longer payload")
+	encoded, err := r.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	decoded, err := r.DecodeFrame(encoded[:18*8*3])
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(frame[:18], decoded) {
+		t.Errorf("prefix decode: expected %q, got %q", frame[:18], decoded)
+	}
+}
+
+func TestInterleavedRepetition3_DecodeFrameSoft(t *testing.T) {
+	r := &InterleavedRepetition3{}
+
+	original := []byte{0xA5, 0x3C}
+	encoded, err := r.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// One confident copy outvotes two barely wrong ones
+	soft := make([]float64, len(encoded))
+	for i, bit := range encoded {
+		if bit {
+			soft[i] = 1
+		} else {
+			soft[i] = -1
+		}
+	}
+	n := len(original) * 8
+	soft[0], soft[n] = -soft[0]*0.1, -soft[n]*0.1
+
+	decoded, err := r.DecodeFrameSoft(soft)
+	if err != nil {
+		t.Fatalf("DecodeFrameSoft failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("soft decode failed: expected %v, got %v", original, decoded)
+	}
+
+	if _, err := r.DecodeFrameSoft(soft[:2]); err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits, got %v", err)
+	}
+}
+
+func TestInterleavedRepetition3_TrimsToWholeBytes(t *testing.T) {
+	r := &InterleavedRepetition3{}
+	original := []byte("interleaved frame!!!")
+	encoded, err := r.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// Cut inside the final short span: its remainder decodes as an even
+	// shorter span, and the partial byte it leaves is dropped rather than
+	// zero-padded
+	decoded, err := r.DecodeFrame(encoded[:len(encoded)-5])
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if len(decoded) != len(original)-1 {
+		t.Errorf("expected %d whole bytes, got %d", len(original)-1, len(decoded))
+	}
+	if !reflect.DeepEqual(original[:12], decoded[:12]) {
+		t.Errorf("expected the full spans intact: %q, got %q", original[:12], decoded[:12])
+	}
+
+	if _, err := r.DecodeFrame(encoded[:23]); err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits for 23 bits, got %v", err)
+	}
+}
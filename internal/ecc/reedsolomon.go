@@ -0,0 +1,289 @@
+package ecc
+
+import (
+	"errors"
+
+	"github.com/tuomas-lb/emganography/internal/bitstream"
+)
+
+const (
+	// rsDataBytes is the number of data bytes carried per Reed-Solomon codeword
+	rsDataBytes = 32
+	// rsParityBytes is the number of parity bytes per codeword (corrects rsParityBytes/2 byte errors)
+	rsParityBytes = 16
+	// gfPrimitive is the primitive polynomial x^8 + x^4 + x^3 + x^2 + 1 used for GF(256)
+	gfPrimitive = 0x11d
+)
+
+var (
+	// ErrTooManyErrors indicates a codeword has more errors than the code can correct
+	ErrTooManyErrors = errors.New("too many errors to correct")
+)
+
+// Precomputed GF(256) exponent and logarithm tables
+// gfExp is doubled in length so products of logs never need a modulo
+var (
+	gfExp [512]byte
+	gfLog [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPrimitive
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+// gfMul multiplies two GF(256) elements
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+// gfDiv divides two GF(256) elements (b must be non-zero)
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+255-gfLog[b]]
+}
+
+// gfPow returns alpha^n for the generator alpha = 2 (n may be negative)
+func gfPow(n int) byte {
+	n %= 255
+	if n < 0 {
+		n += 255
+	}
+	return gfExp[n]
+}
+
+// ReedSolomon implements a systematic Reed-Solomon code over GF(256)
+// The frame is split into fixed-size chunks of rsDataBytes (the last chunk
+// zero-padded), and each chunk is followed by rsParityBytes of parity, i.e. a
+// shortened RS(48,32) code. Each codeword corrects up to 8 byte errors, so a
+// burst of up to 64 consecutive corrupted bits (blocks) is always recoverable
+// Because codewords have a fixed size, any prefix of whole codewords decodes
+// on its own, which lets extraction read the frame header first
+type ReedSolomon struct {
+	generator []byte
+}
+
+// NewReedSolomon creates a Reed-Solomon scheme with the default parameters
+func NewReedSolomon() *ReedSolomon {
+	// g(x) = (x - a^0)(x - a^1)...(x - a^(n-1)), coefficients highest degree first
+	gen := []byte{1}
+	for i := 0; i < rsParityBytes; i++ {
+		next := make([]byte, len(gen)+1)
+		root := gfPow(i)
+		for j, c := range gen {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, root)
+		}
+		gen = next
+	}
+	return &ReedSolomon{generator: gen}
+}
+
+// ExpansionBits returns the encoded length of dataBits: a whole codeword per
+// chunk of rsDataBytes, the last zero-padded
+func (rs *ReedSolomon) ExpansionBits(dataBits int) int {
+	chunkCount := ((dataBits+7)/8 + rsDataBytes - 1) / rsDataBytes
+	return chunkCount * (rsDataBytes + rsParityBytes) * 8
+}
+
+// EncodeFrame encodes a frame into a bitstream using Reed-Solomon codewords
+func (rs *ReedSolomon) EncodeFrame(frame []byte) ([]bool, error) {
+	chunkCount := (len(frame) + rsDataBytes - 1) / rsDataBytes
+	codewordSize := rsDataBytes + rsParityBytes
+	encoded := make([]byte, chunkCount*codewordSize)
+
+	for c := 0; c < chunkCount; c++ {
+		codeword := encoded[c*codewordSize : (c+1)*codewordSize]
+		// Data bytes beyond the end of the frame stay zero (padding)
+		end := (c + 1) * rsDataBytes
+		if end > len(frame) {
+			end = len(frame)
+		}
+		copy(codeword, frame[c*rsDataBytes:end])
+		rs.computeParity(codeword[:rsDataBytes], codeword[rsDataBytes:])
+	}
+
+	return bitstream.BytesToBits(encoded), nil
+}
+
+// DecodeFrame decodes a Reed-Solomon bitstream, correcting byte errors in each codeword
+// Trailing bits that don't form a complete codeword are ignored
+func (rs *ReedSolomon) DecodeFrame(bits []bool) ([]byte, error) {
+	codewordSize := rsDataBytes + rsParityBytes
+	codewordCount := len(bits) / (codewordSize * 8)
+	if codewordCount == 0 {
+		return nil, ErrInsufficientBits
+	}
+
+	received := bitstream.BitsToBytes(bits[:codewordCount*codewordSize*8])
+	decoded := make([]byte, 0, codewordCount*rsDataBytes)
+	for c := 0; c < codewordCount; c++ {
+		codeword := received[c*codewordSize : (c+1)*codewordSize]
+		if err := rs.correct(codeword); err != nil {
+			return nil, err
+		}
+		decoded = append(decoded, codeword[:rsDataBytes]...)
+	}
+
+	return decoded, nil
+}
+
+// computeParity writes the remainder of data(x)*x^n / g(x) into parity
+func (rs *ReedSolomon) computeParity(data, parity []byte) {
+	for i := range parity {
+		parity[i] = 0
+	}
+	for _, d := range data {
+		feedback := d ^ parity[0]
+		copy(parity, parity[1:])
+		parity[len(parity)-1] = 0
+		if feedback != 0 {
+			for j := range parity {
+				parity[j] ^= gfMul(feedback, rs.generator[j+1])
+			}
+		}
+	}
+}
+
+// syndromes evaluates the codeword at each root of the generator
+// Returns the syndromes and whether any of them are non-zero
+func syndromes(codeword []byte) ([]byte, bool) {
+	synd := make([]byte, rsParityBytes)
+	hasErrors := false
+	for j := range synd {
+		root := gfPow(j)
+		var s byte
+		for _, b := range codeword {
+			s = gfMul(s, root) ^ b
+		}
+		synd[j] = s
+		if s != 0 {
+			hasErrors = true
+		}
+	}
+	return synd, hasErrors
+}
+
+// correct corrects byte errors in a codeword in place
+// Uses Berlekamp-Massey to find the error locator, Chien search for the
+// error positions and Forney's algorithm for the error magnitudes
+func (rs *ReedSolomon) correct(codeword []byte) error {
+	synd, hasErrors := syndromes(codeword)
+	if !hasErrors {
+		return nil
+	}
+
+	// Berlekamp-Massey: error locator polynomial, lowest degree first
+	locator := []byte{1}
+	prev := []byte{1}
+	errCount := 0
+	shift := 1
+	prevDiscrepancy := byte(1)
+	for n := 0; n < len(synd); n++ {
+		discrepancy := synd[n]
+		for i := 1; i <= errCount && i < len(locator); i++ {
+			discrepancy ^= gfMul(locator[i], synd[n-i])
+		}
+		if discrepancy == 0 {
+			shift++
+			continue
+		}
+
+		scale := gfDiv(discrepancy, prevDiscrepancy)
+		next := make([]byte, max(len(locator), len(prev)+shift))
+		copy(next, locator)
+		for i, c := range prev {
+			next[i+shift] ^= gfMul(scale, c)
+		}
+
+		if 2*errCount <= n {
+			prev = locator
+			errCount = n + 1 - errCount
+			prevDiscrepancy = discrepancy
+			shift = 1
+		} else {
+			shift++
+		}
+		locator = next
+	}
+	if 2*errCount > rsParityBytes {
+		return ErrTooManyErrors
+	}
+
+	// Chien search: an error at degree i is a root at alpha^-i
+	n := len(codeword)
+	var positions []int
+	for i := 0; i < n; i++ {
+		if evalPoly(locator, gfPow(-i)) == 0 {
+			positions = append(positions, i)
+		}
+	}
+	if len(positions) != errCount {
+		return ErrTooManyErrors
+	}
+
+	// Error evaluator: omega(x) = S(x) * locator(x) mod x^(2t)
+	omega := make([]byte, len(synd))
+	for i, s := range synd {
+		for j, l := range locator {
+			if i+j < len(omega) {
+				omega[i+j] ^= gfMul(s, l)
+			}
+		}
+	}
+
+	// Forney: e = X * omega(X^-1) / locator'(X^-1)
+	for _, degree := range positions {
+		x := gfPow(degree)
+		xInv := gfPow(-degree)
+		var derivative byte
+		for i := 1; i < len(locator); i += 2 {
+			derivative ^= gfMul(locator[i], powElem(xInv, i-1))
+		}
+		if derivative == 0 {
+			return ErrTooManyErrors
+		}
+		magnitude := gfMul(x, gfDiv(evalPoly(omega, xInv), derivative))
+		codeword[n-1-degree] ^= magnitude
+	}
+
+	if _, hasErrors := syndromes(codeword); hasErrors {
+		return ErrTooManyErrors
+	}
+	return nil
+}
+
+// evalPoly evaluates a polynomial (lowest degree first) at x
+func evalPoly(poly []byte, x byte) byte {
+	var result byte
+	for i := len(poly) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ poly[i]
+	}
+	return result
+}
+
+// powElem raises a GF(256) element to a non-negative power
+func powElem(x byte, n int) byte {
+	if n == 0 {
+		return 1
+	}
+	if x == 0 {
+		return 0
+	}
+	return gfExp[(gfLog[x]*n)%255]
+}
@@ -0,0 +1,109 @@
+package ecc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReedSolomon_EncodeDecode(t *testing.T) {
+	rs := NewReedSolomon()
+
+	original := []byte("reed-solomon round trip over more than one codeword")
+	encoded, err := rs.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// 51 bytes -> 2 codewords of 48 bytes each
+	if len(encoded) != 2*48*8 {
+		t.Errorf("expected encoded length %d, got %d", 2*48*8, len(encoded))
+	}
+
+	decoded, err := rs.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+
+	// Decoded data includes the zero padding of the final codeword
+	if !reflect.DeepEqual(original, decoded[:len(original)]) {
+		t.Errorf("round trip failed: expected %v, got %v", original, decoded[:len(original)])
+	}
+	for _, b := range decoded[len(original):] {
+		if b != 0 {
+			t.Fatalf("expected zero padding, got %v", decoded[len(original):])
+		}
+	}
+}
+
+func TestReedSolomon_BurstError(t *testing.T) {
+	rs := NewReedSolomon()
+
+	original := []byte("burst errors wipe out runs of adjacent blocks")
+	encoded, err := rs.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// Zero out a contiguous span of bits, as if consecutive blocks were destroyed
+	for i := 100; i < 160; i++ {
+		encoded[i] = false
+	}
+
+	decoded, err := rs.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded[:len(original)]) {
+		t.Errorf("burst correction failed: expected %q, got %q", original, decoded[:len(original)])
+	}
+}
+
+func TestReedSolomon_ParityErrors(t *testing.T) {
+	rs := NewReedSolomon()
+
+	original := []byte{0x01, 0x02, 0x03}
+	encoded, err := rs.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// Flip bits in both the data and parity regions
+	for _, idx := range []int{0, 9, 17, 200, 300, 383} {
+		encoded[idx] = !encoded[idx]
+	}
+
+	decoded, err := rs.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded[:len(original)]) {
+		t.Errorf("expected %v, got %v", original, decoded[:len(original)])
+	}
+}
+
+func TestReedSolomon_TooManyErrors(t *testing.T) {
+	rs := NewReedSolomon()
+
+	encoded, err := rs.EncodeFrame([]byte("too much damage"))
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// Corrupt 12 bytes, more than the 8 correctable per codeword
+	for i := 0; i < 12*8; i += 8 {
+		encoded[i] = !encoded[i]
+	}
+
+	if _, err := rs.DecodeFrame(encoded); err != ErrTooManyErrors {
+		t.Errorf("expected ErrTooManyErrors, got %v", err)
+	}
+}
+
+func TestReedSolomon_InsufficientBits(t *testing.T) {
+	rs := NewReedSolomon()
+
+	_, err := rs.DecodeFrame(make([]bool, 48*8-1))
+	if err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits, got %v", err)
+	}
+}
@@ -9,6 +9,8 @@ import (
 var (
 	// ErrCorruptedTriple indicates a triple of bits couldn't be decoded (all 3 differ)
 	ErrCorruptedTriple = errors.New("corrupted triple: all bits differ")
+	// ErrInvalidRepetition indicates a repetition factor that isn't a positive odd number
+	ErrInvalidRepetition = errors.New("repetition factor must be a positive odd number")
 )
 
 // Repetition3 implements repetition-3 error correction coding
@@ -16,6 +18,11 @@ var (
 // Decoding uses majority vote on each triple
 type Repetition3 struct{}
 
+// ExpansionBits returns the encoded length of dataBits: three bits each
+func (r *Repetition3) ExpansionBits(dataBits int) int {
+	return dataBits * 3
+}
+
 // EncodeFrame encodes a frame into a bitstream using repetition-3
 func (r *Repetition3) EncodeFrame(frame []byte) ([]bool, error) {
 	// Convert frame bytes to bits
@@ -30,14 +37,21 @@ func (r *Repetition3) EncodeFrame(frame []byte) ([]bool, error) {
 	return encodedBits, nil
 }
 
+// EncodeFrameStream encodes a frame like EncodeFrame, yielding each bit
+// three times as it's pulled instead of allocating the encoded slice
+func (r *Repetition3) EncodeFrameStream(frame []byte) (bitstream.Iterator, int, error) {
+	return newRepeatIterator(frame, 3), len(frame) * 8 * 3, nil
+}
+
 // DecodeFrame decodes a bitstream using repetition-3 majority voting
+// Trailing bits that don't form a complete triple (a stream of 3k+1 or 3k+2
+// bits) are ignored, and the decoded data is trimmed to whole bytes: a
+// stream cut short inside a byte decodes to a shorter frame, which the frame
+// checks reject, rather than to a final byte silently padded with zeros
+// Returns ErrInsufficientBits if the stream holds less than one byte
 func (r *Repetition3) DecodeFrame(bits []bool) ([]byte, error) {
-	if len(bits) == 0 {
-		return nil, ErrInsufficientBits
-	}
-
 	// Group bits into triples and decode using majority vote
-	tripleCount := len(bits) / 3
+	tripleCount := len(bits) / 3 / 8 * 8
 	if tripleCount == 0 {
 		return nil, ErrInsufficientBits
 	}
@@ -70,5 +84,133 @@ func (r *Repetition3) DecodeFrame(bits []bool) ([]byte, error) {
 	return bitstream.BitsToBytes(decodedBits), nil
 }
 
+// DecodeFrameSoft decodes soft bit values by summing each triple, so one
+// confident bit can outvote two barely wrong ones
+// Incomplete triples and bytes at the end are dropped as in DecodeFrame
+func (r *Repetition3) DecodeFrameSoft(soft []float64) ([]byte, error) {
+	n := len(soft) / 3 / 8 * 8 * 3
+	if n == 0 {
+		return nil, ErrInsufficientBits
+	}
+	return decodeRepetitionSoft(soft[:n], 3), nil
+}
+
+// RepetitionN implements repetition-N error correction coding for an odd N
+// Each data bit is encoded as N identical bits, and decoding uses majority
+// vote over each group, correcting up to (N-1)/2 flipped bits per data bit
+type RepetitionN struct {
+	n int
+}
+
+// NewRepetitionN creates a repetition-N scheme
+// Returns ErrInvalidRepetition unless n is positive and odd (so votes can't tie)
+func NewRepetitionN(n int) (*RepetitionN, error) {
+	if n < 1 || n%2 == 0 {
+		return nil, ErrInvalidRepetition
+	}
+	return &RepetitionN{n: n}, nil
+}
+
+// N returns the repetition factor
+func (r *RepetitionN) N() int {
+	return r.n
+}
+
+// ExpansionBits returns the encoded length of dataBits: N bits each
+func (r *RepetitionN) ExpansionBits(dataBits int) int {
+	return dataBits * r.n
+}
+
+// EncodeFrame encodes a frame into a bitstream, repeating each bit N times
+func (r *RepetitionN) EncodeFrame(frame []byte) ([]bool, error) {
+	dataBits := bitstream.BytesToBits(frame)
+
+	encodedBits := make([]bool, 0, len(dataBits)*r.n)
+	for _, bit := range dataBits {
+		for i := 0; i < r.n; i++ {
+			encodedBits = append(encodedBits, bit)
+		}
+	}
+
+	return encodedBits, nil
+}
+
+// EncodeFrameStream encodes a frame like EncodeFrame, yielding each bit N
+// times as it's pulled instead of allocating the encoded slice
+func (r *RepetitionN) EncodeFrameStream(frame []byte) (bitstream.Iterator, int, error) {
+	return newRepeatIterator(frame, r.n), len(frame) * 8 * r.n, nil
+}
+
+// DecodeFrame decodes a bitstream using majority voting over each group of N bits
+// Trailing bits that don't form a complete group are ignored, and the
+// decoded data is trimmed to whole bytes, as Repetition3 does
+// Returns ErrInsufficientBits if the stream holds less than one byte
+func (r *RepetitionN) DecodeFrame(bits []bool) ([]byte, error) {
+	groupCount := len(bits) / r.n / 8 * 8
+	if groupCount == 0 {
+		return nil, ErrInsufficientBits
+	}
+
+	decodedBits := make([]bool, groupCount)
+	for i := 0; i < groupCount; i++ {
+		ones := 0
+		for _, bit := range bits[i*r.n : (i+1)*r.n] {
+			if bit {
+				ones++
+			}
+		}
+		decodedBits[i] = ones > r.n/2
+	}
+
+	return bitstream.BitsToBytes(decodedBits), nil
+}
+
+// DecodeFrameSoft decodes soft bit values by summing each group of N
+// Incomplete groups and bytes at the end are dropped as in DecodeFrame
+func (r *RepetitionN) DecodeFrameSoft(soft []float64) ([]byte, error) {
+	n := len(soft) / r.n / 8 * 8 * r.n
+	if n == 0 {
+		return nil, ErrInsufficientBits
+	}
+	return decodeRepetitionSoft(soft[:n], r.n), nil
+}
 
+// repeatIterator yields each bit of a frame n times in a row
+type repeatIterator struct {
+	data *bitstream.ByteIterator
+	n    int
+	bit  bool
+	left int
+}
 
+// newRepeatIterator returns an iterator repeating each bit of frame n times
+func newRepeatIterator(frame []byte, n int) *repeatIterator {
+	return &repeatIterator{data: bitstream.NewByteIterator(frame), n: n}
+}
+
+// NextBit returns the current bit until it has been yielded n times, then
+// moves on to the next bit of the frame
+func (r *repeatIterator) NextBit() (bool, bool) {
+	if r.left == 0 {
+		bit, ok := r.data.NextBit()
+		if !ok {
+			return false, false
+		}
+		r.bit, r.left = bit, r.n
+	}
+	r.left--
+	return r.bit, true
+}
+
+// decodeRepetitionSoft decides each group of n soft values by the sign of its sum
+func decodeRepetitionSoft(soft []float64, n int) []byte {
+	decodedBits := make([]bool, len(soft)/n)
+	for i := range decodedBits {
+		sum := 0.0
+		for _, v := range soft[i*n : (i+1)*n] {
+			sum += v
+		}
+		decodedBits[i] = sum > 0
+	}
+	return bitstream.BitsToBytes(decodedBits)
+}
@@ -1,6 +1,7 @@
 package ecc
 
 import (
+	"bytes"
 	"reflect"
 	"testing"
 
@@ -98,3 +99,242 @@ func TestRepetition3_InsufficientBits(t *testing.T) {
 
 
 
+
+func TestRepetition3_TrailingBits(t *testing.T) {
+	r := &Repetition3{}
+	frame := []byte{0xA5, 0x3C, 0xFF}
+	encoded, err := r.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	toSoft := func(bits []bool) []float64 {
+		soft := make([]float64, len(bits))
+		for i, bit := range bits {
+			soft[i] = -1
+			if bit {
+				soft[i] = 1
+			}
+		}
+		return soft
+	}
+
+	tests := []struct {
+		name string
+		bits []bool
+		want []byte
+	}{
+		// Over-reads of one or two bits (3k+1, 3k+2) are ignored
+		{"3k", encoded, frame},
+		{"3k+1", append(append([]bool(nil), encoded...), true), frame},
+		{"3k+2", append(append([]bool(nil), encoded...), true, true), frame},
+		// An under-read loses the whole final byte rather than zero-padding it
+		{"3k-1", encoded[:len(encoded)-1], frame[:2]},
+		{"one triple short", encoded[:len(encoded)-3], frame[:2]},
+		{"one byte", encoded[:24], frame[:1]},
+	}
+	for _, tt := range tests {
+		decoded, err := r.DecodeFrame(tt.bits)
+		if err != nil {
+			t.Fatalf("%s: DecodeFrame failed: %v", tt.name, err)
+		}
+		if !bytes.Equal(decoded, tt.want) {
+			t.Errorf("%s: DecodeFrame: expected %x, got %x", tt.name, tt.want, decoded)
+		}
+		decoded, err = r.DecodeFrameSoft(toSoft(tt.bits))
+		if err != nil {
+			t.Fatalf("%s: DecodeFrameSoft failed: %v", tt.name, err)
+		}
+		if !bytes.Equal(decoded, tt.want) {
+			t.Errorf("%s: DecodeFrameSoft: expected %x, got %x", tt.name, tt.want, decoded)
+		}
+	}
+
+	// Less than a byte's worth of triples decodes to nothing
+	if _, err := r.DecodeFrame(encoded[:23]); err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits for 23 bits, got %v", err)
+	}
+	if _, err := r.DecodeFrameSoft(toSoft(encoded[:23])); err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits for 23 soft values, got %v", err)
+	}
+}
+
+func TestRepetitionN_CorrectsTwoOfFive(t *testing.T) {
+	r, err := NewRepetitionN(5)
+	if err != nil {
+		t.Fatalf("NewRepetitionN failed: %v", err)
+	}
+
+	original := []byte{0xA5, 0x3C}
+	encoded, err := r.EncodeFrame(original)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	if len(encoded) != len(original)*8*5 {
+		t.Fatalf("expected encoded length %d, got %d", len(original)*8*5, len(encoded))
+	}
+
+	// Flip two of the five repeats of every data bit
+	for i := 0; i < len(encoded); i += 5 {
+		encoded[i] = !encoded[i]
+		encoded[i+3] = !encoded[i+3]
+	}
+
+	decoded, err := r.DecodeFrame(encoded)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if !reflect.DeepEqual(original, decoded) {
+		t.Errorf("error correction failed: expected %v, got %v", original, decoded)
+	}
+}
+
+func TestRepetitionN_TrailingBits(t *testing.T) {
+	r, err := NewRepetitionN(5)
+	if err != nil {
+		t.Fatalf("NewRepetitionN failed: %v", err)
+	}
+	frame := []byte{0xA5, 0x3C, 0xFF}
+	encoded, err := r.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	toSoft := func(bits []bool) []float64 {
+		soft := make([]float64, len(bits))
+		for i, bit := range bits {
+			soft[i] = -1
+			if bit {
+				soft[i] = 1
+			}
+		}
+		return soft
+	}
+
+	tests := []struct {
+		name string
+		bits []bool
+		want []byte
+	}{
+		// Over-reads short of a whole group are ignored
+		{"5k", encoded, frame},
+		{"5k+4", append(append([]bool(nil), encoded...), true, true, true, true), frame},
+		// An under-read loses the whole final byte rather than zero-padding it
+		{"5k-1", encoded[:len(encoded)-1], frame[:2]},
+		{"one group short", encoded[:len(encoded)-5], frame[:2]},
+		{"one byte", encoded[:40], frame[:1]},
+	}
+	for _, tt := range tests {
+		decoded, err := r.DecodeFrame(tt.bits)
+		if err != nil {
+			t.Fatalf("%s: DecodeFrame failed: %v", tt.name, err)
+		}
+		if !bytes.Equal(decoded, tt.want) {
+			t.Errorf("%s: DecodeFrame: expected %x, got %x", tt.name, tt.want, decoded)
+		}
+		decoded, err = r.DecodeFrameSoft(toSoft(tt.bits))
+		if err != nil {
+			t.Fatalf("%s: DecodeFrameSoft failed: %v", tt.name, err)
+		}
+		if !bytes.Equal(decoded, tt.want) {
+			t.Errorf("%s: DecodeFrameSoft: expected %x, got %x", tt.name, tt.want, decoded)
+		}
+	}
+
+	// Less than a byte's worth of groups decodes to nothing
+	if _, err := r.DecodeFrame(encoded[:39]); err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits for 39 bits, got %v", err)
+	}
+	if _, err := r.DecodeFrameSoft(toSoft(encoded[:39])); err != ErrInsufficientBits {
+		t.Errorf("expected ErrInsufficientBits for 39 soft values, got %v", err)
+	}
+}
+
+func TestRepetitionN_InvalidFactor(t *testing.T) {
+	for _, n := range []int{0, -1, 2, 4} {
+		if _, err := NewRepetitionN(n); err != ErrInvalidRepetition {
+			t.Errorf("n=%d: expected ErrInvalidRepetition, got %v", n, err)
+		}
+	}
+}
+
+func TestGetScheme_Repetition5(t *testing.T) {
+	scheme, err := GetScheme(ECCSchemeRepetition5)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	r, ok := scheme.(*RepetitionN)
+	if !ok || r.N() != 5 {
+		t.Errorf("expected repetition-5 scheme, got %#v", scheme)
+	}
+}
+
+func TestRepetition_DecodeFrameSoft(t *testing.T) {
+	original := []byte{0xA5}
+	r5, _ := NewRepetitionN(5)
+
+	for _, tt := range []struct {
+		name   string
+		scheme Scheme
+		n      int
+	}{
+		{"repetition3", &Repetition3{}, 3},
+		{"repetition5", r5, 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.scheme.EncodeFrame(original)
+			if err != nil {
+				t.Fatalf("EncodeFrame failed: %v", err)
+			}
+
+			// In every group, all but one bit are barely wrong and the
+			// remaining one is confidently right: majority vote fails,
+			// soft decoding recovers the frame
+			soft := make([]float64, len(encoded))
+			for i, bit := range encoded {
+				v := -0.1
+				if i%tt.n == 0 {
+					v = 10
+				}
+				if !bit {
+					v = -v
+				}
+				soft[i] = v
+			}
+
+			if hard, _ := tt.scheme.DecodeFrame(HardDecisions(soft)); reflect.DeepEqual(hard, original) {
+				t.Fatalf("expected hard decoding to fail")
+			}
+			decoded, err := DecodeSoft(tt.scheme, soft)
+			if err != nil {
+				t.Fatalf("DecodeSoft failed: %v", err)
+			}
+			if !reflect.DeepEqual(decoded, original) {
+				t.Errorf("expected %v, got %v", original, decoded)
+			}
+		})
+	}
+}
+
+func TestRepetition_EncodeFrameStream(t *testing.T) {
+	repetition5, err := NewRepetitionN(5)
+	if err != nil {
+		t.Fatalf("NewRepetitionN failed: %v", err)
+	}
+	frame := []byte("streamed frame")
+
+	for _, scheme := range []Scheme{&Repetition3{}, repetition5, &Hamming74{}} {
+		expected, err := scheme.EncodeFrame(frame)
+		if err != nil {
+			t.Fatalf("EncodeFrame failed: %v", err)
+		}
+		it, count, err := EncodeStream(scheme, frame)
+		if err != nil {
+			t.Fatalf("EncodeStream failed: %v", err)
+		}
+		if count != len(expected) {
+			t.Errorf("%T: expected count %d, got %d", scheme, len(expected), count)
+		}
+		if got := bitstream.Collect(it); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%T: streamed bits differ from EncodeFrame", scheme)
+		}
+	}
+}
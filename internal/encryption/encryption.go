@@ -0,0 +1,116 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+const (
+	// SaltSize is the size of the random PBKDF2 salt in bytes
+	SaltSize = 16
+	// NonceSize is the size of the random AES-GCM nonce in bytes
+	NonceSize = 12
+	// TagSize is the size of the AES-GCM authentication tag in bytes
+	TagSize = 16
+	// Overhead is the number of bytes Encrypt adds to the plaintext
+	Overhead = SaltSize + NonceSize + TagSize
+	// KeySize is the AES-256 key size in bytes
+	KeySize = 32
+	// Iterations is the PBKDF2-SHA256 iteration count used to derive the key
+	Iterations = 100000
+)
+
+var (
+	// ErrDecryptionFailed indicates the password is wrong or the ciphertext was modified
+	ErrDecryptionFailed = errors.New("decryption failed")
+)
+
+// deterministicLabel keys the derivation of EncryptDeterministic's salt and
+// nonce apart from other uses of the password
+const deterministicLabel = "emganography deterministic salt and nonce"
+
+// Encrypt encrypts plaintext with AES-256-GCM using a key derived from password
+// Output layout: salt (16 bytes) || nonce (12 bytes) || ciphertext || tag (16 bytes)
+func Encrypt(plaintext []byte, password string) ([]byte, error) {
+	out := make([]byte, SaltSize+NonceSize, SaltSize+NonceSize+len(plaintext)+TagSize)
+	if _, err := rand.Read(out); err != nil {
+		return nil, fmt.Errorf("failed to generate salt and nonce: %w", err)
+	}
+	return seal(out, plaintext, password)
+}
+
+// EncryptDeterministic encrypts like Encrypt, with the salt and nonce derived
+// from an HMAC-SHA256 of plaintext keyed with password instead of drawn at
+// random, so the same plaintext and password always give the same output
+// A nonce only repeats with the same salt, hence key, for the same
+// plaintext, but equal ciphertexts do reveal equal plaintexts
+// Decrypt reads its output like Encrypt's
+func EncryptDeterministic(plaintext []byte, password string) ([]byte, error) {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(deterministicLabel))
+	mac.Write(plaintext)
+	out := make([]byte, 0, SaltSize+NonceSize+len(plaintext)+TagSize)
+	out = mac.Sum(out)[:SaltSize+NonceSize]
+	return seal(out, plaintext, password)
+}
+
+// seal appends the AES-GCM encryption of plaintext to out, which holds the
+// salt and nonce
+func seal(out, plaintext []byte, password string) ([]byte, error) {
+	salt := out[:SaltSize]
+	nonce := out[SaltSize : SaltSize+NonceSize]
+
+	gcm, err := newGCM(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(out, nonce, plaintext, nil), nil
+}
+
+// Decrypt reverses Encrypt, returning ErrDecryptionFailed if the password
+// is wrong or the data was tampered with
+func Decrypt(data []byte, password string) ([]byte, error) {
+	if len(data) < Overhead {
+		return nil, ErrDecryptionFailed
+	}
+	salt := data[:SaltSize]
+	nonce := data[SaltSize : SaltSize+NonceSize]
+
+	gcm, err := newGCM(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, data[SaltSize+NonceSize:], nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return plaintext, nil
+}
+
+// DeriveKey derives a KeySize-byte key from password and salt with PBKDF2-SHA256
+func DeriveKey(password string, salt []byte) ([]byte, error) {
+	key, err := pbkdf2.Key(sha256.New, password, salt, Iterations, KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	return key, nil
+}
+
+// newGCM derives the AES key from password and salt and returns an AES-GCM AEAD
+func newGCM(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := DeriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,102 @@
+package encryption
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	plaintext := []byte("attack at dawn")
+
+	ciphertext, err := Encrypt(plaintext, "correct horse")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	if len(ciphertext) != len(plaintext)+Overhead {
+		t.Errorf("expected ciphertext length %d, got %d", len(plaintext)+Overhead, len(ciphertext))
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Errorf("ciphertext contains the plaintext")
+	}
+
+	decrypted, err := Decrypt(ciphertext, "correct horse")
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("expected %q, got %q", plaintext, decrypted)
+	}
+}
+
+func TestDecrypt_WrongPassword(t *testing.T) {
+	ciphertext, err := Encrypt([]byte("secret"), "right")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+
+	_, err = Decrypt(ciphertext, "wrong")
+	if err != ErrDecryptionFailed {
+		t.Errorf("expected ErrDecryptionFailed, got %v", err)
+	}
+}
+
+func TestDecrypt_Tampered(t *testing.T) {
+	ciphertext, err := Encrypt([]byte("secret"), "pw")
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	ciphertext[SaltSize+NonceSize] ^= 0x01
+
+	_, err = Decrypt(ciphertext, "pw")
+	if err != ErrDecryptionFailed {
+		t.Errorf("expected ErrDecryptionFailed, got %v", err)
+	}
+}
+
+func TestDecrypt_TooShort(t *testing.T) {
+	_, err := Decrypt(make([]byte, Overhead-1), "pw")
+	if err != ErrDecryptionFailed {
+		t.Errorf("expected ErrDecryptionFailed, got %v", err)
+	}
+}
+
+func TestEncryptDeterministic(t *testing.T) {
+	plaintext := []byte("attack at dawn")
+
+	first, err := EncryptDeterministic(plaintext, "correct horse")
+	if err != nil {
+		t.Fatalf("EncryptDeterministic failed: %v", err)
+	}
+	second, err := EncryptDeterministic(plaintext, "correct horse")
+	if err != nil {
+		t.Fatalf("EncryptDeterministic failed: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("expected identical ciphertexts for the same plaintext and password")
+	}
+
+	// Another plaintext or password gets another salt and nonce
+	other, err := EncryptDeterministic([]byte("attack at dusk"), "correct horse")
+	if err != nil {
+		t.Fatalf("EncryptDeterministic failed: %v", err)
+	}
+	if bytes.Equal(first[:SaltSize+NonceSize], other[:SaltSize+NonceSize]) {
+		t.Error("expected a different salt and nonce for another plaintext")
+	}
+	other, err = EncryptDeterministic(plaintext, "battery staple")
+	if err != nil {
+		t.Fatalf("EncryptDeterministic failed: %v", err)
+	}
+	if bytes.Equal(first[:SaltSize+NonceSize], other[:SaltSize+NonceSize]) {
+		t.Error("expected a different salt and nonce for another password")
+	}
+
+	decrypted, err := Decrypt(first, "correct horse")
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		t.Errorf("expected %q, got %q", plaintext, decrypted)
+	}
+}
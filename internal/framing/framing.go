@@ -9,12 +9,58 @@ import (
 const (
 	// Magic is the 4-byte magic identifier for the frame format
 	Magic = "EMG0"
-	// HeaderSize is the total size of the frame header in bytes
-	HeaderSize = 16
+	// HeaderSize is the total size of the current (version 2) frame header in bytes
+	HeaderSize = 18
+	// HeaderSizeV1 is the size of the version 1 header, which has no header CRC
+	HeaderSizeV1 = 16
 	// CurrentVersion is the current frame format version
-	CurrentVersion = 0x01
+	CurrentVersion = 0x02
+	// VersionHMAC is the version of frames with FlagHMAC set: the layout
+	// matches version 2, but readers that predate it would return the HMAC
+	// as part of the message, so they must fail with ErrUnsupportedVersion
+	VersionHMAC = 0x03
 )
 
+// Header flag bits (byte 6)
+const (
+	// FlagEncrypted indicates the payload is encrypted
+	FlagEncrypted uint8 = 1 << 0
+	// FlagCompressed indicates the payload is DEFLATE-compressed
+	FlagCompressed uint8 = 1 << 1
+	// FlagMetadata indicates a metadata section precedes the payload
+	FlagMetadata uint8 = 1 << 2
+	// FlagCRC32C indicates PayloadCRC32 uses the Castagnoli polynomial
+	// (CRC32C) rather than IEEE
+	FlagCRC32C uint8 = 1 << 3
+	// FlagHMAC indicates the payload ends with an HMAC-SHA256 of the bytes
+	// before it; it is only valid in VersionHMAC headers
+	FlagHMAC uint8 = 1 << 6
+)
+
+// Embedding methods, stored in bits 4-5 of the flags byte (byte 6): no
+// reserved byte is left in the header, and frames written before the method
+// existed have these bits clear, which reads as MethodDCT
+const (
+	// MethodDCT marks a frame embedded in DCT coefficients
+	MethodDCT uint8 = 0
+	// MethodLSB marks a frame embedded in pixel least significant bits
+	MethodLSB uint8 = 1
+
+	// methodShift is the position of the method bits in the flags byte
+	methodShift = 4
+	// methodMask selects the method bits of the flags byte
+	methodMask uint8 = 0x3 << methodShift
+	// maxMethod is the largest method the header can hold
+	maxMethod = methodMask >> methodShift
+
+	// reservedMask selects the flag bits no feature uses yet (bit 7), the
+	// header's only reserved space; before VersionHMAC, FlagHMAC is too
+	reservedMask uint8 = 0x80
+)
+
+// castagnoli is the CRC32C table used when FlagCRC32C is set
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 var (
 	// ErrInvalidMagic indicates the frame magic bytes don't match
 	ErrInvalidMagic = errors.New("invalid frame magic")
@@ -24,39 +70,114 @@ var (
 	ErrCRCMismatch = errors.New("CRC32 checksum mismatch")
 	// ErrFrameTooShort indicates the frame is shorter than the header
 	ErrFrameTooShort = errors.New("frame too short")
+	// ErrUnsupportedVersion indicates the frame was written by an unknown format version
+	ErrUnsupportedVersion = errors.New("unsupported frame version")
+	// ErrHeaderCRCMismatch indicates the header CRC16 doesn't match, so no header field can be trusted
+	ErrHeaderCRCMismatch = errors.New("header CRC16 checksum mismatch")
+	// ErrInvalidMethod indicates an embedding method too large for the header
+	ErrInvalidMethod = errors.New("invalid embedding method")
+	// ErrReservedNonZero indicates reserved header bits are set, so the frame
+	// was probably written by a newer format this version would misread
+	ErrReservedNonZero = errors.New("reserved header bits are non-zero")
 )
 
 // Header represents the frame header structure
 // Byte layout:
 //   0-3:   Magic ("EMG0")
-//   4:     Version (0x01)
+//   4:     Version (0x02, or 0x03 with FlagHMAC; 0x01 headers end after byte 15)
 //   5:     ECCScheme (1 byte)
-//   6-7:   Reserved (0x00 0x00)
+//   6:     Flags (bitfield, see Flag* constants; bits 4-5 hold the Method)
+//   7:     Stream (identifies one of several frames in an image; 0x00 by default)
 //   8-11:  PayloadLength (big-endian uint32)
-//   12-15: PayloadCRC32 (big-endian CRC32-IEEE)
+//   12-15: PayloadCRC32 (big-endian CRC32-IEEE, or CRC32C with FlagCRC32C)
+//   16-17: HeaderCRC16 (big-endian CRC-16/CCITT-FALSE over bytes 0-15)
+// PayloadLength and PayloadCRC32 cover everything after the header, i.e.
+// the metadata section (if FlagMetadata is set) and the payload
+// Reserved space policy: flag bit 7 (and bit 6 before VersionHMAC) is
+// reserved and must be zero; strict parsing rejects them with ErrReservedNonZero rather
+// than silently ignoring a future feature. A feature that starts using them
+// must also bump the version, so older readers fail with
+// ErrUnsupportedVersion instead
 type Header struct {
 	Magic         string
 	Version       uint8
 	ECCScheme     uint8
-	Reserved      [2]byte
+	Flags         uint8
+	Stream        uint8
 	PayloadLength uint32
 	PayloadCRC32  uint32
+	// HeaderCRC16 is the header checksum (zero for version 1 headers)
+	HeaderCRC16 uint16
+	// Method is the embedding method (MethodDCT or MethodLSB), which shares
+	// the flags byte; Flags doesn't include its bits
+	Method uint8
+	// Metadata holds the parsed metadata section (nil without FlagMetadata)
+	Metadata map[string]string
 }
 
 // BuildFrame constructs a frame from a message and ECC scheme.
 // The frame consists of: header (16 bytes) || message bytes
 func BuildFrame(message []byte, eccScheme uint8) ([]byte, error) {
+	return BuildFrameWithFlags(message, eccScheme, 0)
+}
+
+// BuildFrameWithFlags constructs a frame like BuildFrame, setting the header flags byte
+// Returns ErrReservedNonZero if flags sets reserved bits
+func BuildFrameWithFlags(message []byte, eccScheme uint8, flags uint8) ([]byte, error) {
+	return BuildFrameWithMetadata(message, eccScheme, flags, nil)
+}
+
+// BuildFrameWithMetadata constructs a frame like BuildFrameWithFlags, adding
+// a metadata section with the given key-value pairs
+// An empty metadata map produces the same frame as BuildFrameWithFlags
+func BuildFrameWithMetadata(message []byte, eccScheme uint8, flags uint8, metadata map[string]string) ([]byte, error) {
+	return BuildFrameWithStream(message, eccScheme, flags, metadata, 0)
+}
+
+// BuildFrameWithStream constructs a frame like BuildFrameWithMetadata,
+// setting the header's stream ID
+func BuildFrameWithStream(message []byte, eccScheme uint8, flags uint8, metadata map[string]string, stream uint8) ([]byte, error) {
+	return BuildFrameWithMethod(message, eccScheme, flags, metadata, stream, MethodDCT)
+}
+
+// BuildFrameWithMethod constructs a frame like BuildFrameWithStream,
+// recording the embedding method in the header
+// Returns ErrInvalidMethod if method doesn't fit the header's method bits,
+// and ErrReservedNonZero if flags sets reserved bits, which no reader would
+// accept
+func BuildFrameWithMethod(message []byte, eccScheme uint8, flags uint8, metadata map[string]string, stream uint8, method uint8) ([]byte, error) {
+	if method > maxMethod {
+		return nil, ErrInvalidMethod
+	}
+	if flags&reservedMask != 0 {
+		return nil, ErrReservedNonZero
+	}
+	flags &^= FlagMetadata | methodMask
+	if len(metadata) > 0 {
+		section, err := EncodeMetadata(metadata)
+		if err != nil {
+			return nil, err
+		}
+		message = append(section, message...)
+		flags |= FlagMetadata
+	}
+
 	// Calculate CRC32 of the message (payload only, no header)
-	crc := crc32.ChecksumIEEE(message)
+	crc := payloadCRC(message, flags)
 
 	// Build header
 	header := make([]byte, HeaderSize)
 	copy(header[0:4], []byte(Magic))
 	header[4] = CurrentVersion
+	if flags&FlagHMAC != 0 {
+		header[4] = VersionHMAC
+	}
 	header[5] = eccScheme
-	// Reserved bytes [6-7] are already 0x00
+	header[6] = flags | method<<methodShift
+	header[7] = stream
 	binary.BigEndian.PutUint32(header[8:12], uint32(len(message)))
 	binary.BigEndian.PutUint32(header[12:16], crc)
+	binary.BigEndian.PutUint16(header[16:18], crc16(header[0:16]))
 
 	// Frame = header || message
 	frame := make([]byte, HeaderSize+len(message))
@@ -66,43 +187,198 @@ func BuildFrame(message []byte, eccScheme uint8) ([]byte, error) {
 	return frame, nil
 }
 
-// ParseFrame parses a frame and validates its structure.
-// Returns the header, payload bytes, and any error encountered.
-func ParseFrame(frame []byte) (*Header, []byte, error) {
-	if len(frame) < HeaderSize {
-		return nil, nil, ErrFrameTooShort
+// Parser parses frames and headers; the zero value parses strictly, as the
+// package-level functions do
+type Parser struct {
+	// Lenient accepts headers with reserved bits set instead of returning
+	// ErrReservedNonZero; the bits are left in Flags, uninterpreted
+	Lenient bool
+}
+
+// ParseHeader parses and validates the frame header at the start of data
+// Version 2 headers are checked against their CRC16 before any field is
+// trusted; version 1 headers (no header CRC) are still accepted
+// Returns the header and its size in bytes. With ErrUnsupportedVersion the
+// header is returned too, with only Magic and Version set
+// Returns ErrReservedNonZero if reserved bits are set (see Header)
+// This is the only place header bytes are decoded; ParseFrame and extraction
+// build on it
+func ParseHeader(data []byte) (*Header, int, error) {
+	return Parser{}.ParseHeader(data)
+}
+
+// ParseHeader parses the frame header at the start of data like the
+// package-level ParseHeader, tolerating reserved bits if p.Lenient is set
+func (p Parser) ParseHeader(data []byte) (*Header, int, error) {
+	if len(data) < HeaderSizeV1 {
+		return nil, 0, ErrFrameTooShort
 	}
 
 	// Extract magic
-	magic := string(frame[0:4])
+	magic := string(data[0:4])
 	if magic != Magic {
-		return nil, nil, ErrInvalidMagic
+		return nil, 0, ErrInvalidMagic
+	}
+
+	headerSize := HeaderSize
+	switch data[4] {
+	case 0x01:
+		headerSize = HeaderSizeV1
+	case CurrentVersion, VersionHMAC:
+		if len(data) < HeaderSize {
+			return nil, 0, ErrFrameTooShort
+		}
+	default:
+		// Report the version, so callers needn't read it from the bytes
+		return &Header{Magic: magic, Version: data[4]}, 0, ErrUnsupportedVersion
 	}
 
 	// Extract header fields
 	header := &Header{
 		Magic:     magic,
-		Version:   frame[4],
-		ECCScheme: frame[5],
+		Version:   data[4],
+		ECCScheme: data[5],
+		Flags:     data[6] &^ methodMask,
+		Stream:    data[7],
+		Method:    (data[6] & methodMask) >> methodShift,
+	}
+	header.PayloadLength = binary.BigEndian.Uint32(data[8:12])
+	header.PayloadCRC32 = binary.BigEndian.Uint32(data[12:16])
+	if headerSize == HeaderSize {
+		header.HeaderCRC16 = binary.BigEndian.Uint16(data[16:18])
+		if crc16(data[0:16]) != header.HeaderCRC16 {
+			return nil, 0, ErrHeaderCRCMismatch
+		}
+	}
+	// Checked after the header CRC, so set bits mean a different writer
+	// rather than corruption
+	reserved := reservedMask
+	if header.Version < VersionHMAC {
+		reserved |= FlagHMAC
+	}
+	if data[6]&reserved != 0 && !p.Lenient {
+		return nil, 0, ErrReservedNonZero
+	}
+
+	return header, headerSize, nil
+}
+
+// ParseFrame parses a frame and validates its structure.
+// Returns the header, payload bytes, and any error encountered.
+func ParseFrame(frame []byte) (*Header, []byte, error) {
+	return Parser{}.ParseFrame(frame)
+}
+
+// ParseFrame parses a frame like the package-level ParseFrame, tolerating
+// reserved bits if p.Lenient is set
+func (p Parser) ParseFrame(frame []byte) (*Header, []byte, error) {
+	header, payload, crcOK, err := p.ParseFrameUnverified(frame)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !crcOK {
+		return nil, nil, ErrCRCMismatch
+	}
+	return header, payload, nil
+}
+
+// ParseFrameUnverified parses a frame like ParseFrame, but returns the payload
+// even when it fails the payload CRC, reporting the check in crcOK
+// The header itself must still be valid. If an unverified payload's metadata
+// section doesn't decode, the whole payload is returned without splitting it
+func ParseFrameUnverified(frame []byte) (header *Header, payload []byte, crcOK bool, err error) {
+	return Parser{}.ParseFrameUnverified(frame)
+}
+
+// ParseFrameUnverified parses a frame like the package-level
+// ParseFrameUnverified, tolerating reserved bits if p.Lenient is set
+func (p Parser) ParseFrameUnverified(frame []byte) (header *Header, payload []byte, crcOK bool, err error) {
+	header, headerSize, err := p.ParseHeader(frame)
+	if err != nil {
+		return nil, nil, false, err
 	}
-	copy(header.Reserved[:], frame[6:8])
-	header.PayloadLength = binary.BigEndian.Uint32(frame[8:12])
-	header.PayloadCRC32 = binary.BigEndian.Uint32(frame[12:16])
 
 	// Extract payload
-	if len(frame) < HeaderSize+int(header.PayloadLength) {
-		return nil, nil, ErrInvalidLength
+	if len(frame) < headerSize+int(header.PayloadLength) {
+		return nil, nil, false, ErrInvalidLength
 	}
-	payload := frame[HeaderSize : HeaderSize+int(header.PayloadLength)]
+	payload = frame[headerSize : headerSize+int(header.PayloadLength)]
 
 	// Validate CRC32
-	calculatedCRC := crc32.ChecksumIEEE(payload)
-	if calculatedCRC != header.PayloadCRC32 {
-		return nil, nil, ErrCRCMismatch
+	crcOK = payloadCRC(payload, header.Flags) == header.PayloadCRC32
+
+	// Split off the metadata section
+	if header.Flags&FlagMetadata != 0 {
+		metadata, n, err := decodeMetadata(payload)
+		if err != nil {
+			if !crcOK {
+				return header, payload, false, nil
+			}
+			return nil, nil, false, err
+		}
+		header.Metadata = metadata
+		payload = payload[n:]
 	}
 
-	return header, payload, nil
+	return header, payload, crcOK, nil
 }
 
+// ParseFramePrefix parses the start of a frame that may be cut off before its
+// end (e.g. read from a cropped image), returning the header and as much of
+// the payload as frame holds; complete reports whether all of it was there
+// The payload CRC isn't checked. Returns ErrInvalidLength if the frame is cut
+// off inside the metadata section
+func ParseFramePrefix(frame []byte) (header *Header, payload []byte, complete bool, err error) {
+	return Parser{}.ParseFramePrefix(frame)
+}
+
+// ParseFramePrefix parses a frame prefix like the package-level
+// ParseFramePrefix, tolerating reserved bits if p.Lenient is set
+func (p Parser) ParseFramePrefix(frame []byte) (header *Header, payload []byte, complete bool, err error) {
+	header, headerSize, err := p.ParseHeader(frame)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	end := headerSize + int(header.PayloadLength)
+	complete = len(frame) >= end
+	payload = frame[headerSize:min(len(frame), end)]
 
+	if header.Flags&FlagMetadata != 0 {
+		metadata, n, err := decodeMetadata(payload)
+		if err != nil {
+			if !complete {
+				return nil, nil, false, ErrInvalidLength
+			}
+			return nil, nil, false, err
+		}
+		header.Metadata = metadata
+		payload = payload[n:]
+	}
+
+	return header, payload, complete, nil
+}
+
+// payloadCRC computes the payload checksum with the algorithm selected by flags
+func payloadCRC(data []byte, flags uint8) uint32 {
+	if flags&FlagCRC32C != 0 {
+		return crc32.Checksum(data, castagnoli)
+	}
+	return crc32.ChecksumIEEE(data)
+}
 
+// crc16 computes the CRC-16/CCITT-FALSE checksum (polynomial 0x1021, initial value 0xFFFF)
+func crc16(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -1,6 +1,13 @@
 package framing
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"reflect"
 	"testing"
 )
 
@@ -68,6 +75,128 @@ func TestParseFrame_InvalidMagic(t *testing.T) {
 	}
 }
 
+func TestParseFrame_UnsupportedVersion(t *testing.T) {
+	frame, err := BuildFrame([]byte("hello"), 1)
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	frame[4] = 0xFF
+
+	_, _, err = ParseFrame(frame)
+	if err != ErrUnsupportedVersion {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestParseHeader_Errors(t *testing.T) {
+	frame, err := BuildFrame([]byte("payload"), 1)
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+
+	// The header alone is enough; the payload is never looked at
+	header, size, err := ParseHeader(frame[:HeaderSize])
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if size != HeaderSize || header.PayloadLength != 7 || header.ECCScheme != 1 {
+		t.Errorf("unexpected header %+v (size %d)", header, size)
+	}
+
+	badMagic := append([]byte("XXXX"), frame[4:HeaderSize]...)
+	corrupt := append([]byte{}, frame[:HeaderSize]...)
+	corrupt[9] ^= 0x01
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "empty", data: nil, want: ErrFrameTooShort},
+		{name: "shorter than version 1", data: frame[:HeaderSizeV1-1], want: ErrFrameTooShort},
+		{name: "version 2 without its CRC", data: frame[:HeaderSize-1], want: ErrFrameTooShort},
+		{name: "bad magic", data: badMagic, want: ErrInvalidMagic},
+		{name: "bad magic, short", data: []byte("XXXX\x02"), want: ErrFrameTooShort},
+		{name: "header CRC", data: corrupt, want: ErrHeaderCRCMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ParseHeader(tt.data); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseHeader_UnsupportedVersionReportsVersion(t *testing.T) {
+	frame, err := BuildFrame([]byte("future"), 1)
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	frame[4] = 0x09
+
+	header, _, err := ParseHeader(frame)
+	if err != ErrUnsupportedVersion {
+		t.Fatalf("expected ErrUnsupportedVersion, got %v", err)
+	}
+	if header == nil || header.Version != 0x09 {
+		t.Errorf("expected the header to report version 9, got %+v", header)
+	}
+}
+
+func TestParseHeader_AgreesWithParseFrame(t *testing.T) {
+	// A payload length with every byte distinct catches byte order mistakes
+	long := make([]byte, 0x010203)
+
+	v1 := make([]byte, HeaderSizeV1+5)
+	copy(v1[0:4], Magic)
+	v1[4] = 0x01
+	v1[5] = 2
+	binary.BigEndian.PutUint32(v1[8:12], 5)
+	binary.BigEndian.PutUint32(v1[12:16], crc32.ChecksumIEEE(v1[HeaderSizeV1:]))
+
+	build := func(message []byte, flags uint8, metadata map[string]string, stream, method uint8) []byte {
+		frame, err := BuildFrameWithMethod(message, 3, flags, metadata, stream, method)
+		if err != nil {
+			t.Fatalf("BuildFrameWithMethod failed: %v", err)
+		}
+		return frame
+	}
+	tests := []struct {
+		name  string
+		frame []byte
+	}{
+		{name: "plain", frame: build([]byte("hello"), 0, nil, 0, MethodDCT)},
+		{name: "long payload", frame: build(long, FlagCRC32C, nil, 0, MethodDCT)},
+		{name: "flags stream method", frame: build([]byte("x"), FlagEncrypted|FlagCompressed, nil, 9, MethodLSB)},
+		{name: "metadata", frame: build([]byte("x"), 0, map[string]string{"k": "v"}, 0, MethodDCT)},
+		{name: "version 1", frame: v1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			full, _, err := ParseFrame(tt.frame)
+			if err != nil {
+				t.Fatalf("ParseFrame failed: %v", err)
+			}
+			header, size, err := ParseHeader(tt.frame[:min(HeaderSize, len(tt.frame))])
+			if err != nil {
+				t.Fatalf("ParseHeader failed: %v", err)
+			}
+
+			// ParseHeader leaves the metadata section to ParseFrame
+			full.Metadata = nil
+			if !reflect.DeepEqual(header, full) {
+				t.Errorf("ParseHeader and ParseFrame disagree:\n header %+v\n frame  %+v", header, full)
+			}
+			if got := binary.BigEndian.Uint32(tt.frame[8:12]); header.PayloadLength != got {
+				t.Errorf("expected big-endian payload length %d, got %d", got, header.PayloadLength)
+			}
+			if want := len(tt.frame) - int(header.PayloadLength); size != want {
+				t.Errorf("expected header size %d, got %d", want, size)
+			}
+		})
+	}
+}
+
 func TestParseFrame_CRCMismatch(t *testing.T) {
 	message := []byte("hello")
 	eccScheme := uint8(1)
@@ -88,3 +217,442 @@ func TestParseFrame_CRCMismatch(t *testing.T) {
 
 
 
+
+func TestBuildFrameWithFlags(t *testing.T) {
+	message := []byte("flagged")
+
+	frame, err := BuildFrameWithFlags(message, 1, FlagEncrypted)
+	if err != nil {
+		t.Fatalf("BuildFrameWithFlags failed: %v", err)
+	}
+
+	header, payload, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+	if header.Flags != FlagEncrypted {
+		t.Errorf("expected flags %#x, got %#x", FlagEncrypted, header.Flags)
+	}
+	if string(payload) != string(message) {
+		t.Errorf("expected payload %s, got %s", message, payload)
+	}
+}
+
+func TestBuildFrameWithMetadata(t *testing.T) {
+	message := []byte("payload")
+	metadata := map[string]string{
+		"content-type": "text/plain",
+		"filename":     "notes.txt",
+		"timestamp":    "2024-01-02T03:04:05Z",
+		"empty":        "",
+	}
+
+	frame, err := BuildFrameWithMetadata(message, 1, FlagCompressed, metadata)
+	if err != nil {
+		t.Fatalf("BuildFrameWithMetadata failed: %v", err)
+	}
+
+	header, payload, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+	if header.Flags != FlagCompressed|FlagMetadata {
+		t.Errorf("expected flags %#x, got %#x", FlagCompressed|FlagMetadata, header.Flags)
+	}
+	if !reflect.DeepEqual(header.Metadata, metadata) {
+		t.Errorf("metadata mismatch: expected %v, got %v", metadata, header.Metadata)
+	}
+	if string(payload) != string(message) {
+		t.Errorf("expected payload %s, got %s", message, payload)
+	}
+}
+
+func TestBuildFrameWithStream(t *testing.T) {
+	message := []byte("second stream")
+
+	frame, err := BuildFrameWithStream(message, 1, 0, nil, 7)
+	if err != nil {
+		t.Fatalf("BuildFrameWithStream failed: %v", err)
+	}
+
+	header, payload, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+	if header.Stream != 7 {
+		t.Errorf("expected stream 7, got %d", header.Stream)
+	}
+	if string(payload) != string(message) {
+		t.Errorf("expected payload %s, got %s", message, payload)
+	}
+}
+
+func TestBuildFrameWithMethod(t *testing.T) {
+	frame, err := BuildFrameWithMethod([]byte("lsb"), 1, FlagCompressed, nil, 0, MethodLSB)
+	if err != nil {
+		t.Fatalf("BuildFrameWithMethod failed: %v", err)
+	}
+	header, _, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+	if header.Method != MethodLSB {
+		t.Errorf("expected method %d, got %d", MethodLSB, header.Method)
+	}
+	if header.Flags != FlagCompressed {
+		t.Errorf("expected flags %#x without the method bits, got %#x", FlagCompressed, header.Flags)
+	}
+
+	// Frames built without a method read as DCT
+	frame, err = BuildFrame([]byte("dct"), 1)
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	if header, _, err := ParseFrame(frame); err != nil || header.Method != MethodDCT {
+		t.Errorf("expected MethodDCT, got %+v (err %v)", header, err)
+	}
+
+	if _, err := BuildFrameWithMethod(nil, 1, 0, nil, 0, maxMethod+1); !errors.Is(err, ErrInvalidMethod) {
+		t.Errorf("expected ErrInvalidMethod, got %v", err)
+	}
+}
+
+func TestBuildFrameWithMetadata_EmptyMatchesOldFrame(t *testing.T) {
+	message := []byte("payload")
+
+	old, err := BuildFrameWithFlags(message, 1, 0)
+	if err != nil {
+		t.Fatalf("BuildFrameWithFlags failed: %v", err)
+	}
+	frame, err := BuildFrameWithMetadata(message, 1, 0, nil)
+	if err != nil {
+		t.Fatalf("BuildFrameWithMetadata failed: %v", err)
+	}
+	if !reflect.DeepEqual(old, frame) {
+		t.Errorf("expected frame without metadata to be unchanged")
+	}
+
+	header, payload, err := ParseFrame(old)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+	if header.Metadata != nil || string(payload) != string(message) {
+		t.Errorf("expected plain payload and no metadata, got %q and %v", payload, header.Metadata)
+	}
+}
+
+func TestBuildFrameWithMetadata_Errors(t *testing.T) {
+	longKey := string(make([]byte, MaxMetadataKeyLen+1))
+	if _, err := BuildFrameWithMetadata(nil, 1, 0, map[string]string{longKey: "v"}); err != ErrMetadataTooLarge {
+		t.Errorf("expected ErrMetadataTooLarge, got %v", err)
+	}
+
+	// A section length that runs past the payload doesn't parse
+	frame, err := BuildFrameWithMetadata([]byte("x"), 1, 0, map[string]string{"k": "v"})
+	if err != nil {
+		t.Fatalf("BuildFrameWithMetadata failed: %v", err)
+	}
+	frame[HeaderSize] = 0xFF
+	binary.BigEndian.PutUint32(frame[12:16], crc32.ChecksumIEEE(frame[HeaderSize:]))
+	binary.BigEndian.PutUint16(frame[16:18], crc16(frame[0:16]))
+	if _, _, err := ParseFrame(frame); err != ErrInvalidMetadata {
+		t.Errorf("expected ErrInvalidMetadata, got %v", err)
+	}
+}
+
+func TestParseFrame_HeaderCRCMismatch(t *testing.T) {
+	frame, err := BuildFrame([]byte("hello"), 1)
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+
+	// Flip a bit in the payload length
+	frame[9] ^= 0x01
+
+	_, _, err = ParseFrame(frame)
+	if err != ErrHeaderCRCMismatch {
+		t.Errorf("expected ErrHeaderCRCMismatch, got %v", err)
+	}
+}
+
+func TestParseFrame_Version1(t *testing.T) {
+	message := []byte("hello")
+
+	// Version 1 frames have a 16-byte header without a header CRC
+	frame := make([]byte, HeaderSizeV1+len(message))
+	copy(frame[0:4], Magic)
+	frame[4] = 0x01
+	frame[5] = 1
+	binary.BigEndian.PutUint32(frame[8:12], uint32(len(message)))
+	binary.BigEndian.PutUint32(frame[12:16], crc32.ChecksumIEEE(message))
+	copy(frame[HeaderSizeV1:], message)
+
+	header, payload, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+	if header.Version != 0x01 || string(payload) != string(message) {
+		t.Errorf("expected version 1 frame with payload %q, got version %d and %q", message, header.Version, payload)
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	// Standard check value for CRC-16/CCITT-FALSE
+	if got := crc16([]byte("123456789")); got != 0x29B1 {
+		t.Errorf("expected 0x29B1, got %#04x", got)
+	}
+}
+
+func TestHeader_MarshalJSON(t *testing.T) {
+	frame, err := BuildFrameWithStream([]byte("hello"), 2, FlagCompressed|FlagCRC32C, map[string]string{"k": "v"}, 3)
+	if err != nil {
+		t.Fatalf("BuildFrameWithStream failed: %v", err)
+	}
+	header, _, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+
+	want := map[string]any{
+		"magic":          "EMG0",
+		"version":        float64(CurrentVersion),
+		"ecc_scheme":     float64(2),
+		"flags":          []any{"compressed", "metadata", "crc32c"},
+		"stream":         float64(3),
+		"payload_length": float64(header.PayloadLength),
+		"payload_crc32":  fmt.Sprintf("0x%08x", header.PayloadCRC32),
+		"header_crc16":   fmt.Sprintf("0x%04x", header.HeaderCRC16),
+		"metadata":       map[string]any{"k": "v"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON mismatch:\n got  %s\n want %v", data, want)
+	}
+
+	// Version 1 headers have no header CRC, and no flags renders as []
+	v1 := Header{Magic: Magic, Version: 0x01, ECCScheme: 1}
+	data, err = json.Marshal(v1)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	expected := `{"magic":"EMG0","version":1,"ecc_scheme":1,"flags":[],"stream":0,"payload_length":0,"payload_crc32":"0x00000000"}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+
+	// Unknown bits, as a lenient parse leaves them, render as hex
+	v1.Flags = FlagCompressed | reservedMask
+	data, err = json.Marshal(v1)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`"flags":["compressed","0x80"]`)) {
+		t.Errorf("expected the reserved bit rendered as 0x80, got %s", data)
+	}
+}
+
+func TestParseFrameUnverified_ReturnsCorruptPayload(t *testing.T) {
+	frame, err := BuildFrame([]byte("hello"), 1)
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	frame[HeaderSize+1] ^= 0x01
+
+	if _, _, err := ParseFrame(frame); !errors.Is(err, ErrCRCMismatch) {
+		t.Errorf("ParseFrame: expected ErrCRCMismatch, got %v", err)
+	}
+	_, payload, crcOK, err := ParseFrameUnverified(frame)
+	if err != nil {
+		t.Fatalf("ParseFrameUnverified failed: %v", err)
+	}
+	if crcOK {
+		t.Error("expected crcOK to be false")
+	}
+	if string(payload) != "hdllo" {
+		t.Errorf("expected payload hdllo, got %s", payload)
+	}
+}
+
+func TestParseFrame_ReservedBits(t *testing.T) {
+	message := []byte("reserved")
+	clean, err := BuildFrameWithFlags(message, 1, FlagCompressed)
+	if err != nil {
+		t.Fatalf("BuildFrameWithFlags failed: %v", err)
+	}
+	if _, _, err := ParseFrame(clean); err != nil {
+		t.Fatalf("ParseFrame with zero reserved bits failed: %v", err)
+	}
+
+	// The builder refuses to set reserved bits
+	if _, err := BuildFrameWithFlags(message, 1, FlagCompressed|reservedMask); !errors.Is(err, ErrReservedNonZero) {
+		t.Errorf("BuildFrameWithFlags: expected ErrReservedNonZero, got %v", err)
+	}
+
+	// The reserved bit, in a version 2 header (valid header CRC) and a
+	// version 1 header (none)
+	v2 := bytes.Clone(clean)
+	v2[6] |= reservedMask
+	binary.BigEndian.PutUint16(v2[16:18], crc16(v2[0:16]))
+	// FlagHMAC is still reserved in a version 2 header
+	hmacV2, err := BuildFrameWithFlags(message, 1, FlagHMAC)
+	if err != nil {
+		t.Fatalf("BuildFrameWithFlags failed: %v", err)
+	}
+	hmacV2[4] = CurrentVersion
+	binary.BigEndian.PutUint16(hmacV2[16:18], crc16(hmacV2[0:16]))
+	v1 := make([]byte, HeaderSizeV1+len(message))
+	copy(v1[0:4], Magic)
+	v1[4] = 0x01
+	v1[5] = 1
+	v1[6] = 0x80
+	binary.BigEndian.PutUint32(v1[8:12], uint32(len(message)))
+	binary.BigEndian.PutUint32(v1[12:16], crc32.ChecksumIEEE(message))
+	copy(v1[HeaderSizeV1:], message)
+
+	for name, frame := range map[string][]byte{"version 2": v2, "version 1": v1, "version 2 FlagHMAC": hmacV2} {
+		if _, _, err := ParseFrame(frame); !errors.Is(err, ErrReservedNonZero) {
+			t.Errorf("%s: ParseFrame: expected ErrReservedNonZero, got %v", name, err)
+		}
+		if _, _, err := ParseHeader(frame); !errors.Is(err, ErrReservedNonZero) {
+			t.Errorf("%s: ParseHeader: expected ErrReservedNonZero, got %v", name, err)
+		}
+
+		header, payload, err := Parser{Lenient: true}.ParseFrame(frame)
+		if err != nil {
+			t.Fatalf("%s: lenient ParseFrame failed: %v", name, err)
+		}
+		if string(payload) != string(message) {
+			t.Errorf("%s: expected payload %q, got %q", name, message, payload)
+		}
+		if header.Flags&(reservedMask|FlagHMAC) == 0 {
+			t.Errorf("%s: expected the reserved bits left in Flags, got %#02x", name, header.Flags)
+		}
+	}
+}
+
+func TestBuildFrame_HMACVersion(t *testing.T) {
+	frame, err := BuildFrameWithFlags([]byte("signed"), 1, FlagHMAC)
+	if err != nil {
+		t.Fatalf("BuildFrameWithFlags failed: %v", err)
+	}
+	if frame[4] != VersionHMAC {
+		t.Errorf("expected version %d with FlagHMAC, got %d", VersionHMAC, frame[4])
+	}
+	header, _, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+	if header.Flags&FlagHMAC == 0 {
+		t.Errorf("expected FlagHMAC in Flags, got %#02x", header.Flags)
+	}
+
+	plain, err := BuildFrame([]byte("unsigned"), 1)
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	if plain[4] != CurrentVersion {
+		t.Errorf("expected version %d without FlagHMAC, got %d", CurrentVersion, plain[4])
+	}
+}
+
+func TestParseFramePrefix(t *testing.T) {
+	message := []byte("a message cut short")
+	frame, err := BuildFrameWithMetadata(message, 1, 0, map[string]string{"k": "v"})
+	if err != nil {
+		t.Fatalf("BuildFrameWithMetadata failed: %v", err)
+	}
+	metadataEnd := HeaderSize + MetadataSize(map[string]string{"k": "v"})
+
+	header, payload, complete, err := ParseFramePrefix(frame)
+	if err != nil || !complete || string(payload) != string(message) {
+		t.Fatalf("whole frame: expected complete %q, got %q (complete %v, err %v)", message, payload, complete, err)
+	}
+	if header.Metadata["k"] != "v" {
+		t.Errorf("expected metadata k=v, got %v", header.Metadata)
+	}
+
+	header, payload, complete, err = ParseFramePrefix(frame[:metadataEnd+5])
+	if err != nil {
+		t.Fatalf("truncated frame: ParseFramePrefix failed: %v", err)
+	}
+	if complete || string(payload) != string(message[:5]) {
+		t.Errorf("truncated frame: expected incomplete %q, got %q (complete %v)", message[:5], payload, complete)
+	}
+	if header.Metadata["k"] != "v" {
+		t.Errorf("truncated frame: expected metadata k=v, got %v", header.Metadata)
+	}
+
+	if _, _, _, err := ParseFramePrefix(frame[:metadataEnd-1]); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("frame cut inside the metadata: expected ErrInvalidLength, got %v", err)
+	}
+}
+
+func TestParseFrame_NoFixedPayloadCeiling(t *testing.T) {
+	// The payload length is bounded only by the carrier's capacity, checked
+	// by extraction, so frames past the old 1,000,000-byte limit parse
+	message := make([]byte, 2_000_000)
+	for i := range message {
+		message[i] = byte(i * 7)
+	}
+	frame, err := BuildFrame(message, 1)
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+
+	header, _, err := ParseHeader(frame[:HeaderSize])
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %v", err)
+	}
+	if header.PayloadLength != uint32(len(message)) {
+		t.Errorf("expected payload length %d, got %d", len(message), header.PayloadLength)
+	}
+	_, payload, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatalf("ParseFrame failed: %v", err)
+	}
+	if !bytes.Equal(payload, message) {
+		t.Error("payload mismatch")
+	}
+}
+
+func TestPayloadCRC_Algorithms(t *testing.T) {
+	message := []byte("checksummed payload")
+
+	for _, tc := range []struct {
+		name  string
+		flags uint8
+		want  uint32
+	}{
+		{"IEEE", 0, crc32.ChecksumIEEE(message)},
+		{"Castagnoli", FlagCRC32C, crc32.Checksum(message, crc32.MakeTable(crc32.Castagnoli))},
+	} {
+		frame, err := BuildFrameWithFlags(message, 1, tc.flags)
+		if err != nil {
+			t.Fatalf("%s: BuildFrameWithFlags failed: %v", tc.name, err)
+		}
+
+		header, payload, err := ParseFrame(frame)
+		if err != nil {
+			t.Fatalf("%s: ParseFrame failed: %v", tc.name, err)
+		}
+		if header.PayloadCRC32 != tc.want {
+			t.Errorf("%s: expected CRC %#08x, got %#08x", tc.name, tc.want, header.PayloadCRC32)
+		}
+		if string(payload) != string(message) {
+			t.Errorf("%s: expected payload %s, got %s", tc.name, message, payload)
+		}
+
+		frame[HeaderSize] ^= 0x01
+		if _, _, err := ParseFrame(frame); err != ErrCRCMismatch {
+			t.Errorf("%s: expected ErrCRCMismatch, got %v", tc.name, err)
+		}
+	}
+}
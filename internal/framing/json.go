@@ -0,0 +1,68 @@
+package framing
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// flagNames names the header flag bits in MarshalJSON output, in bit order
+var flagNames = []struct {
+	flag uint8
+	name string
+}{
+	{FlagEncrypted, "encrypted"},
+	{FlagCompressed, "compressed"},
+	{FlagMetadata, "metadata"},
+	{FlagCRC32C, "crc32c"},
+	{FlagHMAC, "hmac"},
+}
+
+// headerJSON is the JSON form of a Header
+type headerJSON struct {
+	Magic         string            `json:"magic"`
+	Version       uint8             `json:"version"`
+	ECCScheme     uint8             `json:"ecc_scheme"`
+	Flags         []string          `json:"flags"`
+	Stream        uint8             `json:"stream"`
+	Method        uint8             `json:"method,omitempty"`
+	PayloadLength uint32            `json:"payload_length"`
+	PayloadCRC32  string            `json:"payload_crc32"`
+	HeaderCRC16   string            `json:"header_crc16,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// MarshalJSON renders the header readably: flags as a list of names (unknown
+// bits as "0x.." values) and checksums as hex strings
+// A version 1 header has no header CRC, so header_crc16 is omitted, and
+// method is omitted for MethodDCT
+func (h Header) MarshalJSON() ([]byte, error) {
+	out := headerJSON{
+		Magic:         h.Magic,
+		Version:       h.Version,
+		ECCScheme:     h.ECCScheme,
+		Flags:         []string{},
+		Stream:        h.Stream,
+		Method:        h.Method,
+		PayloadLength: h.PayloadLength,
+		PayloadCRC32:  fmt.Sprintf("0x%08x", h.PayloadCRC32),
+		Metadata:      h.Metadata,
+	}
+	if h.Version != 0x01 {
+		out.HeaderCRC16 = fmt.Sprintf("0x%04x", h.HeaderCRC16)
+	}
+
+	rest := h.Flags
+	for _, f := range flagNames {
+		if rest&f.flag != 0 {
+			out.Flags = append(out.Flags, f.name)
+			rest &^= f.flag
+		}
+	}
+	for bit := uint8(1); bit != 0; bit <<= 1 {
+		if rest&bit != 0 {
+			out.Flags = append(out.Flags, fmt.Sprintf("0x%02x", bit))
+		}
+	}
+
+	return json.Marshal(out)
+}
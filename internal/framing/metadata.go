@@ -0,0 +1,100 @@
+package framing
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// Metadata section layout (present when FlagMetadata is set), placed between
+// the header and the payload:
+//   0-1: Section length in bytes, excluding these 2 bytes (big-endian uint16)
+//   then for each entry, sorted by key:
+//     1 byte key length, key bytes, 2 bytes value length (big-endian), value bytes
+const (
+	// MaxMetadataKeyLen is the longest metadata key in bytes
+	MaxMetadataKeyLen = 0xFF
+	// MaxMetadataSize is the largest metadata section in bytes, excluding its length prefix
+	MaxMetadataSize = 0xFFFF
+)
+
+var (
+	// ErrMetadataTooLarge indicates a metadata key or the whole section exceeds its size limit
+	ErrMetadataTooLarge = errors.New("metadata too large")
+	// ErrInvalidMetadata indicates a metadata section that doesn't parse
+	ErrInvalidMetadata = errors.New("invalid metadata section")
+)
+
+// EncodeMetadata serializes key-value pairs into a metadata section, as
+// frames store it (an empty map gives a section with no entries)
+// Keys are sorted so the same map always produces the same bytes
+func EncodeMetadata(metadata map[string]string) ([]byte, error) {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	section := make([]byte, 2)
+	for _, key := range keys {
+		value := metadata[key]
+		if len(key) > MaxMetadataKeyLen || len(value) > MaxMetadataSize {
+			return nil, ErrMetadataTooLarge
+		}
+		section = append(section, byte(len(key)))
+		section = append(section, key...)
+		section = binary.BigEndian.AppendUint16(section, uint16(len(value)))
+		section = append(section, value...)
+	}
+
+	if len(section)-2 > MaxMetadataSize {
+		return nil, ErrMetadataTooLarge
+	}
+	binary.BigEndian.PutUint16(section[0:2], uint16(len(section)-2))
+	return section, nil
+}
+
+// MetadataSize returns the number of bytes the metadata section adds to a
+// frame (0 for no metadata), assuming the keys and values are within limits
+func MetadataSize(metadata map[string]string) int {
+	if len(metadata) == 0 {
+		return 0
+	}
+	size := 2
+	for key, value := range metadata {
+		size += 1 + len(key) + 2 + len(value)
+	}
+	return size
+}
+
+// decodeMetadata parses the metadata section at the start of data
+// Returns the key-value pairs and the number of bytes the section occupies
+func decodeMetadata(data []byte) (map[string]string, int, error) {
+	if len(data) < 2 {
+		return nil, 0, ErrInvalidMetadata
+	}
+	size := int(binary.BigEndian.Uint16(data[0:2]))
+	if len(data) < 2+size {
+		return nil, 0, ErrInvalidMetadata
+	}
+
+	metadata := make(map[string]string)
+	section := data[2 : 2+size]
+	for len(section) > 0 {
+		keyLen := int(section[0])
+		if len(section) < 1+keyLen+2 {
+			return nil, 0, ErrInvalidMetadata
+		}
+		key := string(section[1 : 1+keyLen])
+		section = section[1+keyLen:]
+
+		valueLen := int(binary.BigEndian.Uint16(section[0:2]))
+		if len(section) < 2+valueLen {
+			return nil, 0, ErrInvalidMetadata
+		}
+		metadata[key] = string(section[2 : 2+valueLen])
+		section = section[2+valueLen:]
+	}
+
+	return metadata, 2 + size, nil
+}
@@ -0,0 +1,110 @@
+package imgutil
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestBMP_RoundTrip(t *testing.T) {
+	// Odd width exercises row padding
+	img := image.NewRGBA(image.Rect(0, 0, 13, 7))
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 13; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 19), G: uint8(y * 36), B: uint8(x * y), A: 255})
+		}
+	}
+
+	data, err := EncodeImage(img, "bmp", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+
+	decoded, format, err := LoadImage(data)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	if format != "bmp" {
+		t.Errorf("expected format bmp, got %s", format)
+	}
+	if decoded.Bounds() != img.Bounds() {
+		t.Fatalf("expected bounds %v, got %v", img.Bounds(), decoded.Bounds())
+	}
+	for y := 0; y < 7; y++ {
+		for x := 0; x < 13; x++ {
+			if got, want := color.RGBAModel.Convert(decoded.At(x, y)), img.At(x, y); got != want {
+				t.Fatalf("pixel (%d,%d): expected %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
+
+func TestBMP_Paletted(t *testing.T) {
+	// 2x2 8-bit bottom-up bitmap with a 2-color palette
+	var buf bytes.Buffer
+	header := []byte{
+		'B', 'M', 0, 0, 0, 0, 0, 0, 0, 0, 62, 0, 0, 0,
+		40, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0, 1, 0, 8, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		2, 0, 0, 0, 0, 0, 0, 0,
+	}
+	buf.Write(header)
+	buf.Write([]byte{0, 0, 255, 0, 255, 0, 0, 0}) // palette: red, blue (BGRX)
+	buf.Write([]byte{0, 1, 0, 0})                 // bottom row: red, blue
+	buf.Write([]byte{1, 0, 0, 0})                 // top row: blue, red
+
+	img, format, err := LoadImage(buf.Bytes())
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	if format != "bmp" {
+		t.Errorf("expected format bmp, got %s", format)
+	}
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	if got := color.RGBAModel.Convert(img.At(0, 0)); got != blue {
+		t.Errorf("expected top-left blue, got %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(0, 1)); got != red {
+		t.Errorf("expected bottom-left red, got %v", got)
+	}
+}
+
+func TestBMP_Bitfields(t *testing.T) {
+	// 2x1 32-bit bitmap with a BITMAPV4HEADER and BI_BITFIELDS compression
+	// using the standard masks, as image editors write when saving alpha
+	le := func(v uint32) []byte { return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)} }
+	var buf bytes.Buffer
+	buf.WriteString("BM")
+	buf.Write(le(14 + 108 + 8))
+	buf.Write(le(0))
+	buf.Write(le(14 + 108))
+	info := make([]byte, 108)
+	copy(info[0:], le(108))
+	copy(info[4:], le(2))
+	copy(info[8:], le(1))
+	copy(info[12:], []byte{1, 0, 32, 0})
+	copy(info[16:], le(3)) // BI_BITFIELDS
+	copy(info[20:], le(8))
+	copy(info[40:], le(0x00ff0000))
+	copy(info[44:], le(0x0000ff00))
+	copy(info[48:], le(0x000000ff))
+	copy(info[52:], le(0xff000000))
+	buf.Write(info)
+	buf.Write([]byte{0, 0, 255, 255, 255, 0, 0, 255}) // red, blue (BGRA)
+
+	img, format, err := LoadImage(buf.Bytes())
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	if format != "bmp" {
+		t.Errorf("expected format bmp, got %s", format)
+	}
+	if got := color.RGBAModel.Convert(img.At(0, 0)); got != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("expected red, got %v", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(1, 0)); got != (color.RGBA{B: 255, A: 255}) {
+		t.Errorf("expected blue, got %v", got)
+	}
+}
@@ -1,13 +1,34 @@
 package imgutil
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"image"
+	_ "image/gif" // registers the GIF decoder (first frame only)
 	"image/jpeg"
 	"image/png"
+	"io"
 	"os"
+	"slices"
 	"strings"
+
+	"golang.org/x/image/bmp"  // also registers the BMP decoder
+	"golang.org/x/image/tiff" // also registers the TIFF decoder
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	// ErrGIFOutput indicates GIF output was requested; GIF is paletted, so
+	// re-quantizing the modified pixels would destroy the embedded data
+	ErrGIFOutput = errors.New("GIF output is not supported: palette quantization destroys embedded data, use PNG")
+	// ErrUnsupportedFormat indicates data isn't recognized as any image
+	// format this package can decode
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrCorruptImage indicates data in a recognized format failed to decode,
+	// e.g. because the file is truncated
+	ErrCorruptImage = errors.New("corrupt image data")
 )
 
 // LoadImageFromFile loads an image from a file path
@@ -21,15 +42,114 @@ func LoadImageFromFile(path string) (image.Image, string, error) {
 }
 
 // LoadImage loads an image from byte data
-// Returns the image, format string, and any error
+// Returns the image, format string, and any error: ErrUnsupportedFormat if
+// the format isn't recognized, ErrCorruptImage if a recognized format fails
+// to decode
 func LoadImage(data []byte) (image.Image, string, error) {
-	img, format, err := image.Decode(bytes.NewReader(data))
+	return DecodeImage(bytes.NewReader(data))
+}
+
+// DecodeImage decodes an image read from r, without first reading the
+// encoded data into memory (the TIFF decoder still buffers it unless r is an
+// io.ReaderAt, such as an *os.File)
+// Returns the image, format string, and any error, as for LoadImage
+func DecodeImage(r io.Reader) (image.Image, string, error) {
+	if _, ok := r.(io.ReaderAt); !ok {
+		// Buffer so the header can be peeked at
+		r = bufio.NewReader(r)
+	} else if isTIFF(peekHeader(r)) {
+		// image.Decode would hide the io.ReaderAt behind a buffer, making
+		// the TIFF decoder read the whole file into memory
+		img, err := tiff.Decode(r)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to decode image: %w: %w", ErrCorruptImage, err)
+		}
+		return img, "tiff", nil
+	}
+	// Copied, as decoding reuses a bufio.Reader's buffer
+	header := slices.Clone(peekHeader(r))
+	img, format, err := image.Decode(r)
+	if errors.Is(err, image.ErrFormat) {
+		return nil, "", fmt.Errorf("failed to decode image: %w", ErrUnsupportedFormat)
+	}
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+		return nil, "", fmt.Errorf("failed to decode image: %w: %w", ErrCorruptImage, err)
+	}
+	if format == "" {
+		// Some registered decoders don't name their format
+		format = SniffFormat(header)
 	}
 	return img, format, nil
 }
 
+// peekHeader returns the first 12 bytes of r without consuming them, or nil
+// if r can't be read from the start again
+func peekHeader(r io.Reader) []byte {
+	header := make([]byte, 12)
+	switch r := r.(type) {
+	case io.ReaderAt:
+		n, _ := r.ReadAt(header, 0)
+		return header[:n]
+	case *bufio.Reader:
+		header, _ = r.Peek(12)
+		return header
+	}
+	return nil
+}
+
+// DetectFormat returns the format LoadImage would decode data as ("png",
+// "jpeg", "gif", "bmp", "tiff" or "webp"), reading only the header rather than
+// decoding the pixels
+// Returns ErrUnsupportedFormat and ErrCorruptImage as LoadImage does, and
+// ErrUnsupportedFormat as well if a decoder accepts data but names no format
+// and its signature isn't recognized either
+func DetectFormat(data []byte) (string, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	switch {
+	case errors.Is(err, image.ErrFormat):
+		return "", ErrUnsupportedFormat
+	case err != nil:
+		return "", fmt.Errorf("failed to read %s header: %w: %w", format, ErrCorruptImage, err)
+	case format == "":
+		format = SniffFormat(data)
+	}
+	if format == "" {
+		return "", fmt.Errorf("%w: the decoder names no format", ErrUnsupportedFormat)
+	}
+	return format, nil
+}
+
+// SniffFormat returns the format data's signature identifies ("png", "jpeg",
+// "gif", "bmp", "tiff" or "webp", as named by the standard decoders), or "" if it
+// matches none; only the first few bytes are looked at
+func SniffFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte(pngSignature)):
+		return "png"
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "gif"
+	case bytes.HasPrefix(data, []byte("BM")):
+		return "bmp"
+	case isTIFF(data):
+		return "tiff"
+	case isWebP(data):
+		return "webp"
+	}
+	return ""
+}
+
+// isTIFF reports whether data starts with a little- or big-endian TIFF header
+func isTIFF(data []byte) bool {
+	return len(data) >= 4 && (string(data[0:4]) == "II*\x00" || string(data[0:4]) == "MM\x00*")
+}
+
+// isWebP reports whether data starts with a RIFF/WEBP container header
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
 // SaveImageToFile saves an image to a file
 func SaveImageToFile(img image.Image, format, path string, quality int) error {
 	data, err := EncodeImage(img, format, quality)
@@ -39,34 +159,168 @@ func SaveImageToFile(img image.Image, format, path string, quality int) error {
 	return os.WriteFile(path, data, 0644)
 }
 
-// EncodeImage encodes an image to the specified format
-func EncodeImage(img image.Image, format string, quality int) ([]byte, error) {
-	var buf bytes.Buffer
+// JPEGSubsampleRatio is the default chroma subsampling of EncodeImage's JPEG
+// output for color images: image/jpeg always writes 4:2:0, which preserves
+// the ratio of typical (4:2:0) JPEG input but halves the chroma resolution of
+// anything finer (see EncodeOptions.JPEGSubsampleRatio). Grayscale images
+// are written without chroma
+const JPEGSubsampleRatio = image.YCbCrSubsampleRatio420
 
-	format = strings.ToLower(format)
-	switch format {
-	case "png", "image/png":
-		if err := png.Encode(&buf, img); err != nil {
-			return nil, fmt.Errorf("failed to encode PNG: %w", err)
+// EncodeOptions tunes EncodeImageWithOptions; the zero value is the
+// encoders' defaults
+type EncodeOptions struct {
+	// Quality is the JPEG quality (1-100)
+	Quality int
+	// PNGCompression is the PNG compression level (0 = png.DefaultCompression)
+	PNGCompression png.CompressionLevel
+	// JPEGSubsampleRatio, if set, is the chroma subsampling of JPEG output,
+	// e.g. to keep a JPEG input's (see EncodeJPEG); nil means
+	// JPEGSubsampleRatio
+	JPEGSubsampleRatio *image.YCbCrSubsampleRatio
+}
+
+// imageEncoder writes images in one output format
+type imageEncoder struct {
+	// names are the format names selecting it, the canonical one first
+	names []string
+	// label names the format in error messages
+	label string
+	// lossless formats store every pixel exactly as given
+	lossless bool
+	// alpha formats store transparency, and deep ones 16-bit samples
+	alpha, deep bool
+	encode      func(w io.Writer, img image.Image, opts EncodeOptions) error
+}
+
+// encoders lists the output formats EncodeImage supports
+var encoders = []imageEncoder{
+	{names: []string{"png", "image/png"}, label: "PNG", lossless: true, alpha: true, deep: true, encode: func(w io.Writer, img image.Image, opts EncodeOptions) error {
+		enc := png.Encoder{CompressionLevel: opts.PNGCompression}
+		return enc.Encode(w, img)
+	}},
+	{names: []string{"jpg", "jpeg", "image/jpeg"}, label: "JPEG", encode: func(w io.Writer, img image.Image, opts EncodeOptions) error {
+		if ratio := opts.JPEGSubsampleRatio; ratio != nil && *ratio != JPEGSubsampleRatio {
+			data, err := EncodeJPEG(img, opts.Quality, *ratio)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(data)
+			return err
 		}
-	case "jpg", "jpeg", "image/jpeg":
-		opts := &jpeg.Options{Quality: quality}
-		if err := jpeg.Encode(&buf, img, opts); err != nil {
-			return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: opts.Quality})
+	}},
+	{names: []string{"bmp", "image/bmp"}, label: "BMP", lossless: true, encode: func(w io.Writer, img image.Image, _ EncodeOptions) error {
+		return bmp.Encode(w, img)
+	}},
+	{names: []string{"tiff", "tif", "image/tiff"}, label: "TIFF", lossless: true, alpha: true, deep: true, encode: func(w io.Writer, img image.Image, _ EncodeOptions) error {
+		// Deflate keeps the output lossless while still compressing it
+		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	}},
+	{names: []string{"webp", "image/webp"}, label: "WebP", lossless: true, alpha: true, encode: func(w io.Writer, img image.Image, _ EncodeOptions) error {
+		// Always lossless (VP8L), never the lossy VP8 format
+		return encodeWebP(w, img)
+	}},
+}
+
+// SupportedFormats returns the canonical names of the formats EncodeImage
+// can write ("png", "jpg", "bmp", "tiff", "webp"), e.g. for a format picker
+func SupportedFormats() []string {
+	formats := make([]string, len(encoders))
+	for i, enc := range encoders {
+		formats[i] = enc.names[0]
+	}
+	return formats
+}
+
+// lookupEncoder returns the encoder a format name (canonical or alias, in
+// any case) selects
+func lookupEncoder(format string) (imageEncoder, bool) {
+	format = strings.ToLower(format)
+	for _, enc := range encoders {
+		if slices.Contains(enc.names, format) {
+			return enc, true
 		}
-	default:
-		return nil, fmt.Errorf("unsupported format: %s", format)
 	}
+	return imageEncoder{}, false
+}
 
+// IsSupportedFormat reports whether EncodeImage can write format, one of
+// SupportedFormats or an alias (e.g. "jpeg", "image/png"), in any case
+func IsSupportedFormat(format string) bool {
+	_, ok := lookupEncoder(format)
+	return ok
+}
+
+// IsLosslessFormat reports whether format names a supported output format
+// that stores every pixel exactly (not JPEG)
+func IsLosslessFormat(format string) bool {
+	enc, ok := lookupEncoder(format)
+	return ok && enc.lossless
+}
+
+// FormatKeepsAlpha reports whether format names a supported output format
+// that stores transparency
+func FormatKeepsAlpha(format string) bool {
+	enc, ok := lookupEncoder(format)
+	return ok && enc.alpha
+}
+
+// FormatKeeps16Bit reports whether format names a supported output format
+// that stores 16-bit samples
+func FormatKeeps16Bit(format string) bool {
+	enc, ok := lookupEncoder(format)
+	return ok && enc.deep
+}
+
+// CanonicalFormat returns the canonical name (see SupportedFormats) of the
+// output format a name or alias selects, or "" if it isn't supported
+func CanonicalFormat(format string) string {
+	enc, _ := lookupEncoder(format)
+	if len(enc.names) == 0 {
+		return ""
+	}
+	return enc.names[0]
+}
+
+// EncodeImage encodes an image to the specified format, one of
+// SupportedFormats or an alias (e.g. "jpeg", "image/png")
+// JPEG output subsamples chroma to JPEGSubsampleRatio (see
+// EncodeImageWithOptions for other ratios)
+func EncodeImage(img image.Image, format string, quality int) ([]byte, error) {
+	return EncodeImageWithOptions(img, format, EncodeOptions{Quality: quality})
+}
+
+// IsGIFFormat reports whether format names GIF, which is read but never
+// written (see ErrGIFOutput)
+func IsGIFFormat(format string) bool {
+	format = strings.ToLower(format)
+	return format == "gif" || format == "image/gif"
+}
+
+// EncodeImageWithOptions is EncodeImage with encoder options beyond the JPEG
+// quality, such as the PNG compression level or the JPEG chroma subsampling
+func EncodeImageWithOptions(img image.Image, format string, opts EncodeOptions) ([]byte, error) {
+	if IsGIFFormat(format) {
+		return nil, ErrGIFOutput
+	}
+	enc, ok := lookupEncoder(format)
+	if !ok {
+		return nil, fmt.Errorf("unsupported format: %s", strings.ToLower(format))
+	}
+	var buf bytes.Buffer
+	if err := enc.encode(&buf, img, opts); err != nil {
+		return nil, fmt.Errorf("failed to encode %s: %w", enc.label, err)
+	}
 	return buf.Bytes(), nil
 }
 
 // CapacityBits calculates the number of bits that can be embedded in an image
-// based on its dimensions (8x8 blocks)
-func CapacityBits(width, height int) int {
-	blocksAcross := width / 8
-	blocksDown := height / 8
-	return blocksAcross * blocksDown
+// based on its dimensions (blockSize x blockSize blocks) and the number of
+// carrier channels
+func CapacityBits(width, height, channels, blockSize int) int {
+	blocksAcross := width / blockSize
+	blocksDown := height / blockSize
+	return blocksAcross * blocksDown * channels
 }
 
 
@@ -0,0 +1,117 @@
+package imgutil
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLoadImage_DistinguishesUnsupportedFromCorrupt(t *testing.T) {
+	// Random bytes don't match any registered format's magic
+	blob := make([]byte, 256)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range blob {
+		blob[i] = byte(rng.IntN(256))
+	}
+	blob[0] = 0
+	if _, _, err := LoadImage(blob); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("random blob: expected ErrUnsupportedFormat, got %v", err)
+	}
+	webp := []byte("RIFF\x10\x00\x00\x00WEBPVP8 ")
+	if _, _, err := LoadImage(webp); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("truncated WebP: expected ErrCorruptImage, got %v", err)
+	}
+
+	data, err := EncodeImage(image.NewGray(image.Rect(0, 0, 32, 32)), "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+	_, _, err = LoadImage(data[:len(data)/2])
+	if !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("truncated PNG: expected ErrCorruptImage, got %v", err)
+	}
+	if errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("truncated PNG: unexpected ErrUnsupportedFormat: %v", err)
+	}
+
+	// DetectFormat agrees with LoadImage on both kinds of failure
+	if _, err := DetectFormat(blob); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("random blob: expected ErrUnsupportedFormat from DetectFormat, got %v", err)
+	}
+	if _, err := DetectFormat(data[:20]); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("truncated PNG header: expected ErrCorruptImage from DetectFormat, got %v", err)
+	}
+}
+
+func TestSupportedFormats_AllEncode(t *testing.T) {
+	formats := SupportedFormats()
+	if len(formats) == 0 {
+		t.Fatal("SupportedFormats returned no formats")
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for _, format := range formats {
+		data, err := EncodeImage(img, format, 90)
+		if err != nil {
+			t.Errorf("%s: EncodeImage failed: %v", format, err)
+			continue
+		}
+		if _, _, err := LoadImage(data); err != nil {
+			t.Errorf("%s: encoded image doesn't decode: %v", format, err)
+		}
+	}
+	if slices.Contains(formats, "gif") {
+		t.Error("SupportedFormats lists gif, which EncodeImage rejects")
+	}
+}
+
+func TestIsSupportedFormat_MatchesRegistry(t *testing.T) {
+	for _, format := range SupportedFormats() {
+		if !IsSupportedFormat(format) || !IsSupportedFormat(strings.ToUpper(format)) {
+			t.Errorf("%s: expected IsSupportedFormat to accept it in any case", format)
+		}
+		if CanonicalFormat(strings.ToUpper(format)) != format {
+			t.Errorf("%s: CanonicalFormat gave %q", format, CanonicalFormat(format))
+		}
+	}
+	for _, format := range []string{"", "gif", "image/gif", "webm"} {
+		if IsSupportedFormat(format) {
+			t.Errorf("IsSupportedFormat(%q): expected false", format)
+		}
+	}
+	if CanonicalFormat("image/jpeg") != "jpg" || IsLosslessFormat("jpeg") {
+		t.Error("expected image/jpeg to select lossy jpg")
+	}
+	if !IsLosslessFormat("tif") || !FormatKeepsAlpha("png") || !FormatKeeps16Bit("TIFF") || FormatKeepsAlpha("bmp") {
+		t.Error("unexpected format properties")
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for _, format := range SupportedFormats() {
+		data, err := EncodeImage(img, format, 90)
+		if err != nil {
+			t.Fatalf("%s: EncodeImage failed: %v", format, err)
+		}
+		// SniffFormat must agree with the name the decoder gives the format
+		_, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: DecodeConfig failed: %v", format, err)
+		}
+		if got := SniffFormat(data); got != decoded {
+			t.Errorf("%s: expected %q, got %q", format, decoded, got)
+		}
+	}
+	if got := SniffFormat([]byte("GIF89a\x10\x00")); got != "gif" {
+		t.Errorf("GIF: expected \"gif\", got %q", got)
+	}
+	for _, blob := range [][]byte{nil, []byte("not an image"), []byte("\xff")} {
+		if got := SniffFormat(blob); got != "" {
+			t.Errorf("SniffFormat(%q): expected \"\", got %q", blob, got)
+		}
+	}
+}
@@ -0,0 +1,792 @@
+package imgutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/tuomas-lb/emganography/internal/dct"
+)
+
+// Minimal JPEG coefficient codec, so embedding can modify the quantized DCT
+// coefficients of a JPEG directly instead of decoding it to pixels and
+// re-encoding it (which loses quality on every generation)
+// Reading supports sequential Huffman-coded 8-bit JPEGs (baseline and
+// extended) with a single scan; writing keeps every segment except the
+// Huffman tables and restart interval, and re-codes the coefficients with
+// the standard tables (Annex K.3)
+
+var (
+	// ErrUnsupportedJPEG indicates a JPEG variant the coefficient codec
+	// doesn't handle (progressive, lossless, arithmetic-coded, 12-bit or
+	// spread over several scans)
+	ErrUnsupportedJPEG = errors.New("unsupported JPEG variant")
+	// ErrInvalidJPEG indicates malformed JPEG data
+	ErrInvalidJPEG = errors.New("invalid JPEG data")
+)
+
+// JPEG markers the coefficient codec handles
+const (
+	jpegSOF0 = 0xC0 // baseline
+	jpegSOF1 = 0xC1 // extended sequential
+	jpegDHT  = 0xC4
+	jpegRST0 = 0xD0
+	jpegRST7 = 0xD7
+	jpegSOI  = 0xD8
+	jpegEOI  = 0xD9
+	jpegSOS  = 0xDA
+	jpegDQT  = 0xDB
+	jpegDNL  = 0xDC
+	jpegDRI  = 0xDD
+)
+
+// jpegMaxCoeff is the largest coefficient magnitude 8-bit sequential JPEG
+// can code (AC category 10)
+const jpegMaxCoeff = 1023
+
+// jpegZigzag maps zigzag scan positions to natural (row-major) indices
+var jpegZigzag = dct.ZigZagOrder()
+
+// JPEGComponent is one color component of a JPEG: its sampling factors,
+// quantization table and quantized coefficient blocks
+type JPEGComponent struct {
+	// ID is the component identifier from the frame header
+	ID uint8
+	// H and V are the horizontal and vertical sampling factors
+	H, V int
+	// Quant holds the quantization steps in natural row-major order
+	Quant [64]uint16
+	// BlocksAcross and BlocksDown are the dimensions of Blocks, padded to
+	// whole MCUs
+	BlocksAcross, BlocksDown int
+	// Blocks holds each block's quantized coefficients in natural row-major
+	// order (index row*8+col, as dct.DCT8x8), blocks in raster order
+	Blocks [][64]int32
+
+	tq uint8
+}
+
+// JPEGCoefficients is a decoded JPEG's quantized DCT coefficients, with the
+// segments needed to write it back
+type JPEGCoefficients struct {
+	Width, Height int
+	Components    []JPEGComponent
+
+	// segments holds the marker segments before the scan (e.g. APPn, DQT,
+	// SOF), except DHT and DRI, in their original order
+	segments [][]byte
+}
+
+// jpegHuffman is a Huffman table in the canonical form of the DHT segment,
+// with the derived decoding (maxCode, valPtr) and encoding (codes) tables
+type jpegHuffman struct {
+	counts [16]uint8
+	values []uint8
+
+	minCode, maxCode [17]int32
+	valPtr           [17]int32
+	codes            [256]jpegCode
+}
+
+// jpegCode is a symbol's Huffman code and its length in bits (0 if unused)
+type jpegCode struct {
+	code uint16
+	size uint8
+}
+
+// newJPEGHuffman builds the decoding and encoding tables for a DHT table
+func newJPEGHuffman(counts [16]uint8, values []uint8) (*jpegHuffman, error) {
+	h := &jpegHuffman{counts: counts, values: values}
+	code, k := int32(0), int32(0)
+	for l := 1; l <= 16; l++ {
+		n := int32(counts[l-1])
+		h.valPtr[l] = k
+		h.minCode[l] = code
+		h.maxCode[l] = -1
+		if n > 0 {
+			h.maxCode[l] = code + n - 1
+		}
+		for i := int32(0); i < n; i++ {
+			h.codes[values[k+i]] = jpegCode{code: uint16(code + i), size: uint8(l)}
+		}
+		code += n
+		k += n
+		if code > 1<<l {
+			return nil, fmt.Errorf("%w: oversubscribed Huffman table", ErrInvalidJPEG)
+		}
+		code <<= 1
+	}
+	return h, nil
+}
+
+// Standard Huffman tables (Annex K.3): luminance and chrominance, DC and AC
+var (
+	jpegStdDCLuma = jpegHuffmanSpec{
+		[16]uint8{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	jpegStdACLuma = jpegHuffmanSpec{
+		[16]uint8{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]uint8{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+	jpegStdDCChroma = jpegHuffmanSpec{
+		[16]uint8{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	}
+	jpegStdACChroma = jpegHuffmanSpec{
+		[16]uint8{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]uint8{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	}
+)
+
+// jpegHuffmanSpec is a Huffman table as the DHT segment stores it: the
+// number of codes of each length (1-16) and the symbols in code order
+type jpegHuffmanSpec struct {
+	counts [16]uint8
+	values []uint8
+}
+
+// ReadJPEGCoefficients decodes the quantized DCT coefficients of a JPEG
+// without converting them to pixels
+// Returns ErrUnsupportedJPEG for progressive, lossless, arithmetic-coded,
+// 12-bit and multi-scan JPEGs, or ErrInvalidJPEG for malformed data
+func ReadJPEGCoefficients(data []byte) (*JPEGCoefficients, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != jpegSOI {
+		return nil, fmt.Errorf("%w: missing SOI marker", ErrInvalidJPEG)
+	}
+
+	c := &JPEGCoefficients{}
+	var quant [4][64]uint16
+	var dcTables, acTables [4]*jpegHuffman
+	restartInterval := 0
+
+	pos := 2
+	for {
+		// Markers may be preceded by any number of 0xFF fill bytes
+		for pos+1 < len(data) && data[pos] == 0xFF && data[pos+1] == 0xFF {
+			pos++
+		}
+		if pos+4 > len(data) || data[pos] != 0xFF {
+			return nil, fmt.Errorf("%w: expected a marker at offset %d", ErrInvalidJPEG, pos)
+		}
+		marker := data[pos+1]
+		if marker == jpegEOI {
+			return nil, fmt.Errorf("%w: no scan", ErrInvalidJPEG)
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return nil, fmt.Errorf("%w: truncated segment at offset %d", ErrInvalidJPEG, pos)
+		}
+		segment := data[pos : pos+2+length]
+		payload := segment[4:]
+		pos += 2 + length
+
+		switch {
+		case marker == jpegSOF0 || marker == jpegSOF1:
+			if c.Components != nil {
+				return nil, fmt.Errorf("%w: multiple frames", ErrInvalidJPEG)
+			}
+			if err := c.parseFrame(payload); err != nil {
+				return nil, err
+			}
+			c.segments = append(c.segments, segment)
+		case marker >= 0xC2 && marker <= 0xCF && marker != jpegDHT && marker != 0xC8 && marker != 0xCC:
+			// Progressive, lossless, hierarchical or arithmetic-coded frames
+			return nil, fmt.Errorf("%w: frame type %#x", ErrUnsupportedJPEG, marker)
+		case marker == 0xCC:
+			return nil, fmt.Errorf("%w: arithmetic coding", ErrUnsupportedJPEG)
+		case marker == jpegDHT:
+			if err := parseDHT(payload, &dcTables, &acTables); err != nil {
+				return nil, err
+			}
+		case marker == jpegDQT:
+			if err := parseDQT(payload, &quant); err != nil {
+				return nil, err
+			}
+			c.segments = append(c.segments, segment)
+		case marker == jpegDRI:
+			if len(payload) != 2 {
+				return nil, fmt.Errorf("%w: bad DRI segment", ErrInvalidJPEG)
+			}
+			restartInterval = int(binary.BigEndian.Uint16(payload))
+		case marker == jpegSOS:
+			if c.Components == nil {
+				return nil, fmt.Errorf("%w: scan before frame header", ErrInvalidJPEG)
+			}
+			for i := range c.Components {
+				c.Components[i].Quant = quant[c.Components[i].tq]
+			}
+			end, err := c.decodeScan(data, pos, payload, &dcTables, &acTables, restartInterval)
+			if err != nil {
+				return nil, err
+			}
+			if err := checkScanEnd(data, end); err != nil {
+				return nil, err
+			}
+			return c, nil
+		default:
+			// APPn, COM and other segments are kept as they are
+			c.segments = append(c.segments, segment)
+		}
+	}
+}
+
+// parseFrame parses an SOF0/SOF1 segment and allocates the coefficient blocks
+func (c *JPEGCoefficients) parseFrame(payload []byte) error {
+	if len(payload) < 6 {
+		return fmt.Errorf("%w: short frame header", ErrInvalidJPEG)
+	}
+	if payload[0] != 8 {
+		return fmt.Errorf("%w: %d-bit samples", ErrUnsupportedJPEG, payload[0])
+	}
+	c.Height = int(binary.BigEndian.Uint16(payload[1:]))
+	c.Width = int(binary.BigEndian.Uint16(payload[3:]))
+	n := int(payload[5])
+	if c.Height == 0 {
+		return fmt.Errorf("%w: height defined by DNL", ErrUnsupportedJPEG)
+	}
+	if c.Width == 0 || n == 0 || n > 4 || len(payload) != 6+3*n {
+		return fmt.Errorf("%w: bad frame header", ErrInvalidJPEG)
+	}
+
+	c.Components = make([]JPEGComponent, n)
+	hMax, vMax := 1, 1
+	for i := range c.Components {
+		p := payload[6+3*i:]
+		comp := &c.Components[i]
+		comp.ID, comp.H, comp.V, comp.tq = p[0], int(p[1]>>4), int(p[1]&0x0F), p[2]
+		if comp.H < 1 || comp.H > 4 || comp.V < 1 || comp.V > 4 || comp.tq > 3 {
+			return fmt.Errorf("%w: bad component %d", ErrInvalidJPEG, comp.ID)
+		}
+		hMax, vMax = max(hMax, comp.H), max(vMax, comp.V)
+	}
+
+	mcusAcross := (c.Width + 8*hMax - 1) / (8 * hMax)
+	mcusDown := (c.Height + 8*vMax - 1) / (8 * vMax)
+	for i := range c.Components {
+		comp := &c.Components[i]
+		comp.BlocksAcross, comp.BlocksDown = mcusAcross*comp.H, mcusDown*comp.V
+		comp.Blocks = make([][64]int32, comp.BlocksAcross*comp.BlocksDown)
+	}
+	return nil
+}
+
+// parseDHT parses the Huffman tables of a DHT segment
+func parseDHT(payload []byte, dcTables, acTables *[4]*jpegHuffman) error {
+	for len(payload) > 0 {
+		if len(payload) < 17 {
+			return fmt.Errorf("%w: short DHT segment", ErrInvalidJPEG)
+		}
+		class, id := payload[0]>>4, payload[0]&0x0F
+		if class > 1 || id > 3 {
+			return fmt.Errorf("%w: bad Huffman table %#x", ErrInvalidJPEG, payload[0])
+		}
+		var counts [16]uint8
+		copy(counts[:], payload[1:17])
+		total := 0
+		for _, n := range counts {
+			total += int(n)
+		}
+		if total > 256 || len(payload) < 17+total {
+			return fmt.Errorf("%w: short DHT segment", ErrInvalidJPEG)
+		}
+		h, err := newJPEGHuffman(counts, payload[17:17+total])
+		if err != nil {
+			return err
+		}
+		if class == 0 {
+			dcTables[id] = h
+		} else {
+			acTables[id] = h
+		}
+		payload = payload[17+total:]
+	}
+	return nil
+}
+
+// parseDQT parses the quantization tables of a DQT segment into natural order
+func parseDQT(payload []byte, quant *[4][64]uint16) error {
+	for len(payload) > 0 {
+		precision, id := payload[0]>>4, payload[0]&0x0F
+		size := 64 * (1 + int(precision))
+		if precision > 1 || id > 3 || len(payload) < 1+size {
+			return fmt.Errorf("%w: bad DQT segment", ErrInvalidJPEG)
+		}
+		for k := 0; k < 64; k++ {
+			if precision == 0 {
+				quant[id][jpegZigzag[k]] = uint16(payload[1+k])
+			} else {
+				quant[id][jpegZigzag[k]] = binary.BigEndian.Uint16(payload[1+2*k:])
+			}
+		}
+		payload = payload[1+size:]
+	}
+	return nil
+}
+
+// scanComponent is a component of a scan with its Huffman tables
+type scanComponent struct {
+	comp   *JPEGComponent
+	dc, ac *jpegHuffman
+}
+
+// decodeScan parses an SOS segment and decodes the entropy-coded data
+// starting at pos
+// Returns the offset just past the entropy-coded data
+func (c *JPEGCoefficients) decodeScan(data []byte, pos int, payload []byte, dcTables, acTables *[4]*jpegHuffman, restartInterval int) (int, error) {
+	if len(payload) < 1 || len(payload) != 4+2*int(payload[0]) {
+		return 0, fmt.Errorf("%w: bad scan header", ErrInvalidJPEG)
+	}
+	n := int(payload[0])
+	if n != len(c.Components) {
+		return 0, fmt.Errorf("%w: scan covers %d of %d components", ErrUnsupportedJPEG, n, len(c.Components))
+	}
+	if ss, se, a := payload[1+2*n], payload[2+2*n], payload[3+2*n]; ss != 0 || se != 63 || a != 0 {
+		return 0, fmt.Errorf("%w: spectral selection or successive approximation", ErrUnsupportedJPEG)
+	}
+
+	scan := make([]scanComponent, n)
+	for i := range scan {
+		id, tables := payload[1+2*i], payload[2+2*i]
+		for j := range c.Components {
+			if c.Components[j].ID == id {
+				scan[i].comp = &c.Components[j]
+			}
+		}
+		if scan[i].comp == nil || tables>>4 > 3 || tables&0x0F > 3 {
+			return 0, fmt.Errorf("%w: bad scan component %d", ErrInvalidJPEG, id)
+		}
+		scan[i].dc, scan[i].ac = dcTables[tables>>4], acTables[tables&0x0F]
+		if scan[i].dc == nil || scan[i].ac == nil {
+			return 0, fmt.Errorf("%w: undefined Huffman table", ErrInvalidJPEG)
+		}
+	}
+
+	r := &jpegBitReader{data: data, pos: pos}
+	preds := make([]int32, n)
+	mcu := 0
+	err := c.forEachMCU(scan, func(blocks []jpegScanBlock) error {
+		if restartInterval > 0 && mcu > 0 && mcu%restartInterval == 0 {
+			if err := r.restart(); err != nil {
+				return err
+			}
+			clear(preds)
+		}
+		mcu++
+		for _, b := range blocks {
+			if err := r.decodeBlock(b.block, scan[b.index].dc, scan[b.index].ac, &preds[b.index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return r.pos, nil
+}
+
+// jpegScanBlock is a block of an MCU and the index of its scan component
+type jpegScanBlock struct {
+	index int
+	block *[64]int32
+}
+
+// forEachMCU calls fn with the blocks of each MCU of the scan in order: with
+// several components, each MCU holds H x V blocks of every component; a
+// single component is coded block by block, covering only its own size
+func (c *JPEGCoefficients) forEachMCU(scan []scanComponent, fn func(blocks []jpegScanBlock) error) error {
+	if len(scan) == 1 {
+		comp := scan[0].comp
+		hMax, vMax := c.maxSampling()
+		across := (c.Width*comp.H + hMax - 1) / hMax
+		down := (c.Height*comp.V + vMax - 1) / vMax
+		blocks := make([]jpegScanBlock, 1)
+		for by := 0; by < (down+7)/8; by++ {
+			for bx := 0; bx < (across+7)/8; bx++ {
+				blocks[0] = jpegScanBlock{0, &comp.Blocks[by*comp.BlocksAcross+bx]}
+				if err := fn(blocks); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	hMax, vMax := c.maxSampling()
+	mcusAcross := (c.Width + 8*hMax - 1) / (8 * hMax)
+	mcusDown := (c.Height + 8*vMax - 1) / (8 * vMax)
+	var blocks []jpegScanBlock
+	for my := 0; my < mcusDown; my++ {
+		for mx := 0; mx < mcusAcross; mx++ {
+			blocks = blocks[:0]
+			for i, s := range scan {
+				comp := s.comp
+				for v := 0; v < comp.V; v++ {
+					for h := 0; h < comp.H; h++ {
+						idx := (my*comp.V+v)*comp.BlocksAcross + mx*comp.H + h
+						blocks = append(blocks, jpegScanBlock{i, &comp.Blocks[idx]})
+					}
+				}
+			}
+			if err := fn(blocks); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// maxSampling returns the largest horizontal and vertical sampling factors
+func (c *JPEGCoefficients) maxSampling() (hMax, vMax int) {
+	hMax, vMax = 1, 1
+	for _, comp := range c.Components {
+		hMax, vMax = max(hMax, comp.H), max(vMax, comp.V)
+	}
+	return hMax, vMax
+}
+
+// checkScanEnd returns ErrUnsupportedJPEG if another scan follows the one
+// ending at pos, as progressive-style multi-scan JPEGs aren't supported
+func checkScanEnd(data []byte, pos int) error {
+	for ; pos+1 < len(data); pos++ {
+		if data[pos] != 0xFF {
+			continue
+		}
+		switch marker := data[pos+1]; {
+		case marker == 0x00 || marker == 0xFF || (marker >= jpegRST0 && marker <= jpegRST7):
+			continue
+		case marker == jpegEOI:
+			return nil
+		case marker == jpegDNL:
+			return fmt.Errorf("%w: height defined by DNL", ErrUnsupportedJPEG)
+		default:
+			return fmt.Errorf("%w: multiple scans", ErrUnsupportedJPEG)
+		}
+	}
+	// A missing EOI is tolerated, as most decoders do
+	return nil
+}
+
+// jpegBitReader reads the entropy-coded data of a scan, removing stuffed zero
+// bytes; at a marker it supplies zero bits, as the decoder must not read past it
+type jpegBitReader struct {
+	data []byte
+	pos  int
+	acc  uint32
+	n    int
+}
+
+// bits returns the next n (<= 16) bits
+func (r *jpegBitReader) bits(n int) int32 {
+	for r.n < n {
+		var b byte
+		if r.pos < len(r.data) {
+			b = r.data[r.pos]
+			if b == 0xFF {
+				if r.pos+1 < len(r.data) && r.data[r.pos+1] == 0x00 {
+					r.pos += 2
+				} else {
+					b = 0 // a marker: leave it for the caller
+				}
+			} else {
+				r.pos++
+			}
+		}
+		r.acc = r.acc<<8 | uint32(b)
+		r.n += 8
+	}
+	r.n -= n
+	return int32(r.acc>>r.n) & (1<<n - 1)
+}
+
+// decode reads one Huffman-coded symbol
+func (r *jpegBitReader) decode(h *jpegHuffman) (uint8, error) {
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | r.bits(1)
+		if code <= h.maxCode[l] {
+			return h.values[h.valPtr[l]+code-h.minCode[l]], nil
+		}
+	}
+	return 0, fmt.Errorf("%w: bad Huffman code", ErrInvalidJPEG)
+}
+
+// receiveExtend reads an s-bit magnitude and sign-extends it (F.2.2.1)
+func (r *jpegBitReader) receiveExtend(s uint8) (int32, error) {
+	if s == 0 {
+		return 0, nil
+	}
+	if s > 16 {
+		return 0, fmt.Errorf("%w: bad coefficient size %d", ErrInvalidJPEG, s)
+	}
+	v := r.bits(int(s))
+	if v < 1<<(s-1) {
+		v += -1<<s + 1
+	}
+	return v, nil
+}
+
+// restart discards the remaining bits of the byte and consumes an RSTn marker
+func (r *jpegBitReader) restart() error {
+	r.acc, r.n = 0, 0
+	if r.pos+1 >= len(r.data) || r.data[r.pos] != 0xFF || r.data[r.pos+1] < jpegRST0 || r.data[r.pos+1] > jpegRST7 {
+		return fmt.Errorf("%w: missing restart marker at offset %d", ErrInvalidJPEG, r.pos)
+	}
+	r.pos += 2
+	return nil
+}
+
+// decodeBlock decodes one block's coefficients into natural order, pred
+// holding the component's DC prediction
+func (r *jpegBitReader) decodeBlock(block *[64]int32, dc, ac *jpegHuffman, pred *int32) error {
+	s, err := r.decode(dc)
+	if err != nil {
+		return err
+	}
+	diff, err := r.receiveExtend(s)
+	if err != nil {
+		return err
+	}
+	*pred += diff
+	block[0] = *pred
+
+	for k := 1; k < 64; {
+		rs, err := r.decode(ac)
+		if err != nil {
+			return err
+		}
+		run, size := int(rs>>4), rs&0x0F
+		if size == 0 {
+			if run != 15 {
+				break // end of block
+			}
+			k += 16
+			continue
+		}
+		k += run
+		if k > 63 {
+			return fmt.Errorf("%w: coefficient run past the block", ErrInvalidJPEG)
+		}
+		if block[jpegZigzag[k]], err = r.receiveExtend(size); err != nil {
+			return err
+		}
+		k++
+	}
+	return nil
+}
+
+// Encode writes the coefficients back as a JPEG: the original segments in
+// their original order, the standard Huffman tables, and a single scan
+// without restart markers
+// Returns ErrInvalidJPEG if a coefficient is out of the codable range
+func (c *JPEGCoefficients) Encode() ([]byte, error) {
+	dcLuma, acLuma, dcChroma, acChroma, err := standardHuffmanTables()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, jpegSOI})
+	for _, segment := range c.segments {
+		buf.Write(segment)
+	}
+
+	// The first component is coded with the luminance tables, others with
+	// the chrominance ones
+	dht := []byte{}
+	for i, spec := range []jpegHuffmanSpec{jpegStdDCLuma, jpegStdACLuma, jpegStdDCChroma, jpegStdACChroma} {
+		dht = append(dht, byte(i%2)<<4|byte(i/2))
+		dht = append(dht, spec.counts[:]...)
+		dht = append(dht, spec.values...)
+	}
+	writeJPEGSegment(&buf, jpegDHT, dht)
+
+	scan := make([]scanComponent, len(c.Components))
+	sos := []byte{byte(len(c.Components))}
+	for i := range c.Components {
+		scan[i] = scanComponent{&c.Components[i], dcLuma, acLuma}
+		tables := byte(0x00)
+		if i > 0 {
+			scan[i].dc, scan[i].ac = dcChroma, acChroma
+			tables = 0x11
+		}
+		sos = append(sos, c.Components[i].ID, tables)
+	}
+	sos = append(sos, 0, 63, 0)
+	writeJPEGSegment(&buf, jpegSOS, sos)
+
+	w := &jpegBitWriter{buf: &buf}
+	preds := make([]int32, len(scan))
+	err = c.forEachMCU(scan, func(blocks []jpegScanBlock) error {
+		for _, b := range blocks {
+			if err := w.encodeBlock(b.block, scan[b.index].dc, scan[b.index].ac, &preds[b.index]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	w.flush()
+
+	buf.Write([]byte{0xFF, jpegEOI})
+	return buf.Bytes(), nil
+}
+
+// standardHuffmanTables builds the Annex K.3 tables
+func standardHuffmanTables() (dcLuma, acLuma, dcChroma, acChroma *jpegHuffman, err error) {
+	tables := make([]*jpegHuffman, 4)
+	for i, spec := range []jpegHuffmanSpec{jpegStdDCLuma, jpegStdACLuma, jpegStdDCChroma, jpegStdACChroma} {
+		if tables[i], err = newJPEGHuffman(spec.counts, spec.values); err != nil {
+			return nil, nil, nil, nil, err
+		}
+	}
+	return tables[0], tables[1], tables[2], tables[3], nil
+}
+
+// writeJPEGSegment writes a marker segment with its length
+func writeJPEGSegment(buf *bytes.Buffer, marker byte, payload []byte) {
+	buf.Write([]byte{0xFF, marker})
+	buf.Write(binary.BigEndian.AppendUint16(nil, uint16(2+len(payload))))
+	buf.Write(payload)
+}
+
+// jpegBitWriter writes entropy-coded data, stuffing a zero byte after 0xFF
+type jpegBitWriter struct {
+	buf *bytes.Buffer
+	acc uint32
+	n   int
+}
+
+// write appends the low n bits of v
+func (w *jpegBitWriter) write(v uint32, n int) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		w.n -= 8
+		b := byte(w.acc >> w.n)
+		w.buf.WriteByte(b)
+		if b == 0xFF {
+			w.buf.WriteByte(0x00)
+		}
+	}
+}
+
+// flush pads the last byte with one bits
+func (w *jpegBitWriter) flush() {
+	if w.n > 0 {
+		w.write(1<<(8-w.n)-1, 8-w.n)
+	}
+}
+
+// writeSymbol writes a symbol's Huffman code
+func (w *jpegBitWriter) writeSymbol(h *jpegHuffman, symbol uint8) error {
+	c := h.codes[symbol]
+	if c.size == 0 {
+		return fmt.Errorf("%w: no Huffman code for symbol %#x", ErrInvalidJPEG, symbol)
+	}
+	w.write(uint32(c.code), int(c.size))
+	return nil
+}
+
+// magnitude returns the size category of v and its coded bits (F.1.2.1)
+func magnitude(v int32) (uint8, uint32) {
+	bits := v
+	if v < 0 {
+		v = -v
+		bits--
+	}
+	size := uint8(0)
+	for ; v > 0; v >>= 1 {
+		size++
+	}
+	return size, uint32(bits) & (1<<size - 1)
+}
+
+// encodeBlock writes one block's coefficients, pred holding the component's
+// DC prediction
+func (w *jpegBitWriter) encodeBlock(block *[64]int32, dc, ac *jpegHuffman, pred *int32) error {
+	size, bits := magnitude(block[0] - *pred)
+	*pred = block[0]
+	if size > 11 {
+		return fmt.Errorf("%w: DC difference out of range", ErrInvalidJPEG)
+	}
+	if err := w.writeSymbol(dc, size); err != nil {
+		return err
+	}
+	w.write(bits, int(size))
+
+	run := 0
+	for k := 1; k < 64; k++ {
+		v := block[jpegZigzag[k]]
+		if v == 0 {
+			run++
+			continue
+		}
+		if v > jpegMaxCoeff || v < -jpegMaxCoeff {
+			return fmt.Errorf("%w: coefficient %d out of range", ErrInvalidJPEG, v)
+		}
+		for ; run > 15; run -= 16 {
+			if err := w.writeSymbol(ac, 0xF0); err != nil {
+				return err
+			}
+		}
+		size, bits := magnitude(v)
+		if err := w.writeSymbol(ac, uint8(run)<<4|size); err != nil {
+			return err
+		}
+		w.write(bits, int(size))
+		run = 0
+	}
+	if run > 0 {
+		return w.writeSymbol(ac, 0x00) // end of block
+	}
+	return nil
+}
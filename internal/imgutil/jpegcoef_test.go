@@ -0,0 +1,76 @@
+package imgutil
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+func TestJPEGCoefficients_RoundTrip(t *testing.T) {
+	// Odd sizes exercise partial MCUs
+	rgba := image.NewRGBA(image.Rect(0, 0, 45, 29))
+	gray := image.NewGray(image.Rect(0, 0, 45, 29))
+	for y := 0; y < 29; y++ {
+		for x := 0; x < 45; x++ {
+			rgba.Set(x, y, color.RGBA{uint8(x * 5), uint8(y * 8), uint8(x * y), 255})
+			gray.SetGray(x, y, color.Gray{uint8(x*3 + y*4)})
+		}
+	}
+
+	for name, img := range map[string]image.Image{"color": rgba, "gray": gray} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+				t.Fatalf("jpeg.Encode failed: %v", err)
+			}
+			coefs, err := ReadJPEGCoefficients(buf.Bytes())
+			if err != nil {
+				t.Fatalf("ReadJPEGCoefficients failed: %v", err)
+			}
+			if coefs.Width != 45 || coefs.Height != 29 {
+				t.Errorf("expected 45x29, got %dx%d", coefs.Width, coefs.Height)
+			}
+
+			output, err := coefs.Encode()
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			again, err := ReadJPEGCoefficients(output)
+			if err != nil {
+				t.Fatalf("ReadJPEGCoefficients of the output failed: %v", err)
+			}
+			if !reflect.DeepEqual(coefs.Components, again.Components) {
+				t.Error("coefficients changed in the round trip")
+			}
+
+			// Same coefficients and tables decode to the same pixels
+			want, err := jpeg.Decode(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("jpeg.Decode failed: %v", err)
+			}
+			got, err := jpeg.Decode(bytes.NewReader(output))
+			if err != nil {
+				t.Fatalf("jpeg.Decode of the output failed: %v", err)
+			}
+			if !reflect.DeepEqual(want, got) {
+				t.Error("decoded pixels differ")
+			}
+		})
+	}
+}
+
+func TestReadJPEGCoefficients_Errors(t *testing.T) {
+	if _, err := ReadJPEGCoefficients([]byte("not a jpeg")); !errors.Is(err, ErrInvalidJPEG) {
+		t.Errorf("expected ErrInvalidJPEG, got %v", err)
+	}
+
+	// A progressive frame header
+	progressive := []byte{0xFF, 0xD8, 0xFF, 0xC2, 0x00, 0x0B, 8, 0, 8, 0, 8, 1, 1, 0x11, 0}
+	if _, err := ReadJPEGCoefficients(progressive); !errors.Is(err, ErrUnsupportedJPEG) {
+		t.Errorf("expected ErrUnsupportedJPEG, got %v", err)
+	}
+}
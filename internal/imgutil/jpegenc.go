@@ -0,0 +1,144 @@
+package imgutil
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/tuomas-lb/emganography/internal/dct"
+)
+
+// jpegSampling maps each chroma subsampling ratio to the luma sampling
+// factors (horizontal, vertical); chroma is always sampled 1x1
+var jpegSampling = map[image.YCbCrSubsampleRatio][2]int{
+	image.YCbCrSubsampleRatio444: {1, 1},
+	image.YCbCrSubsampleRatio422: {2, 1},
+	image.YCbCrSubsampleRatio420: {2, 2},
+	image.YCbCrSubsampleRatio440: {1, 2},
+	image.YCbCrSubsampleRatio411: {4, 1},
+	image.YCbCrSubsampleRatio410: {4, 2},
+}
+
+// EncodeJPEG encodes img as a baseline JPEG at the given quality (1-100, as
+// image/jpeg clamps it) with its chroma subsampled to ratio, which image/jpeg
+// can't do (it always writes 4:2:0). The quantization tables are
+// image/jpeg's; *image.Gray images are written with luma only
+// Returns ErrUnsupportedJPEG for an unknown ratio or an image JPEG can't hold
+func EncodeJPEG(img image.Image, quality int, ratio image.YCbCrSubsampleRatio) ([]byte, error) {
+	sampling, ok := jpegSampling[ratio]
+	if !ok {
+		return nil, fmt.Errorf("%w: subsample ratio %v", ErrUnsupportedJPEG, ratio)
+	}
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > 0xFFFF || height > 0xFFFF {
+		return nil, fmt.Errorf("%w: %dx%d image", ErrUnsupportedJPEG, width, height)
+	}
+
+	planes := jpegPlanes(img)
+	if len(planes) == 1 {
+		sampling = [2]int{1, 1}
+	}
+	quant := [][64]uint16{scaleQuant(&stdLuminanceQuant, quality), scaleQuant(&stdChrominanceQuant, quality)}
+	quant = quant[:min(len(planes), 2)]
+
+	hMax, vMax := sampling[0], sampling[1]
+	mcusAcross := (width + 8*hMax - 1) / (8 * hMax)
+	mcusDown := (height + 8*vMax - 1) / (8 * vMax)
+	c := &JPEGCoefficients{Width: width, Height: height, Components: make([]JPEGComponent, len(planes))}
+	sof := []byte{8, byte(height >> 8), byte(height), byte(width >> 8), byte(width), byte(len(planes))}
+	for i := range c.Components {
+		comp := &c.Components[i]
+		comp.ID, comp.H, comp.V, comp.tq = uint8(i+1), 1, 1, 1
+		if i == 0 {
+			comp.H, comp.V, comp.tq = hMax, vMax, 0
+		}
+		comp.Quant = quant[comp.tq]
+		comp.BlocksAcross, comp.BlocksDown = mcusAcross*comp.H, mcusDown*comp.V
+		comp.Blocks = make([][64]int32, comp.BlocksAcross*comp.BlocksDown)
+		comp.quantize(planes[i], width, height, hMax/comp.H, vMax/comp.V)
+		sof = append(sof, comp.ID, byte(comp.H<<4|comp.V), comp.tq)
+	}
+
+	var dqt []byte
+	for tq, table := range quant {
+		dqt = append(dqt, byte(tq))
+		for _, i := range jpegZigzag {
+			dqt = append(dqt, byte(table[i]))
+		}
+	}
+	var dqtSegment, sofSegment bytes.Buffer
+	writeJPEGSegment(&dqtSegment, jpegDQT, dqt)
+	writeJPEGSegment(&sofSegment, jpegSOF0, sof)
+	c.segments = [][]byte{dqtSegment.Bytes(), sofSegment.Bytes()}
+	return c.Encode()
+}
+
+// jpegPlanes converts img to full-resolution, level-shifted sample planes
+// (row-major, starting at the image's minimum corner): Y alone for an
+// *image.Gray, otherwise Y, Cb and Cr, converted as image/jpeg does (alpha
+// premultiplied, i.e. composited over black)
+func jpegPlanes(img image.Image) [][]float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if gray, ok := img.(*image.Gray); ok {
+		y := make([]float64, width*height)
+		for row := 0; row < height; row++ {
+			for col := 0; col < width; col++ {
+				y[row*width+col] = float64(gray.GrayAt(bounds.Min.X+col, bounds.Min.Y+row).Y) - 128
+			}
+		}
+		return [][]float64{y}
+	}
+
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(bounds)
+		draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
+	}
+	planes := [][]float64{make([]float64, width*height), make([]float64, width*height), make([]float64, width*height)}
+	for row := 0; row < height; row++ {
+		for col := 0; col < width; col++ {
+			p := rgba.PixOffset(bounds.Min.X+col, bounds.Min.Y+row)
+			y, cb, cr := color.RGBToYCbCr(rgba.Pix[p], rgba.Pix[p+1], rgba.Pix[p+2])
+			i := row*width + col
+			planes[0][i], planes[1][i], planes[2][i] = float64(y)-128, float64(cb)-128, float64(cr)-128
+		}
+	}
+	return planes
+}
+
+// quantize fills the component's blocks from a full-resolution plane, each
+// sample averaging sx x sy pixels; pixels past the image edge repeat the
+// last row and column, as image/jpeg pads partial MCUs
+func (comp *JPEGComponent) quantize(plane []float64, width, height, sx, sy int) {
+	var src, dst [64]float64
+	for by := 0; by < comp.BlocksDown; by++ {
+		for bx := 0; bx < comp.BlocksAcross; bx++ {
+			for v := 0; v < 8; v++ {
+				for u := 0; u < 8; u++ {
+					sum := 0.0
+					for dy := 0; dy < sy; dy++ {
+						y := min((by*8+v)*sy+dy, height-1)
+						for dx := 0; dx < sx; dx++ {
+							x := min((bx*8+u)*sx+dx, width-1)
+							sum += plane[y*width+x]
+						}
+					}
+					src[v*8+u] = sum / float64(sx*sy)
+				}
+			}
+
+			dct.DCT8x8(&src, &dst)
+			block := &comp.Blocks[by*comp.BlocksAcross+bx]
+			for i := range block {
+				q := math.Round(dst[i] / float64(comp.Quant[i]))
+				block[i] = int32(max(-jpegMaxCoeff, min(jpegMaxCoeff, q)))
+			}
+		}
+	}
+}
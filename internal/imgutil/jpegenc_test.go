@@ -0,0 +1,70 @@
+package imgutil
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestEncodeJPEG_SubsampleRatios(t *testing.T) {
+	// Odd sizes exercise partial MCUs
+	img := image.NewRGBA(image.Rect(0, 0, 45, 29))
+	for y := 0; y < 29; y++ {
+		for x := 0; x < 45; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 5), uint8(y * 8), uint8(255 - x*y/6), 255})
+		}
+	}
+
+	ratios := []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440, image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410,
+	}
+	for _, ratio := range ratios {
+		t.Run(ratio.String(), func(t *testing.T) {
+			data, err := EncodeImageWithOptions(img, "jpeg", EncodeOptions{Quality: 95, JPEGSubsampleRatio: &ratio})
+			if err != nil {
+				t.Fatalf("EncodeImageWithOptions failed: %v", err)
+			}
+			decoded, format, err := LoadImage(data)
+			if err != nil {
+				t.Fatalf("LoadImage failed: %v", err)
+			}
+			if format != "jpeg" || decoded.Bounds() != img.Bounds() {
+				t.Fatalf("expected a %v jpeg, got a %v %s", img.Bounds(), decoded.Bounds(), format)
+			}
+			ycc, ok := decoded.(*image.YCbCr)
+			if !ok || ycc.SubsampleRatio != ratio {
+				t.Fatalf("expected %v subsampling, got %T", ratio, decoded)
+			}
+
+			// A smooth image survives quality 95 closely at any ratio
+			for y := 0; y < 29; y++ {
+				for x := 0; x < 45; x++ {
+					r0, g0, b0, _ := img.At(x, y).RGBA()
+					r1, g1, b1, _ := decoded.At(x, y).RGBA()
+					for _, d := range []int{int(r0>>8) - int(r1>>8), int(g0>>8) - int(g1>>8), int(b0>>8) - int(b1>>8)} {
+						if d < -24 || d > 24 {
+							t.Fatalf("pixel (%d,%d): expected %v, got %v", x, y, img.At(x, y), decoded.At(x, y))
+						}
+					}
+				}
+			}
+		})
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, 9, 9))
+	data, err := EncodeJPEG(gray, 90, image.YCbCrSubsampleRatio444)
+	if err != nil {
+		t.Fatalf("EncodeJPEG(gray) failed: %v", err)
+	}
+	if decoded, _, err := LoadImage(data); err != nil {
+		t.Fatalf("LoadImage(gray) failed: %v", err)
+	} else if _, ok := decoded.(*image.Gray); !ok {
+		t.Errorf("expected a grayscale JPEG, got %T", decoded)
+	}
+
+	if _, err := EncodeJPEG(img, 90, image.YCbCrSubsampleRatio(99)); !errors.Is(err, ErrUnsupportedJPEG) {
+		t.Errorf("expected ErrUnsupportedJPEG for an unknown ratio, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package imgutil
+
+// stdLuminanceQuant is the standard JPEG luminance quantization table
+// (ITU T.81 Annex K) in natural row-major order
+var stdLuminanceQuant = [64]int{
+	16, 11, 10, 16, 24, 40, 51, 61,
+	12, 12, 14, 19, 26, 58, 60, 55,
+	14, 13, 16, 24, 40, 57, 69, 56,
+	14, 17, 22, 29, 51, 87, 80, 62,
+	18, 22, 37, 56, 68, 109, 103, 77,
+	24, 35, 55, 64, 81, 104, 113, 92,
+	49, 64, 78, 87, 103, 121, 120, 101,
+	72, 92, 95, 98, 112, 100, 103, 99,
+}
+
+// stdChrominanceQuant is the standard JPEG chrominance quantization table
+// (ITU T.81 Annex K) in natural row-major order
+var stdChrominanceQuant = [64]int{
+	17, 18, 24, 47, 99, 99, 99, 99,
+	18, 21, 26, 66, 99, 99, 99, 99,
+	24, 26, 56, 99, 99, 99, 99, 99,
+	47, 66, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+	99, 99, 99, 99, 99, 99, 99, 99,
+}
+
+// JPEGLuminanceQuantTable returns the luminance quantization steps the
+// image/jpeg encoder uses at the given quality (1-100), in natural row-major
+// order, on the same scale as dct.DCT8x8 coefficients
+func JPEGLuminanceQuantTable(quality int) [64]float64 {
+	var table [64]float64
+	for i, step := range scaleQuant(&stdLuminanceQuant, quality) {
+		table[i] = float64(step)
+	}
+	return table
+}
+
+// scaleQuant scales a standard quantization table to the given quality
+// (1-100) with the same clamping and scaling as image/jpeg (the IJG formula)
+func scaleQuant(base *[64]int, quality int) [64]uint16 {
+	if quality < 1 {
+		quality = 1
+	} else if quality > 100 {
+		quality = 100
+	}
+	var scale int
+	if quality < 50 {
+		scale = 5000 / quality
+	} else {
+		scale = 200 - quality*2
+	}
+
+	var table [64]uint16
+	for i, q := range base {
+		step := (q*scale + 50) / 100
+		if step < 1 {
+			step = 1
+		} else if step > 255 {
+			step = 255
+		}
+		table[i] = uint16(step)
+	}
+	return table
+}
@@ -0,0 +1,128 @@
+package imgutil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// pngSignature is the 8-byte header every PNG file starts with
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+var (
+	// ErrInvalidPNG indicates data isn't a well-formed sequence of PNG chunks
+	ErrInvalidPNG = errors.New("invalid PNG data")
+)
+
+// pngChunk is one chunk of a PNG file, data excluding the length, type and CRC
+type pngChunk struct {
+	typ  string
+	data []byte
+	// start is the chunk's offset in the file
+	start int
+}
+
+// pngChunks splits data into its chunks, checking their CRCs
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, fmt.Errorf("%w: missing signature", ErrInvalidPNG)
+	}
+	var chunks []pngChunk
+	for pos := len(pngSignature); pos < len(data); {
+		if len(data)-pos < 12 {
+			return nil, fmt.Errorf("%w: truncated chunk", ErrInvalidPNG)
+		}
+		length := binary.BigEndian.Uint32(data[pos : pos+4])
+		if uint64(length) > uint64(len(data)-pos-12) {
+			return nil, fmt.Errorf("%w: truncated chunk", ErrInvalidPNG)
+		}
+		end := pos + 8 + int(length)
+		if crc32.ChecksumIEEE(data[pos+4:end]) != binary.BigEndian.Uint32(data[end:end+4]) {
+			return nil, fmt.Errorf("%w: chunk CRC mismatch", ErrInvalidPNG)
+		}
+		chunks = append(chunks, pngChunk{typ: string(data[pos+4 : pos+8]), data: data[pos+8 : end], start: pos})
+		pos = end + 4
+	}
+	return chunks, nil
+}
+
+// AddPNGText returns the PNG data with a zTXt (compressed text) chunk holding
+// text under keyword inserted before the IEND chunk; decoders that don't know
+// the keyword ignore it
+// keyword must be 1-79 printable Latin-1 characters, and text should be
+// Latin-1 (e.g. base64 for binary data)
+func AddPNGText(data []byte, keyword, text string) ([]byte, error) {
+	if len(keyword) == 0 || len(keyword) > 79 {
+		return nil, fmt.Errorf("%w: keyword must be 1-79 bytes, got %d", ErrInvalidPNG, len(keyword))
+	}
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil, err
+	}
+	last := chunks[len(chunks)-1]
+	if last.typ != "IEND" {
+		return nil, fmt.Errorf("%w: missing IEND chunk", ErrInvalidPNG)
+	}
+
+	var body bytes.Buffer
+	body.WriteString(keyword)
+	// Null separator, then compression method 0 (zlib)
+	body.Write([]byte{0, 0})
+	zw := zlib.NewWriter(&body)
+	if _, err := io.WriteString(zw, text); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	chunk := make([]byte, 0, 12+body.Len())
+	chunk = binary.BigEndian.AppendUint32(chunk, uint32(body.Len()))
+	chunk = append(chunk, "zTXt"...)
+	chunk = append(chunk, body.Bytes()...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	out := make([]byte, 0, len(data)+len(chunk))
+	out = append(out, data[:last.start]...)
+	out = append(out, chunk...)
+	return append(out, data[last.start:]...), nil
+}
+
+// ReadPNGText returns the text stored under keyword in the first tEXt or zTXt
+// chunk of the PNG data with that keyword, and whether one was found
+func ReadPNGText(data []byte, keyword string) (string, bool, error) {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return "", false, err
+	}
+	for _, c := range chunks {
+		if c.typ != "tEXt" && c.typ != "zTXt" {
+			continue
+		}
+		key, rest, ok := bytes.Cut(c.data, []byte{0})
+		if !ok || string(key) != keyword {
+			continue
+		}
+		if c.typ == "tEXt" {
+			return string(rest), true, nil
+		}
+
+		if len(rest) == 0 || rest[0] != 0 {
+			return "", false, fmt.Errorf("%w: unknown zTXt compression method", ErrInvalidPNG)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(rest[1:]))
+		if err != nil {
+			return "", false, fmt.Errorf("%w: zTXt: %w", ErrInvalidPNG, err)
+		}
+		text, err := io.ReadAll(zr)
+		if err != nil {
+			return "", false, fmt.Errorf("%w: zTXt: %w", ErrInvalidPNG, err)
+		}
+		return string(text), true, nil
+	}
+	return "", false, nil
+}
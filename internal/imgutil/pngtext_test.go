@@ -0,0 +1,56 @@
+package imgutil
+
+import (
+	"errors"
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestPNGText_RoundTrip(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 9, 5))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i * 5)
+	}
+	data, err := EncodeImage(img, "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+	if _, found, err := ReadPNGText(data, "key"); err != nil || found {
+		t.Fatalf("expected no text chunk, got found %v, err %v", found, err)
+	}
+
+	text := strings.Repeat("spilled text ", 100)
+	withText, err := AddPNGText(data, "key", text)
+	if err != nil {
+		t.Fatalf("AddPNGText failed: %v", err)
+	}
+	got, found, err := ReadPNGText(withText, "key")
+	if err != nil || !found {
+		t.Fatalf("ReadPNGText failed: found %v, err %v", found, err)
+	}
+	if got != text {
+		t.Errorf("text mismatch: got %q", got)
+	}
+	if _, found, _ := ReadPNGText(withText, "other"); found {
+		t.Error("expected no text under another keyword")
+	}
+
+	// The image itself is unchanged
+	decoded, _, err := LoadImage(withText)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	if string(decoded.(*image.Gray).Pix) != string(img.Pix) {
+		t.Error("pixels changed by adding a text chunk")
+	}
+
+	corrupt := append([]byte(nil), withText...)
+	corrupt[len(corrupt)-20] ^= 0xFF
+	if _, _, err := ReadPNGText(corrupt, "key"); !errors.Is(err, ErrInvalidPNG) {
+		t.Errorf("expected ErrInvalidPNG for a corrupt chunk, got %v", err)
+	}
+	if _, err := AddPNGText([]byte("not a png"), "key", text); !errors.Is(err, ErrInvalidPNG) {
+		t.Errorf("expected ErrInvalidPNG for non-PNG data, got %v", err)
+	}
+}
@@ -0,0 +1,430 @@
+package imgutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"slices"
+)
+
+// Lossless WebP (VP8L) encoder, as golang.org/x/image/webp only decodes
+// It applies the subtract-green and predictor transforms and Huffman-codes
+// the residuals, without backward references or a color cache, so files are
+// larger than libwebp's, but every pixel survives exactly
+
+const (
+	// vp8lSignature is the first byte of a VP8L bitstream
+	vp8lSignature = 0x2f
+	// vp8lMaxSize is the largest width or height VP8L can store
+	vp8lMaxSize = 1 << 14
+	// vp8lPredictorBits is the log2 tile size of the predictor transform
+	vp8lPredictorBits = 4
+	// vp8lMaxCodeLength is the longest Huffman code VP8L allows, and
+	// vp8lMaxCodeLengthCodeLength the longest for coding code lengths
+	vp8lMaxCodeLength           = 15
+	vp8lMaxCodeLengthCodeLength = 7
+
+	vp8lTransformPredictor     = 0
+	vp8lTransformSubtractGreen = 2
+)
+
+// vp8lCodeLengthOrder is the order the code length code's lengths are stored in
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// vp8lAlphabetSizes are the sizes of a prefix code group's alphabets: green
+// (literals and 24 length prefixes), red, blue, alpha and distance
+var vp8lAlphabetSizes = [5]int{256 + 24, 256, 256, 256, 40}
+
+// vp8lPredictorModes are the predictor modes tried for each tile: L, T and
+// the average of L and T
+var vp8lPredictorModes = []uint32{1, 2, 7}
+
+// bitWriter packs values LSB first, as VP8L stores them
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nAcc uint
+}
+
+// write appends the low n bits of v
+func (w *bitWriter) write(v uint32, n uint) {
+	w.acc |= uint64(v) << w.nAcc
+	w.nAcc += n
+	for w.nAcc >= 8 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc >>= 8
+		w.nAcc -= 8
+	}
+}
+
+// bytes returns the written bits, the last byte zero padded
+func (w *bitWriter) bytes() []byte {
+	if w.nAcc > 0 {
+		w.buf = append(w.buf, byte(w.acc))
+		w.acc, w.nAcc = 0, 0
+	}
+	return w.buf
+}
+
+// encodeWebP writes img as a lossless WebP file
+func encodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 || width > vp8lMaxSize || height > vp8lMaxSize {
+		return fmt.Errorf("WebP images must be 1-%d pixels across, got %dx%d", vp8lMaxSize, width, height)
+	}
+	nrgba := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+
+	// ARGB pixels, with green subtracted from red and blue
+	argb := make([]uint32, width*height)
+	hasAlpha := false
+	for i := range argb {
+		p := nrgba.Pix[i*4 : i*4+4 : i*4+4]
+		r, g, bl, a := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+		argb[i] = a<<24 | (r-g)&0xff<<16 | g<<8 | (bl-g)&0xff
+		hasAlpha = hasAlpha || a != 0xff
+	}
+	modes, residuals := vp8lPredict(argb, width, height)
+
+	bw := &bitWriter{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+	// The decoder undoes the transforms in reverse order
+	bw.write(1, 1)
+	bw.write(vp8lTransformSubtractGreen, 2)
+	bw.write(1, 1)
+	bw.write(vp8lTransformPredictor, 2)
+	bw.write(vp8lPredictorBits-2, 3)
+	writeVP8LImage(bw, modes, false)
+	bw.write(0, 1) // no more transforms
+	writeVP8LImage(bw, residuals, true)
+	data := bw.bytes()
+
+	pad := len(data) & 1
+	header := make([]byte, 0, 20)
+	header = append(header, "RIFF"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(4+8+len(data)+pad))
+	header = append(header, "WEBPVP8L"...)
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if pad != 0 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+// vp8lPredict applies the predictor transform to the ARGB pixels, choosing
+// for each tile the mode with the smallest residuals
+// Returns the transform's sub-image (each tile's mode in green) and the
+// residuals
+func vp8lPredict(argb []uint32, width, height int) (modes, residuals []uint32) {
+	tile := 1 << vp8lPredictorBits
+	tilesX, tilesY := (width+tile-1)/tile, (height+tile-1)/tile
+	modes = make([]uint32, tilesX*tilesY)
+	residuals = make([]uint32, len(argb))
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			x0, y0 := tx*tile, ty*tile
+			x1, y1 := min(x0+tile, width), min(y0+tile, height)
+			best, bestCost := vp8lPredictorModes[0], -1
+			for _, mode := range vp8lPredictorModes {
+				cost := 0
+				for y := y0; y < y1; y++ {
+					for x := x0; x < x1; x++ {
+						cost += residualCost(subPixels(argb[y*width+x], vp8lPrediction(argb, width, x, y, mode)))
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[ty*tilesX+tx] = best << 8
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					residuals[y*width+x] = subPixels(argb[y*width+x], vp8lPrediction(argb, width, x, y, best))
+				}
+			}
+		}
+	}
+	return modes, residuals
+}
+
+// vp8lPrediction returns the predicted pixel at (x, y) under mode; the top
+// left pixel, top row and left column use fixed predictors
+func vp8lPrediction(argb []uint32, width, x, y int, mode uint32) uint32 {
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		mode = 1
+	case x == 0:
+		mode = 2
+	}
+	left, top := argb[y*width+x-min(x, 1)], argb[max(y-1, 0)*width+x]
+	switch mode {
+	case 1:
+		return left
+	case 2:
+		return top
+	default:
+		return averagePixels(left, top)
+	}
+}
+
+// subPixels subtracts b from a channel by channel, modulo 256
+func subPixels(a, b uint32) uint32 {
+	alphaGreen := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	redBlue := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return alphaGreen&0xff00ff00 | redBlue&0x00ff00ff
+}
+
+// averagePixels returns the channel by channel average of a and b, rounded down
+func averagePixels(a, b uint32) uint32 {
+	return (a^b)&0xfefefefe>>1 + a&b
+}
+
+// residualCost estimates the cost of coding a residual: the sum of its
+// channels' distances from zero
+func residualCost(p uint32) int {
+	cost := 0
+	for shift := 0; shift < 32; shift += 8 {
+		v := int(p >> shift & 0xff)
+		cost += min(v, 256-v)
+	}
+	return cost
+}
+
+// writeVP8LImage writes ARGB pixels as a VP8L entropy-coded image, with a
+// single prefix code group and only literal pixels; topLevel is true for
+// the main image, which may have a meta prefix image
+func writeVP8LImage(bw *bitWriter, argb []uint32, topLevel bool) {
+	bw.write(0, 1) // no color cache
+	if topLevel {
+		bw.write(0, 1) // no meta prefix image
+	}
+	var hist [5][]int
+	for i, size := range vp8lAlphabetSizes {
+		hist[i] = make([]int, size)
+	}
+	for _, p := range argb {
+		hist[0][p>>8&0xff]++
+		hist[1][p>>16&0xff]++
+		hist[2][p&0xff]++
+		hist[3][p>>24]++
+	}
+	var codes [5][]uint32
+	var lengths [5][]uint8
+	for i := range hist {
+		codes[i], lengths[i] = writeVP8LPrefixCode(bw, hist[i])
+	}
+	for _, p := range argb {
+		// Green, red, blue, then alpha
+		for i, v := range [4]uint32{p >> 8 & 0xff, p >> 16 & 0xff, p & 0xff, p >> 24} {
+			writeCode(bw, codes[i][v], lengths[i][v])
+		}
+	}
+}
+
+// writeVP8LPrefixCode writes a prefix code for symbols with the given
+// histogram, returning each symbol's code and its length as written (zero
+// for a code with a single symbol)
+func writeVP8LPrefixCode(bw *bitWriter, hist []int) ([]uint32, []uint8) {
+	var used []int
+	for symbol, n := range hist {
+		if n > 0 {
+			used = append(used, symbol)
+		}
+	}
+	codes, lengths := make([]uint32, len(hist)), make([]uint8, len(hist))
+
+	if len(used) <= 2 && (len(used) == 0 || used[len(used)-1] < 256) {
+		// Simple code: one or two 8-bit symbols, coded in 0 or 1 bits
+		if len(used) == 0 {
+			used = []int{0}
+		}
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		if used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(used[0]), 8)
+		}
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			codes[used[1]] = 1
+			lengths[used[0]], lengths[used[1]] = 1, 1
+		}
+		return codes, lengths
+	}
+
+	// Normal code: the code lengths, themselves coded with a code of
+	// lengths 0-15 (the repeat codes aren't used)
+	lengths = huffmanCodeLengths(hist, vp8lMaxCodeLength)
+	var clHist [19]int
+	for _, l := range lengths {
+		clHist[l]++
+	}
+	clLengths := huffmanCodeLengths(clHist[:], vp8lMaxCodeLengthCodeLength)
+	clCodes := canonicalCodes(clLengths)
+	stored := 4
+	for i, symbol := range vp8lCodeLengthOrder {
+		if clLengths[symbol] > 0 {
+			stored = max(stored, i+1)
+		}
+	}
+	bw.write(0, 1)
+	bw.write(uint32(stored-4), 4)
+	for _, symbol := range vp8lCodeLengthOrder[:stored] {
+		bw.write(uint32(clLengths[symbol]), 3)
+	}
+	bw.write(0, 1) // lengths for the whole alphabet follow
+	// A code with a single symbol is read with no bits
+	if countNonZero(clHist[:]) > 1 {
+		for _, l := range lengths {
+			writeCode(bw, clCodes[l], clLengths[l])
+		}
+	}
+	codes = canonicalCodes(lengths)
+	if len(used) == 1 {
+		clear(lengths)
+	}
+	return codes, lengths
+}
+
+// countNonZero returns the number of non-zero counts in hist
+func countNonZero(hist []int) int {
+	n := 0
+	for _, v := range hist {
+		if v > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// writeCode writes a canonical Huffman code of the given length, most
+// significant bit first
+func writeCode(bw *bitWriter, code uint32, length uint8) {
+	var reversed uint32
+	for i := uint8(0); i < length; i++ {
+		reversed = reversed<<1 | code>>i&1
+	}
+	bw.write(reversed, uint(length))
+}
+
+// huffmanCodeLengths returns Huffman code lengths for symbols with the
+// given histogram, no longer than maxLength; unused symbols get length 0,
+// and a single used symbol gets length 1
+// Counts are flattened until the code fits, which always happens once they
+// are all 1 as long as maxLength covers a balanced tree
+func huffmanCodeLengths(hist []int, maxLength int) []uint8 {
+	lengths := make([]uint8, len(hist))
+	for shift := 0; ; shift++ {
+		if buildHuffmanLengths(hist, shift, lengths) <= maxLength {
+			return lengths
+		}
+	}
+}
+
+// buildHuffmanLengths fills lengths with the Huffman code lengths for hist's
+// counts shifted right by shift (at least 1 for used symbols), returning the
+// longest
+func buildHuffmanLengths(hist []int, shift int, lengths []uint8) int {
+	clear(lengths)
+	type leaf struct{ weight, symbol int }
+	var leaves []leaf
+	for symbol, n := range hist {
+		if n > 0 {
+			leaves = append(leaves, leaf{max(n>>shift, 1), symbol})
+		}
+	}
+	switch len(leaves) {
+	case 0:
+		return 0
+	case 1:
+		lengths[leaves[0].symbol] = 1
+		return 1
+	}
+	slices.SortFunc(leaves, func(a, b leaf) int {
+		if a.weight != b.weight {
+			return a.weight - b.weight
+		}
+		return a.symbol - b.symbol
+	})
+
+	// Merge the two lightest nodes until one is left, taking from the
+	// sorted leaves and the internal nodes, which are created in order
+	n := len(leaves)
+	weight := make([]int, n, 2*n-1)
+	parent := make([]int, 2*n-1)
+	for i, l := range leaves {
+		weight[i] = l.weight
+	}
+	nextLeaf, nextNode := 0, n
+	pick := func() int {
+		if nextLeaf < n && (nextNode >= len(weight) || weight[nextLeaf] <= weight[nextNode]) {
+			nextLeaf++
+			return nextLeaf - 1
+		}
+		nextNode++
+		return nextNode - 1
+	}
+	for len(weight) < 2*n-1 {
+		a, b := pick(), pick()
+		weight = append(weight, weight[a]+weight[b])
+		parent[a], parent[b] = len(weight)-1, len(weight)-1
+	}
+
+	// Parents come after their children, so depths follow from the root down
+	depth := make([]int, 2*n-1)
+	longest := 0
+	for node := 2*n - 3; node >= 0; node-- {
+		depth[node] = depth[parent[node]] + 1
+		if node < n {
+			lengths[leaves[node].symbol] = uint8(depth[node])
+			longest = max(longest, depth[node])
+		}
+	}
+	return longest
+}
+
+// canonicalCodes returns the canonical Huffman codes for code lengths
+func canonicalCodes(lengths []uint8) []uint32 {
+	var count [vp8lMaxCodeLength + 1]uint32
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [vp8lMaxCodeLength + 1]uint32
+	code := uint32(0)
+	for l := 1; l <= vp8lMaxCodeLength; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	codes := make([]uint32, len(lengths))
+	for symbol, l := range lengths {
+		if l > 0 {
+			codes[symbol] = next[l]
+			next[l]++
+		}
+	}
+	return codes
+}
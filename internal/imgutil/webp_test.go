@@ -0,0 +1,74 @@
+package imgutil
+
+import (
+	"image"
+	"image/color"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestWebP_RoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	tests := []struct {
+		name          string
+		width, height int
+		pixel         func(x, y int) color.NRGBA
+	}{
+		{"single pixel", 1, 1, func(x, y int) color.NRGBA { return color.NRGBA{R: 10, G: 200, B: 30, A: 255} }},
+		{"flat", 20, 20, func(x, y int) color.NRGBA { return color.NRGBA{R: 90, G: 90, B: 90, A: 255} }},
+		{"gradient", 37, 29, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(x * 7), G: uint8(y * 9), B: uint8(x * y), A: 255}
+		}},
+		{"alpha", 13, 7, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(x * 19), G: uint8(y * 36), B: 77, A: uint8(x*y*5 + 1)}
+		}},
+		{"noise", 64, 48, func(x, y int) color.NRGBA {
+			return color.NRGBA{R: uint8(rng.IntN(256)), G: uint8(rng.IntN(256)), B: uint8(rng.IntN(256)), A: 255}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image.NewNRGBA(image.Rect(0, 0, tt.width, tt.height))
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					img.SetNRGBA(x, y, tt.pixel(x, y))
+				}
+			}
+
+			data, err := EncodeImage(img, "webp", 0)
+			if err != nil {
+				t.Fatalf("EncodeImage failed: %v", err)
+			}
+			if got := SniffFormat(data); got != "webp" {
+				t.Errorf("expected SniffFormat webp, got %q", got)
+			}
+			decoded, format, err := LoadImage(data)
+			if err != nil {
+				t.Fatalf("LoadImage failed: %v", err)
+			}
+			if format != "webp" {
+				t.Errorf("expected format webp, got %s", format)
+			}
+			if decoded.Bounds() != img.Bounds() {
+				t.Fatalf("expected bounds %v, got %v", img.Bounds(), decoded.Bounds())
+			}
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					if got, want := color.NRGBAModel.Convert(decoded.At(x, y)), img.NRGBAAt(x, y); got != want {
+						t.Fatalf("pixel (%d,%d): expected %v, got %v", x, y, want, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestWebP_SizeLimits(t *testing.T) {
+	if _, err := EncodeImage(image.NewGray(image.Rect(0, 0, 0, 5)), "webp", 0); err == nil {
+		t.Error("expected an error for an empty image")
+	}
+	if _, err := EncodeImage(image.NewGray(image.Rect(0, 0, 1<<14+1, 1)), "webp", 0); err == nil {
+		t.Error("expected an error for an image wider than VP8L allows")
+	}
+}
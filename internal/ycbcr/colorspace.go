@@ -0,0 +1,47 @@
+package ycbcr
+
+// ColorSpace selects the matrix used for RGB <-> YCbCr conversion
+// Embedding and extraction must use the same one, as Y differs between them
+type ColorSpace uint8
+
+const (
+	// BT601 is the SD matrix used by JPEG/JFIF (the default)
+	BT601 ColorSpace = iota
+	// BT709 is the HD matrix matching most sRGB/HD content
+	BT709
+)
+
+// matrix holds the coefficients of a ColorSpace
+type matrix struct {
+	// Forward: Y, Cb, Cr (without the +128 offset) from R, G, B
+	yR, yG, yB    float64
+	cbR, cbG, cbB float64
+	crR, crG, crB float64
+	// Inverse: R = Y + rCr*Cr, G = Y - gCb*Cb - gCr*Cr, B = Y + bCb*Cb
+	rCr, gCb, gCr, bCb float64
+}
+
+var (
+	// bt601 holds the BT.601 coefficients
+	bt601 = matrix{
+		yR: 0.299, yG: 0.587, yB: 0.114,
+		cbR: -0.168736, cbG: -0.331264, cbB: 0.5,
+		crR: 0.5, crG: -0.418688, crB: -0.081312,
+		rCr: 1.402, gCb: 0.344136, gCr: 0.714136, bCb: 1.772,
+	}
+	// bt709 holds the BT.709 coefficients
+	bt709 = matrix{
+		yR: 0.2126, yG: 0.7152, yB: 0.0722,
+		cbR: -0.114572, cbG: -0.385428, cbB: 0.5,
+		crR: 0.5, crG: -0.454153, crB: -0.045847,
+		rCr: 1.5748, gCb: 0.187324, gCr: 0.468124, bCb: 1.8556,
+	}
+)
+
+// matrix returns the coefficients for the color space (BT.601 if unknown)
+func (cs ColorSpace) matrix() *matrix {
+	if cs == BT709 {
+		return &bt709
+	}
+	return &bt601
+}
@@ -0,0 +1,63 @@
+package ycbcr
+
+import "math"
+
+// Rounding selects how the fractional RGB values converted from the planes
+// are rounded to 8-bit levels; the planes themselves stay float64 throughout,
+// so this is the only point where precision is lost
+type Rounding uint8
+
+const (
+	// RoundHalfUp rounds each channel to the nearest level, ties up (the default)
+	RoundHalfUp Rounding = iota
+	// RoundHalfEven rounds each channel to the nearest level, ties to even
+	// (banker's rounding), so exact ties don't bias the image brighter
+	RoundHalfEven
+	// RoundLuma rounds each channel of a pixel up or down, picking the
+	// combination whose luma is closest to the pixel's Y value, so the
+	// quantization error goes to chroma instead of Y. A channel can move by
+	// up to a whole level rather than half of one
+	RoundLuma
+)
+
+// round converts r, g, b (0-255 scale, unclamped) to 8-bit levels with the
+// rounding mode; y is the pixel's Y value, which RoundLuma preserves
+func (m *matrix) round(r, g, b, y float64, mode Rounding) (uint8, uint8, uint8) {
+	switch mode {
+	case RoundHalfEven:
+		return clampEven(r), clampEven(g), clampEven(b)
+	case RoundLuma:
+		return m.roundLuma(r, g, b, y)
+	}
+	return clamp(r), clamp(g), clamp(b)
+}
+
+// roundLuma tries rounding each channel down and up, keeping the combination
+// whose luma is closest to y, then the one closest to r, g, b
+func (m *matrix) roundLuma(r, g, b, y float64) (uint8, uint8, uint8) {
+	v := [3]float64{r, g, b}
+	var best [3]float64
+	bestLuma, bestDist := math.Inf(1), math.Inf(1)
+	for combo := 0; combo < 8; combo++ {
+		var c [3]float64
+		var dist float64
+		for i := range c {
+			c[i] = math.Floor(v[i])
+			if combo>>i&1 == 1 {
+				c[i]++
+			}
+			c[i] = min(max(c[i], 0), 255)
+			dist += (c[i] - v[i]) * (c[i] - v[i])
+		}
+		luma := math.Abs(m.yR*c[0] + m.yG*c[1] + m.yB*c[2] - y)
+		if luma < bestLuma-1e-9 || (luma < bestLuma+1e-9 && dist < bestDist) {
+			best, bestLuma, bestDist = c, luma, dist
+		}
+	}
+	return uint8(best[0]), uint8(best[1]), uint8(best[2])
+}
+
+// clampEven clamps a float64 value to [0, 255] and rounds it half to even
+func clampEven(v float64) uint8 {
+	return uint8(math.RoundToEven(min(max(v, 0), 255)))
+}
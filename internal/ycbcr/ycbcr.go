@@ -17,6 +17,32 @@ type Plane struct {
 // Uses BT.601 coefficients for RGB to YCbCr conversion
 // If the image is already YCbCr, preserves values directly
 func ImageToYCbCrPlanes(img image.Image) (y, cb, cr *Plane) {
+	y, cb, cr, _ = ImageToYCbCrPlanesWithAlpha(img, BT601)
+	return y, cb, cr
+}
+
+// SubsampleRatio returns the chroma subsampling of img: the ratio of an
+// *image.YCbCr (as decoded from JPEG), or 4:4:4 for other images, whose
+// chroma is stored at full resolution
+// ImageToYCbCrPlanes always upsamples chroma to full resolution, so this is
+// the only record of the source's chroma resolution
+func SubsampleRatio(img image.Image) image.YCbCrSubsampleRatio {
+	if src, ok := img.(*image.YCbCr); ok {
+		return src.SubsampleRatio
+	}
+	return image.YCbCrSubsampleRatio444
+}
+
+// ImageToYCbCrPlanesWithAlpha converts an image to Y, Cb, Cr planes using the
+// given color space, plus an alpha plane (0-255), which is nil if the image
+// is fully opaque
+// Colors are taken non-premultiplied, so translucent pixels keep their color
+// Planes are always on the 0-255 scale; 16-bit images (see Is16Bit) keep
+// their full precision as fractional values
+// Gray, NRGBA and paletted images are read directly rather than pixel by
+// pixel through At
+func ImageToYCbCrPlanesWithAlpha(img image.Image, cs ColorSpace) (y, cb, cr, alpha *Plane) {
+	m := cs.matrix()
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
@@ -26,47 +52,228 @@ func ImageToYCbCrPlanes(img image.Image) (y, cb, cr *Plane) {
 	cbPix := make([]float64, width*height)
 	crPix := make([]float64, width*height)
 
+	// Grayscale images are pure luma: copy it and leave chroma neutral
+	switch gray := img.(type) {
+	case *image.Gray:
+		for y := 0; y < height; y++ {
+			row := gray.Pix[y*gray.Stride : y*gray.Stride+width]
+			for x, v := range row {
+				yPix[y*stride+x] = float64(v)
+			}
+		}
+		fillNeutral(cbPix, crPix)
+		y, cb, cr = planes(yPix, cbPix, crPix, width, height, stride)
+		return y, cb, cr, nil
+	case *image.Gray16:
+		for y := 0; y < height; y++ {
+			row := gray.Pix[y*gray.Stride : y*gray.Stride+width*2]
+			for x := 0; x < width; x++ {
+				yPix[y*stride+x] = float64(uint16(row[x*2])<<8|uint16(row[x*2+1])) / 257.0
+			}
+		}
+		fillNeutral(cbPix, crPix)
+		y, cb, cr = planes(yPix, cbPix, crPix, width, height, stride)
+		return y, cb, cr, nil
+	}
+
+	var alphaPix []float64
+	if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+		alphaPix = make([]float64, width*height)
+	}
+	opaque := true
+	deep := Is16Bit(img)
+
+	// setAlpha records a pixel's alpha, noting whether any isn't opaque
+	setAlpha := func(idx int, a8 float64) {
+		if alphaPix != nil {
+			alphaPix[idx] = a8
+			if a8 != 255 {
+				opaque = false
+			}
+		}
+	}
+
 	// Convert from image to YCbCr planes
-	// Handle YCbCr images specially to extract Y, Cb, Cr directly
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			idx := y*stride + x
-			c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
-			
-			// Check if the color is already YCbCr
-			if ycbcrColor, ok := c.(color.YCbCr); ok {
-				// Extract Y, Cb, Cr directly from YCbCr color
-				yPix[idx] = float64(ycbcrColor.Y)
-				cbPix[idx] = float64(ycbcrColor.Cb)
-				crPix[idx] = float64(ycbcrColor.Cr)
-			} else {
-				// Convert from RGB to YCbCr
-				r, g, b, _ := c.RGBA()
-
-				// Convert from 16-bit to 8-bit
-				r8 := float64(r >> 8)
-				g8 := float64(g >> 8)
-				b8 := float64(b >> 8)
-
-				// BT.601 coefficients
-				// Y  = 0.299*R + 0.587*G + 0.114*B
-				// Cb = -0.168736*R - 0.331264*G + 0.5*B + 128
-				// Cr = 0.5*R - 0.418688*G - 0.081312*B + 128
-				yPix[idx] = 0.299*r8 + 0.587*g8 + 0.114*b8
-				cbPix[idx] = -0.168736*r8 - 0.331264*g8 + 0.5*b8 + 128.0
-				crPix[idx] = 0.5*r8 - 0.418688*g8 - 0.081312*b8 + 128.0
+	switch src := img.(type) {
+	case *image.NRGBA:
+		// Read the non-premultiplied samples directly, so translucent pixels
+		// keep their exact color
+		for y := 0; y < height; y++ {
+			row := src.Pix[y*src.Stride : y*src.Stride+width*4]
+			for x := 0; x < width; x++ {
+				idx := y*stride + x
+				p := row[x*4 : x*4+4]
+				yPix[idx], cbPix[idx], crPix[idx] = m.fromRGB(float64(p[0]), float64(p[1]), float64(p[2]))
+				setAlpha(idx, float64(p[3]))
+			}
+		}
+	case *image.Paletted:
+		// Convert each palette entry once, then look pixels up by index
+		// (indices past the palette read as transparent black)
+		var entries [256][4]float64
+		for i, c := range src.Palette {
+			n := color.NRGBAModel.Convert(c).(color.NRGBA)
+			entries[i][0], entries[i][1], entries[i][2] = m.fromRGB(float64(n.R), float64(n.G), float64(n.B))
+			entries[i][3] = float64(n.A)
+		}
+		for i := len(src.Palette); i < len(entries); i++ {
+			entries[i][0], entries[i][1], entries[i][2] = m.fromRGB(0, 0, 0)
+		}
+		for y := 0; y < height; y++ {
+			row := src.Pix[y*src.Stride : y*src.Stride+width]
+			for x, v := range row {
+				idx := y*stride + x
+				e := &entries[v]
+				yPix[idx], cbPix[idx], crPix[idx] = e[0], e[1], e[2]
+				setAlpha(idx, e[3])
 			}
 		}
+	default:
+		// Handle YCbCr images specially to extract Y, Cb, Cr directly
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				idx := y*stride + x
+				c := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+
+				// Check if the color is already YCbCr (JPEG YCbCr is BT.601)
+				if ycbcrColor, ok := c.(color.YCbCr); ok && cs == BT601 {
+					// Extract Y, Cb, Cr directly from YCbCr color
+					yPix[idx] = float64(ycbcrColor.Y)
+					cbPix[idx] = float64(ycbcrColor.Cb)
+					crPix[idx] = float64(ycbcrColor.Cr)
+					setAlpha(idx, 255)
+					continue
+				}
+
+				// Convert from non-premultiplied RGB to YCbCr
+				var r8, g8, b8, a8 float64
+				if deep {
+					n := color.NRGBA64Model.Convert(c).(color.NRGBA64)
+					r8 = float64(n.R) / 257.0
+					g8 = float64(n.G) / 257.0
+					b8 = float64(n.B) / 257.0
+					a8 = float64(n.A) / 257.0
+				} else {
+					n := color.NRGBAModel.Convert(c).(color.NRGBA)
+					r8 = float64(n.R)
+					g8 = float64(n.G)
+					b8 = float64(n.B)
+					a8 = float64(n.A)
+				}
+				yPix[idx], cbPix[idx], crPix[idx] = m.fromRGB(r8, g8, b8)
+				setAlpha(idx, a8)
+			}
+		}
+	}
+
+	y, cb, cr = planes(yPix, cbPix, crPix, width, height, stride)
+	if alphaPix != nil && !opaque {
+		alpha = &Plane{Pix: alphaPix, Width: width, Height: height, Stride: stride}
+	}
+	return y, cb, cr, alpha
+}
+
+// Is16Bit reports whether img stores 16 bits per channel, so converting
+// it back should use YCbCrPlanesToImage16 or YPlaneToGray16
+func Is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.RGBA64, *image.NRGBA64, *image.Gray16:
+		return true
 	}
+	return false
+}
 
+// planes wraps the Y, Cb, Cr sample slices in Planes of the given size
+func planes(yPix, cbPix, crPix []float64, width, height, stride int) (y, cb, cr *Plane) {
 	return &Plane{Pix: yPix, Width: width, Height: height, Stride: stride},
 		&Plane{Pix: cbPix, Width: width, Height: height, Stride: stride},
 		&Plane{Pix: crPix, Width: width, Height: height, Stride: stride}
 }
 
+// fillNeutral sets every chroma sample to the neutral value 128
+func fillNeutral(cbPix, crPix []float64) {
+	for i := range cbPix {
+		cbPix[i] = 128.0
+		crPix[i] = 128.0
+	}
+}
+
+// IsNeutral reports whether every chroma sample rounds to 128 (no color)
+func IsNeutral(cb, cr *Plane) bool {
+	for y := 0; y < cb.Height; y++ {
+		for x := 0; x < cb.Width; x++ {
+			if clamp(cb.Pix[y*cb.Stride+x]) != 128 || clamp(cr.Pix[y*cr.Stride+x]) != 128 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Pad returns p extended to width x height by repeating its last column and
+// row, so the padding blends into the edge; p is returned as is if it already
+// covers that size (or is nil)
+func (p *Plane) Pad(width, height int) *Plane {
+	if p == nil || (width <= p.Width && height <= p.Height) {
+		return p
+	}
+	width = max(width, p.Width)
+	height = max(height, p.Height)
+
+	pix := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		src := min(y, p.Height-1) * p.Stride
+		row := pix[y*width : (y+1)*width]
+		copy(row, p.Pix[src:src+p.Width])
+		for x := p.Width; x < width; x++ {
+			row[x] = row[p.Width-1]
+		}
+	}
+	return &Plane{Pix: pix, Width: width, Height: height, Stride: width}
+}
+
+// YPlaneToGray converts a Y plane to a grayscale image
+// Use it instead of YCbCrPlanesToImage when the chroma is neutral (see
+// IsNeutral) to keep grayscale output grayscale and small
+func YPlaneToGray(y *Plane) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, y.Width, y.Height))
+	for yIdx := 0; yIdx < y.Height; yIdx++ {
+		row := img.Pix[yIdx*img.Stride : yIdx*img.Stride+y.Width]
+		for xIdx := range row {
+			row[xIdx] = clamp(y.Pix[yIdx*y.Stride+xIdx])
+		}
+	}
+	return img
+}
+
+// YPlaneToGray16 converts a Y plane to a 16-bit grayscale image, keeping the
+// plane's fractional precision
+func YPlaneToGray16(y *Plane) *image.Gray16 {
+	img := image.NewGray16(image.Rect(0, 0, y.Width, y.Height))
+	for yIdx := 0; yIdx < y.Height; yIdx++ {
+		for xIdx := 0; xIdx < y.Width; xIdx++ {
+			img.SetGray16(xIdx, yIdx, color.Gray16{Y: clamp16(y.Pix[yIdx*y.Stride+xIdx])})
+		}
+	}
+	return img
+}
+
 // YCbCrPlanesToImage converts Y, Cb, Cr planes back to an RGBA image
 // Converts to RGBA explicitly to ensure consistent conversion when PNG encodes
 func YCbCrPlanesToImage(y, cb, cr *Plane) *image.RGBA {
+	return YCbCrPlanesToImageIn(y, cb, cr, BT601)
+}
+
+// YCbCrPlanesToImageIn converts Y, Cb, Cr planes in the given color space
+// back to an RGBA image
+func YCbCrPlanesToImageIn(y, cb, cr *Plane, cs ColorSpace) *image.RGBA {
+	return YCbCrPlanesToImageRounded(y, cb, cr, cs, RoundHalfUp)
+}
+
+// YCbCrPlanesToImageRounded converts Y, Cb, Cr planes in the given color
+// space back to an RGBA image, rounding with the given mode
+func YCbCrPlanesToImageRounded(y, cb, cr *Plane, cs ColorSpace, rounding Rounding) *image.RGBA {
+	m := cs.matrix()
 	width := y.Width
 	height := y.Height
 	img := image.NewRGBA(image.Rect(0, 0, width, height))
@@ -74,23 +281,10 @@ func YCbCrPlanesToImage(y, cb, cr *Plane) *image.RGBA {
 	for yIdx := 0; yIdx < height; yIdx++ {
 		for xIdx := 0; xIdx < width; xIdx++ {
 			idx := yIdx*y.Stride + xIdx
-
-			Y := y.Pix[idx]
-			Cb := cb.Pix[idx] - 128.0
-			Cr := cr.Pix[idx] - 128.0
-
-			// YCbCr to RGB conversion (BT.601)
-			// R = Y + 1.402*Cr
-			// G = Y - 0.344136*Cb - 0.714136*Cr
-			// B = Y + 1.772*Cb
-			r := Y + 1.402*Cr
-			g := Y - 0.344136*Cb - 0.714136*Cr
-			b := Y + 1.772*Cb
+			r, g, b := m.toRGB(y, cb, cr, idx)
 
 			// Clamp to [0, 255] and convert to uint8
-			r8 := clamp(r)
-			g8 := clamp(g)
-			b8 := clamp(b)
+			r8, g8, b8 := m.round(r, g, b, y.Pix[idx], rounding)
 
 			img.Set(xIdx, yIdx, color.RGBA{R: r8, G: g8, B: b8, A: 255})
 		}
@@ -99,6 +293,83 @@ func YCbCrPlanesToImage(y, cb, cr *Plane) *image.RGBA {
 	return img
 }
 
+// YCbCrPlanesToImage16 converts Y, Cb, Cr planes in the given color space
+// and an optional alpha plane back to a 16-bit image, keeping the planes'
+// fractional precision: *image.RGBA64 if alpha is nil, else *image.NRGBA64
+func YCbCrPlanesToImage16(y, cb, cr, alpha *Plane, cs ColorSpace) image.Image {
+	m := cs.matrix()
+	rect := image.Rect(0, 0, y.Width, y.Height)
+	var rgba *image.RGBA64
+	var nrgba *image.NRGBA64
+	if alpha == nil {
+		rgba = image.NewRGBA64(rect)
+	} else {
+		nrgba = image.NewNRGBA64(rect)
+	}
+
+	for yIdx := 0; yIdx < y.Height; yIdx++ {
+		for xIdx := 0; xIdx < y.Width; xIdx++ {
+			idx := yIdx*y.Stride + xIdx
+			r, g, b := m.toRGB(y, cb, cr, idx)
+			if alpha == nil {
+				rgba.SetRGBA64(xIdx, yIdx, color.RGBA64{R: clamp16(r), G: clamp16(g), B: clamp16(b), A: 0xFFFF})
+			} else {
+				nrgba.SetNRGBA64(xIdx, yIdx, color.NRGBA64{R: clamp16(r), G: clamp16(g), B: clamp16(b), A: clamp16(alpha.Pix[idx])})
+			}
+		}
+	}
+
+	if alpha == nil {
+		return rgba
+	}
+	return nrgba
+}
+
+// fromRGB converts R, G, B on the 0-255 scale to Y, Cb, Cr
+func (m *matrix) fromRGB(r, g, b float64) (y, cb, cr float64) {
+	// e.g. BT.601:
+	// Y  = 0.299*R + 0.587*G + 0.114*B
+	// Cb = -0.168736*R - 0.331264*G + 0.5*B + 128
+	// Cr = 0.5*R - 0.418688*G - 0.081312*B + 128
+	return m.yR*r + m.yG*g + m.yB*b,
+		m.cbR*r + m.cbG*g + m.cbB*b + 128.0,
+		m.crR*r + m.crG*g + m.crB*b + 128.0
+}
+
+// toRGB converts the sample at idx of the Y, Cb, Cr planes to R, G, B on the
+// 0-255 scale, unclamped
+func (m *matrix) toRGB(y, cb, cr *Plane, idx int) (r, g, b float64) {
+	Y := y.Pix[idx]
+	Cb := cb.Pix[idx] - 128.0
+	Cr := cr.Pix[idx] - 128.0
+
+	// YCbCr to RGB conversion, e.g. BT.601:
+	// R = Y + 1.402*Cr
+	// G = Y - 0.344136*Cb - 0.714136*Cr
+	// B = Y + 1.772*Cb
+	return Y + m.rCr*Cr, Y - m.gCb*Cb - m.gCr*Cr, Y + m.bCb*Cb
+}
+
+// YCbCrAlphaPlanesToImage converts Y, Cb, Cr planes in the given color space
+// and an alpha plane back to a non-premultiplied NRGBA image, rounding with
+// the given mode
+// If alpha is nil the image is opaque and this is YCbCrPlanesToImageRounded
+func YCbCrAlphaPlanesToImage(y, cb, cr, alpha *Plane, cs ColorSpace, rounding Rounding) image.Image {
+	if alpha == nil {
+		return YCbCrPlanesToImageRounded(y, cb, cr, cs, rounding)
+	}
+
+	rgba := YCbCrPlanesToImageRounded(y, cb, cr, cs, rounding)
+	img := image.NewNRGBA(rgba.Rect)
+	copy(img.Pix, rgba.Pix)
+	for yIdx := 0; yIdx < y.Height; yIdx++ {
+		for xIdx := 0; xIdx < y.Width; xIdx++ {
+			img.Pix[yIdx*img.Stride+xIdx*4+3] = clamp(alpha.Pix[yIdx*alpha.Stride+xIdx])
+		}
+	}
+	return img
+}
+
 // clamp clamps a float64 value to [0, 255] and returns as uint8
 func clamp(v float64) uint8 {
 	if v < 0 {
@@ -110,6 +381,17 @@ func clamp(v float64) uint8 {
 	return uint8(v + 0.5) // Round
 }
 
+// clamp16 clamps a float64 value to [0, 255] and scales it to a rounded uint16
+func clamp16(v float64) uint16 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 0xFFFF
+	}
+	return uint16(v*257.0 + 0.5)
+}
+
 // clampToUint8 clamps a float64 value to [0, 255] and returns as uint8
 func clampToUint8(v float64) uint8 {
 	if v < 0 {
@@ -0,0 +1,111 @@
+package emganography
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/tuomas-lb/emganography/internal/ecc"
+	"github.com/tuomas-lb/emganography/internal/framing"
+)
+
+// autoSchemes lists the built-in ECC schemes EmbedMessageDCTAuto picks from;
+// schemes with the same expansion are preferred in this order
+var autoSchemes = []ECCScheme{
+	ECCSchemeRepetition5,
+	ECCSchemeRepetition3Interleaved,
+	ECCSchemeRepetition3,
+	ECCSchemeBCH157,
+	ECCSchemeHamming74,
+	ECCSchemeReedSolomon,
+}
+
+// EmbedMessageDCTAuto embeds message like EmbedMessageDCT, but picks the ECC
+// scheme itself: the built-in schemes are tried from strongest to weakest
+// (by how many bits the encoded frame takes) and the first that fits the
+// image's capacity is used; opts.Config.ECC is ignored
+// The choice is recorded in the preamble and frame header as usual, so any
+// extraction function can read the result
+// Returns ErrMessageTooLong if the message doesn't fit even the weakest scheme
+func EmbedMessageDCTAuto(input []byte, message []byte, opts *EmbedOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	e, err := newEmbedding(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	planes := opts.Config.carrierPlanes(e.y, e.cb, e.cr)
+	capacityBits, err := e.capacityBits(ctx, planes, opts.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compress and encrypt once; only the frame's length differs per scheme
+	payload, flags, err := encodePayload(message, opts)
+	if err != nil {
+		return nil, err
+	}
+	frameSize := framing.HeaderSize + framing.MetadataSize(e.metadata) + len(payload)
+	if len(opts.HMACKey) > 0 {
+		frameSize += hmacSize
+	}
+
+	// Measure the encoded frame under each scheme, strongest first
+	type candidate struct {
+		config DCTConfig
+		count  int
+	}
+	candidates := make([]candidate, 0, len(autoSchemes))
+	for _, scheme := range autoSchemes {
+		config := opts.Config
+		config.ECC = scheme
+		eccScheme, err := ecc.GetScheme(scheme)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get ECC scheme: %w", err)
+		}
+		count, err := encodedBitLength(eccScheme, frameSize)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate{config, preambleBits + count})
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int { return b.count - a.count })
+
+	for _, c := range candidates {
+		if c.count > capacityBits {
+			continue
+		}
+		// The signature covers the header's ECC scheme, so sign for the
+		// chosen one only
+		signed, err := e.sign(payload, flags, 0, c.config)
+		if err != nil {
+			return nil, err
+		}
+		bits, count, err := e.encodeFrame(signed, flags, e.metadata, 0, c.config)
+		if err != nil {
+			return nil, err
+		}
+		err = embedBitsIntoDCTQuantized(ctx, planes, bits, count, c.config, e.quant, opts.OnProgress, e.workers)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed bits: %w", err)
+		}
+
+		output, err := e.encode()
+		if err != nil {
+			return nil, err
+		}
+		if opts.Verify {
+			if err := verifyEmbedding(ctx, output, message, opts); err != nil {
+				return nil, err
+			}
+		}
+		return output, nil
+	}
+	return nil, ErrMessageTooLong
+}
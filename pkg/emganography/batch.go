@@ -0,0 +1,148 @@
+package emganography
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BatchStatus is the outcome of embedding into one file of a batch
+type BatchStatus uint8
+
+const (
+	// BatchEmbedded means the message was embedded and the output written
+	BatchEmbedded BatchStatus = iota
+	// BatchSkipped means the image is too small to hold the message
+	BatchSkipped
+	// BatchFailed means the file couldn't be processed (see BatchResult.Err)
+	BatchFailed
+)
+
+// String returns a lowercase name for the status
+func (s BatchStatus) String() string {
+	switch s {
+	case BatchEmbedded:
+		return "embedded"
+	case BatchSkipped:
+		return "skipped"
+	case BatchFailed:
+		return "failed"
+	default:
+		return fmt.Sprintf("BatchStatus(%d)", uint8(s))
+	}
+}
+
+// BatchResult is the outcome of embedding into one file of a batch
+type BatchResult struct {
+	// Input is the input path as given
+	Input string
+	// Output is the path written (empty unless Status is BatchEmbedded)
+	Output string
+	// Status is the outcome
+	Status BatchStatus
+	// Err is why the file was skipped or failed (nil if embedded)
+	Err error
+}
+
+// errDuplicateOutput indicates two inputs of a batch map to the same output path
+var errDuplicateOutput = errors.New("another input writes the same output file")
+
+// EmbedMessageDCTBatch embeds the same message into every input file,
+// writing each result to outputDir under the input's file name (with the
+// extension changed if the output format differs, e.g. GIF input to .png)
+// Per-file problems don't stop the batch: images too small for the message
+// are skipped and others fail, as reported in the result for each input (in
+// input order). Up to opts.BatchParallelism files are processed at once
+// Returns an error only if the batch can't run at all (invalid options or
+// an unusable output directory)
+func EmbedMessageDCTBatch(inputs []string, outputDir string, message []byte, opts *EmbedOptions) ([]BatchResult, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	results := make([]BatchResult, len(inputs))
+	seen := make(map[string]bool, len(inputs))
+	for i, input := range inputs {
+		results[i].Input = input
+		// Compare without the extension, which may change with the output format
+		name := strings.TrimSuffix(filepath.Base(input), filepath.Ext(input))
+		if seen[name] {
+			results[i].Status = BatchFailed
+			results[i].Err = fmt.Errorf("%s: %w", name, errDuplicateOutput)
+		}
+		seen[name] = true
+	}
+
+	forEachChunk(len(inputs), max(opts.BatchParallelism, 1), func(lo, hi int) {
+		for i := lo; i < hi; i++ {
+			if results[i].Err == nil {
+				embedBatchFile(&results[i], outputDir, message, opts)
+			}
+		}
+	})
+
+	return results, nil
+}
+
+// embedBatchFile embeds into result.Input and fills in the rest of result
+func embedBatchFile(result *BatchResult, outputDir string, message []byte, opts *EmbedOptions) {
+	inputData, err := os.ReadFile(result.Input)
+	if err != nil {
+		result.Status = BatchFailed
+		result.Err = fmt.Errorf("failed to read input file: %w", err)
+		return
+	}
+
+	outputData, err := EmbedMessageDCT(inputData, message, opts)
+	if err != nil {
+		result.Status = BatchFailed
+		if errors.Is(err, ErrMessageTooLong) {
+			result.Status = BatchSkipped
+		}
+		result.Err = err
+		return
+	}
+
+	output := filepath.Join(outputDir, batchOutputName(result.Input, outputData))
+	if err := os.WriteFile(output, outputData, 0644); err != nil {
+		result.Status = BatchFailed
+		result.Err = fmt.Errorf("failed to write output file: %w", err)
+		return
+	}
+	result.Output = output
+	result.Status = BatchEmbedded
+}
+
+// batchOutputName returns the input's file name, with the extension replaced
+// if it doesn't match the format of the encoded output
+func batchOutputName(input string, output []byte) string {
+	name := filepath.Base(input)
+	_, format, err := image.DecodeConfig(bytes.NewReader(output))
+	if err != nil {
+		return name
+	}
+
+	ext := strings.ToLower(filepath.Ext(name))
+	switch {
+	case format == "jpeg" && (ext == ".jpg" || ext == ".jpeg"):
+		return name
+	case format == "jpeg":
+		return strings.TrimSuffix(name, filepath.Ext(name)) + ".jpg"
+	case format == "tiff" && ext == ".tif":
+		return name
+	case ext == "."+format:
+		return name
+	default:
+		return strings.TrimSuffix(name, filepath.Ext(name)) + "." + format
+	}
+}
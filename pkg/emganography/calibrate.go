@@ -0,0 +1,128 @@
+package emganography
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+)
+
+const (
+	// calibrateMinDelta and calibrateMaxDelta bound the Delta CalibrateDelta searches
+	calibrateMinDelta = 0.05
+	calibrateMaxDelta = 255.0
+	// calibrateSteps is the number of bisection steps, narrowing the range
+	// to within 0.05% of the returned Delta
+	calibrateSteps = 24
+)
+
+var (
+	// ErrTargetUnreachable indicates no Delta keeps the distortion within the
+	// requested target
+	ErrTargetUnreachable = errors.New("target quality unreachable")
+)
+
+// CalibrateDelta returns the largest Delta (the strongest embedding) whose
+// stego image keeps a PSNR of at least targetPSNR dB against the cover, for
+// DefaultDCTConfig
+func CalibrateDelta(image []byte, targetPSNR float64) (float64, error) {
+	return CalibrateDeltaForConfig(image, targetPSNR, DefaultDCTConfig())
+}
+
+// CalibrateDeltaForConfig is CalibrateDelta for the given DCT configuration;
+// its Delta is ignored
+// Each step of the binary search fills the image's whole capacity with
+// pseudo-random bits, so shorter messages distort less and stay above the
+// target. The PSNR is measured on lossless output, excluding JPEG re-encoding
+// Returns ErrTargetUnreachable if even the smallest Delta searched falls
+// below the target; a target the largest Delta meets returns that Delta
+func CalibrateDeltaForConfig(image []byte, targetPSNR float64, config DCTConfig) (float64, error) {
+	if !(targetPSNR > 0) {
+		return 0, fmt.Errorf("%w: target PSNR must be positive, got %g", ErrInvalidConfig, targetPSNR)
+	}
+
+	psnrAt := func(delta float64) (float64, error) {
+		opts := DefaultEmbedOptions()
+		opts.Config = config
+		opts.Config.Delta = delta
+		opts.Config.OutputFormat = "png"
+		// Padding would change the size, which PSNR can't compare across
+		opts.Config.PadToBlockSize = false
+		return calibrationPSNR(image, opts)
+	}
+
+	psnr, err := psnrAt(calibrateMaxDelta)
+	if err != nil {
+		return 0, err
+	}
+	if psnr >= targetPSNR {
+		return calibrateMaxDelta, nil
+	}
+	psnr, err = psnrAt(calibrateMinDelta)
+	if err != nil {
+		return 0, err
+	}
+	if psnr < targetPSNR {
+		return 0, fmt.Errorf("%w: %.2f dB at the smallest Delta (%g), below %.2f dB", ErrTargetUnreachable, psnr, calibrateMinDelta, targetPSNR)
+	}
+
+	// PSNR falls as Delta grows; bisect geometrically, as the useful range
+	// spans orders of magnitude
+	lo, hi := calibrateMinDelta, calibrateMaxDelta
+	for range calibrateSteps {
+		mid := math.Sqrt(lo * hi)
+		psnr, err := psnrAt(mid)
+		if err != nil {
+			return 0, err
+		}
+		if psnr >= targetPSNR {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo, nil
+}
+
+// calibrationPSNR embeds pseudo-random bits into the whole capacity of the
+// image and returns the PSNR of the output against it
+func calibrationPSNR(input []byte, opts *EmbedOptions) (float64, error) {
+	if err := opts.Validate(); err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+	e, err := newEmbedding(ctx, input, opts)
+	if err != nil {
+		return 0, err
+	}
+	planes := opts.Config.carrierPlanes(e.y, e.cb, e.cr)
+	capacityBits, err := e.capacityBits(ctx, planes, opts.Config)
+	if err != nil {
+		return 0, err
+	}
+
+	// The same bits every step, so PSNR only changes with Delta
+	rng := rand.New(rand.NewPCG(uint64(capacityBits), 0))
+	bits := make([]bool, capacityBits)
+	for i := range bits {
+		bits[i] = rng.IntN(2) == 1
+	}
+	if err := embedBitsIntoDCT(ctx, planes, bits, opts.Config, e.workers); err != nil {
+		return 0, fmt.Errorf("failed to embed bits: %w", err)
+	}
+
+	output, err := e.encode()
+	if err != nil {
+		return 0, err
+	}
+	stego, _, err := imgutil.LoadImage(output)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load image: %w", err)
+	}
+	psnr, _, err := MeasureDistortion(e.img, stego)
+	return psnr, err
+}
@@ -0,0 +1,78 @@
+package emganography
+
+import (
+	"math"
+	"slices"
+
+	"github.com/tuomas-lb/emganography/internal/dct"
+	"github.com/tuomas-lb/emganography/internal/ycbcr"
+)
+
+// deblockMaxStep is the largest step across a block boundary Deblock
+// smooths; larger steps are taken to be real image edges and kept
+const deblockMaxStep = 24.0
+
+// deblock smooths the steps between neighbouring blocks of plane (the Y
+// plane) within config's block rect, then restores each block's carrier
+// coefficients, so the smoothing can't flip an embedded bit
+// With UseAllBlocks false, a block whose smoothing would move its energy
+// across the threshold is left as it was, so extraction selects the same blocks
+func deblock(plane *ycbcr.Plane, config DCTConfig) {
+	n := config.blockSize()
+	r := config.blockRect(plane.Width/n, plane.Height/n)
+	smoothed := *plane
+	smoothed.Pix = slices.Clone(plane.Pix)
+
+	// Vertical boundaries, then horizontal ones
+	for bx := r.Min.X + 1; bx < r.Max.X; bx++ {
+		for y := r.Min.Y * n; y < r.Max.Y*n; y++ {
+			smoothStep(smoothed.Pix, y*plane.Stride+bx*n-2, 1)
+		}
+	}
+	for by := r.Min.Y + 1; by < r.Max.Y; by++ {
+		for x := r.Min.X * n; x < r.Max.X*n; x++ {
+			smoothStep(smoothed.Pix, (by*n-2)*plane.Stride+x, plane.Stride)
+		}
+	}
+
+	mask := config.carrierMask()
+	threshold := config.energyThreshold()
+	block := make([]float64, n*n)
+	original := make([]float64, n*n)
+	dctBlock := make([]float64, n*n)
+	for by := r.Min.Y; by < r.Max.Y; by++ {
+		for bx := r.Min.X; bx < r.Max.X; bx++ {
+			loadBlock(plane, n, bx, by, block)
+			dct.DCTNxN(n, block, original)
+			loadBlock(&smoothed, n, bx, by, block)
+			dct.DCTNxN(n, block, dctBlock)
+
+			for i, carrier := range mask {
+				if carrier {
+					dctBlock[i] = original[i]
+				}
+			}
+			if !config.UseAllBlocks && (acEnergy(dctBlock, mask) >= threshold) != (acEnergy(original, mask) >= threshold) {
+				continue
+			}
+
+			dct.IDCTNxN(n, dctBlock, block)
+			storeBlock(plane, n, bx, by, block)
+		}
+	}
+}
+
+// smoothStep softens the step between pix[i+step] and pix[i+2*step], the
+// two pixels either side of a block boundary, pulling them (and, by half as
+// much, their outer neighbours) towards each other
+func smoothStep(pix []float64, i, step int) {
+	p0, q0 := pix[i+step], pix[i+2*step]
+	if math.Abs(q0-p0) > deblockMaxStep {
+		return
+	}
+	d := (q0 - p0) / 4
+	pix[i] += d / 2
+	pix[i+step] += d
+	pix[i+2*step] -= d
+	pix[i+3*step] -= d / 2
+}
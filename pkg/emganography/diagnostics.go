@@ -0,0 +1,133 @@
+package emganography
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+
+	"github.com/tuomas-lb/emganography/internal/ecc"
+)
+
+// ExtractRawBits returns what the low-level extractor reads from an image,
+// before any ECC decoding or framing: one bit and one signed coefficient gap
+// per carrier block, in embedding order (positive gaps read as 1). Gaps
+// clustered near zero explain why an image fails to decode
+func ExtractRawBits(data []byte) (bits []bool, gaps []float64, err error) {
+	return ExtractRawBitsWithOptions(data, nil)
+}
+
+// ExtractRawBitsWithOptions is ExtractRawBits with extraction options (the
+// DCT configuration must match the one used for embedding)
+func ExtractRawBitsWithOptions(data []byte, opts *ExtractOptions) (bits []bool, gaps []float64, err error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	ctx := context.Background()
+	planes, capacityBits, err := extractionPlanes(ctx, data, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gaps, err = extractSoftBitsFromDCT(ctx, planes, capacityBits, opts.Config, opts.OnProgress, workerCount(opts.Parallelism))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to extract bits: %w", err)
+	}
+	return ecc.HardDecisions(gaps), gaps, nil
+}
+
+// WriteRawBitsCSV writes the output of ExtractRawBits as CSV, one row per
+// block with columns index, bit (0 or 1) and gap
+func WriteRawBitsCSV(w io.Writer, bits []bool, gaps []float64) error {
+	if len(bits) != len(gaps) {
+		return fmt.Errorf("bit and gap counts differ: %d != %d", len(bits), len(gaps))
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"index", "bit", "gap"}); err != nil {
+		return err
+	}
+	for i, bit := range bits {
+		b := "0"
+		if bit {
+			b = "1"
+		}
+		if err := cw.Write([]string{strconv.Itoa(i), b, strconv.FormatFloat(gaps[i], 'f', -1, 64)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Carrier map levels of blocks carrying a bit and of blocks visited but
+// skipped for low texture; blocks never visited stay black
+const (
+	carrierMapUsed    = 255
+	carrierMapSkipped = 64
+)
+
+// CarrierMap returns a map of the blocks embedding with opts would use,
+// without embedding anything: blocks carrying a bit are white, blocks the
+// traversal visits but skips for low texture are dark gray, and blocks it
+// never visits (outside Region, or partial blocks along the edges) are black
+// The map is the size of the (padded) image; with several carrier channels
+// their maps are stacked top to bottom in fill order (Y, Cb, Cr). The white
+// blocks number the capacity in bits
+func CarrierMap(data []byte, opts *EmbedOptions) (*image.Gray, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	e, err := newEmbedding(ctx, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	config := opts.Config
+	planes := config.carrierPlanes(e.y, e.cb, e.cr)
+	n := config.blockSize()
+	width, height := e.y.Width, e.y.Height
+	order, err := blockOrder(len(planes), width/n, height/n, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks carry bits by the same rule capacityBits counts them with
+	used := make([]bool, len(order))
+	if config.UseAllBlocks {
+		for i := range used {
+			used[i] = true
+		}
+	} else {
+		energies, err := blockEnergies(ctx, planes, order, config, e.workers)
+		if err != nil {
+			return nil, err
+		}
+		threshold := config.energyThreshold()
+		for i, energy := range energies {
+			used[i] = energy >= threshold+energyMargin
+		}
+	}
+
+	m := image.NewGray(image.Rect(0, 0, width, height*len(planes)))
+	for i, ref := range order {
+		level := uint8(carrierMapSkipped)
+		if used[i] {
+			level = carrierMapUsed
+		}
+		top := ref.plane*height + ref.by*n
+		for y := top; y < top+n; y++ {
+			row := m.Pix[y*m.Stride+ref.bx*n : y*m.Stride+(ref.bx+1)*n]
+			for x := range row {
+				row[x] = level
+			}
+		}
+	}
+	return m, nil
+}
@@ -1,13 +1,22 @@
 package emganography
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
+	"image/png"
+	"math"
 	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/tuomas-lb/emganography/internal/bitstream"
 	"github.com/tuomas-lb/emganography/internal/dct"
 	"github.com/tuomas-lb/emganography/internal/ecc"
+	"github.com/tuomas-lb/emganography/internal/encryption"
 	"github.com/tuomas-lb/emganography/internal/framing"
 	"github.com/tuomas-lb/emganography/internal/imgutil"
 	"github.com/tuomas-lb/emganography/internal/ycbcr"
@@ -20,22 +29,124 @@ var (
 	ErrFrameCorrupt = errors.New("extracted frame is corrupted")
 	// ErrCRCMismatch indicates CRC validation failed
 	ErrCRCMismatch = errors.New("CRC32 checksum mismatch")
+	// ErrDecryptionFailed indicates the password is wrong or the encrypted payload was modified
+	ErrDecryptionFailed = encryption.ErrDecryptionFailed
+	// ErrPasswordRequired indicates the message is encrypted but no password was given
+	ErrPasswordRequired = errors.New("message is encrypted, password required")
+	// ErrInvalidMagic indicates no frame header was found, i.e. the image carries no message
+	ErrInvalidMagic = framing.ErrInvalidMagic
+	// ErrUnsupportedVersion indicates a message is present but uses an unknown (newer) frame version
+	ErrUnsupportedVersion = framing.ErrUnsupportedVersion
+	// ErrHeaderCRCMismatch indicates the frame header is corrupted (wrapped in ErrFrameCorrupt)
+	ErrHeaderCRCMismatch = framing.ErrHeaderCRCMismatch
+	// ErrMetadataTooLarge indicates a metadata key exceeds 255 bytes or the
+	// metadata as a whole exceeds 64 KiB
+	ErrMetadataTooLarge = framing.ErrMetadataTooLarge
+	// ErrDimensionMismatch indicates two images that must match in size don't
+	ErrDimensionMismatch = errors.New("image dimensions don't match")
+	// ErrInvalidCoefficient indicates a configured DCT coefficient position is unusable
+	ErrInvalidCoefficient = errors.New("invalid DCT coefficient position")
+	// ErrGIFOutput indicates GIF output was requested, which can't hold embedded data
+	ErrGIFOutput = imgutil.ErrGIFOutput
+	// ErrInvalidConfig indicates a DCTConfig or EmbedOptions field has a value
+	// that would produce unrecoverable output
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrInvalidBlockSize indicates a DCTConfig.BlockSize outside 4-32
+	ErrInvalidBlockSize = errors.New("invalid DCT block size")
+	// ErrReservedScheme indicates RegisterScheme was given a built-in or zero scheme ID
+	ErrReservedScheme = ecc.ErrReservedScheme
+	// ErrVerificationFailed indicates EmbedOptions.Verify couldn't extract the
+	// message back from the encoded output (e.g. JPEG quantization destroyed it)
+	ErrVerificationFailed = errors.New("embedded message failed verification")
+	// ErrImageTooSmall indicates the image is smaller than one DCT block in
+	// width or height, so it has no blocks to carry data
+	ErrImageTooSmall = errors.New("image too small to hold any DCT block")
+	// ErrPNGFallback is reported in EmbedResult.Warnings when OutputFormat is
+	// empty and the input's format couldn't be determined, neither by its
+	// decoder nor from its signature, so the output was written as PNG
+	ErrPNGFallback = errors.New("input format not determined, writing PNG")
+	// ErrReservedNonZero indicates the frame header has reserved bits set,
+	// meaning a newer format wrote it (see ExtractOptions.LenientHeader)
+	ErrReservedNonZero = framing.ErrReservedNonZero
+	// ErrFrameTruncated indicates the frame header was found but the frame
+	// runs past the image's capacity, e.g. because the image was cropped
+	// (see ExtractOptions.AllowPartial)
+	ErrFrameTruncated = errors.New("frame truncated")
+	// ErrUnsupportedFormat indicates the input image's format isn't
+	// recognized, whether by loading or by DetectFormat
+	ErrUnsupportedFormat = imgutil.ErrUnsupportedFormat
+	// ErrCorruptImage indicates the input image is in a recognized format but
+	// fails to decode, e.g. because it's truncated
+	ErrCorruptImage = imgutil.ErrCorruptImage
+	// ErrLossyOutput indicates a lossy output format (JPEG) was requested for
+	// LSB embedding, which any re-quantization destroys
+	ErrLossyOutput = errors.New("LSB embedding needs a lossless output format")
+	// ErrDeltaTooSmallForJPEG is reported in EmbedResult.Warnings when the
+	// output is JPEG and the embedding gap is smaller than the quantization
+	// steps of the carrier coefficients at EmbedOptions.JPEGQuality, so the
+	// message will likely not survive the encode
+	ErrDeltaTooSmallForJPEG = errors.New("delta too small to survive JPEG quantization")
+	// ErrAuthenticationFailed indicates ExtractOptions.HMACKey was set but
+	// the message isn't signed, or not with that key, or was modified
+	ErrAuthenticationFailed = errors.New("message authentication failed")
 )
 
 // CapacityInfo holds information about image embedding capacity
 type CapacityInfo struct {
 	// Image dimensions
-	Width  int
-	Height int
-	// Raw capacity in 8x8 blocks
-	BlocksAcross int
-	BlocksDown   int
-	// Capacity in bits (number of 8x8 blocks)
-	CapacityBits int
-	// Maximum embeddable payload bytes (after accounting for header and ECC)
-	MaxPayloadBytes int
-	// Maximum embeddable UTF-8 string length (approximate)
-	MaxUTF8Chars int
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Raw capacity in blocks (within DCTConfig.Region, if set)
+	BlocksAcross int `json:"blocks_across"`
+	BlocksDown   int `json:"blocks_down"`
+	// Number of carrier channels (planes)
+	Channels int `json:"channels"`
+	// RawCapacityBits is the raw channel capacity: one bit per carrier block
+	// across all carrier channels, before the preamble and ECC
+	RawCapacityBits int `json:"raw_capacity_bits"`
+	// CapacityBits is the same as RawCapacityBits
+	// Deprecated: use RawCapacityBits
+	CapacityBits int `json:"capacity_bits"`
+	// EncodedCapacityBits is the frame data the channel carries after the
+	// 24-bit preamble and the ECC expansion, in whole frame bytes (so always
+	// a multiple of 8)
+	EncodedCapacityBits int `json:"encoded_capacity_bits"`
+	// OverheadBytes is the part of the frame that isn't message: the frame
+	// header and the padding metadata with PadToBlockSize (PlanEmbedding
+	// adds the other metadata and the encryption overhead)
+	OverheadBytes int `json:"overhead_bytes"`
+	// Maximum embeddable payload bytes: EncodedCapacityBits/8 minus
+	// OverheadBytes (never negative)
+	MaxPayloadBytes int `json:"max_payload_bytes"`
+	// Maximum embeddable UTF-8 string length, guaranteed even if every
+	// character takes the worst case of 4 bytes
+	MaxUTF8Chars int `json:"max_utf8_chars"`
+	// Estimated embeddable UTF-8 string length for typical, mostly ASCII text
+	// (assumes 1.5 bytes per character; not a guarantee)
+	EstimatedUTF8Chars int `json:"estimated_utf8_chars"`
+}
+
+// setMaxPayloadBytes sets MaxPayloadBytes and the character counts derived from it
+func (c *CapacityInfo) setMaxPayloadBytes(n int) {
+	c.MaxPayloadBytes = n
+	c.MaxUTF8Chars = n / utf8.UTFMax
+	c.EstimatedUTF8Chars = int(float64(n) / 1.5)
+}
+
+// TruncateToCapacity trims s to at most info.MaxPayloadBytes bytes without
+// splitting a multi-byte UTF-8 character
+// With compression enabled the capacity bounds the compressed size, so the
+// result is conservative
+func TruncateToCapacity(s string, info *CapacityInfo) string {
+	n := max(info.MaxPayloadBytes, 0)
+	if len(s) <= n {
+		return s
+	}
+	// Back up to the start of the character that would be cut
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
 }
 
 // ECCScheme represents an error correction code scheme
@@ -44,6 +155,112 @@ type ECCScheme = ecc.ECCScheme
 const (
 	// ECCSchemeRepetition3 uses repetition-3 encoding
 	ECCSchemeRepetition3 = ecc.ECCSchemeRepetition3
+	// ECCSchemeHamming74 uses Hamming(7,4) encoding
+	ECCSchemeHamming74 = ecc.ECCSchemeHamming74
+	// ECCSchemeReedSolomon uses Reed-Solomon encoding for burst error resilience
+	ECCSchemeReedSolomon = ecc.ECCSchemeReedSolomon
+	// ECCSchemeRepetition5 uses repetition-5 encoding for very noisy channels (e.g. heavy JPEG)
+	ECCSchemeRepetition5 = ecc.ECCSchemeRepetition5
+	// ECCSchemeRepetition3Interleaved uses interleaved repetition-3 encoding to
+	// survive bursts of damaged consecutive blocks
+	ECCSchemeRepetition3Interleaved = ecc.ECCSchemeRepetition3Interleaved
+	// ECCSchemeBCH157 uses BCH(15,7) encoding, correcting up to two random
+	// bit errors (e.g. from JPEG quantization) per 15-bit codeword
+	ECCSchemeBCH157 = ecc.ECCSchemeBCH157
+)
+
+// Scheme is an error correction code: it expands a frame into the bits
+// embedded in the image and recovers the frame from (possibly damaged) bits
+type Scheme = ecc.Scheme
+
+// SoftScheme is optionally implemented by a Scheme to decode from soft bits:
+// the signed coefficient gap of each block, whose magnitude is the
+// confidence in the bit. Extraction uses it when available
+type SoftScheme = ecc.SoftScheme
+
+// ExpansionScheme is optionally implemented by a Scheme to report how many
+// bits it encodes a frame of a given size into, so capacity calculations
+// needn't encode a throwaway frame of each size they try
+type ExpansionScheme = ecc.ExpansionScheme
+
+// RegisterScheme makes a custom ECC scheme available under id, so it can be
+// selected with DCTConfig.ECC and is recognised when extracting
+// The ID is stored in the image, so extraction must register the same scheme
+// Built-in IDs and zero are reserved (ErrReservedScheme); registering a
+// custom ID again replaces its factory
+func RegisterScheme(id ECCScheme, factory func() Scheme) error {
+	return ecc.RegisterScheme(id, factory)
+}
+
+// Compression selects the payload compression applied before framing
+type Compression uint8
+
+const (
+	// CompressionNone embeds the message as-is
+	CompressionNone Compression = 0
+	// CompressionFlate compresses the message with DEFLATE (compress/flate)
+	CompressionFlate Compression = 1
+)
+
+// Checksum selects the CRC32 algorithm protecting the payload
+type Checksum uint8
+
+const (
+	// ChecksumCRC32 uses CRC32-IEEE, readable by every version of the library
+	ChecksumCRC32 Checksum = 0
+	// ChecksumCRC32C uses CRC32C (Castagnoli), which detects more error
+	// patterns in large payloads and is hardware accelerated on most CPUs
+	ChecksumCRC32C Checksum = 1
+)
+
+// Mode selects how a bit is embedded in a block's DCT coefficients
+type Mode uint8
+
+const (
+	// ModeComparison encodes a bit as the order of a coefficient pair
+	// (A > B for 1), see DCTConfig.CoeffA/CoeffB
+	ModeComparison Mode = 0
+	// ModeSpreadSpectrum encodes a bit as the sign of a keyed pseudo-random
+	// pattern added across the block's mid-frequency coefficients, and
+	// extraction correlates against the same pattern. Noise averages out over
+	// the pattern, so bits survive far more additive noise, at the cost of
+	// changing more coefficients per block
+	ModeSpreadSpectrum Mode = 1
+)
+
+// Channel selects a YCbCr plane that carries data
+type Channel uint8
+
+const (
+	// ChannelY is the luma plane
+	ChannelY Channel = 1 << iota
+	// ChannelCb is the blue-difference chroma plane
+	ChannelCb
+	// ChannelCr is the red-difference chroma plane
+	ChannelCr
+)
+
+// ColorSpace selects the RGB <-> YCbCr conversion matrix
+type ColorSpace = ycbcr.ColorSpace
+
+const (
+	// ColorSpaceBT601 uses the BT.601 (SD/JPEG) matrix, the default
+	ColorSpaceBT601 = ycbcr.BT601
+	// ColorSpaceBT709 uses the BT.709 (HD/sRGB) matrix
+	ColorSpaceBT709 = ycbcr.BT709
+)
+
+// Rounding selects how the planes are rounded to 8-bit RGB for the output
+type Rounding = ycbcr.Rounding
+
+const (
+	// RoundHalfUp rounds each channel to the nearest level, ties up (the default)
+	RoundHalfUp = ycbcr.RoundHalfUp
+	// RoundHalfEven rounds each channel to the nearest level, ties to even
+	RoundHalfEven = ycbcr.RoundHalfEven
+	// RoundLuma rounds each pixel's channels so its luma stays closest to
+	// the Y plane, which carries the embedded bits
+	RoundLuma = ycbcr.RoundLuma
 )
 
 // DCTConfig holds configuration for DCT-based embedding
@@ -52,11 +269,115 @@ type DCTConfig struct {
 	ECC ECCScheme
 	// Delta is the coefficient adjustment magnitude
 	Delta float64
+	// AdaptiveDelta if true, scales Delta per block with its texture (the
+	// RMS of its non-carrier AC coefficients, relative to a pixel standard
+	// deviation of 8), so flat blocks, where changes show, get a gentler
+	// adjustment (down to MinGap alone) and busy blocks a stronger one.
+	// Extraction only compares the coefficients, so needs no setting
+	AdaptiveDelta bool
+	// MaxDelta caps the adjustment when AdaptiveDelta is set (0 = 4x Delta)
+	MaxDelta float64
 	// MinGap is the minimum required difference between coeffs to encode a bit
 	MinGap float64
-	// UseAllBlocks if true, use all blocks; else allow skipping low-energy blocks
+	// UseAllBlocks if true, use all blocks; else skip low-energy (flat) blocks,
+	// where changes are most visible. Extraction must use the same setting
 	UseAllBlocks bool
-	// OutputFormat is the output image format: "png" or "jpg"
+	// PadToBlockSize if true, extends images whose dimensions aren't
+	// multiples of the block size by repeating the edge pixels, so the
+	// otherwise unused partial edge blocks carry data too. The output is
+	// larger than the input; its original size is recorded in the frame, and
+	// CropToOriginal restores it after extraction
+	PadToBlockSize bool
+	// Deblock if true, smooths the luminance steps heavy Delta leaves at
+	// block boundaries after embedding. Each block's carrier coefficients
+	// are restored afterwards, so every bit survives; extraction needs no
+	// setting. It does nothing unless Channels includes ChannelY
+	Deblock bool
+	// EnergyThreshold is the AC energy (L2 norm of the non-carrier AC
+	// coefficients) below which blocks are skipped when UseAllBlocks is false
+	// (0 = default of 40)
+	EnergyThreshold float64
+	// Interleave if true, spreads consecutive encoded bits across distant blocks
+	// so a local edit damages scattered bits instead of a contiguous run
+	Interleave bool
+	// InterleaveDepth is the number of stripes bits are dealt across (0 = default of 8)
+	InterleaveDepth int
+	// Seed, if non-empty, shuffles the block order with a permutation keyed
+	// by it (via PBKDF2), so the bits can't be located without the seed
+	// Extraction with a different seed fails with ErrInvalidMagic
+	Seed string
+	// CoeffA and CoeffB are the (row, col) positions of the DCT coefficient pair
+	// carrying each bit: a 1 is encoded as A > B, a 0 as A < B
+	// Both zero means the default pair (2,2)/(2,3)
+	CoeffA [2]int
+	CoeffB [2]int
+	// CoeffPairs, if set, overrides CoeffA/CoeffB and writes the same bit into
+	// every listed (A, B) pair of each block; extraction takes a majority vote
+	// Each block still carries a single bit, so capacity is unchanged
+	CoeffPairs [][2][2]int
+	// CarrierQuality, if non-zero, picks the carrier pair for JPEG output at
+	// this quality (1-100), overriding CoeffA/CoeffB: of the mid-frequency
+	// coefficients, the two highest in zig-zag order whose steps in the
+	// standard luminance quantization table keep within half the default
+	// gap, or the two with the smallest steps if none do, so lower qualities
+	// fall back to the coefficients quantized most finely. Needs 8x8 blocks
+	// and ModeComparison, excludes CoeffPairs; extraction must use the same
+	// value
+	CarrierQuality int
+	// BlockSize is the DCT block dimension in pixels (0 = 8); each block
+	// carries one bit, so larger blocks trade capacity for less visible
+	// changes. Coefficient positions must be below it, QuantizationAware
+	// needs 8, and extraction must use the same size
+	BlockSize int
+	// Region, if set, confines embedding to the blocks lying entirely inside
+	// it (in pixels from the image's top-left corner, snapped inwards to the
+	// block grid), leaving the rest of the image untouched; capacity counts
+	// only those blocks. Extraction must use the same region
+	Region *image.Rectangle
+	// Compression selects how the message is compressed before framing
+	Compression Compression
+	// Checksum selects the payload CRC algorithm; extraction detects it from
+	// the frame header
+	Checksum Checksum
+	// Mode selects how each bit is embedded (0 = ModeComparison). In
+	// ModeSpreadSpectrum the pattern is keyed by Seed, CoeffA/CoeffB and
+	// CoeffPairs are ignored, and QuantizationAware isn't supported
+	// Extraction must use the same mode
+	Mode Mode
+	// Channels is the set of planes carrying data (0 = ChannelY only)
+	// Blocks are filled in Y, Cb, Cr order, so capacity scales with the
+	// channel count. Chroma tolerates modification well visually, but JPEG
+	// output usually subsamples chroma (4:2:0), which destroys chroma-carried
+	// bits; use a lossless output format when embedding into Cb/Cr
+	// Without ChannelY the Y plane is never modified, so luma only changes
+	// by the rounding to RGB (see Rounding and WithChromaOnly)
+	Channels Channel
+	// ColorSpace is the matrix used to convert RGB to YCbCr and back; match
+	// it to the source content to avoid color shifts. Extraction must use
+	// the same one
+	ColorSpace ColorSpace
+	// Rounding is how the planes are rounded to 8-bit RGB for the output
+	// (0 = RoundHalfUp). RoundLuma keeps the output's Y closest to the
+	// embedded values, leaving less quantization error for extraction to
+	// absorb; 16-bit and grayscale output round Y directly and ignore it
+	Rounding Rounding
+	// QuantizationAware if true and the output is JPEG, snaps the carrier
+	// coefficients to multiples of the encoder's luminance quantization step
+	// for EmbedOptions.JPEGQuality, so bit relationships survive the JPEG
+	// encode/decode. Only the Y channel benefits, as chroma is subsampled
+	QuantizationAware bool
+	// JPEGCoefficients if true embeds into a JPEG's quantized DCT
+	// coefficients directly, changing only the carrier coefficients of the
+	// luma blocks used, rather than decoding the JPEG to pixels and
+	// re-encoding it, which costs quality even without embedding. It needs
+	// baseline JPEG input and output, 8x8 blocks, ChannelY, UseAllBlocks and
+	// ModeComparison, and excludes PadToBlockSize and Deblock. Only the
+	// EmbedMessageDCT variants honour it; extraction needs no setting
+	JPEGCoefficients bool
+	// OutputFormat is the output image format: "png", "jpg", "bmp", "tiff" or "webp"
+	// Empty means the input's format, except that GIF input (first frame)
+	// and input of an undetermined format (see ErrPNGFallback) are
+	// written as PNG
 	OutputFormat string
 }
 
@@ -68,22 +389,297 @@ func DefaultDCTConfig() DCTConfig {
 		MinGap:       5.0,  // Reduced from 100.0 for less visible artifacts
 		UseAllBlocks: true,
 		OutputFormat: "", // Empty means preserve input format
+		CoeffA:       [2]int{2, 2},
+		CoeffB:       [2]int{2, 3},
+	}
+}
+
+// carrierPairs returns the row-major index pairs of the coefficients carrying each bit
+func (c DCTConfig) carrierPairs() [][2]int {
+	n := c.blockSize()
+	if c.CarrierQuality != 0 {
+		return [][2]int{quantCarrierPair(c.CarrierQuality)}
+	}
+	if len(c.CoeffPairs) > 0 {
+		pairs := make([][2]int, len(c.CoeffPairs))
+		for i, p := range c.CoeffPairs {
+			pairs[i] = [2]int{p[0][0]*n + p[0][1], p[1][0]*n + p[1][1]}
+		}
+		return pairs
+	}
+
+	a, b := c.CoeffA, c.CoeffB
+	if a == [2]int{} && b == [2]int{} {
+		a, b = [2]int{2, 2}, [2]int{2, 3}
+	}
+	return [][2]int{{a[0]*n + a[1], b[0]*n + b[1]}}
+}
+
+// validateCoeffs checks the block size and carrier coefficient pairs are usable
+func (c DCTConfig) validateCoeffs() error {
+	if c.BlockSize != 0 && (c.BlockSize < minBlockSize || c.BlockSize > maxBlockSize) {
+		return fmt.Errorf("%w: %d", ErrInvalidBlockSize, c.BlockSize)
+	}
+	n := c.blockSize()
+
+	pairs := c.CoeffPairs
+	if len(pairs) == 0 {
+		if c.CoeffA == [2]int{} && c.CoeffB == [2]int{} {
+			return nil
+		}
+		pairs = [][2][2]int{{c.CoeffA, c.CoeffB}}
+	}
+
+	used := make(map[[2]int]bool)
+	for _, pair := range pairs {
+		for _, p := range pair {
+			if p[0] < 0 || p[0] >= n || p[1] < 0 || p[1] >= n {
+				return fmt.Errorf("%w: (%d,%d) out of range", ErrInvalidCoefficient, p[0], p[1])
+			}
+			if p == [2]int{} {
+				return fmt.Errorf("%w: DC coefficient (0,0) can't carry data", ErrInvalidCoefficient)
+			}
+			// A shared position would make two pairs fight over the same coefficient
+			if used[p] {
+				return fmt.Errorf("%w: (%d,%d) used more than once", ErrInvalidCoefficient, p[0], p[1])
+			}
+			used[p] = true
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration can produce recoverable output: Delta
+// must be positive, MinGap non-negative, OutputFormat empty or one of
+// SupportedFormats (or an alias), and the block size and coefficients usable
+// Returns ErrInvalidConfig (wrapped) describing the first problem found
+func (c DCTConfig) Validate() error {
+	if c.Delta <= 0 {
+		return fmt.Errorf("%w: Delta must be positive, got %g", ErrInvalidConfig, c.Delta)
+	}
+	if c.MinGap < 0 {
+		return fmt.Errorf("%w: MinGap can't be negative, got %g", ErrInvalidConfig, c.MinGap)
+	}
+	if c.MaxDelta < 0 {
+		return fmt.Errorf("%w: MaxDelta can't be negative, got %g", ErrInvalidConfig, c.MaxDelta)
+	}
+	switch c.Mode {
+	case ModeComparison:
+	case ModeSpreadSpectrum:
+		if c.QuantizationAware {
+			return fmt.Errorf("%w: QuantizationAware needs ModeComparison", ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: unknown Mode %d", ErrInvalidConfig, c.Mode)
+	}
+	if c.Rounding > RoundLuma {
+		return fmt.Errorf("%w: unknown Rounding %d", ErrInvalidConfig, c.Rounding)
+	}
+	if c.Checksum > ChecksumCRC32C {
+		return fmt.Errorf("%w: unknown Checksum %d", ErrInvalidConfig, c.Checksum)
+	}
+	for _, err := range []error{validateCompression(c.Compression), validateColorSpace(c.ColorSpace), validateChannels(c.Channels)} {
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
 	}
+	switch {
+	case c.OutputFormat == "" || imgutil.IsSupportedFormat(c.OutputFormat):
+	case imgutil.IsGIFFormat(c.OutputFormat):
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, ErrGIFOutput)
+	default:
+		return fmt.Errorf("%w: unsupported OutputFormat %q", ErrInvalidConfig, c.OutputFormat)
+	}
+	if err := c.validateCoeffs(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	if c.Region != nil && c.regionBlocks().Empty() {
+		return fmt.Errorf("%w: Region contains no whole blocks", ErrInvalidConfig)
+	}
+	if c.CarrierQuality != 0 {
+		if err := c.validateCarrierQuality(); err != nil {
+			return fmt.Errorf("%w: CarrierQuality: %w", ErrInvalidConfig, err)
+		}
+	}
+	if c.JPEGCoefficients {
+		if err := c.validateJPEGCoefficients(); err != nil {
+			return fmt.Errorf("%w: JPEGCoefficients: %w", ErrInvalidConfig, err)
+		}
+	}
+	return nil
+}
+
+// validateCompression checks c is a known Compression
+func validateCompression(c Compression) error {
+	if c != CompressionNone && c != CompressionFlate {
+		return fmt.Errorf("unknown Compression %d", c)
+	}
+	return nil
+}
+
+// validateColorSpace checks cs is a known ColorSpace
+func validateColorSpace(cs ColorSpace) error {
+	if cs != ColorSpaceBT601 && cs != ColorSpaceBT709 {
+		return fmt.Errorf("unknown ColorSpace %d", cs)
+	}
+	return nil
+}
+
+// validateChannels checks channels names no planes beyond Y, Cb and Cr
+// (0 means ChannelY)
+func validateChannels(channels Channel) error {
+	if channels&^(ChannelY|ChannelCb|ChannelCr) != 0 {
+		return fmt.Errorf("invalid Channels %#x", channels)
+	}
+	return nil
 }
 
 // EmbedOptions holds options for embedding
 type EmbedOptions struct {
 	// Config is the DCT configuration
 	Config DCTConfig
-	// JPEGQuality is the JPEG quality (1-100) if output format is JPEG, default 90
+	// JPEGQuality is the JPEG quality (1-100) if output format is JPEG
+	// (0 = default of 90)
 	JPEGQuality int
+	// PNGCompression is the compression level of PNG output
+	// (0 = png.DefaultCompression); png.BestCompression makes smaller files
+	// more slowly, which helps with large stego images, png.BestSpeed the
+	// reverse. The pixels, and so the message, are the same at every level
+	PNGCompression png.CompressionLevel
+	// Parallelism is the number of goroutines processing blocks
+	// (0 = runtime.NumCPU(), 1 = serial); output is identical either way
+	Parallelism int
+	// BatchParallelism is the number of files EmbedMessageDCTBatch embeds
+	// at once (0 = one at a time)
+	BatchParallelism int
+	// Password, if non-empty, encrypts the message with AES-256-GCM using a
+	// key derived from it; the salt and nonce are stored in the payload
+	Password string
+	// DeterministicEncryption, if true, derives the salt and nonce used with
+	// Password from the message and password instead of drawing them at
+	// random, so embedding stays byte-identical across runs. Embedding the
+	// same message twice then reveals that it's the same, so leave it off
+	// unless reproducible output is needed
+	DeterministicEncryption bool
+	// HMACKey, if non-empty, signs the message with an HMAC-SHA256 of the
+	// header fields, the metadata and the payload (after compression and
+	// encryption) keyed with this shared secret, so extraction with the same
+	// key can tell it came from a holder of the key and wasn't modified.
+	// Adds 32 bytes to the payload
+	HMACKey []byte
+	// Metadata holds optional key-value pairs (e.g. content type, filename)
+	// stored in the frame alongside the message. It is neither compressed
+	// nor encrypted, even when Password is set, but is covered by HMACKey.
+	// The key "emg.original-size" is reserved (see DCTConfig.PadToBlockSize)
+	Metadata map[string]string
+	// Verify, if true, extracts the message back from the encoded output and
+	// returns ErrVerificationFailed instead of output that doesn't carry it
+	Verify bool
+	// OnProgress, if set, is called after each row's worth of blocks is
+	// embedded (once per frame with EmbedStreams). With Parallelism other
+	// than 1 it may be called from different goroutines, but never concurrently
+	OnProgress ProgressFunc
+	// PNGSpill lets a message too long for the DCT capacity spill its
+	// remainder into a zTXt metadata chunk of the PNG output, which
+	// extraction reassembles transparently. The chunk is plainly visible to
+	// anyone inspecting the file, so this trades covertness for capacity;
+	// it needs PNG output
+	PNGSpill bool
 }
 
 // DefaultEmbedOptions returns default embedding options
 func DefaultEmbedOptions() *EmbedOptions {
 	return &EmbedOptions{
 		Config:      DefaultDCTConfig(),
-		JPEGQuality: 90,
+		JPEGQuality: defaultJPEGQuality,
+	}
+}
+
+// defaultJPEGQuality is the JPEG quality used when EmbedOptions.JPEGQuality is zero
+const defaultJPEGQuality = 90
+
+// Validate checks the options can produce recoverable output: the Config
+// (see DCTConfig.Validate), a JPEG quality of 0 (default) or 1-100, one
+// of the png package's compression levels and no reserved Metadata key
+// Returns ErrInvalidConfig (wrapped) describing the first problem found
+func (o *EmbedOptions) Validate() error {
+	if err := o.Config.Validate(); err != nil {
+		return err
+	}
+	if o.JPEGQuality < 0 || o.JPEGQuality > 100 {
+		return fmt.Errorf("%w: JPEGQuality must be in 1-100, got %d", ErrInvalidConfig, o.JPEGQuality)
+	}
+	if o.PNGCompression < png.BestCompression || o.PNGCompression > png.DefaultCompression {
+		return fmt.Errorf("%w: unknown PNGCompression %d", ErrInvalidConfig, o.PNGCompression)
+	}
+	if o.PNGSpill && o.Config.OutputFormat != "" && strings.ToLower(o.Config.OutputFormat) != "png" {
+		return fmt.Errorf("%w: PNGSpill needs PNG output, got %q", ErrInvalidConfig, o.Config.OutputFormat)
+	}
+	if _, ok := o.Metadata[originalSizeKey]; ok {
+		return fmt.Errorf("%w: Metadata key %q is reserved for PadToBlockSize", ErrInvalidConfig, originalSizeKey)
+	}
+	return nil
+}
+
+// jpegQuality returns the configured JPEG quality or the default
+func (o *EmbedOptions) jpegQuality() int {
+	if o.JPEGQuality == 0 {
+		return defaultJPEGQuality
+	}
+	return o.JPEGQuality
+}
+
+// ExtractOptions holds options for extraction
+type ExtractOptions struct {
+	// Config is the DCT configuration; its block layout fields (e.g. Interleave)
+	// must match the ones used when embedding
+	Config DCTConfig
+	// Parallelism is the number of goroutines processing blocks
+	// (0 = runtime.NumCPU(), 1 = serial)
+	Parallelism int
+	// Password decrypts messages that were embedded with a password
+	Password string
+	// HMACKey, if non-empty, requires the message to be signed with this key
+	// (see EmbedOptions.HMACKey): unsigned, differently signed or modified
+	// messages fail with ErrAuthenticationFailed. Without it a signed
+	// message is extracted unverified
+	HMACKey []byte
+	// OnProgress, if set, is called after each row's worth of blocks of the
+	// frame is read (the much shorter preamble and header reads aren't
+	// reported). With Parallelism other than 1 it may be called from
+	// different goroutines, but never concurrently
+	OnProgress ProgressFunc
+	// SkipCRC returns the payload without checking it against the payload
+	// CRC, for channels so lossy the CRC nearly always fails although the
+	// ECC-corrected payload is still usable. The message may then contain
+	// wrong bytes; the frame header is still validated against its own CRC
+	// (see also ExtractMessageDCTUnsafe)
+	SkipCRC bool
+	// LenientHeader accepts frame headers with reserved bits set, which are
+	// otherwise rejected with ErrReservedNonZero as probably written by a
+	// newer format; the bits are ignored, so such a message may be misread
+	LenientHeader bool
+	// AllowPartial returns as much of the message as the image still holds
+	// when the frame runs past its capacity (e.g. the bottom of the image was
+	// cropped off), with ExtractResult.Partial set, instead of failing with
+	// ErrFrameTruncated. A partial message can't be checked against the
+	// payload CRC, and encrypted or compressed payloads can't be recovered
+	AllowPartial bool
+
+	// spill holds the payload bytes the input's PNG spill chunk carries, if
+	// any (see EmbedOptions.PNGSpill)
+	spill []byte
+}
+
+// parser returns the frame parser for the options' header policy
+func (o *ExtractOptions) parser() framing.Parser {
+	return framing.Parser{Lenient: o.LenientHeader}
+}
+
+// DefaultExtractOptions returns default extraction options
+func DefaultExtractOptions() *ExtractOptions {
+	return &ExtractOptions{
+		Config: DefaultDCTConfig(),
 	}
 }
 
@@ -112,398 +708,1494 @@ func EmbedMessageDCTFile(inputPath, outputPath string, message []byte, opts *Emb
 // EmbedMessageDCT embeds a message into an image using DCT
 // input is the encoded image bytes (PNG/JPEG), or nil to load from file
 // Returns encoded image bytes with embedded message
+// Embedding is deterministic: the same input, message and options produce
+// byte-identical output on every run, whatever the Parallelism. The one
+// exception is a Password, as encryption draws a random salt and nonce,
+// unless DeterministicEncryption is set
 func EmbedMessageDCT(input []byte, message []byte, opts *EmbedOptions) ([]byte, error) {
-	if opts == nil {
-		opts = DefaultEmbedOptions()
-	}
+	return EmbedMessageDCTContext(context.Background(), input, message, opts)
+}
 
-	// Load image
-	var img image.Image
-	var format string
-	var err error
-	if input == nil {
-		return nil, fmt.Errorf("input data required")
-	}
-	img, format, err = imgutil.LoadImage(input)
+// EmbedMessageDCTContext is like EmbedMessageDCT, but stops and returns
+// ctx.Err() when ctx is cancelled (checked between block rows)
+func EmbedMessageDCTContext(ctx context.Context, input []byte, message []byte, opts *EmbedOptions) ([]byte, error) {
+	result, err := embedMessageDCT(ctx, input, message, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load image: %w", err)
+		return nil, err
 	}
+	return result.Output, nil
+}
 
-	// Convert to YCbCr planes
-	yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(img)
+// EmbedResult describes the outcome of an embedding
+type EmbedResult struct {
+	// Output is the encoded image bytes with the embedded message
+	Output []byte
+	// BitsWritten is the number of encoded bits embedded (preamble and ECC-encoded frame)
+	BitsWritten int
+	// BlocksUsed is the number of 8x8 blocks modified (one per bit written)
+	BlocksUsed int
+	// BlocksAvailable is the number of blocks that could carry bits, across
+	// all carrier channels and excluding skipped low-energy blocks
+	BlocksAvailable int
+	// FractionUsed is BlocksUsed / BlocksAvailable
+	FractionUsed float64
+	// InputFormat is the detected format of the input image ("png", "jpeg",
+	// "gif", "bmp", "tiff" or "webp")
+	InputFormat string
+	// SubsampleRatio is the chroma subsampling of the input image: the
+	// stored ratio for JPEG input, 4:4:4 for formats with full-resolution
+	// chroma. JPEG output of JPEG input keeps this ratio; other inputs are
+	// written 4:2:0 (see imgutil.JPEGSubsampleRatio)
+	SubsampleRatio image.YCbCrSubsampleRatio
+	// Warnings lists problems that didn't stop the embedding but will likely
+	// make extraction fail or surprise the caller, each wrapping a sentinel
+	// such as ErrDeltaTooSmallForJPEG or ErrPNGFallback (check with
+	// errors.Is)
+	Warnings []error
+}
 
-	// Build frame (header + message)
-	frame, err := framing.BuildFrame(message, uint8(opts.Config.ECC))
-	if err != nil {
-		return nil, fmt.Errorf("failed to build frame: %w", err)
-	}
+// EmbedMessageDCTWithResult is like EmbedMessageDCT, but also reports how
+// much of the image's capacity the message consumed
+func EmbedMessageDCTWithResult(input []byte, message []byte, opts *EmbedOptions) (*EmbedResult, error) {
+	return embedMessageDCT(context.Background(), input, message, opts)
+}
 
-	// Get ECC scheme
-	eccScheme, err := ecc.GetScheme(opts.Config.ECC)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ECC scheme: %w", err)
+// EmbedMessageDCTImage is like EmbedMessageDCT for an already decoded image,
+// returning the stego image itself rather than encoding it, for pipelines
+// that process it further in memory or use their own encoder
+// The image is in the form the output format would be written from (e.g.
+// with alpha and 16-bit depth for PNG, the default); the caller's encoding
+// must be lossless or survivable, as with OutputFormat. It has img's bounds
+// (grown by PadToBlockSize), so the output for a sub-image with a non-zero
+// origin lines up with the sub-image; blocks are taken from its origin
+// JPEGCoefficients and PNGSpill work on encoded files, so return
+// ErrInvalidConfig here
+func EmbedMessageDCTImage(img image.Image, message []byte, opts *EmbedOptions) (image.Image, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Config.JPEGCoefficients || opts.PNGSpill {
+		return nil, fmt.Errorf("%w: JPEGCoefficients and PNGSpill need encoded output", ErrInvalidConfig)
 	}
 
-	// ECC encode frame
-	encodedBits, err := eccScheme.EncodeFrame(frame)
+	ctx := context.Background()
+	e, err := newImageEmbedding(ctx, img, "", opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to ECC encode: %w", err)
+		return nil, err
+	}
+	if _, _, err := e.embedFrame(ctx, message, 0, opts.Config); err != nil {
+		return nil, err
+	}
+	output := e.image()
+	if opts.Verify {
+		if err := verifyImageEmbedding(ctx, output, message, opts); err != nil {
+			return nil, err
+		}
 	}
+	return output, nil
+}
 
-	// Check capacity
-	capacityBits := imgutil.CapacityBits(yPlane.Width, yPlane.Height)
-	if len(encodedBits) > capacityBits {
-		return nil, ErrMessageTooLong
+// embedMessageDCT implements the EmbedMessageDCT variants
+func embedMessageDCT(ctx context.Context, input []byte, message []byte, opts *EmbedOptions) (*EmbedResult, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
 
-	// Embed bits into DCT coefficients
-	err = embedBitsIntoDCT(yPlane, encodedBits, opts.Config)
+	e, err := newEmbedding(ctx, input, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to embed bits: %w", err)
+		return nil, err
 	}
-
-	// Convert back to image
-	outputImg := ycbcr.YCbCrPlanesToImage(yPlane, cbPlane, crPlane)
-
-	// Determine output format
-	outputFormat := opts.Config.OutputFormat
-	if outputFormat == "" {
-		outputFormat = format
+	var bitsWritten, capacityBits int
+	var output []byte
+	if opts.Config.JPEGCoefficients {
+		output, bitsWritten, capacityBits, err = e.embedJPEGCoefficients(input, message)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var spill []byte
+		bitsWritten, capacityBits, err = e.embedFrame(ctx, message, 0, opts.Config)
+		if errors.Is(err, ErrMessageTooLong) && opts.PNGSpill {
+			bitsWritten, capacityBits, spill, err = e.embedFrameSpilling(ctx, message)
+		}
+		if err != nil {
+			return nil, err
+		}
+		output, err = e.encode()
+		if err != nil {
+			return nil, err
+		}
+		if spill != nil {
+			output, err = addSpillChunk(output, spill)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
-	if outputFormat == "" {
-		outputFormat = "png"
+	if opts.Verify {
+		if err := verifyEmbedding(ctx, output, message, opts); err != nil {
+			return nil, err
+		}
 	}
 
-	// Encode image
-	return imgutil.EncodeImage(outputImg, outputFormat, opts.JPEGQuality)
-}
-
-// ExtractMessageDCTFile extracts a message from an image file using DCT
-func ExtractMessageDCTFile(inputPath string) ([]byte, error) {
-	// Load image data
-	data, err := os.ReadFile(inputPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+	result := &EmbedResult{
+		Output:          output,
+		BitsWritten:     bitsWritten,
+		BlocksUsed:      bitsWritten,
+		BlocksAvailable: capacityBits,
+		InputFormat:     e.inputFormat,
+		SubsampleRatio:  ycbcr.SubsampleRatio(e.img),
 	}
+	if capacityBits > 0 {
+		result.FractionUsed = float64(result.BlocksUsed) / float64(capacityBits)
+	}
+	result.Warnings = append(result.Warnings, e.warnings...)
+	if err := e.checkJPEGGap(); err != nil {
+		result.Warnings = append(result.Warnings, err)
+	}
+	return result, nil
+}
 
-	return ExtractMessageDCT(data)
+// embedding is an image being embedded into: its YCbCr planes (padded if
+// requested) and how they will be encoded
+type embedding struct {
+	opts             *EmbedOptions
+	img              image.Image
+	y, cb, cr, alpha *ycbcr.Plane
+	metadata         map[string]string
+	method           uint8
+	inputFormat      string
+	outputFormat     string
+	quant            *[64]float64
+	workers          int
+	// warnings are problems found preparing the embedding, for
+	// EmbedResult.Warnings
+	warnings []error
 }
 
-// ExtractMessageDCT extracts a message from an image using DCT
-func ExtractMessageDCT(input []byte) ([]byte, error) {
-	// Load image
-	img, _, err := imgutil.LoadImage(input)
+// newEmbedding decodes input and prepares its planes for embedding
+// opts must already be validated
+func newEmbedding(ctx context.Context, input []byte, opts *EmbedOptions) (*embedding, error) {
+	if input == nil {
+		return nil, fmt.Errorf("input data required")
+	}
+	img, format, err := imgutil.LoadImage(input)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load image: %w", err)
 	}
+	return newImageEmbedding(ctx, img, format, opts)
+}
 
-	// Convert to YCbCr planes
-	yPlane, _, _ := ycbcr.ImageToYCbCrPlanes(img)
-
-	// Determine ECC scheme (assume Repetition3 for now)
-	eccScheme, err := ecc.GetScheme(ECCSchemeRepetition3)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ECC scheme: %w", err)
+// newImageEmbedding prepares the planes of a decoded image for embedding;
+// format is the format it was decoded from, or empty
+// opts must already be validated
+func newImageEmbedding(ctx context.Context, img image.Image, format string, opts *EmbedOptions) (*embedding, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
 
-	capacityBits := imgutil.CapacityBits(yPlane.Width, yPlane.Height)
+	e := &embedding{opts: opts, img: img, inputFormat: format, workers: workerCount(opts.Parallelism)}
 
-	// First pass: Extract enough bits to decode the frame header
-	// Header is 16 bytes = 128 bits, with repetition-3 = 384 encoded bits
-	minBitsForHeader := framing.HeaderSize * 8 * 3 // 384 bits
-	extractBits := minBitsForHeader
-	if capacityBits < extractBits {
-		extractBits = capacityBits
-	}
+	// Convert to YCbCr planes, keeping any transparency
+	y, cb, cr, alpha := ycbcr.ImageToYCbCrPlanesWithAlpha(img, opts.Config.ColorSpace)
 
-	extractedBits := extractBitsFromDCT(yPlane, extractBits)
-	frameBytes, err := eccScheme.DecodeFrame(extractedBits)
-	if err != nil {
-		return nil, fmt.Errorf("failed to ECC decode header: %w", err)
+	// Pad to whole blocks if requested, recording the original size
+	e.metadata = opts.frameMetadata(y.Width, y.Height)
+	width, height := opts.Config.paddedSize(y.Width, y.Height)
+	e.y, e.cb, e.cr, e.alpha = y.Pad(width, height), cb.Pad(width, height), cr.Pad(width, height), alpha.Pad(width, height)
+	if err := opts.Config.checkImageSize(width, height); err != nil {
+		return nil, err
 	}
 
-	// Parse header to get payload length
-	if len(frameBytes) < framing.HeaderSize {
-		// Not enough bits, try extracting more
-		extractedBits = extractBitsFromDCT(yPlane, capacityBits)
-		frameBytes, err = eccScheme.DecodeFrame(extractedBits)
-		if err != nil {
-			return nil, fmt.Errorf("failed to ECC decode: %w", err)
-		}
+	// Determine output format
+	e.outputFormat = opts.Config.OutputFormat
+	if e.outputFormat == "" {
+		e.outputFormat = format
 	}
-
-	// Try to parse just the header fields manually to get payload length
-	if len(frameBytes) < framing.HeaderSize {
-		return nil, fmt.Errorf("insufficient data for frame header")
+	if e.outputFormat == "" || (e.outputFormat == "gif" && opts.Config.OutputFormat == "") {
+		// GIF input is written as PNG, as re-quantizing to a palette would
+		// destroy the embedded data, as is input of an unknown format
+		e.outputFormat = "png"
+	}
+	if opts.Config.OutputFormat == "" && format == "" {
+		e.warnings = append(e.warnings, ErrPNGFallback)
 	}
 
-	// Validate magic bytes first
-	magic := string(frameBytes[0:4])
-	if magic != framing.Magic {
-		// Header is corrupted, try extracting all bits
-		extractedBits = extractBitsFromDCT(yPlane, capacityBits)
-		frameBytes, err = eccScheme.DecodeFrame(extractedBits)
-		if err != nil {
-			return nil, fmt.Errorf("failed to ECC decode: %w", err)
-		}
-		// Try parsing again
-		header, payload, err := framing.ParseFrame(frameBytes)
-		if err != nil {
-			if errors.Is(err, framing.ErrCRCMismatch) {
-				return nil, ErrCRCMismatch
-			}
-			return nil, fmt.Errorf("%w: %v", ErrFrameCorrupt, err)
-		}
-		if header.ECCScheme != uint8(ECCSchemeRepetition3) {
-			eccScheme, err = ecc.GetScheme(ECCScheme(header.ECCScheme))
-			if err != nil {
-				return nil, fmt.Errorf("unsupported ECC scheme: %d", header.ECCScheme)
-			}
-			frameBytes, err = eccScheme.DecodeFrame(extractedBits)
-			if err != nil {
-				return nil, fmt.Errorf("failed to ECC decode with correct scheme: %w", err)
-			}
-			_, payload, err = framing.ParseFrame(frameBytes)
-			if err != nil {
-				if errors.Is(err, framing.ErrCRCMismatch) {
-					return nil, ErrCRCMismatch
-				}
-				return nil, fmt.Errorf("%w: %v", ErrFrameCorrupt, err)
-			}
+	// Align embedding to the JPEG quantization grid if requested
+	if opts.Config.QuantizationAware && isJPEG(e.outputFormat) {
+		if n := opts.Config.blockSize(); n != 8 {
+			return nil, fmt.Errorf("%w: quantization-aware embedding needs 8x8 blocks, got %d", ErrInvalidBlockSize, n)
 		}
-		return payload, nil
+		table := imgutil.JPEGLuminanceQuantTable(opts.jpegQuality())
+		e.quant = &table
 	}
 
-	// Read payload length from header (bytes 8-11, big-endian uint32)
-	payloadLength := uint32(frameBytes[8])<<24 | uint32(frameBytes[9])<<16 | uint32(frameBytes[10])<<8 | uint32(frameBytes[11])
-	// Sanity check payload length
-	if payloadLength > 1000000 { // Unreasonably large
-		return nil, fmt.Errorf("invalid payload length in header: %d", payloadLength)
-	}
-	totalFrameBytes := framing.HeaderSize + int(payloadLength)
-	totalFrameBits := totalFrameBytes * 8 * 3 // With repetition-3
+	return e, nil
+}
 
-	// Second pass: Extract exactly the number of bits needed for the full frame
-	if totalFrameBits > capacityBits {
-		return nil, fmt.Errorf("frame requires %d bits but capacity is only %d", totalFrameBits, capacityBits)
+// embedFrame frames message under the given stream ID and embeds it into the
+// planes using config's block layout (e.g. Region)
+// Returns the number of encoded bits written and the capacity in bits
+func (e *embedding) embedFrame(ctx context.Context, message []byte, stream uint8, config DCTConfig) (int, int, error) {
+	bits, count, err := e.frameBits(message, stream, config)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	extractedBits = extractBitsFromDCT(yPlane, totalFrameBits)
-	frameBytes, err = eccScheme.DecodeFrame(extractedBits)
+	// Check capacity
+	planes := config.carrierPlanes(e.y, e.cb, e.cr)
+	capacityBits, err := e.capacityBits(ctx, planes, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to ECC decode full frame: %w", err)
+		return 0, 0, err
+	}
+	if count > capacityBits {
+		return 0, 0, ErrMessageTooLong
 	}
 
-	// Parse frame
-	header, payload, err := framing.ParseFrame(frameBytes)
+	// Embed bits into DCT coefficients
+	err = embedBitsIntoDCTQuantized(ctx, planes, bits, count, config, e.quant, e.opts.OnProgress, e.workers)
 	if err != nil {
-		if errors.Is(err, framing.ErrCRCMismatch) {
-			return nil, ErrCRCMismatch
+		if ctx.Err() != nil {
+			return 0, 0, err
 		}
-		return nil, fmt.Errorf("%w: %v", ErrFrameCorrupt, err)
+		return 0, 0, fmt.Errorf("failed to embed bits: %w", err)
 	}
+	return count, capacityBits, nil
+}
 
-	// Verify ECC scheme matches
-	if header.ECCScheme != uint8(ECCSchemeRepetition3) {
-		// Try with the correct scheme
-		eccScheme, err = ecc.GetScheme(ECCScheme(header.ECCScheme))
-		if err != nil {
-			return nil, fmt.Errorf("unsupported ECC scheme in frame: %d", header.ECCScheme)
-		}
-		// Re-decode with correct scheme
-		frameBytes, err = eccScheme.DecodeFrame(extractedBits)
-		if err != nil {
-			return nil, fmt.Errorf("failed to ECC decode with correct scheme: %w", err)
-		}
-		_, payload, err = framing.ParseFrame(frameBytes)
-		if err != nil {
-			if errors.Is(err, framing.ErrCRCMismatch) {
-				return nil, ErrCRCMismatch
-			}
-			return nil, fmt.Errorf("%w: %v", ErrFrameCorrupt, err)
-		}
+// frameBits frames message under the given stream ID and ECC-encodes it,
+// prefixed with the preamble
+// Returns the encoded bits and their count
+func (e *embedding) frameBits(message []byte, stream uint8, config DCTConfig) (bitstream.Iterator, int, error) {
+	// Apply payload transforms (e.g. encryption) and build frame (header + payload)
+	payload, flags, err := encodePayload(message, e.opts)
+	if err != nil {
+		return nil, 0, err
 	}
-
-	return payload, nil
+	payload, err = e.sign(payload, flags, stream, config)
+	if err != nil {
+		return nil, 0, err
+	}
+	return e.encodeFrame(payload, flags, e.metadata, stream, config)
 }
 
-// GetCapacityInfoFromData calculates capacity from image data in memory
-func GetCapacityInfoFromData(data []byte, eccScheme ECCScheme) (*CapacityInfo, error) {
-	img, _, err := imgutil.LoadImage(data)
+// encodeFrame frames an already encoded payload with the given flags and
+// metadata and ECC-encodes it, prefixed with the preamble, as frameBits
+func (e *embedding) encodeFrame(payload []byte, flags uint8, metadata map[string]string, stream uint8, config DCTConfig) (bitstream.Iterator, int, error) {
+	frame, err := framing.BuildFrameWithMethod(payload, uint8(config.ECC), flags, metadata, stream, e.method)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load image: %w", err)
+		return nil, 0, fmt.Errorf("failed to build frame: %w", err)
 	}
 
-	// Convert to YCbCr to get dimensions
-	yPlane, _, _ := ycbcr.ImageToYCbCrPlanes(img)
-
-	// Calculate capacity
-	width := yPlane.Width
-	height := yPlane.Height
-	blocksAcross := width / 8
-	blocksDown := height / 8
-	capacityBits := blocksAcross * blocksDown
-
-	// Get ECC scheme to determine expansion factor
-	ecc, err := ecc.GetScheme(eccScheme)
+	// Get ECC scheme
+	eccScheme, err := ecc.GetScheme(config.ECC)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get ECC scheme: %w", err)
+		return nil, 0, fmt.Errorf("failed to get ECC scheme: %w", err)
 	}
 
-	// Calculate maximum payload
-	// Frame = header (16 bytes) + payload
-	// Encoded bits = frameBytes * 8 * eccExpansion
-	// We need: capacityBits >= (16 + payloadBytes) * 8 * eccExpansion
-	// Solving: payloadBytes <= (capacityBits / (8 * eccExpansion)) - 16
-
-	// For repetition-3, expansion is 3
-	// Test with a dummy frame to get the expansion factor
-	testFrame := make([]byte, framing.HeaderSize+1) // 16 + 1 = 17 bytes
-	encodedBits, err := ecc.EncodeFrame(testFrame)
+	// ECC encode frame, lazily where the scheme supports it
+	encoded, count, err := ecc.EncodeStream(eccScheme, frame)
 	if err != nil {
-		return nil, fmt.Errorf("failed to encode test frame: %w", err)
+		return nil, 0, fmt.Errorf("failed to ECC encode: %w", err)
 	}
 
-	// Calculate expansion factor
-	expansionFactor := len(encodedBits) / (len(testFrame) * 8)
+	// Prefix the preamble so extraction can learn the ECC scheme
+	bits := bitstream.Concat(bitstream.NewSliceIterator(encodePreamble(config.ECC)), encoded)
+	return bits, count + preambleBits, nil
+}
 
-	// Calculate max payload bytes
-	maxFrameBytes := capacityBits / (8 * expansionFactor)
-	maxPayloadBytes := maxFrameBytes - framing.HeaderSize
-	if maxPayloadBytes < 0 {
-		maxPayloadBytes = 0
+// capacityBits returns the number of bits planes can carry with config's
+// block layout, excluding skipped low-energy blocks
+func (e *embedding) capacityBits(ctx context.Context, planes []*ycbcr.Plane, config DCTConfig) (int, error) {
+	if config.UseAllBlocks {
+		return config.capacityBits(e.y.Width, e.y.Height, len(planes)), nil
 	}
 
-	// Estimate UTF-8 character capacity (most UTF-8 chars are 1 byte, but some are 2-4)
-	// Use a conservative estimate: assume average 1.5 bytes per UTF-8 char
-	maxUTF8Chars := int(float64(maxPayloadBytes) / 1.5)
-
-	return &CapacityInfo{
-		Width:           width,
-		Height:          height,
-		BlocksAcross:    blocksAcross,
-		BlocksDown:      blocksDown,
-		CapacityBits:    capacityBits,
-		MaxPayloadBytes: maxPayloadBytes,
-		MaxUTF8Chars:    maxUTF8Chars,
-	}, nil
-}
-
-// GetCapacityInfo calculates capacity from an image file
-func GetCapacityInfo(inputPath string, eccScheme ECCScheme) (*CapacityInfo, error) {
-	// Load image
-	data, err := os.ReadFile(inputPath)
+	// Only blocks with enough texture carry bits
+	n := config.blockSize()
+	order, err := blockOrder(len(planes), e.y.Width/n, e.y.Height/n, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return 0, err
 	}
-
-	return GetCapacityInfoFromData(data, eccScheme)
+	return usableBlockCount(ctx, planes, order, config, e.workers)
 }
 
-// embedBitsIntoDCT embeds bits into DCT coefficients of Y plane
-func embedBitsIntoDCT(yPlane *ycbcr.Plane, bits []bool, config DCTConfig) error {
-	blocksAcross := yPlane.Width / 8
-	blocksDown := yPlane.Height / 8
-	bitIdx := 0
-
-	var block [64]float64
-	var dctBlock [64]float64
-
-	for by := 0; by < blocksDown; by++ {
-		for bx := 0; bx < blocksAcross; bx++ {
-			// Extract 8x8 block and center values (subtract 128) for DCT
-			for y := 0; y < 8; y++ {
-				for x := 0; x < 8; x++ {
-					srcY := by*8 + y
-					srcX := bx*8 + x
-					block[y*8+x] = yPlane.Pix[srcY*yPlane.Stride+srcX] - 128.0
-				}
-			}
-
-			// Apply DCT
-			dct.DCT8x8(&block, &dctBlock)
-
-			// Embed bit if available
-			if bitIdx < len(bits) {
-				bit := bits[bitIdx]
-				coeff22 := dctBlock[2*8+2] // (2,2)
-				coeff23 := dctBlock[2*8+3] // (2,3)
-
-				// Adjust coefficients symmetrically to encode bit
-				// Only modify (2,2) and (2,3), no other coefficients
-				// Always enforce the relationship to ensure reliable extraction
-				midpoint := (coeff22 + coeff23) / 2.0
-				requiredGap := config.MinGap + config.Delta
-
-				if bit {
-					// Encode 1: ensure (2,2) > (2,3) by at least MinGap
-					dctBlock[2*8+2] = midpoint + requiredGap/2.0
-					dctBlock[2*8+3] = midpoint - requiredGap/2.0
-				} else {
-					// Encode 0: ensure (2,2) < (2,3) by at least MinGap
-					dctBlock[2*8+2] = midpoint - requiredGap/2.0
-					dctBlock[2*8+3] = midpoint + requiredGap/2.0
-				}
-				bitIdx++
-			}
+// encode converts the planes back to an image in the output format
+func (e *embedding) encode() ([]byte, error) {
+	return imgutil.EncodeImageWithOptions(e.image(), e.outputFormat, imgutil.EncodeOptions{
+		Quality:            e.opts.jpegQuality(),
+		PNGCompression:     e.opts.PNGCompression,
+		JPEGSubsampleRatio: jpegSubsampling(e.img),
+	})
+}
 
-			// Apply inverse DCT
-			dct.IDCT8x8(&dctBlock, &block)
-
-			// Write back to Y plane with clamping (add 128 back after IDCT)
-			// Keep as float64 to preserve precision through the round-trip
-			for y := 0; y < 8; y++ {
-				for x := 0; x < 8; x++ {
-					srcY := by*8 + y
-					srcX := bx*8 + x
-					val := block[y*8+x] + 128.0
-					if val < 0 {
-						val = 0
-					}
-					if val > 255 {
-						val = 255
-					}
-					// Keep as float64, don't round yet - rounding happens in YCbCr->RGB conversion
-					yPlane.Pix[srcY*yPlane.Stride+srcX] = val
-				}
-			}
-		}
+// jpegSubsampling returns the chroma subsampling JPEG output of img keeps:
+// its own if it was decoded from a color JPEG, otherwise nil for the
+// encoder's default (imgutil.JPEGSubsampleRatio)
+func jpegSubsampling(img image.Image) *image.YCbCrSubsampleRatio {
+	if src, ok := img.(*image.YCbCr); ok {
+		ratio := src.SubsampleRatio
+		return &ratio
 	}
-
 	return nil
 }
 
-// extractBitsFromDCT extracts bits from DCT coefficients of Y plane
-func extractBitsFromDCT(yPlane *ycbcr.Plane, maxBits int) []bool {
-	blocksAcross := yPlane.Width / 8
-	blocksDown := yPlane.Height / 8
-	bits := make([]bool, 0, maxBits)
-
-	var block [64]float64
-	var dctBlock [64]float64
-
-	for by := 0; by < blocksDown && len(bits) < maxBits; by++ {
-		for bx := 0; bx < blocksAcross && len(bits) < maxBits; bx++ {
-			// Extract 8x8 block and center values (subtract 128) for DCT
-			for y := 0; y < 8; y++ {
-				for x := 0; x < 8; x++ {
-					srcY := by*8 + y
-					srcX := bx*8 + x
-					block[y*8+x] = yPlane.Pix[srcY*yPlane.Stride+srcX] - 128.0
-				}
-			}
-
-			// Apply DCT
-			dct.DCT8x8(&block, &dctBlock)
+// image converts the planes back to an image suited to the output format
+func (e *embedding) image() image.Image {
+	// Keep grayscale input grayscale unless embedding into chroma added
+	// color, and keep alpha where the output format supports it (PNG, TIFF,
+	// WebP), as well as 16-bit precision
+	// Deblock only smooths luma, so chroma-only embedding leaves Y untouched
+	if e.opts.Config.Deblock && e.opts.Config.carriesLuma() {
+		deblock(e.y, e.opts.Config)
+	}
 
-			// Extract bit by comparing coefficients
-			coeff22 := dctBlock[2*8+2] // (2,2)
-			coeff23 := dctBlock[2*8+3] // (2,3)
+	cs := e.opts.Config.ColorSpace
+	deep := imgutil.FormatKeeps16Bit(e.outputFormat) && ycbcr.Is16Bit(e.img)
+	var outputImg image.Image
+	switch {
+	case isGray(e.img) && ycbcr.IsNeutral(e.cb, e.cr) && deep:
+		outputImg = ycbcr.YPlaneToGray16(e.y)
+	case isGray(e.img) && ycbcr.IsNeutral(e.cb, e.cr):
+		outputImg = ycbcr.YPlaneToGray(e.y)
+	case deep:
+		outputImg = ycbcr.YCbCrPlanesToImage16(e.y, e.cb, e.cr, e.alpha, cs)
+	case imgutil.FormatKeepsAlpha(e.outputFormat):
+		outputImg = ycbcr.YCbCrAlphaPlanesToImage(e.y, e.cb, e.cr, e.alpha, cs, e.opts.Config.Rounding)
+	default:
+		outputImg = ycbcr.YCbCrPlanesToImageRounded(e.y, e.cb, e.cr, cs, e.opts.Config.Rounding)
+	}
+	return withOrigin(outputImg, e.img.Bounds().Min)
+}
 
-			bit := coeff22 > coeff23
-			bits = append(bits, bit)
-		}
+// withOrigin moves an image built by the ycbcr package, which starts at
+// (0, 0), to start at origin, so the output of a sub-image input keeps the
+// input's bounds; the pixels are shared
+func withOrigin(img image.Image, origin image.Point) image.Image {
+	switch m := img.(type) {
+	case *image.Gray:
+		m.Rect = m.Rect.Add(origin)
+	case *image.Gray16:
+		m.Rect = m.Rect.Add(origin)
+	case *image.RGBA:
+		m.Rect = m.Rect.Add(origin)
+	case *image.NRGBA:
+		m.Rect = m.Rect.Add(origin)
+	case *image.RGBA64:
+		m.Rect = m.Rect.Add(origin)
+	case *image.NRGBA64:
+		m.Rect = m.Rect.Add(origin)
 	}
+	return img
+}
 
-	return bits
+// verifyImageEmbedding is verifyEmbedding for an unencoded output image
+func verifyImageEmbedding(ctx context.Context, output image.Image, message []byte, opts *EmbedOptions) error {
+	extractOpts := &ExtractOptions{
+		Config:      opts.Config,
+		Parallelism: opts.Parallelism,
+		Password:    opts.Password,
+		HMACKey:     opts.HMACKey,
+	}
+	planes, capacityBits, err := imagePlanes(ctx, output, extractOpts)
+	if err != nil {
+		return err
+	}
+	result, err := extractFromPlanes(ctx, planes, capacityBits, extractOpts)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrVerificationFailed, err)
+	}
+	if !bytes.Equal(result.Message, message) {
+		return ErrVerificationFailed
+	}
+	return nil
+}
+
+// verifyEmbedding extracts the message from output with the options it was
+// embedded with, returning ErrVerificationFailed unless it matches message
+func verifyEmbedding(ctx context.Context, output, message []byte, opts *EmbedOptions) error {
+	extractOpts := &ExtractOptions{
+		Config:      opts.Config,
+		Parallelism: opts.Parallelism,
+		Password:    opts.Password,
+		HMACKey:     opts.HMACKey,
+	}
+	extracted, err := ExtractMessageDCTContext(ctx, output, extractOpts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrVerificationFailed, err)
+	}
+	if !bytes.Equal(extracted, message) {
+		return ErrVerificationFailed
+	}
+	return nil
+}
+
+// checkJPEGGap returns ErrDeltaTooSmallForJPEG (wrapped) if the output is
+// JPEG and the gap between some carrier pair, MinGap + Delta, is under the
+// sum of the pair's luminance quantization steps at the output quality.
+// Rounding to the grid alone can close up to half that, and the encoder's
+// integer DCT and colour conversion add more, so smaller gaps are fragile
+// QuantizationAware and JPEGCoefficients embedding land on the grid, and
+// spread spectrum and other block sizes don't map onto single steps, so
+// they aren't checked; with AdaptiveDelta flat blocks get less than Delta
+func (e *embedding) checkJPEGGap() error {
+	config := e.opts.Config
+	if !isJPEG(e.outputFormat) || config.QuantizationAware || config.JPEGCoefficients ||
+		config.Mode != ModeComparison || config.blockSize() != 8 {
+		return nil
+	}
+	quality := e.opts.jpegQuality()
+	table := imgutil.JPEGLuminanceQuantTable(quality)
+	gap := config.MinGap + config.Delta
+	for _, pair := range config.carrierPairs() {
+		if steps := table[pair[0]] + table[pair[1]]; gap < steps {
+			return fmt.Errorf("%w: gap %g (MinGap + Delta) is below the quantization steps %g at quality %d; raise Delta or use QuantizationAware",
+				ErrDeltaTooSmallForJPEG, gap, steps, quality)
+		}
+	}
+	return nil
+}
+
+// isJPEG reports whether an output format name selects JPEG
+func isJPEG(format string) bool {
+	return imgutil.CanonicalFormat(format) == "jpg"
+}
+
+// isGray reports whether img is a grayscale image
+func isGray(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+// ExtractMessageDCTFile extracts a message from an image file using DCT
+func ExtractMessageDCTFile(inputPath string) ([]byte, error) {
+	// Load image data
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return ExtractMessageDCT(data)
+}
+
+// ExtractMessageDCT extracts a message from an image using DCT
+func ExtractMessageDCT(input []byte) ([]byte, error) {
+	return ExtractMessageDCTWithOptions(input, nil)
+}
+
+// ExtractMessageDCTWithPassword extracts a message that was embedded with
+// EmbedOptions.Password set, decrypting it after CRC validation
+// Returns ErrDecryptionFailed if the password is wrong
+func ExtractMessageDCTWithPassword(input []byte, password string) ([]byte, error) {
+	opts := DefaultExtractOptions()
+	opts.Password = password
+	return ExtractMessageDCTWithOptions(input, opts)
+}
+
+// ExtractMessageDCTWithOptions extracts a message from an image using DCT
+// with the given options (nil uses DefaultExtractOptions)
+func ExtractMessageDCTWithOptions(input []byte, opts *ExtractOptions) ([]byte, error) {
+	return ExtractMessageDCTContext(context.Background(), input, opts)
+}
+
+// ExtractMessageDCTContext is like ExtractMessageDCTWithOptions, but stops
+// and returns ctx.Err() when ctx is cancelled (checked between block rows)
+func ExtractMessageDCTContext(ctx context.Context, input []byte, opts *ExtractOptions) ([]byte, error) {
+	result, err := extractMessageDCT(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result.Message, nil
+}
+
+// ExtractResult holds an extracted message and the data stored alongside it
+type ExtractResult struct {
+	// Message is the extracted message
+	Message []byte
+	// Metadata holds the key-value pairs embedded with EmbedOptions.Metadata
+	// (nil if none were embedded)
+	Metadata map[string]string
+	// OriginalSize is the image size before DCTConfig.PadToBlockSize padded
+	// it (zero if the image wasn't padded)
+	OriginalSize image.Point
+	// Stream is the frame's stream ID (0 unless embedded with EmbedStreams)
+	Stream uint8
+	// Partial is set when the image held only the start of the frame (see
+	// ExtractOptions.AllowPartial): Message is then truncated and unverified
+	Partial bool
+	// Authenticated is set when the message's HMAC was verified against
+	// ExtractOptions.HMACKey
+	Authenticated bool
+}
+
+// ExtractMessageDCTWithResult is like ExtractMessageDCTWithOptions, but also
+// returns the metadata embedded with the message
+func ExtractMessageDCTWithResult(input []byte, opts *ExtractOptions) (*ExtractResult, error) {
+	return extractMessageDCT(context.Background(), input, opts)
+}
+
+// extractMessageDCT implements the ExtractMessageDCT variants
+func extractMessageDCT(ctx context.Context, input []byte, opts *ExtractOptions) (*ExtractResult, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	planes, capacityBits, err := extractionPlanes(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	if spill := readSpillChunk(input); spill != nil {
+		withSpill := *opts
+		withSpill.spill = spill
+		opts = &withSpill
+	}
+	return extractFromPlanes(ctx, planes, capacityBits, opts)
+}
+
+// extractFromPlanes finds and extracts the frame carried by planes
+func extractFromPlanes(ctx context.Context, planes []*ycbcr.Plane, capacityBits int, opts *ExtractOptions) (*ExtractResult, error) {
+	var result *ExtractResult
+	err := findFrame(ctx, planes, opts, func(offset int, scheme ECCScheme) error {
+		header, payload, err := extractFrameDCT(ctx, planes, offset, capacityBits, scheme, opts)
+		partial := false
+		if errors.Is(err, ErrFrameTruncated) && opts.AllowPartial {
+			header, payload, partial, err = extractFramePrefixDCT(ctx, planes, offset, capacityBits, scheme, opts)
+		}
+		if err != nil {
+			return err
+		}
+		result, err = extractResult(header, payload, opts)
+		if err != nil {
+			return err
+		}
+		result.Partial = partial
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// extractFramePrefixDCT extracts as much of the frame as capacityBits holds,
+// assuming the given scheme and offset as extractFrameDCT, for frames that
+// run past it; partial reports whether any of the payload was missing
+func extractFramePrefixDCT(ctx context.Context, planes []*ycbcr.Plane, offset, capacityBits int, id ECCScheme, opts *ExtractOptions) (*framing.Header, []byte, bool, error) {
+	_, _, eccScheme, err := extractHeaderDCT(ctx, planes, offset, capacityBits, id, opts)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	softBits, err := extractSoftBitsFromDCT(ctx, planes, capacityBits, opts.Config, opts.OnProgress, workerCount(opts.Parallelism))
+	if err != nil {
+		return nil, nil, false, err
+	}
+	primed, err := withHeaderPrior(eccScheme, id, softBits[offset:])
+	if err != nil {
+		return nil, nil, false, err
+	}
+	frameBytes, err := ecc.DecodeSoft(eccScheme, primed)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("failed to ECC decode partial frame: %w", err)
+	}
+	// Some decoders zero-pad a trailing partial byte; keep only whole bytes
+	whole, err := maxFrameBytes(eccScheme, capacityBits-offset)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	frameBytes = frameBytes[:min(len(frameBytes), whole)]
+	header, payload, complete, err := opts.parser().ParseFramePrefix(frameBytes)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("%w: %w", ErrFrameCorrupt, err)
+	}
+	return header, payload, !complete, nil
+}
+
+// Header is a decoded frame header; it marshals to JSON with readable flag
+// names and hex checksums
+type Header = framing.Header
+
+// ExtractHeader decodes and validates the frame header of an embedded message
+// (magic, version and header CRC) without extracting the payload
+// Only the bits covering the header are read, so it's much cheaper than
+// extraction; the payload CRC isn't checked and Metadata is nil, since both
+// need the payload. Returns ErrInvalidMagic (wrapped in ErrFrameCorrupt) if the image
+// carries no frame
+func ExtractHeader(data []byte) (*Header, error) {
+	return ExtractHeaderWithOptions(data, nil)
+}
+
+// ExtractHeaderWithOptions is ExtractHeader with extraction options (the DCT
+// configuration must match the one used for embedding)
+func ExtractHeaderWithOptions(data []byte, opts *ExtractOptions) (*Header, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	ctx := context.Background()
+	planes, capacityBits, err := extractionPlanes(ctx, data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var header *Header
+	err = findFrame(ctx, planes, opts, func(offset int, scheme ECCScheme) error {
+		var err error
+		header, _, _, err = extractHeaderDCT(ctx, planes, offset, capacityBits, scheme, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// DetectFormat returns the format of an image ("png", "jpeg", "gif", "bmp",
+// "tiff" or "webp") from its header alone, without decoding the pixels
+// Returns ErrUnsupportedFormat if data isn't in a supported format, and
+// ErrCorruptImage if its header is damaged or truncated
+func DetectFormat(data []byte) (string, error) {
+	return imgutil.DetectFormat(data)
+}
+
+// SupportedFormats returns the output formats DCTConfig.OutputFormat accepts
+// ("png", "jpg", "bmp", "tiff", "webp"), e.g. to populate a format picker
+func SupportedFormats() []string {
+	return imgutil.SupportedFormats()
+}
+
+// HasEmbeddedMessage reports whether an image carries a message, by checking
+// for a valid frame header (magic and header CRC) without extracting the
+// payload, which makes it cheap enough to scan many images
+func HasEmbeddedMessage(data []byte) (bool, error) {
+	return HasEmbeddedMessageWithOptions(data, nil)
+}
+
+// HasEmbeddedMessageWithOptions is HasEmbeddedMessage with extraction options
+// (the DCT configuration must match the one used for embedding)
+// A frame written by an unsupported (newer) version returns false with
+// ErrUnsupportedVersion; a corrupted header returns false
+func HasEmbeddedMessageWithOptions(data []byte, opts *ExtractOptions) (bool, error) {
+	_, err := ExtractHeaderWithOptions(data, opts)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, ErrInvalidMagic), errors.Is(err, ErrFrameCorrupt):
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// extractionPlanes loads an image and returns its carrier planes and
+// capacity in bits for extraction
+func extractionPlanes(ctx context.Context, input []byte, opts *ExtractOptions) ([]*ycbcr.Plane, int, error) {
+	if err := opts.Config.validateCoeffs(); err != nil {
+		return nil, 0, err
+	}
+
+	// Load image
+	img, _, err := imgutil.LoadImage(input)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to load image: %w", err)
+	}
+	return imagePlanes(ctx, img, opts)
+}
+
+// imagePlanes returns a decoded image's carrier planes and capacity in bits
+// for extraction
+func imagePlanes(ctx context.Context, img image.Image, opts *ExtractOptions) ([]*ycbcr.Plane, int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	// Convert to YCbCr planes
+	yPlane, cbPlane, crPlane, _ := ycbcr.ImageToYCbCrPlanesWithAlpha(img, opts.Config.ColorSpace)
+	if err := opts.Config.checkImageSize(yPlane.Width, yPlane.Height); err != nil {
+		return nil, 0, err
+	}
+	planes := opts.Config.carrierPlanes(yPlane, cbPlane, crPlane)
+
+	capacityBits := opts.Config.capacityBits(yPlane.Width, yPlane.Height, len(planes))
+	return planes, capacityBits, nil
+}
+
+// findFrame calls try with each place a frame may start (bit offset and ECC
+// scheme) whose frame header is valid, stopping at the first that doesn't
+// fail with errHeaderNotFound
+// The bits covering every candidate header are extracted in a single pass,
+// so an image without a message fails fast, without another pass per scheme
+// Returns try's result, or ErrInvalidMagic (wrapped in ErrFrameCorrupt) if
+// no frame header was found at all
+func findFrame(ctx context.Context, planes []*ycbcr.Plane, opts *ExtractOptions, try func(offset int, scheme ECCScheme) error) error {
+	type candidate struct {
+		offset int
+		scheme ECCScheme
+	}
+	var candidates []candidate
+
+	span, err := headerSpan(preambleBits, supportedSchemes...)
+	if err != nil {
+		return err
+	}
+	soft, err := extractSoftBitsFromDCT(ctx, planes, span, opts.Config, nil, workerCount(opts.Parallelism))
+	if err != nil {
+		return err
+	}
+
+	// The preamble names the ECC scheme the frame was encoded with
+	if scheme, ok := decodePreamble(ecc.HardDecisions(soft[:min(preambleBits, len(soft))])); ok {
+		candidates = append(candidates, candidate{preambleBits, scheme})
+		// A registered scheme may need more bits than the built-in ones
+		more, err := headerSpan(preambleBits, scheme)
+		if err != nil {
+			return err
+		}
+		if more > span {
+			if soft, err = extractSoftBitsFromDCT(ctx, planes, more, opts.Config, nil, workerCount(opts.Parallelism)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Images embedded before the preamble existed start directly with the
+	// frame, so fall back to trying each supported scheme on that layout
+	for _, scheme := range supportedSchemes {
+		candidates = append(candidates, candidate{0, scheme})
+	}
+
+	var lastErr error
+	for _, c := range candidates {
+		_, _, _, err := decodeHeader(soft, c.offset, c.scheme, opts)
+		switch {
+		case errors.Is(err, errHeaderNotFound):
+			lastErr = err
+			continue
+		case err != nil:
+			// The magic matched, but the header is corrupt or unsupported
+			return err
+		}
+		err = try(c.offset, c.scheme)
+		if !errors.Is(err, errHeaderNotFound) {
+			return err
+		}
+		lastErr = err
+	}
+
+	// No scheme recovered the magic, so there's no message to extract
+	return fmt.Errorf("%w: %w (%v)", ErrFrameCorrupt, ErrInvalidMagic, lastErr)
+}
+
+// headerSpan returns the number of bits covering a frame header encoded with
+// the largest of the given schemes, starting offset bits into the block order
+func headerSpan(offset int, schemes ...ECCScheme) (int, error) {
+	span := 0
+	for _, id := range schemes {
+		eccScheme, err := ecc.GetScheme(id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get ECC scheme: %w", err)
+		}
+		headerBits, err := encodedBitLength(eccScheme, framing.HeaderSize)
+		if err != nil {
+			return 0, err
+		}
+		span = max(span, offset+headerBits)
+	}
+	return span, nil
+}
+
+// extractResult reverses the payload transforms of a parsed frame and
+// collects the message with its metadata
+func extractResult(header *framing.Header, payload []byte, opts *ExtractOptions) (*ExtractResult, error) {
+	payload, err := joinSpill(header, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+	payload, authenticated, err := checkHMAC(header, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+	message, err := decodePayload(header, payload, opts)
+	if err != nil {
+		return nil, err
+	}
+	result := &ExtractResult{Message: message, Metadata: header.Metadata, Stream: header.Stream, Authenticated: authenticated}
+	result.OriginalSize = takeOriginalSize(result.Metadata)
+	if len(result.Metadata) == 0 {
+		result.Metadata = nil
+	}
+	return result, nil
+}
+
+// supportedSchemes lists the ECC schemes tried when extracting a frame without a preamble
+var supportedSchemes = []ECCScheme{ECCSchemeRepetition3, ECCSchemeHamming74, ECCSchemeReedSolomon, ECCSchemeRepetition5}
+
+// errHeaderNotFound indicates no valid frame header could be decoded with a scheme
+var errHeaderNotFound = errors.New("frame header not found")
+
+// extractFrameDCT extracts and parses a frame assuming it was encoded with the
+// given scheme and starts offset bits into the block order
+// Returns the frame header and the (still encoded) payload
+// Returns errHeaderNotFound (wrapped) if the header doesn't decode under that scheme
+func extractFrameDCT(ctx context.Context, planes []*ycbcr.Plane, offset, capacityBits int, id ECCScheme, opts *ExtractOptions) (*framing.Header, []byte, error) {
+	frameBytes, err := extractFrameBytesDCT(ctx, planes, offset, capacityBits, id, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return parseFrame(frameBytes, opts)
+}
+
+// parseFrame parses a decoded frame, checking the payload CRC unless
+// opts.SkipCRC is set
+func parseFrame(frameBytes []byte, opts *ExtractOptions) (*framing.Header, []byte, error) {
+	var header *framing.Header
+	var payload []byte
+	var err error
+	if opts.SkipCRC {
+		header, payload, _, err = opts.parser().ParseFrameUnverified(frameBytes)
+	} else {
+		header, payload, err = opts.parser().ParseFrame(frameBytes)
+	}
+	if err != nil {
+		if errors.Is(err, framing.ErrCRCMismatch) {
+			return nil, nil, ErrCRCMismatch
+		}
+		return nil, nil, fmt.Errorf("%w: %w", ErrFrameCorrupt, err)
+	}
+	return header, payload, nil
+}
+
+// extractFrameBytesDCT extracts and ECC-decodes the frame assuming the given
+// scheme and offset as extractFrameDCT, without parsing it
+// Returns errHeaderNotFound (wrapped) if the header doesn't decode under that scheme
+func extractFrameBytesDCT(ctx context.Context, planes []*ycbcr.Plane, offset, capacityBits int, id ECCScheme, opts *ExtractOptions) ([]byte, error) {
+	workers := workerCount(opts.Parallelism)
+
+	// First pass: Extract just enough bits to decode the frame header
+	header, headerSize, eccScheme, err := extractHeaderDCT(ctx, planes, offset, capacityBits, id, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every scheme needs at least 8 bits per byte, so a frame longer than
+	// that can't fit (checked before probing the scheme with a frame that size)
+	totalFrameBytes := headerSize + int(header.PayloadLength)
+	if totalFrameBytes > (capacityBits-offset)/8 {
+		return nil, fmt.Errorf("%w: frame of %d bytes exceeds capacity of %d bits", ErrFrameTruncated, totalFrameBytes, capacityBits)
+	}
+	totalFrameBits, err := encodedBitLength(eccScheme, totalFrameBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	// Second pass: Extract exactly the number of bits needed for the full frame
+	if offset+totalFrameBits > capacityBits {
+		return nil, fmt.Errorf("%w: frame requires %d bits but capacity is only %d", ErrFrameTruncated, offset+totalFrameBits, capacityBits)
+	}
+
+	softBits, err := extractSoftBitsFromDCT(ctx, planes, offset+totalFrameBits, opts.Config, opts.OnProgress, workers)
+	if err != nil {
+		return nil, err
+	}
+	// The header is valid, so its known prefix can be restored as it was
+	// when decoding the header alone
+	primed, err := withHeaderPrior(eccScheme, id, softBits[offset:])
+	if err != nil {
+		return nil, err
+	}
+	frameBytes, err := ecc.DecodeSoft(eccScheme, primed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ECC decode full frame: %w", err)
+	}
+	return frameBytes, nil
+}
+
+// extractHeaderDCT extracts and validates (magic, version, header CRC) just
+// the frame header, assuming the given scheme and offset as extractFrameDCT
+// Returns the header, its size in bytes and the ECC scheme implementation
+// Returns errHeaderNotFound (wrapped) if the header doesn't decode under that scheme
+func extractHeaderDCT(ctx context.Context, planes []*ycbcr.Plane, offset, capacityBits int, id ECCScheme, opts *ExtractOptions) (*framing.Header, int, ecc.Scheme, error) {
+	eccScheme, err := ecc.GetScheme(id)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to get ECC scheme: %w", err)
+	}
+
+	headerBits, err := encodedBitLength(eccScheme, framing.HeaderSize)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if offset+headerBits > capacityBits {
+		return nil, 0, nil, fmt.Errorf("%w: insufficient data for frame header", errHeaderNotFound)
+	}
+
+	softBits, err := extractSoftBitsFromDCT(ctx, planes, offset+headerBits, opts.Config, nil, workerCount(opts.Parallelism))
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return decodeHeader(softBits, offset, id, opts)
+}
+
+// decodeHeader decodes and validates the frame header encoded with the given
+// scheme offset bits into soft, as extractHeaderDCT, under opts' reserved
+// bits policy
+func decodeHeader(soft []float64, offset int, id ECCScheme, opts *ExtractOptions) (*framing.Header, int, ecc.Scheme, error) {
+	eccScheme, err := ecc.GetScheme(id)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("failed to get ECC scheme: %w", err)
+	}
+	headerBits, err := encodedBitLength(eccScheme, framing.HeaderSize)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if offset+headerBits > len(soft) {
+		return nil, 0, nil, fmt.Errorf("%w: insufficient data for frame header", errHeaderNotFound)
+	}
+
+	frameBytes, err := ecc.DecodeSoft(eccScheme, soft[offset:offset+headerBits])
+	if err != nil {
+		// Block codes fail outright on bits that weren't encoded with them
+		return nil, 0, nil, fmt.Errorf("%w: failed to ECC decode header: %v", errHeaderNotFound, err)
+	}
+	if len(frameBytes) < framing.HeaderSize {
+		return nil, 0, nil, fmt.Errorf("%w: insufficient data for frame header", errHeaderNotFound)
+	}
+
+	// Validate the header (magic, version, header CRC) before trusting the payload length
+	header, headerSize, err := opts.parser().ParseHeader(frameBytes)
+	if err != nil || header.ECCScheme != uint8(id) {
+		// A few wrong bits in the magic, version or scheme can be restored
+		// from their known values
+		if repaired, ok := repairHeader(eccScheme, id, soft[offset:offset+headerBits], frameBytes, opts); ok {
+			header, headerSize, err = repaired, framing.HeaderSize, nil
+		}
+	}
+	switch {
+	case errors.Is(err, framing.ErrInvalidMagic):
+		return nil, 0, nil, fmt.Errorf("%w: %v", errHeaderNotFound, err)
+	case errors.Is(err, framing.ErrUnsupportedVersion):
+		// The magic matched, so this is a frame, just not one this version can read
+		return nil, 0, nil, fmt.Errorf("%w: version %d", ErrUnsupportedVersion, header.Version)
+	case errors.Is(err, framing.ErrReservedNonZero):
+		return nil, 0, nil, err
+	case err != nil:
+		return nil, 0, nil, fmt.Errorf("%w: %w", ErrFrameCorrupt, err)
+	}
+	if header.ECCScheme != uint8(id) {
+		return nil, 0, nil, fmt.Errorf("%w: %v", errHeaderNotFound, framing.ErrInvalidMagic)
+	}
+
+	return header, headerSize, eccScheme, nil
+}
+
+// encodedBitLength returns the number of bits the scheme produces when encoding n frame bytes
+func encodedBitLength(scheme ecc.Scheme, n int) (int, error) {
+	count, err := ecc.EncodedBits(scheme, n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode test frame: %w", err)
+	}
+	return count, nil
+}
+
+// GetCapacityInfoFromData calculates capacity from image data in memory
+func GetCapacityInfoFromData(data []byte, eccScheme ECCScheme) (*CapacityInfo, error) {
+	config := DefaultDCTConfig()
+	config.ECC = eccScheme
+	return GetCapacityInfoForConfig(data, config)
+}
+
+// GetCapacityInfoForConfig calculates capacity from image data in memory for
+// the given DCT configuration (ECC scheme and carrier channels)
+func GetCapacityInfoForConfig(data []byte, config DCTConfig) (*CapacityInfo, error) {
+	img, _, err := imgutil.LoadImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+
+	// Convert to YCbCr to get dimensions
+	yPlane, cbPlane, crPlane, _ := ycbcr.ImageToYCbCrPlanesWithAlpha(img, config.ColorSpace)
+
+	// Calculate capacity, over the padded image if padding is enabled
+	width, height := config.paddedSize(yPlane.Width, yPlane.Height)
+	padding := framing.MetadataSize(config.paddingMetadata(yPlane.Width, yPlane.Height))
+	yPlane, cbPlane, crPlane = yPlane.Pad(width, height), cbPlane.Pad(width, height), crPlane.Pad(width, height)
+	n := config.blockSize()
+	blocksAcross := width / n
+	blocksDown := height / n
+	channels := config.channelCount()
+	// One bit per block per channel, even when CoeffPairs writes it into several pairs
+	capacityBits := config.capacityBits(width, height, channels)
+	region := config.blockRect(blocksAcross, blocksDown)
+	if !config.UseAllBlocks {
+		// Only blocks with enough texture carry bits
+		planes := config.carrierPlanes(yPlane, cbPlane, crPlane)
+		order, err := blockOrder(len(planes), blocksAcross, blocksDown, config)
+		if err != nil {
+			return nil, err
+		}
+		capacityBits, err = usableBlockCount(context.Background(), planes, order, config, workerCount(0))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Get ECC scheme to determine expansion factor
+	ecc, err := ecc.GetScheme(config.ECC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ECC scheme: %w", err)
+	}
+
+	// Calculate maximum payload: the largest frame whose encoding fits after
+	// the preamble, minus the header
+	frameBytes, err := maxFrameBytes(ecc, capacityBits-preambleBits)
+	if err != nil {
+		return nil, err
+	}
+	overhead := framing.HeaderSize + padding
+	maxPayloadBytes := frameBytes - overhead
+	if maxPayloadBytes < 0 {
+		maxPayloadBytes = 0
+	}
+
+	info := &CapacityInfo{
+		Width:               img.Bounds().Dx(),
+		Height:              img.Bounds().Dy(),
+		BlocksAcross:        region.Dx(),
+		BlocksDown:          region.Dy(),
+		Channels:            channels,
+		RawCapacityBits:     capacityBits,
+		CapacityBits:        capacityBits,
+		EncodedCapacityBits: frameBytes * 8,
+		OverheadBytes:       overhead,
+	}
+	info.setMaxPayloadBytes(maxPayloadBytes)
+	return info, nil
+}
+
+// maxFrameBytes returns the largest frame size whose encoding fits in bits
+// Asking the scheme for each size keeps this exact for codes that don't
+// expand by a whole factor (Hamming(7,4)) or pad to fixed codewords
+// (Reed-Solomon)
+func maxFrameBytes(scheme ecc.Scheme, bits int) (int, error) {
+	// Encoded length only grows with frame size, and every code needs at
+	// least 8 bits per byte, so binary search [0, bits/8]
+	lo, hi := 0, max(bits/8, 0)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		n, err := encodedBitLength(scheme, mid)
+		if err != nil {
+			return 0, err
+		}
+		if n <= bits {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return lo, nil
+}
+
+// PlanEmbedding reports how much fits in the image with the given options,
+// without embedding anything
+// MaxPayloadBytes accounts for the ECC scheme, preamble, frame header,
+// metadata and encryption overhead. With compression enabled it bounds the compressed
+// size instead, so use FitsMessage to check a specific message
+func PlanEmbedding(data []byte, opts *EmbedOptions) (*CapacityInfo, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+
+	info, err := GetCapacityInfoForConfig(data, opts.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The original size entry of a padded image is already accounted for
+	overhead := framing.MetadataSize(opts.frameMetadata(info.Width, info.Height)) -
+		framing.MetadataSize(opts.Config.paddingMetadata(info.Width, info.Height))
+	if opts.Password != "" {
+		overhead += encryption.Overhead
+	}
+	if len(opts.HMACKey) > 0 {
+		overhead += hmacSize
+	}
+	if overhead > 0 {
+		info.OverheadBytes += overhead
+		info.setMaxPayloadBytes(max(info.MaxPayloadBytes-overhead, 0))
+	}
+
+	return info, nil
+}
+
+// FitsMessage reports whether message would fit in the image with the given
+// options, applying compression and encryption as embedding would
+func FitsMessage(data []byte, message []byte, opts *EmbedOptions) (bool, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+
+	info, err := GetCapacityInfoForConfig(data, opts.Config)
+	if err != nil {
+		return false, err
+	}
+
+	payload, _, err := encodePayload(message, opts)
+	if err != nil {
+		return false, err
+	}
+	payloadSize := len(payload)
+	if len(opts.HMACKey) > 0 {
+		payloadSize += hmacSize
+	}
+
+	eccScheme, err := ecc.GetScheme(opts.Config.ECC)
+	if err != nil {
+		return false, fmt.Errorf("failed to get ECC scheme: %w", err)
+	}
+	metadata := opts.frameMetadata(info.Width, info.Height)
+	frameBits, err := encodedBitLength(eccScheme, framing.HeaderSize+framing.MetadataSize(metadata)+payloadSize)
+	if err != nil {
+		return false, err
+	}
+
+	return preambleBits+frameBits <= info.RawCapacityBits, nil
+}
+
+// GetCapacityInfo calculates capacity from an image file
+func GetCapacityInfo(inputPath string, eccScheme ECCScheme) (*CapacityInfo, error) {
+	// Load image
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return GetCapacityInfoFromData(data, eccScheme)
+}
+
+// embedBitsIntoDCT embeds bits into DCT coefficients of the carrier planes
+// Bits are assigned to blocks in the order given by blockOrder; blocks are
+// split among up to workers goroutines (see workerCount)
+// Each worker checks ctx once per block row's worth of blocks and returns
+// ctx.Err() if cancelled, leaving the planes partially modified
+func embedBitsIntoDCT(ctx context.Context, planes []*ycbcr.Plane, bits []bool, config DCTConfig, workers int) error {
+	return embedBitsIntoDCTQuantized(ctx, planes, bitstream.NewSliceIterator(bits), len(bits), config, nil, nil, workers)
+}
+
+// embedBatchBits is the most encoded bits embedBitsIntoDCTQuantized pulls
+// from its iterator and holds in memory at once
+const embedBatchBits = 1 << 16
+
+// embedBitsIntoDCTQuantized is embedBitsIntoDCT, but takes the count bits
+// from an iterator, pulling them in batches of embedBatchBits so the full
+// encoded stream is never materialized. If quant is non-nil the carrier
+// coefficients are set to multiples of their quantization steps (see
+// quantizedPair), so JPEG quantization with that table leaves them unchanged
+func embedBitsIntoDCTQuantized(ctx context.Context, planes []*ycbcr.Plane, bits bitstream.Iterator, count int, config DCTConfig, quant *[64]float64, onProgress ProgressFunc, workers int) error {
+	n := config.blockSize()
+	blocksAcross := planes[0].Width / n
+	blocksDown := planes[0].Height / n
+	order, err := blockOrder(len(planes), blocksAcross, blocksDown, config)
+	if err != nil {
+		return err
+	}
+	if !config.UseAllBlocks {
+		order, err = selectEmbedBlocks(ctx, planes, order, count, config, workers)
+		if err != nil {
+			return err
+		}
+	}
+	if count > len(order) {
+		return ErrMessageTooLong
+	}
+	pattern, err := config.spreadPattern()
+	if err != nil {
+		return err
+	}
+	tracker := newProgress(onProgress, count)
+
+	batch := make([]bool, min(count, embedBatchBits))
+	for base := 0; base < count; base += len(batch) {
+		size := min(len(batch), count-base)
+		for i := range size {
+			bit, ok := bits.NextBit()
+			if !ok {
+				return fmt.Errorf("bit stream ended after %d of %d bits", base+i, count)
+			}
+			batch[i] = bit
+		}
+
+		// Each worker owns a contiguous range of bit indices, and so a disjoint
+		// set of blocks, which keeps the output identical to the serial path
+		forEachChunk(size, workers, func(lo, hi int) {
+			embedBatch(ctx, planes, batch[lo:hi], order[base+lo:base+hi], config, quant, pattern, tracker)
+		})
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// embedBatch embeds bits into the blocks of order, one bit per block, into
+// the carrier pairs or, if pattern is non-nil, spread across its chips
+// Progress is reported to tracker once per block row's worth of blocks
+// Returns early, leaving the rest unmodified, if ctx is cancelled
+func embedBatch(ctx context.Context, planes []*ycbcr.Plane, bits []bool, order []blockRef, config DCTConfig, quant *[64]float64, pattern []spreadChip, tracker *progress) {
+	n := config.blockSize()
+	blocksAcross := planes[0].Width / n
+	pairs := config.carrierPairs()
+	requiredGap := config.MinGap + config.Delta
+	mask := config.carrierMask()
+	block := make([]float64, n*n)
+	dctBlock := make([]float64, n*n)
+	reported := 0
+
+	for bitIdx, bit := range bits {
+		if bitIdx%blocksAcross == 0 && ctx.Err() != nil {
+			return
+		}
+
+		ref := order[bitIdx]
+		plane := planes[ref.plane]
+
+		// Extract the block and apply DCT
+		loadBlock(plane, n, ref.bx, ref.by, block)
+		dct.DCTNxN(n, block, dctBlock)
+
+		gap := requiredGap
+		if config.AdaptiveDelta {
+			gap = config.MinGap + config.adaptiveDelta(acEnergy(dctBlock, mask), n)
+		}
+
+		if pattern != nil {
+			embedSpread(dctBlock, pattern, gap, bit)
+		} else {
+			embedPairs(dctBlock, pairs, quant, gap, bit)
+		}
+
+		// Apply inverse DCT and write back
+		dct.IDCTNxN(n, dctBlock, block)
+		storeBlock(plane, n, ref.bx, ref.by, block)
+
+		if bitIdx+1-reported == blocksAcross || bitIdx == len(bits)-1 {
+			tracker.add(bitIdx + 1 - reported)
+			reported = bitIdx + 1
+		}
+	}
+}
+
+// embedPairs encodes bit in every carrier pair of dctBlock, moving each
+// pair symmetrically about its midpoint until they're gap apart (or onto the
+// quantization grid, if quant is non-nil)
+func embedPairs(dctBlock []float64, pairs [][2]int, quant *[64]float64, gap float64, bit bool) {
+	for _, pair := range pairs {
+		idxA, idxB := pair[0], pair[1]
+		if quant != nil {
+			dctBlock[idxA], dctBlock[idxB] = quantizedPair(dctBlock[idxA], dctBlock[idxB], quant[idxA], quant[idxB], gap, bit)
+			continue
+		}
+
+		// Adjust coefficients symmetrically to encode bit
+		// Only modify the carrier pairs, no other coefficients
+		// Always enforce the relationship to ensure reliable extraction
+		midpoint := (dctBlock[idxA] + dctBlock[idxB]) / 2.0
+
+		if bit {
+			// Encode 1: ensure A > B by at least MinGap
+			dctBlock[idxA] = midpoint + gap/2.0
+			dctBlock[idxB] = midpoint - gap/2.0
+		} else {
+			// Encode 0: ensure A < B by at least MinGap
+			dctBlock[idxA] = midpoint - gap/2.0
+			dctBlock[idxB] = midpoint + gap/2.0
+		}
+	}
+}
+
+// quantizedPair returns new values for a carrier pair (a, b) encoding bit,
+// each a multiple of its quantization step (qa, qb), at least gap apart and
+// as close as possible to the original midpoint
+func quantizedPair(a, b, qa, qb, gap float64, bit bool) (float64, float64) {
+	midpoint := (a + b) / 2.0
+	half := gap / 2.0
+	if !bit {
+		half = -half
+	}
+
+	// Round away from the midpoint so the gap is never below the requirement
+	newA := roundAway(midpoint+half, midpoint, qa)
+	newB := roundAway(midpoint-half, midpoint, qb)
+	// Coarse steps can still land both values on the same side when the
+	// midpoint is near a grid line; push the offending one a step further
+	for (newA > newB) != bit || newA == newB {
+		if bit {
+			newA += qa
+		} else {
+			newB += qb
+		}
+	}
+	return newA, newB
+}
+
+// roundAway rounds v to a multiple of step, away from ref
+func roundAway(v, ref, step float64) float64 {
+	if v >= ref {
+		return math.Ceil(v/step) * step
+	}
+	return math.Floor(v/step) * step
+}
+
+// extractBitsFromDCT extracts bits from DCT coefficients of the carrier planes
+// Blocks are visited in the same order embedBitsIntoDCT assigns bits to them
+// Returns ctx.Err() if ctx is cancelled (checked once per block row)
+func extractBitsFromDCT(ctx context.Context, planes []*ycbcr.Plane, maxBits int, config DCTConfig, workers int) ([]bool, error) {
+	soft, err := extractSoftBitsFromDCT(ctx, planes, maxBits, config, nil, workers)
+	if err != nil {
+		return nil, err
+	}
+	return ecc.HardDecisions(soft), nil
+}
+
+// extractPassHook, if set, is called with maxBits on every pass of
+// extractSoftBitsFromDCT over the blocks, so tests can count passes
+var extractPassHook func(maxBits int)
+
+// extractSoftBitsFromDCT extracts soft bits from DCT coefficients of the
+// carrier planes: the signed coefficient gap of each block (see softBit),
+// positive for 1, with near-zero values marking ambiguous bits
+func extractSoftBitsFromDCT(ctx context.Context, planes []*ycbcr.Plane, maxBits int, config DCTConfig, onProgress ProgressFunc, workers int) ([]float64, error) {
+	if extractPassHook != nil {
+		extractPassHook(maxBits)
+	}
+	n := config.blockSize()
+	blocksAcross := planes[0].Width / n
+	blocksDown := planes[0].Height / n
+	order, err := blockOrder(len(planes), blocksAcross, blocksDown, config)
+	if err != nil {
+		return nil, err
+	}
+	if !config.UseAllBlocks {
+		order, err = selectExtractBlocks(ctx, planes, order, config, workers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if maxBits > len(order) {
+		maxBits = len(order)
+	}
+	pairs := config.carrierPairs()
+	pattern, err := config.spreadPattern()
+	if err != nil {
+		return nil, err
+	}
+	soft := make([]float64, maxBits)
+	tracker := newProgress(onProgress, maxBits)
+
+	forEachChunk(maxBits, workers, func(lo, hi int) {
+		block := make([]float64, n*n)
+		dctBlock := make([]float64, n*n)
+		reported := lo
+
+		for bitIdx := lo; bitIdx < hi; bitIdx++ {
+			if (bitIdx-lo)%blocksAcross == 0 && ctx.Err() != nil {
+				return
+			}
+
+			ref := order[bitIdx]
+
+			// Extract the block and apply DCT
+			loadBlock(planes[ref.plane], n, ref.bx, ref.by, block)
+			dct.DCTNxN(n, block, dctBlock)
+
+			// Measure the coefficient gap, combined across pairs, or the
+			// correlation with the spread-spectrum pattern
+			if pattern != nil {
+				soft[bitIdx] = spreadSoftBit(dctBlock, pattern)
+			} else {
+				soft[bitIdx] = softBit(dctBlock, pairs)
+			}
+
+			if bitIdx+1-reported == blocksAcross || bitIdx == hi-1 {
+				tracker.add(bitIdx + 1 - reported)
+				reported = bitIdx + 1
+			}
+		}
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return soft, nil
+}
+
+// loadBlock copies the n x n block at block coordinates (bx, by) out of the
+// plane, centering values (subtract 128) for the DCT
+func loadBlock(plane *ycbcr.Plane, n, bx, by int, block []float64) {
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			srcY := by*n + y
+			srcX := bx*n + x
+			block[y*n+x] = plane.Pix[srcY*plane.Stride+srcX] - 128.0
+		}
+	}
+}
+
+// storeBlock writes a centered n x n block back into the plane at (bx, by),
+// adding 128 back and clamping to [0, 255]
+// Values stay float64 to preserve precision; rounding happens in YCbCr->RGB conversion
+func storeBlock(plane *ycbcr.Plane, n, bx, by int, block []float64) {
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			srcY := by*n + y
+			srcX := bx*n + x
+			val := block[y*n+x] + 128.0
+			if val < 0 {
+				val = 0
+			}
+			if val > 255 {
+				val = 255
+			}
+			plane.Pix[srcY*plane.Stride+srcX] = val
+		}
+	}
+}
+
+// softBit returns a block's bit as a signed gap (positive means 1): the
+// median gap over its carrier pairs, so the sign follows the majority vote
+// Ties (possible with an even pair count) are broken by the mean gap
+func softBit(dctBlock []float64, pairs [][2]int) float64 {
+	if len(pairs) == 1 {
+		return dctBlock[pairs[0][0]] - dctBlock[pairs[0][1]]
+	}
+
+	gaps := make([]float64, len(pairs))
+	ones := 0
+	gapSum := 0.0
+	for i, pair := range pairs {
+		gaps[i] = dctBlock[pair[0]] - dctBlock[pair[1]]
+		if gaps[i] > 0 {
+			ones++
+		}
+		gapSum += gaps[i]
+	}
+	if ones*2 == len(pairs) {
+		return gapSum / float64(len(pairs))
+	}
+
+	sort.Float64s(gaps)
+	mid := len(gaps) / 2
+	if len(gaps)%2 == 1 {
+		return gaps[mid]
+	}
+	return (gaps[mid-1] + gaps[mid]) / 2
 }
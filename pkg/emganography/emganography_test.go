@@ -2,14 +2,37 @@ package emganography
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"image"
 	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"image/draw"
+	"image/jpeg"
 	"image/png"
+	"io"
+	"maps"
+	"math"
+	"math/rand/v2"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+	"unicode/utf8"
 
+	"github.com/tuomas-lb/emganography/internal/bitstream"
 	"github.com/tuomas-lb/emganography/internal/dct"
+	"github.com/tuomas-lb/emganography/internal/ecc"
+	"github.com/tuomas-lb/emganography/internal/encryption"
+	"github.com/tuomas-lb/emganography/internal/framing"
 	"github.com/tuomas-lb/emganography/internal/imgutil"
 	"github.com/tuomas-lb/emganography/internal/ycbcr"
 )
@@ -413,3 +436,4714 @@ func TestRoundTripWithoutEmbedding(t *testing.T) {
 	}
 }
 
+
+func TestEmbedExtractDCT_Hamming74(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	message := []byte("hamming coded message")
+	opts := DefaultEmbedOptions()
+	opts.Config.ECC = ECCSchemeHamming74
+
+	outputData, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	// Extraction should detect the scheme from the frame header
+	extracted, err := ExtractMessageDCT(outputData)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_ReedSolomon(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	message := []byte("reed-solomon coded message")
+	opts := DefaultEmbedOptions()
+	opts.Config.ECC = ECCSchemeReedSolomon
+
+	outputData, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCT(outputData)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+// fillRegion decodes a PNG, paints a rectangle with a flat color and re-encodes it
+func fillRegion(t *testing.T, data []byte, region image.Rectangle) []byte {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to decode image: %v", err)
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			if (image.Point{X: x, Y: y}).In(region) {
+				rgba.Set(x, y, color.RGBA{R: 128, G: 128, B: 128, A: 255})
+			} else {
+				rgba.Set(x, y, img.At(x, y))
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, rgba); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEmbedExtractDCT_InterleaveSurvivesRegionDamage(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	message := []byte("interleaved")
+	// Block rows 1-3, columns 4-27: inside the first interleave stripe
+	damage := image.Rect(4*8, 1*8, 28*8, 4*8)
+
+	tests := []struct {
+		name       string
+		interleave bool
+		wantOK     bool
+	}{
+		{name: "raster order", interleave: false, wantOK: false},
+		{name: "interleaved", interleave: true, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultEmbedOptions()
+			opts.Config.Interleave = tt.interleave
+
+			outputData, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+			if err != nil {
+				t.Fatalf("EmbedMessageDCT failed: %v", err)
+			}
+			damaged := fillRegion(t, outputData, damage)
+
+			extracted, err := ExtractMessageDCTWithOptions(damaged, &ExtractOptions{Config: opts.Config})
+			ok := err == nil && bytes.Equal(message, extracted)
+			if ok != tt.wantOK {
+				t.Errorf("expected recovery %v, got %v (err: %v)", tt.wantOK, ok, err)
+			}
+		})
+	}
+}
+
+func TestBlockOrder_InterleaveIsPermutation(t *testing.T) {
+	config := DefaultDCTConfig()
+	config.Interleave = true
+	config.InterleaveDepth = 5
+
+	// 13x7 blocks doesn't divide evenly into 5 stripes
+	order, err := blockOrder(1, 13, 7, config)
+	if err != nil {
+		t.Fatalf("blockOrder failed: %v", err)
+	}
+	if len(order) != 13*7 {
+		t.Fatalf("expected %d blocks, got %d", 13*7, len(order))
+	}
+	seen := make(map[blockRef]bool)
+	for _, ref := range order {
+		if ref.plane != 0 || ref.bx < 0 || ref.bx >= 13 || ref.by < 0 || ref.by >= 7 || seen[ref] {
+			t.Fatalf("order is not a permutation: %v", order)
+		}
+		seen[ref] = true
+	}
+	if order[1].by == order[0].by {
+		t.Errorf("expected consecutive bits to be at least a block row apart, got %v and %v", order[0], order[1])
+	}
+}
+
+func TestEmbedExtractDCT_CoefficientPairs(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("coefficient pair")
+
+	pairs := []struct {
+		name string
+		a, b [2]int
+	}{
+		{name: "(1,2)/(2,1)", a: [2]int{1, 2}, b: [2]int{2, 1}},
+		{name: "(3,1)/(1,3)", a: [2]int{3, 1}, b: [2]int{1, 3}},
+		{name: "(4,4)/(3,5)", a: [2]int{4, 4}, b: [2]int{3, 5}},
+	}
+
+	for _, tt := range pairs {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultEmbedOptions()
+			opts.Config.CoeffA = tt.a
+			opts.Config.CoeffB = tt.b
+
+			outputData, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+			if err != nil {
+				t.Fatalf("EmbedMessageDCT failed: %v", err)
+			}
+
+			extracted, err := ExtractMessageDCTWithOptions(outputData, &ExtractOptions{Config: opts.Config})
+			if err != nil {
+				t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+			}
+			if !bytes.Equal(message, extracted) {
+				t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+			}
+		})
+	}
+}
+
+func TestEmbedDCT_InvalidCoefficientPair(t *testing.T) {
+	img := createTestImage(64, 64)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		a, b [2]int
+	}{
+		{name: "out of range", a: [2]int{8, 2}, b: [2]int{2, 3}},
+		{name: "negative", a: [2]int{2, 2}, b: [2]int{-1, 3}},
+		{name: "DC coefficient", a: [2]int{0, 0}, b: [2]int{2, 3}},
+		{name: "same position", a: [2]int{2, 2}, b: [2]int{2, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultEmbedOptions()
+			opts.Config.CoeffA = tt.a
+			opts.Config.CoeffB = tt.b
+
+			_, err := EmbedMessageDCT(buf.Bytes(), []byte("x"), opts)
+			if !errors.Is(err, ErrInvalidCoefficient) {
+				t.Errorf("expected ErrInvalidCoefficient, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCoeffPairs_MajorityVoteRecoversPerturbedPair(t *testing.T) {
+	img := createTestImage(64, 64)
+	yPlane, _, _ := ycbcr.ImageToYCbCrPlanes(img)
+
+	config := DefaultDCTConfig()
+	config.CoeffPairs = [][2][2]int{
+		{{2, 2}, {2, 3}},
+		{{1, 3}, {3, 1}},
+		{{3, 2}, {2, 4}},
+	}
+
+	bits := []bool{true, false, true, true, false, false, true, false}
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+
+	// Invert the first pair's relationship in every carrier block
+	var block, dctBlock [64]float64
+	for i := range bits {
+		bx, by := i%8, i/8
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				block[y*8+x] = yPlane.Pix[(by*8+y)*yPlane.Stride+bx*8+x] - 128.0
+			}
+		}
+		dct.DCT8x8(&block, &dctBlock)
+		dctBlock[2*8+2], dctBlock[2*8+3] = dctBlock[2*8+3], dctBlock[2*8+2]
+		dct.IDCT8x8(&dctBlock, &block)
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				yPlane.Pix[(by*8+y)*yPlane.Stride+bx*8+x] = block[y*8+x] + 128.0
+			}
+		}
+	}
+
+	single := DefaultDCTConfig()
+	if got, _ := extractBitsFromDCT(context.Background(), []*ycbcr.Plane{yPlane}, len(bits), single, 1); reflect.DeepEqual(got, bits) {
+		t.Fatalf("expected the perturbed pair alone to decode incorrectly")
+	}
+
+	got, err := extractBitsFromDCT(context.Background(), []*ycbcr.Plane{yPlane}, len(bits), config, 1)
+	if err != nil {
+		t.Fatalf("extractBitsFromDCT failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, bits) {
+		t.Errorf("majority vote failed: expected %v, got %v", bits, got)
+	}
+}
+
+func TestEmbedExtractDCT_CoeffPairsRoundTrip(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	opts := DefaultEmbedOptions()
+	opts.Config.CoeffPairs = [][2][2]int{
+		{{2, 2}, {2, 3}},
+		{{1, 3}, {3, 1}},
+		{{3, 2}, {2, 4}},
+	}
+	message := []byte("voted message")
+
+	outputData, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	extracted, err := ExtractMessageDCTWithOptions(outputData, &ExtractOptions{Config: opts.Config})
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedDCT_ParallelMatchesSerial(t *testing.T) {
+	img := createTestImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("parallel and serial embedding must agree byte for byte")
+
+	serialOpts := DefaultEmbedOptions()
+	serialOpts.Parallelism = 1
+	serial, err := EmbedMessageDCT(buf.Bytes(), message, serialOpts)
+	if err != nil {
+		t.Fatalf("serial EmbedMessageDCT failed: %v", err)
+	}
+
+	for _, parallelism := range []int{0, 3, 8} {
+		opts := DefaultEmbedOptions()
+		opts.Parallelism = parallelism
+		parallel, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+		if err != nil {
+			t.Fatalf("parallel EmbedMessageDCT failed: %v", err)
+		}
+		if !bytes.Equal(serial, parallel) {
+			t.Errorf("parallelism %d: output differs from serial embedding", parallelism)
+		}
+
+		extracted, err := ExtractMessageDCTWithOptions(parallel, &ExtractOptions{
+			Config:      opts.Config,
+			Parallelism: parallelism,
+		})
+		if err != nil {
+			t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+		}
+		if !bytes.Equal(message, extracted) {
+			t.Errorf("parallelism %d: message mismatch: got %q", parallelism, extracted)
+		}
+	}
+}
+
+func TestEmbedExtractDCT_Password(t *testing.T) {
+	img := createTestImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	message := []byte("for your eyes only")
+	opts := DefaultEmbedOptions()
+	opts.Password = "hunter2"
+
+	outputData, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCTWithPassword(outputData, "hunter2")
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithPassword failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	if _, err := ExtractMessageDCTWithPassword(outputData, "wrong"); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("expected ErrDecryptionFailed for wrong password, got %v", err)
+	}
+
+	if _, err := ExtractMessageDCT(outputData); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("expected ErrPasswordRequired without password, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_CompressionEnablesLargerMessage(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// ~26 payload bytes fit uncompressed; this is far larger but highly compressible
+	message := bytes.Repeat([]byte("compress me! "), 20)
+
+	opts := DefaultEmbedOptions()
+	if _, err := EmbedMessageDCT(buf.Bytes(), message, opts); err != ErrMessageTooLong {
+		t.Fatalf("expected ErrMessageTooLong without compression, got %v", err)
+	}
+
+	opts.Config.Compression = CompressionFlate
+	outputData, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT with compression failed: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCT(outputData)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_BMP(t *testing.T) {
+	img := createTestImage(256, 256)
+	inputData, err := imgutil.EncodeImage(img, "bmp", 0)
+	if err != nil {
+		t.Fatalf("failed to encode BMP: %v", err)
+	}
+
+	message := []byte("bitmap message")
+	outputData, err := EmbedMessageDCT(inputData, message, DefaultEmbedOptions())
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	if string(outputData[0:2]) != "BM" {
+		t.Errorf("expected BMP output to preserve the input format")
+	}
+
+	extracted, err := ExtractMessageDCT(outputData)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_TIFF(t *testing.T) {
+	img := createTestImage(256, 256)
+	inputData, err := imgutil.EncodeImage(img, "tiff", 0)
+	if err != nil {
+		t.Fatalf("failed to encode TIFF: %v", err)
+	}
+
+	message := []byte("archival message")
+	outputData, err := EmbedMessageDCT(inputData, message, DefaultEmbedOptions())
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	if _, format, err := imgutil.LoadImage(outputData); err != nil || format != "tiff" {
+		t.Errorf("expected TIFF output to preserve the input format, got %q (%v)", format, err)
+	}
+
+	extracted, err := ExtractMessageDCT(outputData)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_WebP(t *testing.T) {
+	inputData, err := imgutil.EncodeImage(createTestImage(256, 256), "png", 0)
+	if err != nil {
+		t.Fatalf("failed to encode PNG: %v", err)
+	}
+
+	// WebP output is always lossless, so the DCT embedding survives it
+	message := []byte("webp message")
+	outputData, err := EmbedMessage(inputData, message, WithOutputFormat("webp"))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+	if _, format, err := imgutil.LoadImage(outputData); err != nil || format != "webp" {
+		t.Fatalf("expected WebP output, got %q (%v)", format, err)
+	}
+	extracted, err := ExtractMessageDCT(outputData)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	// WebP input keeps its format, and LSB embedding accepts it as lossless
+	again, err := EmbedMessageDCT(outputData, []byte("second"), DefaultEmbedOptions())
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT(webp) failed: %v", err)
+	}
+	if _, format, err := imgutil.LoadImage(again); err != nil || format != "webp" {
+		t.Errorf("expected WebP output to preserve the input format, got %q (%v)", format, err)
+	}
+	lsb, err := EmbedMessageLSB(outputData, message, DefaultEmbedOptions())
+	if err != nil {
+		t.Fatalf("EmbedMessageLSB(webp) failed: %v", err)
+	}
+	extracted, err = ExtractMessageLSB(lsb, nil)
+	if err != nil {
+		t.Fatalf("ExtractMessageLSB failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("LSB message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_ChromaChannels(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// 1024 blocks per plane: with repetition-3 the Y plane alone holds
+	// 42 frame bytes, so this message must spill from Y into Cb
+	message := bytes.Repeat([]byte("chroma"), 8)
+
+	yOnly := DefaultEmbedOptions()
+	if _, err := EmbedMessageDCT(buf.Bytes(), message, yOnly); !errors.Is(err, ErrMessageTooLong) {
+		t.Fatalf("expected ErrMessageTooLong with Y only, got %v", err)
+	}
+
+	opts := DefaultEmbedOptions()
+	opts.Config.Channels = ChannelY | ChannelCb
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCTWithOptions(embedded, &ExtractOptions{Config: opts.Config})
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	info, err := GetCapacityInfoForConfig(buf.Bytes(), opts.Config)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoForConfig failed: %v", err)
+	}
+	if info.Channels != 2 || info.CapacityBits != 2*32*32 {
+		t.Errorf("expected 2 channels and %d bits, got %d and %d", 2*32*32, info.Channels, info.CapacityBits)
+	}
+}
+
+func TestExtractMessageDCT_NoMessage(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	_, err := ExtractMessageDCT(buf.Bytes())
+	if !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic, got %v", err)
+	}
+	if errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("did not expect ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestExtractMessageDCT_FutureVersion(t *testing.T) {
+	img := createTestImage(256, 256)
+	config := DefaultDCTConfig()
+
+	frame, err := framing.BuildFrame([]byte("from the future"), uint8(config.ECC))
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	frame[4] = 0xFF
+
+	scheme, err := ecc.GetScheme(config.ECC)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	bits, err := scheme.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(img)
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, ycbcr.YCbCrPlanesToImage(yPlane, cbPlane, crPlane)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	_, err = ExtractMessageDCT(buf.Bytes())
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+	if errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("did not expect ErrInvalidMagic, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_Stream(t *testing.T) {
+	img := createTestImage(512, 512)
+	var input bytes.Buffer
+	if err := png.Encode(&input, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("streamed through buffers")
+
+	opts := DefaultEmbedOptions()
+	opts.Password = "pipe"
+	var output bytes.Buffer
+	if err := EmbedMessageDCTStream(&input, &output, message, opts); err != nil {
+		t.Fatalf("EmbedMessageDCTStream failed: %v", err)
+	}
+
+	// Plain stream extraction can't decrypt, which shows the options were applied
+	if _, err := ExtractMessageDCTStream(bytes.NewReader(output.Bytes())); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("expected ErrPasswordRequired, got %v", err)
+	}
+
+	extractOpts := DefaultExtractOptions()
+	extractOpts.Password = "pipe"
+	extracted, err := ExtractMessageDCTStreamWithOptions(&output, extractOpts)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTStreamWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+// cancelAfterContext reports cancellation once Err has been called n times,
+// so tests can cancel deterministically in the middle of an operation
+type cancelAfterContext struct {
+	context.Context
+	remaining atomic.Int32
+}
+
+func newCancelAfterContext(n int32) *cancelAfterContext {
+	ctx := &cancelAfterContext{Context: context.Background()}
+	ctx.remaining.Store(n)
+	return ctx
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.remaining.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestEmbedExtractDCT_ContextCancelled(t *testing.T) {
+	img := createTestImage(1024, 1024)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := bytes.Repeat([]byte("x"), 500)
+
+	opts := DefaultEmbedOptions()
+	opts.Parallelism = 1
+
+	// The first check happens before embedding starts, so cancelling on the
+	// third lands between block rows
+	_, err := EmbedMessageDCTContext(newCancelAfterContext(2), buf.Bytes(), message, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled from embed, got %v", err)
+	}
+
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	extractOpts := DefaultExtractOptions()
+	extractOpts.Parallelism = 1
+	_, err = ExtractMessageDCTContext(newCancelAfterContext(3), embedded, extractOpts)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled from extract, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ExtractMessageDCTContext(ctx, embedded, extractOpts); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled with an already-cancelled context, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_PreambleSelectsScheme(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("scheme from preamble")
+
+	for _, scheme := range []ECCScheme{ECCSchemeRepetition3, ECCSchemeHamming74, ECCSchemeReedSolomon, ECCSchemeBCH157} {
+		opts := DefaultEmbedOptions()
+		opts.Config.ECC = scheme
+		embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+		if err != nil {
+			t.Fatalf("scheme %d: EmbedMessageDCT failed: %v", scheme, err)
+		}
+
+		decoded, _, err := imgutil.LoadImage(embedded)
+		if err != nil {
+			t.Fatalf("scheme %d: LoadImage failed: %v", scheme, err)
+		}
+		yPlane, _, _ := ycbcr.ImageToYCbCrPlanes(decoded)
+		bits, err := extractBitsFromDCT(context.Background(), []*ycbcr.Plane{yPlane}, preambleBits, opts.Config, 1)
+		if err != nil {
+			t.Fatalf("scheme %d: extractBitsFromDCT failed: %v", scheme, err)
+		}
+		if got, ok := decodePreamble(bits); !ok || got != scheme {
+			t.Errorf("scheme %d: preamble decoded to %d (ok %v)", scheme, got, ok)
+		}
+
+		extracted, err := ExtractMessageDCT(embedded)
+		if err != nil {
+			t.Fatalf("scheme %d: ExtractMessageDCT failed: %v", scheme, err)
+		}
+		if !bytes.Equal(message, extracted) {
+			t.Errorf("scheme %d: message mismatch: expected %q, got %q", scheme, message, extracted)
+		}
+	}
+}
+
+func TestExtractMessageDCT_WithoutPreamble(t *testing.T) {
+	img := createTestImage(256, 256)
+	config := DefaultDCTConfig()
+	config.ECC = ECCSchemeHamming74
+	message := []byte("legacy layout")
+
+	// Embed the frame the way images were written before the preamble existed
+	frame, err := framing.BuildFrame(message, uint8(config.ECC))
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	scheme, err := ecc.GetScheme(config.ECC)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	bits, err := scheme.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(img)
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, ycbcr.YCbCrPlanesToImage(yPlane, cbPlane, crPlane)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCT(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_Repetition5(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("five")
+
+	opts := DefaultEmbedOptions()
+	opts.Config.ECC = ECCSchemeRepetition5
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	// (1024 - 24 preamble bits) / 5 / 8 = 25 frame bytes, 7 after the header
+	info, err := GetCapacityInfoFromData(buf.Bytes(), ECCSchemeRepetition5)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoFromData failed: %v", err)
+	}
+	if info.MaxPayloadBytes != 7 {
+		t.Errorf("expected 7 payload bytes, got %d", info.MaxPayloadBytes)
+	}
+}
+
+func TestPlanEmbedding_MatchesLargestEmbed(t *testing.T) {
+	img := createTestImage(320, 320)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		scheme   ECCScheme
+		password string
+	}{
+		{name: "repetition3", scheme: ECCSchemeRepetition3},
+		{name: "hamming74", scheme: ECCSchemeHamming74},
+		{name: "reedsolomon", scheme: ECCSchemeReedSolomon},
+		{name: "hamming74 encrypted", scheme: ECCSchemeHamming74, password: "secret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultEmbedOptions()
+			opts.Config.ECC = tt.scheme
+			opts.Password = tt.password
+
+			info, err := PlanEmbedding(buf.Bytes(), opts)
+			if err != nil {
+				t.Fatalf("PlanEmbedding failed: %v", err)
+			}
+			if info.MaxPayloadBytes <= 0 {
+				t.Fatalf("expected positive capacity, got %d", info.MaxPayloadBytes)
+			}
+
+			largest := bytes.Repeat([]byte("a"), info.MaxPayloadBytes)
+			if fits, err := FitsMessage(buf.Bytes(), largest, opts); err != nil || !fits {
+				t.Errorf("expected %d bytes to fit (err: %v)", len(largest), err)
+			}
+			if _, err := EmbedMessageDCT(buf.Bytes(), largest, opts); err != nil {
+				t.Errorf("embedding %d bytes failed: %v", len(largest), err)
+			}
+
+			tooLarge := append(largest, 'a')
+			if fits, err := FitsMessage(buf.Bytes(), tooLarge, opts); err != nil || fits {
+				t.Errorf("expected %d bytes not to fit (err: %v)", len(tooLarge), err)
+			}
+			if _, err := EmbedMessageDCT(buf.Bytes(), tooLarge, opts); !errors.Is(err, ErrMessageTooLong) {
+				t.Errorf("expected ErrMessageTooLong for %d bytes, got %v", len(tooLarge), err)
+			}
+		})
+	}
+}
+
+func TestTruncateToCapacity(t *testing.T) {
+	info := &CapacityInfo{}
+	info.setMaxPayloadBytes(10)
+	if info.MaxUTF8Chars != 2 {
+		t.Errorf("expected 2 guaranteed chars for 10 bytes, got %d", info.MaxUTF8Chars)
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "ascii fits", in: "hello", want: "hello"},
+		{name: "ascii trimmed", in: "hello world!", want: "hello worl"},
+		// Each emoji is 4 bytes, so only two fit in 10
+		{name: "emoji", in: "😀😁😂😃", want: "😀😁"},
+		// Each CJK character is 3 bytes, so three fit in 10
+		{name: "cjk", in: "漢字仮名交じり", want: "漢字仮"},
+		{name: "mixed", in: "ab😀漢字", want: "ab😀漢"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TruncateToCapacity(tt.in, info)
+			if got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+			if !utf8.ValidString(got) || len(got) > info.MaxPayloadBytes {
+				t.Errorf("result %q is not valid UTF-8 within %d bytes", got, info.MaxPayloadBytes)
+			}
+		})
+	}
+
+	// A string of MaxUTF8Chars worst-case characters always fits
+	worst := strings.Repeat("😀", info.MaxUTF8Chars)
+	if TruncateToCapacity(worst, info) != worst {
+		t.Errorf("expected %d emoji to fit in %d bytes", info.MaxUTF8Chars, info.MaxPayloadBytes)
+	}
+}
+
+// createFlatAndTexturedImage returns an image whose left half is a flat gray
+// background and whose right half is noise with a per-block amplitude, so
+// block energies spread across the default skip threshold
+func createFlatAndTexturedImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := 128
+			if x >= width/2 {
+				seed = seed*1664525 + 1013904223
+				noise := int(seed>>24) - 128
+				amp := (x/8 + y/8*7) % 24
+				v = 128 + noise*amp/128
+			}
+			img.Set(x, y, color.RGBA{R: uint8(v), G: uint8(v), B: uint8(v), A: 255})
+		}
+	}
+	return img
+}
+
+func TestEmbedExtractDCT_SkipLowEnergyBlocks(t *testing.T) {
+	img := createFlatAndTexturedImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	config := DefaultDCTConfig()
+	config.UseAllBlocks = false
+
+	// Make sure some blocks fall within the margin, so the test covers the
+	// blocks embedding has to flatten
+	yPlane, _, _ := ycbcr.ImageToYCbCrPlanes(img)
+	planes := []*ycbcr.Plane{yPlane}
+	order, err := blockOrder(1, 64, 64, config)
+	if err != nil {
+		t.Fatalf("blockOrder failed: %v", err)
+	}
+	energies, err := blockEnergies(context.Background(), planes, order, config, 1)
+	if err != nil {
+		t.Fatalf("blockEnergies failed: %v", err)
+	}
+	ambiguous := 0
+	for _, energy := range energies {
+		if energy >= defaultEnergyThreshold-energyMargin && energy < defaultEnergyThreshold+energyMargin {
+			ambiguous++
+		}
+	}
+	if ambiguous == 0 {
+		t.Fatalf("expected blocks near the energy threshold")
+	}
+
+	info, err := GetCapacityInfoForConfig(buf.Bytes(), config)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoForConfig failed: %v", err)
+	}
+	if info.CapacityBits == 0 || info.CapacityBits >= 64*32 {
+		t.Fatalf("expected capacity limited to part of the textured half, got %d bits", info.CapacityBits)
+	}
+
+	message := bytes.Repeat([]byte("t"), info.MaxPayloadBytes)
+	opts := DefaultEmbedOptions()
+	opts.Config = config
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCTWithOptions(embedded, &ExtractOptions{Config: config})
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	// The flat half must be left untouched
+	decoded, _, err := imgutil.LoadImage(embedded)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 256; x++ {
+			if decoded.At(x, y) != img.At(x, y) {
+				t.Fatalf("flat pixel (%d,%d) changed: %v -> %v", x, y, img.At(x, y), decoded.At(x, y))
+			}
+		}
+	}
+
+	// An extractor that doesn't skip maps bits to different blocks
+	if _, err := ExtractMessageDCT(embedded); err == nil {
+		t.Errorf("expected extraction without skipping to fail")
+	}
+}
+
+func TestMeasureDistortion(t *testing.T) {
+	gray := func(v uint8) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+		for y := 0; y < 16; y++ {
+			for x := 0; x < 16; x++ {
+				img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+			}
+		}
+		return img
+	}
+
+	t.Run("identical", func(t *testing.T) {
+		img := createTestImage(64, 64)
+		psnr, ssim, err := MeasureDistortion(img, img)
+		if err != nil {
+			t.Fatalf("MeasureDistortion failed: %v", err)
+		}
+		if !math.IsInf(psnr, 1) {
+			t.Errorf("expected +Inf PSNR, got %v", psnr)
+		}
+		if ssim != 1 {
+			t.Errorf("expected SSIM 1.0, got %v", ssim)
+		}
+	})
+
+	t.Run("uniform offset", func(t *testing.T) {
+		// Every Y sample differs by 5, so MSE = 25 and PSNR = 10*log10(255^2/25)
+		psnr, ssim, err := MeasureDistortion(gray(100), gray(105))
+		if err != nil {
+			t.Fatalf("MeasureDistortion failed: %v", err)
+		}
+		want := 10 * math.Log10(255*255/25.0)
+		if math.Abs(psnr-want) > 0.01 {
+			t.Errorf("expected PSNR %.3f, got %.3f", want, psnr)
+		}
+		if ssim >= 1 || ssim < 0.99 {
+			t.Errorf("expected SSIM just below 1.0, got %v", ssim)
+		}
+	})
+
+	t.Run("dimension mismatch", func(t *testing.T) {
+		_, _, err := MeasureDistortion(createTestImage(64, 64), createTestImage(64, 32))
+		if !errors.Is(err, ErrDimensionMismatch) {
+			t.Errorf("expected ErrDimensionMismatch, got %v", err)
+		}
+	})
+}
+
+func TestEmbedMessageDCTWithResult(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("how much did I use")
+
+	opts := DefaultEmbedOptions()
+	opts.Config.ECC = ECCSchemeHamming74
+	result, err := EmbedMessageDCTWithResult(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult failed: %v", err)
+	}
+
+	frame, err := framing.BuildFrame(message, uint8(opts.Config.ECC))
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	scheme, err := ecc.GetScheme(opts.Config.ECC)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	frameBits, err := encodedBitLength(scheme, len(frame))
+	if err != nil {
+		t.Fatalf("encodedBitLength failed: %v", err)
+	}
+
+	if result.BitsWritten != preambleBits+frameBits {
+		t.Errorf("expected %d bits written, got %d", preambleBits+frameBits, result.BitsWritten)
+	}
+	if result.BlocksUsed != result.BitsWritten {
+		t.Errorf("expected one block per bit, got %d blocks for %d bits", result.BlocksUsed, result.BitsWritten)
+	}
+	if result.BlocksAvailable != 32*32 {
+		t.Errorf("expected %d blocks available, got %d", 32*32, result.BlocksAvailable)
+	}
+	if want := float64(result.BlocksUsed) / float64(32*32); result.FractionUsed != want {
+		t.Errorf("expected fraction %v, got %v", want, result.FractionUsed)
+	}
+
+	extracted, err := ExtractMessageDCT(result.Output)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_Metadata(t *testing.T) {
+	img := createTestImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("with metadata")
+	metadata := map[string]string{
+		"content-type": "text/plain",
+		"filename":     "note.txt",
+	}
+
+	opts := DefaultEmbedOptions()
+	opts.Metadata = metadata
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	result, err := ExtractMessageDCTWithResult(embedded, nil)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithResult failed: %v", err)
+	}
+	if !bytes.Equal(message, result.Message) {
+		t.Errorf("message mismatch: expected %q, got %q", message, result.Message)
+	}
+	if !reflect.DeepEqual(metadata, result.Metadata) {
+		t.Errorf("metadata mismatch: expected %v, got %v", metadata, result.Metadata)
+	}
+
+	// The plain API still returns just the message
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	// Messages embedded without metadata report none
+	plain, err := EmbedMessageDCT(buf.Bytes(), message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	result, err = ExtractMessageDCTWithResult(plain, nil)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithResult failed: %v", err)
+	}
+	if result.Metadata != nil {
+		t.Errorf("expected no metadata, got %v", result.Metadata)
+	}
+}
+
+func TestExtractMessageDCT_HeaderCRCMismatch(t *testing.T) {
+	img := createTestImage(256, 256)
+	config := DefaultDCTConfig()
+
+	frame, err := framing.BuildFrame([]byte("corrupt header"), uint8(config.ECC))
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	// Flip a payload length bit beyond what the ECC can correct
+	frame[8] ^= 0x80
+
+	scheme, err := ecc.GetScheme(config.ECC)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	bits, err := scheme.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	bits = append(encodePreamble(config.ECC), bits...)
+
+	yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(img)
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, ycbcr.YCbCrPlanesToImage(yPlane, cbPlane, crPlane)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	_, err = ExtractMessageDCT(buf.Bytes())
+	if !errors.Is(err, ErrHeaderCRCMismatch) {
+		t.Errorf("expected ErrHeaderCRCMismatch, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_Grayscale(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x + y) / 2)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("grayscale")
+
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	decoded, _, err := imgutil.LoadImage(embedded)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	if _, ok := decoded.(*image.Gray); !ok {
+		t.Errorf("expected grayscale output, got %T", decoded)
+	}
+
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	psnr, _, err := MeasureDistortion(img, decoded)
+	if err != nil {
+		t.Fatalf("MeasureDistortion failed: %v", err)
+	}
+	if psnr < 35 {
+		t.Errorf("expected near-identical luma, got PSNR %.2f dB", psnr)
+	}
+}
+
+func TestEmbedExtractDCT_PreservesAlpha(t *testing.T) {
+	src := createTestImage(256, 256)
+	img := image.NewNRGBA(src.Bounds())
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			c := src.RGBAAt(x, y)
+			img.SetNRGBA(x, y, color.NRGBA{R: c.R, G: c.G, B: c.B, A: uint8(x)})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("see-through")
+
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	decoded, _, err := imgutil.LoadImage(embedded)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA).A
+			if diff := int(got) - x; diff < -1 || diff > 1 {
+				t.Fatalf("alpha at (%d,%d): expected %d, got %d", x, y, x, got)
+			}
+		}
+	}
+
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestColorSpace_GradientRoundTrip(t *testing.T) {
+	img := createTestImage(64, 64)
+
+	// rgbError converts img to YCbCr with BT.709 and back with the given
+	// color space, returning the total absolute RGB error
+	rgbError := func(back ColorSpace) int {
+		y, cb, cr, _ := ycbcr.ImageToYCbCrPlanesWithAlpha(img, ColorSpaceBT709)
+		out := ycbcr.YCbCrPlanesToImageIn(y, cb, cr, back)
+		total := 0
+		for i := range img.Pix {
+			diff := int(img.Pix[i]) - int(out.Pix[i])
+			if diff < 0 {
+				diff = -diff
+			}
+			total += diff
+		}
+		return total
+	}
+
+	matching := rgbError(ColorSpaceBT709)
+	mismatched := rgbError(ColorSpaceBT601)
+	if matching >= mismatched {
+		t.Errorf("expected smaller error with the matching matrix: BT.709 %d, BT.601 %d", matching, mismatched)
+	}
+	if perPixel := float64(matching) / float64(len(img.Pix)); perPixel > 1 {
+		t.Errorf("matching matrix round trip error too large: %.2f per channel", perPixel)
+	}
+}
+
+func TestEmbedExtractDCT_BT709(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("HD content")
+
+	opts := DefaultEmbedOptions()
+	opts.Config.ColorSpace = ColorSpaceBT709
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	extractOpts := DefaultExtractOptions()
+	extractOpts.Config.ColorSpace = ColorSpaceBT709
+	extracted, err := ExtractMessageDCTWithOptions(embedded, extractOpts)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+// identityScheme is a custom ECC scheme that embeds frame bits unprotected
+type identityScheme struct{}
+
+func (identityScheme) EncodeFrame(frame []byte) ([]bool, error) {
+	return bitstream.BytesToBits(frame), nil
+}
+
+func (identityScheme) DecodeFrame(bits []bool) ([]byte, error) {
+	return bitstream.BitsToBytes(bits), nil
+}
+
+func TestEmbedExtractDCT_RegisteredScheme(t *testing.T) {
+	const identityID ECCScheme = 0x80
+	if err := RegisterScheme(identityID, func() Scheme { return identityScheme{} }); err != nil {
+		t.Fatalf("RegisterScheme failed: %v", err)
+	}
+	if err := RegisterScheme(ECCSchemeRepetition3, func() Scheme { return identityScheme{} }); !errors.Is(err, ErrReservedScheme) {
+		t.Errorf("expected ErrReservedScheme overriding a built-in, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// Without ECC expansion the 1024 blocks hold (1024-24)/8 = 125 frame bytes
+	info, err := GetCapacityInfoFromData(buf.Bytes(), identityID)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoFromData failed: %v", err)
+	}
+	if info.MaxPayloadBytes != 125-framing.HeaderSize {
+		t.Errorf("expected MaxPayloadBytes %d, got %d", 125-framing.HeaderSize, info.MaxPayloadBytes)
+	}
+
+	message := bytes.Repeat([]byte("x"), info.MaxPayloadBytes)
+	opts := DefaultEmbedOptions()
+	opts.Config.ECC = identityID
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	// The preamble tells extraction to use the registered scheme
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_GIFInput(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, createTestImage(256, 256), nil); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("animated")
+
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	if _, format, err := imgutil.LoadImage(embedded); err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	} else if format != "png" {
+		t.Errorf("expected GIF input to be written as png, got %s", format)
+	}
+
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	opts := DefaultEmbedOptions()
+	opts.Config.OutputFormat = "gif"
+	if _, err := EmbedMessageDCT(buf.Bytes(), message, opts); !errors.Is(err, ErrGIFOutput) {
+		t.Errorf("expected ErrGIFOutput, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_16BitPNG(t *testing.T) {
+	img := image.NewRGBA64(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			img.SetRGBA64(x, y, color.RGBA64{R: uint16(x * 256), G: uint16(y * 256), B: uint16((x + y) * 128), A: 0xFFFF})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// Without embedding, the conversion must keep sub-8-bit precision
+	y, cb, cr, _ := ycbcr.ImageToYCbCrPlanesWithAlpha(img, ColorSpaceBT601)
+	back := ycbcr.YCbCrPlanesToImage16(y, cb, cr, nil, ColorSpaceBT601).(*image.RGBA64)
+	for i := 0; i < len(img.Pix); i += 2 {
+		want := int(img.Pix[i])<<8 | int(img.Pix[i+1])
+		got := int(back.Pix[i])<<8 | int(back.Pix[i+1])
+		if diff := want - got; diff <= -256 || diff >= 256 {
+			t.Fatalf("16-bit round trip error %d at byte %d", diff, i)
+		}
+	}
+
+	message := []byte("deep pixels")
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	decoded, _, err := imgutil.LoadImage(embedded)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	if _, ok := decoded.(*image.RGBA64); !ok {
+		t.Errorf("expected 16-bit output, got %T", decoded)
+	}
+
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestExtractSoftBitsFromDCT_FlagsAmbiguousBits(t *testing.T) {
+	img := createTestImage(64, 64)
+	yPlane, _, _ := ycbcr.ImageToYCbCrPlanes(img)
+	config := DefaultDCTConfig()
+
+	bits := []bool{true, false, true, true, false, false, true, false}
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+
+	// Collapse the carrier gap of two blocks so their bits become ambiguous
+	ambiguous := map[int]bool{1: true, 6: true}
+	block := make([]float64, 64)
+	dctBlock := make([]float64, 64)
+	for i := range ambiguous {
+		loadBlock(yPlane, 8, i%8, i/8, block)
+		dct.DCTNxN(8, block, dctBlock)
+		mean := (dctBlock[2*8+2] + dctBlock[2*8+3]) / 2
+		dctBlock[2*8+2], dctBlock[2*8+3] = mean+0.1, mean-0.1
+		dct.IDCTNxN(8, dctBlock, block)
+		storeBlock(yPlane, 8, i%8, i/8, block)
+	}
+
+	soft, err := extractSoftBitsFromDCT(context.Background(), []*ycbcr.Plane{yPlane}, len(bits), config, nil, 1)
+	if err != nil {
+		t.Fatalf("extractSoftBitsFromDCT failed: %v", err)
+	}
+	if len(soft) != len(bits) {
+		t.Fatalf("expected %d soft bits, got %d", len(bits), len(soft))
+	}
+	for i, v := range soft {
+		switch {
+		case ambiguous[i]:
+			if math.Abs(v) > 1 {
+				t.Errorf("bit %d: expected low confidence, got %.2f", i, v)
+			}
+		case (v > 0) != bits[i]:
+			t.Errorf("bit %d: expected %v, got soft value %.2f", i, bits[i], v)
+		case math.Abs(v) < config.MinGap:
+			t.Errorf("bit %d: expected confidence of at least %.1f, got %.2f", i, config.MinGap, v)
+		}
+	}
+}
+
+func TestEmbedExtractDCT_QuantizationAwareJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("survives JPEG")
+
+	// A gap this small is far below the quality-75 quantization steps at
+	// (2,2)/(2,3) (8 and 12), so only quantization-aware embedding survives
+	opts := DefaultEmbedOptions()
+	opts.Config.OutputFormat = "jpg"
+	opts.Config.Delta = 2
+	opts.Config.MinGap = 1
+	opts.JPEGQuality = 75
+
+	plain, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	if extracted, err := ExtractMessageDCT(plain); err == nil && bytes.Equal(message, extracted) {
+		t.Fatalf("expected the small gap to be destroyed by JPEG without quantization awareness")
+	}
+
+	opts.Config.QuantizationAware = true
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	// Reload the JPEG and save it again at the same quality, as a re-share would
+	decoded, format, err := imgutil.LoadImage(embedded)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	if format != "jpeg" {
+		t.Fatalf("expected jpeg output, got %s", format)
+	}
+	resaved, err := imgutil.EncodeImage(decoded, "jpg", 75)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+
+	for name, data := range map[string][]byte{"embedded": embedded, "resaved": resaved} {
+		extracted, err := ExtractMessageDCT(data)
+		if err != nil {
+			t.Fatalf("%s: ExtractMessageDCT failed: %v", name, err)
+		}
+		if !bytes.Equal(message, extracted) {
+			t.Errorf("%s: message mismatch: expected %q, got %q", name, message, extracted)
+		}
+	}
+}
+
+func TestQuantizedPair(t *testing.T) {
+	for _, tt := range []struct {
+		a, b, qa, qb, gap float64
+		bit               bool
+	}{
+		{3.2, -1.7, 8, 12, 15, true},
+		{3.2, -1.7, 8, 12, 15, false},
+		{0, 0, 16, 16, 1, true},
+		{47.9, 48.1, 24, 40, 15, false},
+	} {
+		a, b := quantizedPair(tt.a, tt.b, tt.qa, tt.qb, tt.gap, tt.bit)
+		if math.Mod(a, tt.qa) != 0 || math.Mod(b, tt.qb) != 0 {
+			t.Errorf("%+v: (%v, %v) not on the quantization grid", tt, a, b)
+		}
+		if (a > b) != tt.bit || math.Abs(a-b) < tt.gap {
+			t.Errorf("%+v: (%v, %v) doesn't encode the bit with the required gap", tt, a, b)
+		}
+	}
+}
+
+func TestEmbedExtractDCT_BlockSizes(t *testing.T) {
+	img := createTestImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("big blocks")
+
+	for _, size := range []int{8, 16} {
+		opts := DefaultEmbedOptions()
+		opts.Config.BlockSize = size
+
+		info, err := GetCapacityInfoForConfig(buf.Bytes(), opts.Config)
+		if err != nil {
+			t.Fatalf("%dx%d: GetCapacityInfoForConfig failed: %v", size, size, err)
+		}
+		if want := (512 / size) * (512 / size); info.CapacityBits != want {
+			t.Errorf("%dx%d: expected %d capacity bits, got %d", size, size, want, info.CapacityBits)
+		}
+
+		embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+		if err != nil {
+			t.Fatalf("%dx%d: EmbedMessageDCT failed: %v", size, size, err)
+		}
+
+		extractOpts := DefaultExtractOptions()
+		extractOpts.Config.BlockSize = size
+		extracted, err := ExtractMessageDCTWithOptions(embedded, extractOpts)
+		if err != nil {
+			t.Fatalf("%dx%d: ExtractMessageDCTWithOptions failed: %v", size, size, err)
+		}
+		if !bytes.Equal(message, extracted) {
+			t.Errorf("%dx%d: message mismatch: expected %q, got %q", size, size, message, extracted)
+		}
+	}
+
+	opts := DefaultEmbedOptions()
+	opts.Config.BlockSize = 64
+	if _, err := EmbedMessageDCT(buf.Bytes(), message, opts); !errors.Is(err, ErrInvalidBlockSize) {
+		t.Errorf("expected ErrInvalidBlockSize, got %v", err)
+	}
+}
+
+func TestHasEmbeddedMessage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	found, err := HasEmbeddedMessage(buf.Bytes())
+	if err != nil {
+		t.Fatalf("HasEmbeddedMessage failed on clean image: %v", err)
+	}
+	if found {
+		t.Error("expected no message in a clean image")
+	}
+
+	embedded, err := EmbedMessageDCT(buf.Bytes(), []byte("here"), nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	found, err = HasEmbeddedMessage(embedded)
+	if err != nil {
+		t.Fatalf("HasEmbeddedMessage failed on embedded image: %v", err)
+	}
+	if !found {
+		t.Error("expected a message in the embedded image")
+	}
+
+	if _, err := HasEmbeddedMessage([]byte("not an image")); err == nil {
+		t.Error("expected an error for undecodable data")
+	}
+}
+
+func TestNewEmbedOptions_Composition(t *testing.T) {
+	opts, err := NewEmbedOptions(
+		WithECC(ECCSchemeHamming74),
+		WithDelta(12),
+		WithOutputFormat("JPG"),
+		WithJPEGQuality(80),
+		WithMetadata(map[string]string{"a": "1"}),
+		WithMetadata(map[string]string{"b": "2"}),
+		WithDelta(14), // later options override earlier ones
+	)
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+
+	want := DefaultEmbedOptions()
+	want.Config.ECC = ECCSchemeHamming74
+	want.Config.Delta = 14
+	want.Config.OutputFormat = "jpg"
+	want.JPEGQuality = 80
+	want.Metadata = map[string]string{"a": "1", "b": "2"}
+	if !reflect.DeepEqual(opts, want) {
+		t.Errorf("expected %+v, got %+v", want, opts)
+	}
+}
+
+func TestNewEmbedOptions_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		options []EmbedOption
+	}{
+		{"unknown ECC", []EmbedOption{WithECC(0xEE)}},
+		{"zero delta", []EmbedOption{WithDelta(0)}},
+		{"negative min gap", []EmbedOption{WithMinGap(-1)}},
+		{"quality too low", []EmbedOption{WithJPEGQuality(0)}},
+		{"quality too high", []EmbedOption{WithJPEGQuality(101)}},
+		{"unknown PNG compression", []EmbedOption{WithPNGCompression(1)}},
+		{"unknown format", []EmbedOption{WithOutputFormat("avif")}},
+		{"gif output", []EmbedOption{WithOutputFormat("gif")}},
+		{"no channels", []EmbedOption{WithChannels(0)}},
+		{"unknown channel", []EmbedOption{WithChannels(ChannelY | 0x10)}},
+		{"unknown color space", []EmbedOption{WithColorSpace(5)}},
+		{"unknown compression", []EmbedOption{WithCompression(9)}},
+		{"block size", []EmbedOption{WithBlockSize(3)}},
+		{"empty password", []EmbedOption{WithPassword("")}},
+		{"quantization without JPEG", []EmbedOption{WithQuantizationAware(), WithOutputFormat("png")}},
+		{"quantization with 16x16 blocks", []EmbedOption{WithQuantizationAware(), WithOutputFormat("jpg"), WithBlockSize(16)}},
+		{"chroma with JPEG", []EmbedOption{WithChannels(ChannelY | ChannelCb), WithOutputFormat("jpg")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewEmbedOptions(tt.options...); !errors.Is(err, ErrInvalidOption) {
+				t.Errorf("expected ErrInvalidOption, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEmbedMessage_FunctionalOptions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("functional")
+
+	embedded, err := EmbedMessage(buf.Bytes(), message, WithECC(ECCSchemeHamming74), WithOutputFormat("bmp"))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+	if _, format, _ := imgutil.LoadImage(embedded); format != "bmp" {
+		t.Errorf("expected bmp output, got %s", format)
+	}
+
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	if _, err := EmbedMessage(buf.Bytes(), message, WithDelta(-1)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption, got %v", err)
+	}
+}
+
+func TestDCTConfigValidate(t *testing.T) {
+	if err := DefaultDCTConfig().Validate(); err != nil {
+		t.Fatalf("default config should be valid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*EmbedOptions)
+	}{
+		{"zero delta", func(o *EmbedOptions) { o.Config.Delta = 0 }},
+		{"negative delta", func(o *EmbedOptions) { o.Config.Delta = -5 }},
+		{"negative min gap", func(o *EmbedOptions) { o.Config.MinGap = -1 }},
+		{"unsupported output format", func(o *EmbedOptions) { o.Config.OutputFormat = "avif" }},
+		{"gif output format", func(o *EmbedOptions) { o.Config.OutputFormat = "gif" }},
+		{"unknown checksum", func(o *EmbedOptions) { o.Config.Checksum = 7 }},
+		{"unknown compression", func(o *EmbedOptions) { o.Config.Compression = 9 }},
+		{"unknown color space", func(o *EmbedOptions) { o.Config.ColorSpace = 5 }},
+		{"unknown channel", func(o *EmbedOptions) { o.Config.Channels = ChannelY | 0x10 }},
+		{"negative MaxDelta", func(o *EmbedOptions) { o.Config.MaxDelta = -1 }},
+		{"invalid coefficient", func(o *EmbedOptions) { o.Config.CoeffA = [2]int{9, 9} }},
+		{"JPEG quality too low", func(o *EmbedOptions) { o.JPEGQuality = -1 }},
+		{"JPEG quality too high", func(o *EmbedOptions) { o.JPEGQuality = 101 }},
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(64, 64)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultEmbedOptions()
+			tt.modify(opts)
+			if err := opts.Validate(); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("Validate: expected ErrInvalidConfig, got %v", err)
+			}
+			if _, err := EmbedMessageDCT(buf.Bytes(), []byte("x"), opts); !errors.Is(err, ErrInvalidConfig) {
+				t.Errorf("EmbedMessageDCT: expected ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+
+	// Zero JPEG quality means the default
+	opts := DefaultEmbedOptions()
+	opts.JPEGQuality = 0
+	if err := opts.Validate(); err != nil {
+		t.Errorf("expected zero JPEG quality to be valid, got %v", err)
+	}
+}
+
+func TestEmbedMessageDCTBatch(t *testing.T) {
+	large := saveTestImage(t, createTestImage(256, 256), "large.png")
+	small := saveTestImage(t, createTestImage(16, 16), "small.png")
+	other := saveTestImage(t, createTestImage(256, 192), "other.png")
+	notImage := filepath.Join(t.TempDir(), "notes.png")
+	if err := os.WriteFile(notImage, []byte("not an image"), 0644); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.png")
+
+	outputDir := filepath.Join(t.TempDir(), "out")
+	message := []byte("watermark")
+	opts := DefaultEmbedOptions()
+	opts.BatchParallelism = 2
+
+	inputs := []string{large, small, notImage, other, missing}
+	results, err := EmbedMessageDCTBatch(inputs, outputDir, message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTBatch failed: %v", err)
+	}
+	if len(results) != len(inputs) {
+		t.Fatalf("expected %d results, got %d", len(inputs), len(results))
+	}
+
+	wantStatus := []BatchStatus{BatchEmbedded, BatchSkipped, BatchFailed, BatchEmbedded, BatchFailed}
+	for i, result := range results {
+		if result.Input != inputs[i] {
+			t.Errorf("result %d: expected input %s, got %s", i, inputs[i], result.Input)
+		}
+		if result.Status != wantStatus[i] {
+			t.Errorf("%s: expected %v, got %v (%v)", filepath.Base(inputs[i]), wantStatus[i], result.Status, result.Err)
+		}
+		if (result.Err == nil) != (result.Status == BatchEmbedded) {
+			t.Errorf("%s: unexpected error %v for status %v", filepath.Base(inputs[i]), result.Err, result.Status)
+		}
+	}
+	if !errors.Is(results[1].Err, ErrMessageTooLong) {
+		t.Errorf("expected skipped image to report ErrMessageTooLong, got %v", results[1].Err)
+	}
+
+	for _, result := range []BatchResult{results[0], results[3]} {
+		if filepath.Dir(result.Output) != outputDir || filepath.Base(result.Output) != filepath.Base(result.Input) {
+			t.Errorf("unexpected output path %s for %s", result.Output, result.Input)
+		}
+		extracted, err := ExtractMessageDCTFile(result.Output)
+		if err != nil {
+			t.Fatalf("%s: ExtractMessageDCTFile failed: %v", result.Output, err)
+		}
+		if !bytes.Equal(message, extracted) {
+			t.Errorf("%s: message mismatch: expected %q, got %q", result.Output, message, extracted)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "small.png")); !os.IsNotExist(err) {
+		t.Errorf("expected no output for the skipped image, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_Region(t *testing.T) {
+	img := createTestImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("only in the lower half")
+
+	// Not block aligned: snaps inwards to blocks 1-63 across, 33-63 down
+	region := image.Rect(3, 260, 512, 515)
+	opts := DefaultEmbedOptions()
+	opts.Config.Region = &region
+
+	info, err := GetCapacityInfoForConfig(buf.Bytes(), opts.Config)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoForConfig failed: %v", err)
+	}
+	if info.BlocksAcross != 63 || info.BlocksDown != 31 || info.CapacityBits != 63*31 {
+		t.Errorf("expected 63x31 blocks (%d bits), got %dx%d (%d bits)",
+			63*31, info.BlocksAcross, info.BlocksDown, info.CapacityBits)
+	}
+
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	out, err := png.Decode(bytes.NewReader(embedded))
+	if err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	inside := image.Rect(8, 264, 512, 512)
+	for y := 0; y < 512; y++ {
+		for x := 0; x < 512; x++ {
+			if image.Pt(x, y).In(inside) {
+				continue
+			}
+			r1, g1, b1, _ := img.At(x, y).RGBA()
+			r2, g2, b2, _ := out.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				t.Fatalf("pixel (%d,%d) outside the region changed", x, y)
+			}
+		}
+	}
+
+	extractOpts := DefaultExtractOptions()
+	extractOpts.Config.Region = &region
+	extracted, err := ExtractMessageDCTWithOptions(embedded, extractOpts)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_RegionWithoutBlocks(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// Wider than a block, but straddling the grid, so no block fits
+	opts := DefaultEmbedOptions()
+	opts.Config.Region = &image.Rectangle{Min: image.Pt(4, 4), Max: image.Pt(14, 100)}
+	if err := opts.Validate(); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "no whole blocks") {
+		t.Errorf("expected ErrInvalidConfig for a region without whole blocks, got %v", err)
+	}
+
+	// Whole blocks, but none of them inside the image
+	opts.Config.Region = &image.Rectangle{Min: image.Pt(512, 0), Max: image.Pt(600, 64)}
+	if err := opts.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	if _, err := EmbedMessageDCT(buf.Bytes(), []byte("nowhere"), opts); !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), "no whole blocks") {
+		t.Errorf("expected ErrInvalidConfig from EmbedMessageDCT, got %v", err)
+	}
+	extractOpts := DefaultExtractOptions()
+	extractOpts.Config.Region = opts.Config.Region
+	if _, err := ExtractMessageDCTWithOptions(buf.Bytes(), extractOpts); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig from ExtractMessageDCTWithOptions, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_Seed(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("shuffled")
+
+	embedded, err := EmbedMessage(buf.Bytes(), message, WithSeed("correct horse"))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+
+	for _, seed := range []string{"", "wrong horse"} {
+		opts := DefaultExtractOptions()
+		opts.Config.Seed = seed
+		if _, err := ExtractMessageDCTWithOptions(embedded, opts); !errors.Is(err, ErrInvalidMagic) {
+			t.Errorf("seed %q: expected ErrInvalidMagic, got %v", seed, err)
+		}
+	}
+
+	opts := DefaultExtractOptions()
+	opts.Config.Seed = "correct horse"
+	extracted, err := ExtractMessageDCTWithOptions(embedded, opts)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestSeedKey_CachesDerivedKeys(t *testing.T) {
+	want, err := encryption.DeriveKey("cached seed", shuffleSalt)
+	if err != nil {
+		t.Fatalf("DeriveKey failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		key, err := seedKey("cached seed", shuffleSalt)
+		if err != nil {
+			t.Fatalf("seedKey failed: %v", err)
+		}
+		if key != [32]byte(want) {
+			t.Fatalf("call %d: seedKey differs from DeriveKey", i)
+		}
+	}
+	// The salt is part of the key's identity
+	if spread, _ := seedKey("cached seed", spreadSalt); spread == [32]byte(want) {
+		t.Error("expected different keys for different salts")
+	}
+
+	// The cache stays bounded however many seeds are used
+	for i := range seedKeyCacheSize + 1 {
+		if _, err := seedKey(fmt.Sprintf("seed %d", i), shuffleSalt); err != nil {
+			t.Fatalf("seedKey failed: %v", err)
+		}
+	}
+	seedKeys.Lock()
+	n := len(seedKeys.m)
+	seedKeys.Unlock()
+	if n > seedKeyCacheSize {
+		t.Errorf("expected at most %d cached keys, got %d", seedKeyCacheSize, n)
+	}
+}
+
+func TestBlockOrder_SeedIsKeyedPermutation(t *testing.T) {
+	config := DefaultDCTConfig()
+	plain, err := blockOrder(1, 16, 16, config)
+	if err != nil {
+		t.Fatalf("blockOrder failed: %v", err)
+	}
+
+	config.Seed = "a"
+	first, err := blockOrder(1, 16, 16, config)
+	if err != nil {
+		t.Fatalf("blockOrder failed: %v", err)
+	}
+	again, _ := blockOrder(1, 16, 16, config)
+	if !reflect.DeepEqual(first, again) {
+		t.Error("same seed produced different orders")
+	}
+	if reflect.DeepEqual(first, plain) {
+		t.Error("seeded order matches raster order")
+	}
+
+	config.Seed = "b"
+	other, _ := blockOrder(1, 16, 16, config)
+	if reflect.DeepEqual(first, other) {
+		t.Error("different seeds produced the same order")
+	}
+
+	seen := make(map[blockRef]bool)
+	for _, ref := range first {
+		seen[ref] = true
+	}
+	if len(first) != len(plain) || len(seen) != len(plain) {
+		t.Errorf("seeded order is not a permutation: %d blocks, %d distinct", len(first), len(seen))
+	}
+}
+
+func TestEmbedMessageDCT_Verify(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("does it survive?")
+
+	// Lossless output verifies fine
+	if _, err := EmbedMessage(buf.Bytes(), message, WithVerify()); err != nil {
+		t.Fatalf("EmbedMessage with verification failed: %v", err)
+	}
+
+	// At very low JPEG quality quantization destroys the message
+	lossy := []EmbedOption{WithOutputFormat("jpg"), WithJPEGQuality(5)}
+	output, err := EmbedMessage(buf.Bytes(), message, lossy...)
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+	if extracted, err := ExtractMessageDCT(output); err == nil && bytes.Equal(extracted, message) {
+		t.Fatal("message survived quality 5 JPEG, test needs a lossier setting")
+	}
+
+	_, err = EmbedMessage(buf.Bytes(), message, append(lossy, WithVerify())...)
+	if !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("expected ErrVerificationFailed, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_PartialEdgeBlocks(t *testing.T) {
+	img := createTestImage(250, 250)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("edges stay put")
+
+	info, err := GetCapacityInfoForConfig(buf.Bytes(), DefaultDCTConfig())
+	if err != nil {
+		t.Fatalf("GetCapacityInfoForConfig failed: %v", err)
+	}
+	if info.CapacityBits != 31*31 {
+		t.Errorf("expected %d capacity bits (whole blocks only), got %d", 31*31, info.CapacityBits)
+	}
+
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, DefaultEmbedOptions())
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	out, err := png.Decode(bytes.NewReader(embedded))
+	if err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	if out.Bounds() != img.Bounds() {
+		t.Fatalf("expected %v output, got %v", img.Bounds(), out.Bounds())
+	}
+	// The partial blocks past x, y = 248 carry no data and are left untouched
+	for y := 0; y < 250; y++ {
+		for x := 0; x < 250; x++ {
+			if x < 248 && y < 248 {
+				continue
+			}
+			r1, g1, b1, _ := img.At(x, y).RGBA()
+			r2, g2, b2, _ := out.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 {
+				t.Fatalf("edge pixel (%d,%d) changed", x, y)
+			}
+		}
+	}
+
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestEmbedExtractDCT_PadToBlockSize(t *testing.T) {
+	img := createTestImage(250, 250)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("padded")
+
+	opts, err := NewEmbedOptions(WithPadToBlockSize(), WithECC(ECCSchemeHamming74), WithMetadata(map[string]string{"n": "e"}))
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	info, err := GetCapacityInfoForConfig(buf.Bytes(), opts.Config)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoForConfig failed: %v", err)
+	}
+	if info.Width != 250 || info.Height != 250 || info.CapacityBits != 32*32 {
+		t.Errorf("expected a 250x250 image with %d capacity bits, got %dx%d with %d",
+			32*32, info.Width, info.Height, info.CapacityBits)
+	}
+
+	// The largest planned payload must still embed
+	plan, err := PlanEmbedding(buf.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("PlanEmbedding failed: %v", err)
+	}
+	if _, err := EmbedMessageDCT(buf.Bytes(), bytes.Repeat([]byte("x"), plan.MaxPayloadBytes), opts); err != nil {
+		t.Errorf("embedding MaxPayloadBytes (%d) failed: %v", plan.MaxPayloadBytes, err)
+	}
+
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(embedded))
+	if err != nil {
+		t.Fatalf("failed to decode output: %v", err)
+	}
+	if cfg.Width != 256 || cfg.Height != 256 {
+		t.Errorf("expected 256x256 padded output, got %dx%d", cfg.Width, cfg.Height)
+	}
+
+	result, err := ExtractMessageDCTWithResult(embedded, DefaultExtractOptions())
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithResult failed: %v", err)
+	}
+	if !bytes.Equal(message, result.Message) {
+		t.Errorf("message mismatch: expected %q, got %q", message, result.Message)
+	}
+	if result.OriginalSize != image.Pt(250, 250) {
+		t.Errorf("expected original size 250x250, got %v", result.OriginalSize)
+	}
+	if !reflect.DeepEqual(result.Metadata, map[string]string{"n": "e"}) {
+		t.Errorf("unexpected metadata: %v", result.Metadata)
+	}
+
+	cropped, err := CropToOriginal(embedded, DefaultExtractOptions(), 0)
+	if err != nil {
+		t.Fatalf("CropToOriginal failed: %v", err)
+	}
+	if cfg, err := png.DecodeConfig(bytes.NewReader(cropped)); err != nil || cfg.Width != 250 || cfg.Height != 250 {
+		t.Errorf("expected 250x250 cropped PNG, got %dx%d (%v)", cfg.Width, cfg.Height, err)
+	}
+	if _, err := CropToOriginal(embedded, DefaultExtractOptions(), 101); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for JPEG quality 101, got %v", err)
+	}
+
+	// The original size key is the library's, so callers can't set it
+	reserved := DefaultEmbedOptions()
+	reserved.Metadata = map[string]string{originalSizeKey: "1x1"}
+	if _, err := EmbedMessageDCT(buf.Bytes(), message, reserved); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for a reserved metadata key, got %v", err)
+	}
+
+	// JPEG is re-encoded at the requested quality
+	jpegOpts := *opts
+	jpegOpts.Config.OutputFormat = "jpg"
+	jpegOpts.Config.Delta = 40
+	embedded, err = EmbedMessageDCT(buf.Bytes(), message, &jpegOpts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT(jpg) failed: %v", err)
+	}
+	sizes := map[int]int{}
+	for _, quality := range []int{50, 100} {
+		cropped, err := CropToOriginal(embedded, DefaultExtractOptions(), quality)
+		if err != nil {
+			t.Fatalf("CropToOriginal at quality %d failed: %v", quality, err)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(cropped))
+		if err != nil || cfg.Width != 250 || cfg.Height != 250 {
+			t.Fatalf("expected 250x250 cropped JPEG at quality %d, got %dx%d (%v)", quality, cfg.Width, cfg.Height, err)
+		}
+		sizes[quality] = len(cropped)
+	}
+	if sizes[100] <= sizes[50] {
+		t.Errorf("expected a larger file at quality 100 (%d bytes) than at 50 (%d bytes)", sizes[100], sizes[50])
+	}
+}
+
+func TestExtractRawBits(t *testing.T) {
+	img := createTestImage(256, 192)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	embedded, err := EmbedMessageDCT(buf.Bytes(), []byte("raw"), DefaultEmbedOptions())
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	info, err := GetCapacityInfoForConfig(embedded, DefaultDCTConfig())
+	if err != nil {
+		t.Fatalf("GetCapacityInfoForConfig failed: %v", err)
+	}
+	bits, gaps, err := ExtractRawBits(embedded)
+	if err != nil {
+		t.Fatalf("ExtractRawBits failed: %v", err)
+	}
+	if len(bits) != info.CapacityBits || len(gaps) != info.CapacityBits {
+		t.Fatalf("expected %d bits and gaps, got %d and %d", info.CapacityBits, len(bits), len(gaps))
+	}
+
+	// The stream starts with the preamble: the scheme ID under repetition-3
+	if !reflect.DeepEqual(bits[:preambleBits], encodePreamble(ECCSchemeRepetition3)) {
+		t.Errorf("raw bits don't start with the preamble")
+	}
+	for i, gap := range gaps[:preambleBits] {
+		if (gap > 0) != bits[i] {
+			t.Errorf("bit %d is %v but its gap is %v", i, bits[i], gap)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := WriteRawBitsCSV(&out, bits, gaps); err != nil {
+		t.Fatalf("WriteRawBitsCSV failed: %v", err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != info.CapacityBits+1 {
+		t.Errorf("expected %d CSV lines, got %d", info.CapacityBits+1, lines)
+	}
+}
+
+func TestEmbedStreams_ExtractIndependently(t *testing.T) {
+	img := createTestImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	alice := StreamMessage{Stream: Stream{ID: 1, Region: image.Rect(0, 0, 512, 256)}, Message: []byte("for alice")}
+	bob := StreamMessage{Stream: Stream{ID: 2, Region: image.Rect(0, 256, 512, 512)}, Message: []byte("for bob, a little longer")}
+
+	opts := DefaultEmbedOptions()
+	opts.Verify = true
+	embedded, err := EmbedStreams(buf.Bytes(), []StreamMessage{alice, bob}, opts)
+	if err != nil {
+		t.Fatalf("EmbedStreams failed: %v", err)
+	}
+
+	for _, m := range []StreamMessage{alice, bob} {
+		extracted, err := ExtractStream(embedded, m.Stream, nil)
+		if err != nil {
+			t.Fatalf("stream %d: ExtractStream failed: %v", m.ID, err)
+		}
+		if !bytes.Equal(m.Message, extracted) {
+			t.Errorf("stream %d: message mismatch: expected %q, got %q", m.ID, m.Message, extracted)
+		}
+	}
+
+	// Reading one stream's region under another's ID doesn't return its message
+	wrong := Stream{ID: alice.ID, Region: bob.Region}
+	if _, err := ExtractStream(embedded, wrong, nil); !errors.Is(err, ErrStreamMismatch) {
+		t.Errorf("expected ErrStreamMismatch, got %v", err)
+	}
+
+	// Regions sharing a block, or IDs used twice, are rejected
+	overlapping := StreamMessage{Stream: Stream{ID: 3, Region: image.Rect(0, 248, 512, 512)}, Message: []byte("x")}
+	if _, err := EmbedStreams(buf.Bytes(), []StreamMessage{alice, overlapping}, nil); !errors.Is(err, ErrOverlappingStreams) {
+		t.Errorf("expected ErrOverlappingStreams for shared blocks, got %v", err)
+	}
+	duplicate := bob
+	duplicate.ID = alice.ID
+	if _, err := EmbedStreams(buf.Bytes(), []StreamMessage{alice, duplicate}, nil); !errors.Is(err, ErrOverlappingStreams) {
+		t.Errorf("expected ErrOverlappingStreams for a reused ID, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_Progress(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("progress report")
+
+	// check asserts done only increases and ends at total; the callback is
+	// never called concurrently, so it needs no locking
+	check := func(name string, calls [][2]int) {
+		t.Helper()
+		if len(calls) < 2 {
+			t.Fatalf("%s: expected several progress calls, got %d", name, len(calls))
+		}
+		for i, call := range calls {
+			if i > 0 && call[0] <= calls[i-1][0] {
+				t.Errorf("%s: done went from %d to %d", name, calls[i-1][0], call[0])
+			}
+			if call[1] != calls[0][1] {
+				t.Errorf("%s: total changed from %d to %d", name, calls[0][1], call[1])
+			}
+		}
+		if last := calls[len(calls)-1]; last[0] != last[1] {
+			t.Errorf("%s: ended at %d of %d", name, last[0], last[1])
+		}
+	}
+
+	var embedCalls [][2]int
+	opts, err := NewEmbedOptions(WithParallelism(4), WithProgress(func(done, total int) {
+		embedCalls = append(embedCalls, [2]int{done, total})
+	}))
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	check("embed", embedCalls)
+
+	var extractCalls [][2]int
+	extractOpts := DefaultExtractOptions()
+	extractOpts.Parallelism = 4
+	extractOpts.OnProgress = func(done, total int) {
+		extractCalls = append(extractCalls, [2]int{done, total})
+	}
+	extracted, err := ExtractMessageDCTWithOptions(embedded, extractOpts)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+	check("extract", extractCalls)
+}
+
+func TestEmbedExtractDCT_CRC32C(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("castagnoli")
+
+	embedded, err := EmbedMessage(buf.Bytes(), message, WithChecksum(ChecksumCRC32C))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+	extracted, err := ExtractMessageDCT(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	if _, err := NewEmbedOptions(WithChecksum(Checksum(9))); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for an unknown checksum, got %v", err)
+	}
+}
+
+func TestExtractMessageDCTRecover_ShiftedStream(t *testing.T) {
+	img := createTestImage(256, 256)
+	config := DefaultDCTConfig()
+	// Repetition-3 shrugs off a one-bit shift (two of each three copies
+	// still line up), Hamming(7,4) doesn't
+	config.ECC = ECCSchemeHamming74
+	message := []byte("one bit late")
+
+	frame, err := framing.BuildFrame(message, uint8(config.ECC))
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	scheme, err := ecc.GetScheme(config.ECC)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	encoded, err := scheme.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+
+	// A stray leading bit misaligns the preamble and the frame behind it
+	bits := append([]bool{true}, encodePreamble(config.ECC)...)
+	bits = append(bits, encoded...)
+	yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(img)
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, ycbcr.YCbCrPlanesToImage(yPlane, cbPlane, crPlane)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	if _, err := ExtractMessageDCT(buf.Bytes()); err == nil {
+		t.Fatal("expected ExtractMessageDCT to fail on the shifted stream")
+	}
+	extracted, err := ExtractMessageDCTRecover(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTRecover failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	// Without any message there is still nothing to recover
+	var clean bytes.Buffer
+	if err := png.Encode(&clean, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	_, err = ExtractMessageDCTRecover(clean.Bytes())
+	if !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic, got %v", err)
+	} else if strings.Contains(err.Error(), "<nil>") {
+		t.Errorf("expected no nil cause in the error, got %v", err)
+	}
+}
+
+func TestExtractMessageDCTRecover_ShiftedSchemes(t *testing.T) {
+	message := []byte("shifted past the preamble")
+	spilled := message[10:]
+	tests := []struct {
+		name     string
+		scheme   ECCScheme
+		payload  []byte
+		metadata map[string]string
+		spill    []byte
+	}{
+		// Not among the schemes frames were written with before the preamble
+		{"BCH157", ECCSchemeBCH157, message, nil, nil},
+		// The rest of the payload is in the PNG spill chunk
+		{"spill", ECCSchemeHamming74, message[:10], map[string]string{
+			spillKey: fmt.Sprintf("%08x%08x", len(spilled), crc32.ChecksumIEEE(spilled)),
+		}, spilled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame, err := framing.BuildFrameWithMetadata(tt.payload, uint8(tt.scheme), 0, tt.metadata)
+			if err != nil {
+				t.Fatalf("BuildFrameWithMetadata failed: %v", err)
+			}
+			scheme, err := ecc.GetScheme(tt.scheme)
+			if err != nil {
+				t.Fatalf("GetScheme failed: %v", err)
+			}
+			encoded, err := scheme.EncodeFrame(frame)
+			if err != nil {
+				t.Fatalf("EncodeFrame failed: %v", err)
+			}
+
+			// Two stray leading bits misalign the preamble and the frame
+			bits := append([]bool{true, false}, encodePreamble(tt.scheme)...)
+			bits = append(bits, encoded...)
+			config := DefaultDCTConfig()
+			yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(createTestImage(256, 256))
+			if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+				t.Fatalf("embedBitsIntoDCT failed: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, ycbcr.YCbCrPlanesToImage(yPlane, cbPlane, crPlane)); err != nil {
+				t.Fatalf("failed to encode image: %v", err)
+			}
+			data := buf.Bytes()
+			if tt.spill != nil {
+				if data, err = addSpillChunk(data, tt.spill); err != nil {
+					t.Fatalf("addSpillChunk failed: %v", err)
+				}
+			}
+
+			extracted, err := ExtractMessageDCTRecover(data)
+			if err != nil {
+				t.Fatalf("ExtractMessageDCTRecover failed: %v", err)
+			}
+			if !bytes.Equal(message, extracted) {
+				t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+			}
+		})
+	}
+}
+
+func TestEmbedExtractDCT_AdaptiveDelta(t *testing.T) {
+	img := createFlatAndTexturedImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("gentle on flat areas")
+	flat := image.Rect(0, 0, 128, 256)
+
+	// flatPSNR embeds with opts and measures the distortion of the flat half
+	flatPSNR := func(opts ...EmbedOption) float64 {
+		t.Helper()
+		embedded, err := EmbedMessage(buf.Bytes(), message, opts...)
+		if err != nil {
+			t.Fatalf("EmbedMessage failed: %v", err)
+		}
+		extracted, err := ExtractMessageDCT(embedded)
+		if err != nil {
+			t.Fatalf("ExtractMessageDCT failed: %v", err)
+		}
+		if !bytes.Equal(message, extracted) {
+			t.Fatalf("message mismatch: expected %q, got %q", message, extracted)
+		}
+
+		out, err := png.Decode(bytes.NewReader(embedded))
+		if err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		psnr, _, err := MeasureDistortion(img.SubImage(flat), out.(*image.RGBA).SubImage(flat))
+		if err != nil {
+			t.Fatalf("MeasureDistortion failed: %v", err)
+		}
+		return psnr
+	}
+
+	fixed := flatPSNR()
+	adaptive := flatPSNR(WithAdaptiveDelta(0))
+	if adaptive <= fixed {
+		t.Errorf("expected adaptive Delta to distort flat blocks less: PSNR %.2f dB vs %.2f dB fixed", adaptive, fixed)
+	}
+
+	if _, err := NewEmbedOptions(WithAdaptiveDelta(-1)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for a negative MaxDelta, got %v", err)
+	}
+}
+
+func TestEmbedExtractDCT_InterleavedRepetitionSurvivesBurst(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("spread across the image")
+
+	// Wipe out one row of blocks, a run of consecutive carriers, over the
+	// header's payload CRC (damage to the magic would be restored from its
+	// known value)
+	damage := func(t *testing.T, data []byte) []byte {
+		t.Helper()
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		damaged := image.NewRGBA(decoded.Bounds())
+		for y := 0; y < 256; y++ {
+			for x := 0; x < 256; x++ {
+				if y >= 80 && y < 88 {
+					damaged.Set(x, y, color.RGBA{128, 128, 128, 255})
+				} else {
+					damaged.Set(x, y, decoded.At(x, y))
+				}
+			}
+		}
+		var out bytes.Buffer
+		if err := png.Encode(&out, damaged); err != nil {
+			t.Fatalf("failed to encode damaged image: %v", err)
+		}
+		return out.Bytes()
+	}
+
+	plain, err := EmbedMessage(buf.Bytes(), message, WithECC(ECCSchemeRepetition3))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+	if extracted, err := ExtractMessageDCT(damage(t, plain)); err == nil && bytes.Equal(message, extracted) {
+		t.Fatal("expected plain repetition-3 to be defeated by the burst")
+	}
+
+	interleaved, err := EmbedMessage(buf.Bytes(), message, WithECC(ECCSchemeRepetition3Interleaved))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+	extracted, err := ExtractMessageDCT(damage(t, interleaved))
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+}
+
+func TestCapacityInfo_JSON(t *testing.T) {
+	info := CapacityInfo{
+		Width: 64, Height: 32, BlocksAcross: 8, BlocksDown: 4, Channels: 1,
+		RawCapacityBits: 32, CapacityBits: 32, EncodedCapacityBits: 160, OverheadBytes: 18,
+		MaxPayloadBytes: 2, MaxUTF8Chars: 0, EstimatedUTF8Chars: 1,
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	expected := `{"width":64,"height":32,"blocks_across":8,"blocks_down":4,"channels":1,` +
+		`"raw_capacity_bits":32,"capacity_bits":32,"encoded_capacity_bits":160,"overhead_bytes":18,` +
+		`"max_payload_bytes":2,"max_utf8_chars":0,"estimated_utf8_chars":1}`
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
+
+func TestExtractHeader(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("header only")
+
+	embedded, err := EmbedMessage(buf.Bytes(), message, WithECC(ECCSchemeHamming74))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+	header, err := ExtractHeader(embedded)
+	if err != nil {
+		t.Fatalf("ExtractHeader failed: %v", err)
+	}
+	if header.Magic != framing.Magic || header.ECCScheme != uint8(ECCSchemeHamming74) || header.PayloadLength != uint32(len(message)) {
+		t.Errorf("unexpected header: %+v", header)
+	}
+
+	data, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if fields["magic"] != "EMG0" || fields["payload_crc32"] != fmt.Sprintf("0x%08x", header.PayloadCRC32) {
+		t.Errorf("unexpected header JSON: %s", data)
+	}
+
+	if _, err := ExtractHeader(buf.Bytes()); !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic for a clean image, got %v", err)
+	}
+}
+
+func TestExtractHeader_MatchesEmbedded(t *testing.T) {
+	img := createTestImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("inspect me")
+	metadata := map[string]string{"a": "b"}
+
+	embedded, err := EmbedMessage(buf.Bytes(), message,
+		WithECC(ECCSchemeHamming74), WithPassword("secret"), WithMetadata(metadata), WithChecksum(ChecksumCRC32C))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+
+	header, err := ExtractHeader(embedded)
+	if err != nil {
+		t.Fatalf("ExtractHeader failed: %v", err)
+	}
+	if header.Version != framing.CurrentVersion {
+		t.Errorf("expected version %d, got %d", framing.CurrentVersion, header.Version)
+	}
+	if header.ECCScheme != uint8(ECCSchemeHamming74) {
+		t.Errorf("expected ECC scheme %d, got %d", ECCSchemeHamming74, header.ECCScheme)
+	}
+	wantFlags := framing.FlagEncrypted | framing.FlagMetadata | framing.FlagCRC32C
+	if header.Flags != wantFlags {
+		t.Errorf("expected flags %#x, got %#x", wantFlags, header.Flags)
+	}
+	wantLength := framing.MetadataSize(metadata) + len(message) + encryption.Overhead
+	if header.PayloadLength != uint32(wantLength) {
+		t.Errorf("expected payload length %d, got %d", wantLength, header.PayloadLength)
+	}
+	if header.Metadata != nil {
+		t.Errorf("expected no metadata without the payload, got %v", header.Metadata)
+	}
+}
+
+func TestEmbedExtractDCT_Deblock(t *testing.T) {
+	img := createFlatAndTexturedImage(512, 512)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("smooth")
+
+	// boundaryStep is the mean luminance step across vertical block
+	// boundaries in excess of the mean step between other columns
+	boundaryStep := func(t *testing.T, data []byte) float64 {
+		t.Helper()
+		decoded, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to decode output: %v", err)
+		}
+		y, _, _ := ycbcr.ImageToYCbCrPlanes(decoded)
+		var boundary, interior float64
+		var nb, ni int
+		for row := 0; row < y.Height; row++ {
+			for x := 1; x < y.Width; x++ {
+				step := math.Abs(y.Pix[row*y.Stride+x] - y.Pix[row*y.Stride+x-1])
+				if x%8 == 0 {
+					boundary += step
+					nb++
+				} else {
+					interior += step
+					ni++
+				}
+			}
+		}
+		return boundary/float64(nb) - interior/float64(ni)
+	}
+
+	for _, useAll := range []bool{true, false} {
+		config := DefaultDCTConfig()
+		config.Delta = 60
+		config.UseAllBlocks = useAll
+		plain, err := EmbedMessageDCT(buf.Bytes(), message, &EmbedOptions{Config: config})
+		if err != nil {
+			t.Fatalf("EmbedMessageDCT failed: %v", err)
+		}
+		smoothed := config
+		smoothed.Deblock = true
+		deblocked, err := EmbedMessageDCT(buf.Bytes(), message, &EmbedOptions{Config: smoothed})
+		if err != nil {
+			t.Fatalf("EmbedMessageDCT with Deblock failed: %v", err)
+		}
+
+		before, after := boundaryStep(t, plain), boundaryStep(t, deblocked)
+		if after >= before {
+			t.Errorf("UseAllBlocks=%v: expected deblocking to reduce the boundary step, got %.3f -> %.3f", useAll, before, after)
+		}
+
+		extracted, err := ExtractMessageDCTWithOptions(deblocked, &ExtractOptions{Config: config})
+		if err != nil {
+			t.Fatalf("UseAllBlocks=%v: ExtractMessageDCT failed: %v", useAll, err)
+		}
+		if !bytes.Equal(message, extracted) {
+			t.Errorf("UseAllBlocks=%v: message mismatch: expected %q, got %q", useAll, message, extracted)
+		}
+	}
+}
+
+func TestEmbedBitsIntoDCT_IteratorMatchesSlice(t *testing.T) {
+	img := createTestImage(256, 256)
+	config := DefaultDCTConfig()
+	frame, err := framing.BuildFrame([]byte("pulled on demand"), uint8(config.ECC))
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	scheme := &ecc.Repetition3{}
+
+	bits, err := scheme.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	slicePlane, _, _ := ycbcr.ImageToYCbCrPlanes(img)
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{slicePlane}, bits, config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+
+	for _, workers := range []int{1, 3} {
+		it, count, err := scheme.EncodeFrameStream(frame)
+		if err != nil {
+			t.Fatalf("EncodeFrameStream failed: %v", err)
+		}
+		iterPlane, _, _ := ycbcr.ImageToYCbCrPlanes(img)
+		if err := embedBitsIntoDCTQuantized(context.Background(), []*ycbcr.Plane{iterPlane}, it, count, config, nil, nil, workers); err != nil {
+			t.Fatalf("embedBitsIntoDCTQuantized failed: %v", err)
+		}
+		if !reflect.DeepEqual(slicePlane.Pix, iterPlane.Pix) {
+			t.Errorf("workers=%d: iterator path output differs from slice path", workers)
+		}
+	}
+
+	// An iterator that runs dry before count is an error
+	yPlane, _, _ := ycbcr.ImageToYCbCrPlanes(img)
+	short := bitstream.NewSliceIterator(bits[:10])
+	if err := embedBitsIntoDCTQuantized(context.Background(), []*ycbcr.Plane{yPlane}, short, len(bits), config, nil, nil, 1); err == nil {
+		t.Error("expected an error for a bit stream shorter than its count")
+	}
+}
+
+func TestEmbedExtractDCT_ImageTooSmall(t *testing.T) {
+	for _, size := range []int{4, 7} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, createTestImage(size, size)); err != nil {
+			t.Fatalf("failed to encode test image: %v", err)
+		}
+
+		if _, err := EmbedMessage(buf.Bytes(), []byte("x")); !errors.Is(err, ErrImageTooSmall) {
+			t.Errorf("%dx%d: expected ErrImageTooSmall from embedding, got %v", size, size, err)
+		}
+		if _, err := ExtractMessageDCT(buf.Bytes()); !errors.Is(err, ErrImageTooSmall) {
+			t.Errorf("%dx%d: expected ErrImageTooSmall from extraction, got %v", size, size, err)
+		}
+	}
+}
+
+func TestEvaluateRobustness(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	evaluate := func(t *testing.T, delta float64, degradation Degradation) *RobustnessReport {
+		t.Helper()
+		opts, err := NewEmbedOptions(WithDelta(delta))
+		if err != nil {
+			t.Fatalf("NewEmbedOptions failed: %v", err)
+		}
+		report, err := EvaluateRobustness(buf.Bytes(), opts, degradation)
+		if err != nil {
+			t.Fatalf("EvaluateRobustness failed: %v", err)
+		}
+		if report.BitsCompared == 0 || report.MessageBytes == 0 {
+			t.Fatalf("expected bits to be compared, got %+v", report)
+		}
+		return report
+	}
+
+	// Higher Delta must lower the bit error rate under the same noise
+	noise := GaussianNoise{Sigma: 4, Seed: 1}
+	weak, strong := evaluate(t, 2, noise), evaluate(t, 40, noise)
+	if weak.BitErrors == 0 {
+		t.Fatalf("expected noise to flip some weakly embedded bits, got %+v", weak)
+	}
+	if strong.BitErrorRate >= weak.BitErrorRate {
+		t.Errorf("expected higher Delta to lower BER: %.4f (delta 2) vs %.4f (delta 40)", weak.BitErrorRate, strong.BitErrorRate)
+	}
+	if !strong.Survived || strong.Err != nil {
+		t.Errorf("expected the strongly embedded message to survive noise, got %+v", strong)
+	}
+
+	// A mild JPEG re-encode keeps a strongly embedded message
+	jpeg := evaluate(t, 40, JPEGRecompression{Quality: 95})
+	if !jpeg.Survived {
+		t.Errorf("expected the message to survive JPEG quality 95, got %+v", jpeg)
+	}
+
+	// Blur smears the carrier coefficients far more than it does DC
+	blur := evaluate(t, 10, GaussianBlur{Sigma: 2})
+	if blur.BitErrorRate <= 0 {
+		t.Errorf("expected blur to flip bits, got %+v", blur)
+	}
+}
+
+func TestEmbedExtractDCT_SpreadSpectrumSurvivesNoise(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	noise := GaussianNoise{Sigma: 12, Seed: 1}
+
+	comparison, err := EvaluateRobustness(buf.Bytes(), DefaultEmbedOptions(), noise)
+	if err != nil {
+		t.Fatalf("EvaluateRobustness failed: %v", err)
+	}
+	if comparison.Survived {
+		t.Fatalf("expected the noise to break comparison mode, got %+v", comparison)
+	}
+
+	opts, err := NewEmbedOptions(WithMode(ModeSpreadSpectrum))
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	spread, err := EvaluateRobustness(buf.Bytes(), opts, noise)
+	if err != nil {
+		t.Fatalf("EvaluateRobustness failed: %v", err)
+	}
+	if !spread.Survived {
+		t.Errorf("expected spread-spectrum to survive the noise, got %+v", spread)
+	}
+	if spread.BitErrorRate >= comparison.BitErrorRate {
+		t.Errorf("expected a lower BER in spread-spectrum mode: %.4f vs %.4f", spread.BitErrorRate, comparison.BitErrorRate)
+	}
+}
+
+func TestEmbedExtractDCT_SpreadSpectrumKeyedBySeed(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("spread thin")
+
+	opts, err := NewEmbedOptions(WithMode(ModeSpreadSpectrum), WithSeed("key"))
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCTWithOptions(embedded, &ExtractOptions{Config: opts.Config})
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	// Comparison mode reads nothing from a spread-spectrum image
+	comparison := opts.Config
+	comparison.Mode = ModeComparison
+	if _, err := ExtractMessageDCTWithOptions(embedded, &ExtractOptions{Config: comparison}); !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic in comparison mode, got %v", err)
+	}
+
+	config := opts.Config
+	config.QuantizationAware = true
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for QuantizationAware spread-spectrum, got %v", err)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	img := createTestImage(256, 256)
+	pngData, err := imgutil.EncodeImage(img, "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage(png) failed: %v", err)
+	}
+	jpegData, err := imgutil.EncodeImage(img, "jpeg", 90)
+	if err != nil {
+		t.Fatalf("EncodeImage(jpeg) failed: %v", err)
+	}
+	webpData, err := imgutil.EncodeImage(img, "webp", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage(webp) failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+	}{
+		{name: "png", data: pngData, format: "png"},
+		{name: "jpeg", data: jpegData, format: "jpeg"},
+		{name: "webp", data: webpData, format: "webp"},
+		// Only the header is read, so a truncated image is still recognized
+		{name: "truncated png", data: pngData[:64], format: "png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := DetectFormat(tt.data)
+			if err != nil {
+				t.Fatalf("DetectFormat failed: %v", err)
+			}
+			if format != tt.format {
+				t.Errorf("expected %q, got %q", tt.format, format)
+			}
+		})
+	}
+
+	for _, blob := range [][]byte{nil, []byte("definitely not an image")} {
+		if _, err := DetectFormat(blob); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("DetectFormat(%q): expected ErrUnsupportedFormat, got %v", blob, err)
+		}
+	}
+	if _, err := DetectFormat([]byte("RIFF\x00\x00\x00\x00WEBPVP8 ")); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("truncated WebP: expected ErrCorruptImage, got %v", err)
+	}
+
+	result, err := EmbedMessageDCTWithResult(jpegData, []byte("hi"), nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult failed: %v", err)
+	}
+	if result.InputFormat != "jpeg" {
+		t.Errorf("expected InputFormat jpeg, got %q", result.InputFormat)
+	}
+}
+
+func TestSubsampleRatio_JPEG420(t *testing.T) {
+	jpegData, err := imgutil.EncodeImage(createTestImage(256, 256), "jpeg", 90)
+	if err != nil {
+		t.Fatalf("EncodeImage(jpeg) failed: %v", err)
+	}
+	img, _, err := imgutil.LoadImage(jpegData)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	if ratio := ycbcr.SubsampleRatio(img); ratio != image.YCbCrSubsampleRatio420 {
+		t.Fatalf("expected 4:2:0 input, got %v", ratio)
+	}
+
+	result, err := EmbedMessageDCTWithResult(jpegData, []byte("chroma"), nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult failed: %v", err)
+	}
+	if result.SubsampleRatio != image.YCbCrSubsampleRatio420 {
+		t.Errorf("expected SubsampleRatio 4:2:0, got %v", result.SubsampleRatio)
+	}
+
+	// The output stays JPEG, with the same chroma subsampling
+	out, format, err := imgutil.LoadImage(result.Output)
+	if err != nil {
+		t.Fatalf("LoadImage(output) failed: %v", err)
+	}
+	if format != "jpeg" {
+		t.Fatalf("expected jpeg output, got %q", format)
+	}
+	if ratio := ycbcr.SubsampleRatio(out); ratio != image.YCbCrSubsampleRatio420 {
+		t.Errorf("expected 4:2:0 output, got %v", ratio)
+	}
+
+	// Formats without subsampling report 4:4:4
+	pngData, err := imgutil.EncodeImage(createTestImage(256, 256), "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage(png) failed: %v", err)
+	}
+	result, err = EmbedMessageDCTWithResult(pngData, []byte("chroma"), nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult failed: %v", err)
+	}
+	if result.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+		t.Errorf("expected SubsampleRatio 4:4:4 for PNG, got %v", result.SubsampleRatio)
+	}
+}
+
+func TestSubsampleRatio_JPEGOutputKeepsRatio(t *testing.T) {
+	message := []byte("full chroma")
+	for _, ratio := range []image.YCbCrSubsampleRatio{image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422} {
+		t.Run(ratio.String(), func(t *testing.T) {
+			jpegData, err := imgutil.EncodeJPEG(createTestImage(256, 256), 95, ratio)
+			if err != nil {
+				t.Fatalf("EncodeJPEG failed: %v", err)
+			}
+			result, err := EmbedMessageDCTWithResult(jpegData, message, nil)
+			if err != nil {
+				t.Fatalf("EmbedMessageDCTWithResult failed: %v", err)
+			}
+			if result.SubsampleRatio != ratio {
+				t.Errorf("expected SubsampleRatio %v, got %v", ratio, result.SubsampleRatio)
+			}
+
+			// The output isn't downsampled to 4:2:0
+			out, _, err := imgutil.LoadImage(result.Output)
+			if err != nil {
+				t.Fatalf("LoadImage(output) failed: %v", err)
+			}
+			if got := ycbcr.SubsampleRatio(out); got != ratio {
+				t.Errorf("expected %v output, got %v", ratio, got)
+			}
+			extracted, err := ExtractMessageDCT(result.Output)
+			if err != nil {
+				t.Fatalf("ExtractMessageDCT failed: %v", err)
+			}
+			if !bytes.Equal(extracted, message) {
+				t.Errorf("expected %q, got %q", message, extracted)
+			}
+		})
+	}
+}
+
+func TestEmbedMessageDCTFill_SurvivesCrop(t *testing.T) {
+	input, err := imgutil.EncodeImage(createTestImage(512, 512), "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+	message := []byte("(c) watermark")
+
+	output, err := EmbedMessageDCTFill(input, message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTFill failed: %v", err)
+	}
+
+	// The first copy sits where a normal frame does
+	extracted, err := ExtractMessageDCT(output)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(extracted, message) {
+		t.Errorf("expected %q, got %q", message, extracted)
+	}
+
+	// Crop away the top half, along the block grid
+	img, _, err := imgutil.LoadImage(output)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	cropped := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(image.Rect(0, 256, 512, 512))
+	croppedData, err := imgutil.EncodeImage(cropped, "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage(cropped) failed: %v", err)
+	}
+
+	extracted, err = ExtractMessageDCTFill(croppedData, nil)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTFill failed on cropped image: %v", err)
+	}
+	if !bytes.Equal(extracted, message) {
+		t.Errorf("expected %q, got %q", message, extracted)
+	}
+
+	// An image without a message yields no copy
+	if _, err := ExtractMessageDCTFill(input, nil); !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic without a message, got %v", err)
+	}
+}
+
+func TestExtractMessageDCTFromReader(t *testing.T) {
+	message := []byte("streamed from disk")
+	for _, format := range []string{"png", "tiff"} {
+		t.Run(format, func(t *testing.T) {
+			input, err := imgutil.EncodeImage(createTestImage(256, 256), format, 0)
+			if err != nil {
+				t.Fatalf("EncodeImage failed: %v", err)
+			}
+			output, err := EmbedMessageDCT(input, message, nil)
+			if err != nil {
+				t.Fatalf("EmbedMessageDCT failed: %v", err)
+			}
+			path := filepath.Join(t.TempDir(), "stego."+format)
+			if err := os.WriteFile(path, output, 0644); err != nil {
+				t.Fatalf("WriteFile failed: %v", err)
+			}
+
+			file, err := os.Open(path)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			defer file.Close()
+			extracted, err := ExtractMessageDCTFromReader(file)
+			if err != nil {
+				t.Fatalf("ExtractMessageDCTFromReader failed: %v", err)
+			}
+			if !bytes.Equal(extracted, message) {
+				t.Errorf("expected %q, got %q", message, extracted)
+			}
+		})
+	}
+
+	// A plain io.Reader (no io.ReaderAt) works too
+	input, err := imgutil.EncodeImage(createTestImage(256, 256), "tiff", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+	output, err := EmbedMessageDCT(input, message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	extracted, err := ExtractMessageDCTFromReader(io.MultiReader(bytes.NewReader(output)))
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTFromReader(io.Reader) failed: %v", err)
+	}
+	if !bytes.Equal(extracted, message) {
+		t.Errorf("expected %q, got %q", message, extracted)
+	}
+
+	webp := []byte("RIFF\x00\x00\x00\x00WEBPVP8 ")
+	if _, err := ExtractMessageDCTFromReader(io.MultiReader(bytes.NewReader(webp))); !errors.Is(err, ErrCorruptImage) {
+		t.Errorf("truncated WebP: expected ErrCorruptImage, got %v", err)
+	}
+}
+
+func TestExtractMessageDCT_CleanImageFailsFast(t *testing.T) {
+	clean, err := imgutil.EncodeImage(createTestImage(512, 512), "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+
+	var passes []int
+	extractPassHook = func(maxBits int) { passes = append(passes, maxBits) }
+	defer func() { extractPassHook = nil }()
+
+	if _, err := ExtractMessageDCT(clean); !errors.Is(err, ErrFrameCorrupt) || !errors.Is(err, ErrInvalidMagic) {
+		t.Fatalf("expected ErrInvalidMagic wrapped in ErrFrameCorrupt, got %v", err)
+	}
+	// One pass over just the blocks covering the candidate headers
+	if len(passes) != 1 {
+		t.Fatalf("expected 1 extraction pass, got %d (%v)", len(passes), passes)
+	}
+	if capacity := (512 / 8) * (512 / 8); passes[0] >= capacity {
+		t.Errorf("expected the pass to stop short of all %d blocks, read %d", capacity, passes[0])
+	}
+
+	// An embedded message still gets its header and full frame passes
+	passes = nil
+	output, err := EmbedMessageDCT(clean, []byte("payload"), nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	if _, err := ExtractMessageDCT(output); err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if len(passes) < 2 {
+		t.Errorf("expected header and frame passes for an embedded message, got %d", len(passes))
+	}
+}
+
+func TestEmbedMessageLSB_RoundTrip(t *testing.T) {
+	input, err := imgutil.EncodeImage(createTestImage(128, 128), "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+
+	// Far more than the 256 DCT blocks of this image could carry
+	message := bytes.Repeat([]byte("lsb "), 100)
+	if fits, err := FitsMessage(input, message, nil); err != nil || fits {
+		t.Fatalf("expected the message not to fit with DCT embedding (fits %v, err %v)", fits, err)
+	}
+
+	hamming := DefaultDCTConfig()
+	hamming.ECC = ECCSchemeHamming74
+	jpegOutput := DefaultDCTConfig()
+	jpegOutput.OutputFormat = "jpeg"
+
+	tests := []struct {
+		name     string
+		opts     *EmbedOptions
+		password string
+	}{
+		{name: "defaults"},
+		{name: "password", opts: &EmbedOptions{Config: DefaultDCTConfig(), Password: "secret"}, password: "secret"},
+		{name: "hamming", opts: &EmbedOptions{Config: hamming}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := EmbedMessageLSB(input, message, tt.opts)
+			if err != nil {
+				t.Fatalf("EmbedMessageLSB failed: %v", err)
+			}
+			if format, _ := DetectFormat(output); format != "png" {
+				t.Errorf("expected png output, got %q", format)
+			}
+			extracted, err := ExtractMessageLSB(output, &ExtractOptions{Password: tt.password})
+			if err != nil {
+				t.Fatalf("ExtractMessageLSB failed: %v", err)
+			}
+			if !bytes.Equal(extracted, message) {
+				t.Errorf("extracted message doesn't match (%d bytes, want %d)", len(extracted), len(message))
+			}
+		})
+	}
+
+	if _, err := EmbedMessageLSB(input, make([]byte, 128*128/8), nil); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("expected ErrMessageTooLong, got %v", err)
+	}
+	if _, err := EmbedMessageLSB(input, message, &EmbedOptions{Config: jpegOutput}); !errors.Is(err, ErrLossyOutput) {
+		t.Errorf("expected ErrLossyOutput for JPEG output, got %v", err)
+	}
+	if _, err := ExtractMessageLSB(input, nil); !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic for a clean image, got %v", err)
+	}
+}
+
+func TestExtractMessage_DispatchesOnMethod(t *testing.T) {
+	input, err := imgutil.EncodeImage(createTestImage(256, 256), "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+	message := []byte("either method")
+
+	dct, err := EmbedMessageDCT(input, message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	lsbOpts := DefaultEmbedOptions()
+	lsbOpts.Password = "secret"
+	lsb, err := EmbedMessageLSB(input, message, lsbOpts)
+	if err != nil {
+		t.Fatalf("EmbedMessageLSB failed: %v", err)
+	}
+
+	for name, data := range map[string][]byte{"dct": dct, "lsb": lsb} {
+		extracted, err := ExtractMessageWithOptions(data, &ExtractOptions{Config: DefaultDCTConfig(), Password: "secret"})
+		if err != nil {
+			t.Fatalf("%s: ExtractMessageWithOptions failed: %v", name, err)
+		}
+		if !bytes.Equal(extracted, message) {
+			t.Errorf("%s: expected %q, got %q", name, message, extracted)
+		}
+	}
+
+	// DCT frames record MethodDCT, as did frames from before the method existed
+	header, err := ExtractHeader(dct)
+	if err != nil {
+		t.Fatalf("ExtractHeader failed: %v", err)
+	}
+	if header.Method != MethodDCT {
+		t.Errorf("expected MethodDCT, got %d", header.Method)
+	}
+
+	if _, err := ExtractMessage(lsb); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("expected ErrPasswordRequired for the encrypted LSB message, got %v", err)
+	}
+	if _, err := ExtractMessage(input); !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic for a clean image, got %v", err)
+	}
+}
+
+func TestRounding_LumaReducesYError(t *testing.T) {
+	// Random mid-range colors, so every rounding case occurs but embedding
+	// never pushes a channel out of range (clipping isn't a rounding error)
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(64 + rng.IntN(128))
+		if i%4 == 3 {
+			img.Pix[i] = 255
+		}
+	}
+	yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(img)
+
+	// Embedding leaves the Y plane fractional, which is where rounding to
+	// 8-bit RGB loses precision
+	bits := make([]bool, (yPlane.Width/8)*(yPlane.Height/8))
+	for i := range bits {
+		bits[i] = rng.IntN(2) == 1
+	}
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, DefaultDCTConfig(), 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+
+	maxYError := func(rounding Rounding) float64 {
+		out := ycbcr.YCbCrPlanesToImageRounded(yPlane, cbPlane, crPlane, ColorSpaceBT601, rounding)
+		y2, _, _ := ycbcr.ImageToYCbCrPlanes(out)
+		var worst float64
+		for i, v := range yPlane.Pix {
+			worst = max(worst, math.Abs(v-y2.Pix[i]))
+		}
+		return worst
+	}
+	halfUp, halfEven, luma := maxYError(RoundHalfUp), maxYError(RoundHalfEven), maxYError(RoundLuma)
+	t.Logf("max Y error: half-up %.4f, half-even %.4f, luma %.4f", halfUp, halfEven, luma)
+	if halfEven > 0.5 || halfUp > 0.5 {
+		t.Errorf("nearest rounding should keep Y within half a level, got %.4f / %.4f", halfUp, halfEven)
+	}
+	if luma >= halfUp/2 {
+		t.Errorf("expected RoundLuma to at least halve the max Y error, got %.4f vs %.4f", luma, halfUp)
+	}
+
+	// Embedding with RoundLuma still round-trips
+	data, err := imgutil.EncodeImage(createTestImage(256, 256), "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+	output, err := EmbedMessage(data, []byte("rounded"), WithRounding(RoundLuma))
+	if err != nil {
+		t.Fatalf("EmbedMessage failed: %v", err)
+	}
+	if extracted, err := ExtractMessageDCT(output); err != nil || string(extracted) != "rounded" {
+		t.Errorf("expected %q, got %q (err %v)", "rounded", extracted, err)
+	}
+	if _, err := NewEmbedOptions(WithRounding(RoundLuma + 1)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for an unknown rounding, got %v", err)
+	}
+}
+
+func TestEmbedFileDCT_RestoresNameAndBytes(t *testing.T) {
+	dir := t.TempDir()
+	cover, err := imgutil.EncodeImage(createTestImage(512, 512), "png", 0)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+	coverPath := filepath.Join(dir, "cover.png")
+	if err := os.WriteFile(coverPath, cover, 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	payload := make([]byte, 64)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	payloadPath := filepath.Join(dir, "secret.bin")
+	if err := os.WriteFile(payloadPath, payload, 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	modTime := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(payloadPath, modTime, modTime); err != nil {
+		t.Fatalf("Chtimes failed: %v", err)
+	}
+
+	stegoPath := filepath.Join(dir, "stego.png")
+	opts := DefaultEmbedOptions()
+	opts.Metadata = map[string]string{"author": "me"}
+	if err := EmbedFileDCT(coverPath, stegoPath, payloadPath, opts); err != nil {
+		t.Fatalf("EmbedFileDCT failed: %v", err)
+	}
+	if len(opts.Metadata) != 1 {
+		t.Errorf("expected the caller's metadata to be left alone, got %v", opts.Metadata)
+	}
+
+	outDir := t.TempDir()
+	restored, err := ExtractFileDCT(stegoPath, outDir)
+	if err != nil {
+		t.Fatalf("ExtractFileDCT failed: %v", err)
+	}
+	if restored != filepath.Join(outDir, "secret.bin") {
+		t.Errorf("expected the file restored as secret.bin, got %s", restored)
+	}
+	got, err := os.ReadFile(restored)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Error("restored bytes don't match the payload")
+	}
+	if info, err := os.Stat(restored); err != nil || !info.ModTime().Equal(modTime) {
+		t.Errorf("expected modification time %v, got %v (err %v)", modTime, info.ModTime(), err)
+	}
+
+	// A plain message has no filename to restore
+	plainPath := filepath.Join(dir, "plain.png")
+	if err := EmbedMessageDCTFile(coverPath, plainPath, payload, nil); err != nil {
+		t.Fatalf("EmbedMessageDCTFile failed: %v", err)
+	}
+	if _, err := ExtractFileDCT(plainPath, outDir); !errors.Is(err, ErrNoFilename) {
+		t.Errorf("expected ErrNoFilename, got %v", err)
+	}
+}
+
+func TestEmbedMessageDCTAuto_PicksStrongestFittingScheme(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	info, err := GetCapacityInfoFromData(buf.Bytes(), ECCSchemeHamming74)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoFromData failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		message  []byte
+		expected ECCScheme
+	}{
+		{name: "small", message: []byte("short"), expected: ECCSchemeRepetition5},
+		// Fills Hamming(7,4), so every stronger scheme is too long
+		{name: "large", message: bytes.Repeat([]byte{'x'}, info.MaxPayloadBytes), expected: ECCSchemeHamming74},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			embedded, err := EmbedMessageDCTAuto(buf.Bytes(), tt.message, nil)
+			if err != nil {
+				t.Fatalf("EmbedMessageDCTAuto failed: %v", err)
+			}
+			header, err := ExtractHeader(embedded)
+			if err != nil {
+				t.Fatalf("ExtractHeader failed: %v", err)
+			}
+			if ECCScheme(header.ECCScheme) != tt.expected {
+				t.Errorf("expected scheme %d, got %d", tt.expected, header.ECCScheme)
+			}
+			extracted, err := ExtractMessageDCT(embedded)
+			if err != nil {
+				t.Fatalf("ExtractMessageDCT failed: %v", err)
+			}
+			if !bytes.Equal(tt.message, extracted) {
+				t.Errorf("message mismatch")
+			}
+		})
+	}
+
+	// The signature counts towards the measured frame: a signed message
+	// filling Hamming(7,4) still just fits it
+	key := []byte("auto key")
+	opts, err := NewEmbedOptions(WithHMACKey(key))
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	signed := bytes.Repeat([]byte{'x'}, info.MaxPayloadBytes-hmacSize)
+	embedded, err := EmbedMessageDCTAuto(buf.Bytes(), signed, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTAuto (signed) failed: %v", err)
+	}
+	result, err := ExtractMessageDCTWithResult(embedded, &ExtractOptions{Config: DefaultDCTConfig(), HMACKey: key})
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithResult (signed) failed: %v", err)
+	}
+	if !bytes.Equal(signed, result.Message) || !result.Authenticated {
+		t.Errorf("expected the authenticated message, got authenticated %v", result.Authenticated)
+	}
+	if header, err := ExtractHeader(embedded); err != nil {
+		t.Fatalf("ExtractHeader (signed) failed: %v", err)
+	} else if ECCScheme(header.ECCScheme) != ECCSchemeHamming74 {
+		t.Errorf("expected scheme %d, got %d", ECCSchemeHamming74, header.ECCScheme)
+	}
+
+	tooLong := bytes.Repeat([]byte{'x'}, 4*info.MaxPayloadBytes)
+	if _, err := EmbedMessageDCTAuto(buf.Bytes(), tooLong, nil); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("expected ErrMessageTooLong, got %v", err)
+	}
+}
+
+func TestExtractMessageDCTUnsafe_ReturnsPayloadOnCRCMismatch(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("a mostly intact message")
+
+	const corruptByte = 2
+	embedded := embedCorruptPayload(t, buf.Bytes(), message, corruptByte)
+
+	if _, err := ExtractMessageDCT(embedded); !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("expected ErrCRCMismatch, got %v", err)
+	}
+
+	payload, crcOK, err := ExtractMessageDCTUnsafe(embedded)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTUnsafe failed: %v", err)
+	}
+	if crcOK {
+		t.Error("expected crcOK to be false")
+	}
+	expected := bytes.Clone(message)
+	expected[corruptByte] ^= 0x80
+	if !bytes.Equal(expected, payload) {
+		t.Errorf("expected %q, got %q", expected, payload)
+	}
+
+	// An intact message is reported as verified
+	intact, err := EmbedMessageDCT(buf.Bytes(), message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	payload, crcOK, err = ExtractMessageDCTUnsafe(intact)
+	if err != nil || !crcOK || !bytes.Equal(message, payload) {
+		t.Errorf("expected verified %q, got %q (crcOK %v, err %v)", message, payload, crcOK, err)
+	}
+}
+
+// embedCorruptPayload embeds message into cover with every repetition-3 copy
+// of the top bit of payload byte index flipped, so ECC can't correct it and
+// the payload CRC fails
+func embedCorruptPayload(t *testing.T, cover, message []byte, index int) []byte {
+	t.Helper()
+	ctx := context.Background()
+	opts := DefaultEmbedOptions()
+	e, err := newEmbedding(ctx, cover, opts)
+	if err != nil {
+		t.Fatalf("newEmbedding failed: %v", err)
+	}
+	it, _, err := e.frameBits(message, 0, opts.Config)
+	if err != nil {
+		t.Fatalf("frameBits failed: %v", err)
+	}
+	bits := bitstream.Collect(it)
+	bit := preambleBits + 3*8*(framing.HeaderSize+index)
+	for i := 0; i < 3; i++ {
+		bits[bit+i] = !bits[bit+i]
+	}
+	if err := embedBitsIntoDCT(ctx, []*ycbcr.Plane{e.y}, bits, opts.Config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+	embedded, err := e.encode()
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	return embedded
+}
+
+func TestExtractOptions_SkipCRC(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("crc-failing message")
+	embedded := embedCorruptPayload(t, buf.Bytes(), message, 0)
+
+	opts := DefaultExtractOptions()
+	if _, err := ExtractMessageDCTWithOptions(embedded, opts); !errors.Is(err, ErrCRCMismatch) {
+		t.Fatalf("without SkipCRC: expected ErrCRCMismatch, got %v", err)
+	}
+
+	opts.SkipCRC = true
+	extracted, err := ExtractMessageDCTWithOptions(embedded, opts)
+	if err != nil {
+		t.Fatalf("with SkipCRC: ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	expected := bytes.Clone(message)
+	expected[0] ^= 0x80
+	if !bytes.Equal(expected, extracted) {
+		t.Errorf("expected %q, got %q", expected, extracted)
+	}
+}
+
+func TestCalibrateDelta_HitsTargetPSNR(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	const target = 40.0
+	delta, err := CalibrateDelta(buf.Bytes(), target)
+	if err != nil {
+		t.Fatalf("CalibrateDelta failed: %v", err)
+	}
+
+	// A message filling the capacity distorts about as much as calibration assumed
+	info, err := GetCapacityInfoFromData(buf.Bytes(), ECCSchemeRepetition3)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoFromData failed: %v", err)
+	}
+	message := make([]byte, info.MaxPayloadBytes)
+	rng := rand.New(rand.NewPCG(3, 4))
+	for i := range message {
+		message[i] = byte(rng.IntN(256))
+	}
+	opts := DefaultEmbedOptions()
+	opts.Config.Delta = delta
+	embedded, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	stego, _, err := imgutil.LoadImage(embedded)
+	if err != nil {
+		t.Fatalf("LoadImage failed: %v", err)
+	}
+	psnr, _, err := MeasureDistortion(img, stego)
+	if err != nil {
+		t.Fatalf("MeasureDistortion failed: %v", err)
+	}
+	if math.Abs(psnr-target) > 1 {
+		t.Errorf("Delta %g gave PSNR %.2f dB, expected within 1 dB of %.0f", delta, psnr, target)
+	}
+
+	if _, err := CalibrateDelta(buf.Bytes(), 200); !errors.Is(err, ErrTargetUnreachable) {
+		t.Errorf("expected ErrTargetUnreachable, got %v", err)
+	}
+}
+
+func TestImageToYCbCrPlanes_FastPathsMatchGeneric(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 37, 21))
+	for i := range nrgba.Pix {
+		nrgba.Pix[i] = byte(rng.IntN(256))
+		if i%4 == 3 {
+			nrgba.Pix[i] = 255
+		}
+	}
+	palette := make(color.Palette, 200)
+	for i := range palette {
+		palette[i] = color.RGBA{byte(rng.IntN(256)), byte(rng.IntN(256)), byte(rng.IntN(256)), 255}
+	}
+	paletted := image.NewPaletted(image.Rect(0, 0, 37, 21), palette)
+	for i := range paletted.Pix {
+		paletted.Pix[i] = byte(rng.IntN(len(palette)))
+	}
+
+	for name, img := range map[string]image.Image{"nrgba": nrgba, "paletted": paletted} {
+		for _, cs := range []ColorSpace{ColorSpaceBT601, ColorSpaceBT709} {
+			// Hiding the concrete type forces the generic At path
+			y, cb, cr, alpha := ycbcr.ImageToYCbCrPlanesWithAlpha(img, cs)
+			gy, gcb, gcr, galpha := ycbcr.ImageToYCbCrPlanesWithAlpha(struct{ image.Image }{img}, cs)
+			if alpha != nil || galpha != nil {
+				t.Errorf("%s: expected no alpha plane for an opaque image", name)
+			}
+			for _, p := range [][2]*ycbcr.Plane{{y, gy}, {cb, gcb}, {cr, gcr}} {
+				if !reflect.DeepEqual(p[0], p[1]) {
+					t.Errorf("%s, color space %d: fast path differs from the generic path", name, cs)
+				}
+			}
+		}
+	}
+
+	// Translucent NRGBA pixels keep their color, not a premultiplied one
+	nrgba.SetNRGBA(0, 0, color.NRGBA{200, 100, 50, 3})
+	y, _, _, alpha := ycbcr.ImageToYCbCrPlanesWithAlpha(nrgba, ColorSpaceBT601)
+	if want := 0.299*200 + 0.587*100 + 0.114*50; math.Abs(y.Pix[0]-want) > 1e-9 {
+		t.Errorf("expected Y %g for a translucent pixel, got %g", want, y.Pix[0])
+	}
+	if alpha == nil || alpha.Pix[0] != 3 {
+		t.Errorf("expected alpha 3, got %v", alpha)
+	}
+}
+
+func TestCarrierMap_WhiteBlocksMatchCapacity(t *testing.T) {
+	// Textured on the left, flat on the right, with partial edge blocks
+	rng := rand.New(rand.NewPCG(7, 8))
+	img := image.NewRGBA(image.Rect(0, 0, 100, 90))
+	for y := 0; y < 90; y++ {
+		for x := 0; x < 100; x++ {
+			v := uint8(128)
+			if x < 50 {
+				v = uint8(rng.IntN(256))
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	opts := DefaultEmbedOptions()
+	opts.Config.Channels = ChannelY | ChannelCb
+	opts.Config.Region = &image.Rectangle{Max: image.Pt(80, 90)}
+	opts.Config.Interleave = true
+	opts.Config.UseAllBlocks = false
+	m, err := CarrierMap(buf.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("CarrierMap failed: %v", err)
+	}
+	if m.Bounds() != image.Rect(0, 0, 100, 180) {
+		t.Fatalf("expected two stacked 100x90 maps, got %v", m.Bounds())
+	}
+
+	levels := map[uint8]int{}
+	for _, v := range m.Pix {
+		levels[v]++
+	}
+	info, err := PlanEmbedding(buf.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("PlanEmbedding failed: %v", err)
+	}
+	if white := levels[carrierMapUsed] / 64; white != info.CapacityBits {
+		t.Errorf("expected %d white blocks, got %d", info.CapacityBits, white)
+	}
+	if levels[carrierMapSkipped] == 0 {
+		t.Error("expected the flat half to be skipped")
+	}
+	if levels[0] == 0 {
+		t.Error("expected blocks outside the region to be black")
+	}
+}
+
+func TestJPEGCoefficients_OnlyCarriersChange(t *testing.T) {
+	img := createTestImage(256, 256)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("coefficients")
+
+	opts, err := NewEmbedOptions(WithJPEGCoefficients())
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	result, err := EmbedMessageDCTWithResult(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult failed: %v", err)
+	}
+
+	before, err := imgutil.ReadJPEGCoefficients(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ReadJPEGCoefficients failed: %v", err)
+	}
+	after, err := imgutil.ReadJPEGCoefficients(result.Output)
+	if err != nil {
+		t.Fatalf("ReadJPEGCoefficients of the output failed: %v", err)
+	}
+
+	// Every coefficient outside the carrier pairs of the blocks written is
+	// bit-identical, as are the chroma components
+	carrier := make(map[[2]int]bool)
+	order, err := blockOrder(1, 256/8, 256/8, opts.Config)
+	if err != nil {
+		t.Fatalf("blockOrder failed: %v", err)
+	}
+	for _, ref := range order[:result.BitsWritten] {
+		for _, pair := range opts.Config.carrierPairs() {
+			for _, k := range pair {
+				carrier[[2]int{ref.by*before.Components[0].BlocksAcross + ref.bx, k}] = true
+			}
+		}
+	}
+	changed := 0
+	for c := range before.Components {
+		for b := range before.Components[c].Blocks {
+			for k := range 64 {
+				if before.Components[c].Blocks[b][k] == after.Components[c].Blocks[b][k] {
+					continue
+				}
+				if c != 0 || !carrier[[2]int{b, k}] {
+					t.Fatalf("component %d block %d coefficient %d changed", c, b, k)
+				}
+				changed++
+			}
+		}
+	}
+	if changed == 0 {
+		t.Error("expected carrier coefficients to change")
+	}
+
+	extracted, err := ExtractMessageDCT(result.Output)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(message, extracted) {
+		t.Errorf("message mismatch: expected %q, got %q", message, extracted)
+	}
+
+	if _, err := NewEmbedOptions(WithJPEGCoefficients(), WithOutputFormat("png")); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for PNG output, got %v", err)
+	}
+}
+
+func TestDiffImage_LightsUpModifiedBlocks(t *testing.T) {
+	cover := createTestImage(64, 64)
+	stego := image.NewRGBA(cover.Bounds())
+	copy(stego.Pix, cover.Pix)
+	// Nudge every pixel of the block at (16, 8) by one level
+	for y := 8; y < 16; y++ {
+		for x := 16; x < 24; x++ {
+			c := stego.RGBAAt(x, y)
+			c.R, c.G, c.B = c.R^1, c.G^1, c.B^1
+			stego.SetRGBA(x, y, c)
+		}
+	}
+
+	diff, err := DiffImage(cover, stego)
+	if err != nil {
+		t.Fatalf("DiffImage failed: %v", err)
+	}
+	if diff.Bounds() != cover.Bounds() {
+		t.Fatalf("expected bounds %v, got %v", cover.Bounds(), diff.Bounds())
+	}
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := diff.RGBAAt(x, y).R
+			inBlock := x >= 16 && x < 24 && y >= 8 && y < 16
+			if inBlock && v == 0 {
+				t.Fatalf("pixel (%d,%d) in the modified block is black", x, y)
+			}
+			if !inBlock && v != 0 {
+				t.Fatalf("pixel (%d,%d) outside the modified block is %d, expected 0", x, y, v)
+			}
+		}
+	}
+
+	if _, err := DiffImage(cover, createTestImage(64, 32)); !errors.Is(err, ErrDimensionMismatch) {
+		t.Errorf("expected ErrDimensionMismatch, got %v", err)
+	}
+}
+
+func TestCapacityInfo_Repetition3Arithmetic(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	info, err := GetCapacityInfoFromData(buf.Bytes(), ECCSchemeRepetition3)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoFromData failed: %v", err)
+	}
+	// 32x32 blocks carry 1024 raw bits; after the 24-bit preamble,
+	// repetition-3 leaves 333 data bits, i.e. 41 whole frame bytes, of which
+	// the 18-byte header leaves 23 for the message
+	if info.RawCapacityBits != 1024 || info.CapacityBits != info.RawCapacityBits {
+		t.Errorf("expected 1024 raw capacity bits, got %d (CapacityBits %d)", info.RawCapacityBits, info.CapacityBits)
+	}
+	if info.EncodedCapacityBits != 41*8 {
+		t.Errorf("expected %d encoded capacity bits, got %d", 41*8, info.EncodedCapacityBits)
+	}
+	if info.OverheadBytes != 18 {
+		t.Errorf("expected 18 overhead bytes, got %d", info.OverheadBytes)
+	}
+	if info.MaxPayloadBytes != 23 {
+		t.Errorf("expected 23 max payload bytes, got %d", info.MaxPayloadBytes)
+	}
+
+	// The boundary is exact: the largest message fits and one more byte doesn't
+	if _, err := EmbedMessageDCT(buf.Bytes(), bytes.Repeat([]byte("x"), info.MaxPayloadBytes), nil); err != nil {
+		t.Errorf("EmbedMessageDCT at MaxPayloadBytes failed: %v", err)
+	}
+	if _, err := EmbedMessageDCT(buf.Bytes(), bytes.Repeat([]byte("x"), info.MaxPayloadBytes+1), nil); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("expected ErrMessageTooLong past MaxPayloadBytes, got %v", err)
+	}
+
+	// Encryption overhead shows up in OverheadBytes
+	plan, err := PlanEmbedding(buf.Bytes(), &EmbedOptions{Config: DefaultDCTConfig(), Password: "secret"})
+	if err != nil {
+		t.Fatalf("PlanEmbedding failed: %v", err)
+	}
+	if plan.OverheadBytes != 18+44 {
+		t.Errorf("expected %d overhead bytes with a password, got %d", 18+44, plan.OverheadBytes)
+	}
+	// The overhead exceeds this image's 41 frame bytes, leaving no room
+	if plan.MaxPayloadBytes != max(plan.EncodedCapacityBits/8-plan.OverheadBytes, 0) {
+		t.Errorf("MaxPayloadBytes %d doesn't match EncodedCapacityBits/8 - OverheadBytes", plan.MaxPayloadBytes)
+	}
+}
+
+func TestEmbedMessageDCT_Deterministic(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("same bytes every time")
+
+	tests := []struct {
+		name string
+		opts []EmbedOption
+	}{
+		{name: "default"},
+		{name: "jpeg", opts: []EmbedOption{WithOutputFormat("jpeg")}},
+		{name: "features", opts: []EmbedOption{
+			WithCompression(CompressionFlate),
+			WithMetadata(map[string]string{"b": "2", "a": "1", "c": "3"}),
+			WithSeed("order"),
+			WithAdaptiveDelta(30),
+			WithDeblock(),
+			WithChannels(ChannelY | ChannelCb),
+		}},
+		{name: "spread spectrum", opts: []EmbedOption{WithMode(ModeSpreadSpectrum)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first []byte
+			// Serial and parallel runs, each repeated, must all agree
+			for _, parallelism := range []int{1, 1, 8, 8} {
+				opts, err := NewEmbedOptions(append(tt.opts, WithParallelism(parallelism))...)
+				if err != nil {
+					t.Fatalf("NewEmbedOptions failed: %v", err)
+				}
+				output, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+				if err != nil {
+					t.Fatalf("EmbedMessageDCT with parallelism %d failed: %v", parallelism, err)
+				}
+				if first == nil {
+					first = output
+				} else if !bytes.Equal(first, output) {
+					t.Fatalf("output with parallelism %d differs from the first run", parallelism)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedOptions_DeterministicEncryption(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(512, 512)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("sealed the same way")
+
+	// A random salt and nonce by default, so the outputs differ
+	random, err := NewEmbedOptions(WithPassword("pw"))
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	first, err := EmbedMessageDCT(buf.Bytes(), message, random)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	second, err := EmbedMessageDCT(buf.Bytes(), message, random)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	if bytes.Equal(first, second) {
+		t.Error("expected different outputs without DeterministicEncryption")
+	}
+
+	deterministic, err := NewEmbedOptions(WithPassword("pw"), WithDeterministicEncryption())
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	first, err = EmbedMessageDCT(buf.Bytes(), message, deterministic)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	second, err = EmbedMessageDCT(buf.Bytes(), message, deterministic)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Error("expected byte-identical outputs with DeterministicEncryption")
+	}
+
+	extracted, err := ExtractMessageDCTWithPassword(first, "pw")
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithPassword failed: %v", err)
+	}
+	if !bytes.Equal(extracted, message) {
+		t.Errorf("expected %q, got %q", message, extracted)
+	}
+}
+
+func TestExtractOptions_AllowPartialCroppedImage(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	// 38 frame bytes take 936 of the 1024 blocks, reaching into row 29
+	message := []byte("cropped off the end!")
+	stego, err := EmbedMessageDCT(buf.Bytes(), message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	opts := DefaultExtractOptions()
+	opts.AllowPartial = true
+	result, err := ExtractMessageDCTWithResult(stego, opts)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithResult on the whole image failed: %v", err)
+	}
+	if result.Partial || !bytes.Equal(result.Message, message) {
+		t.Fatalf("expected the complete message, got %q (partial %v)", result.Message, result.Partial)
+	}
+
+	// Crop off the bottom 4 block rows, leaving 896 blocks
+	img, _, err := imgutil.LoadImage(stego)
+	if err != nil {
+		t.Fatalf("failed to load stego image: %v", err)
+	}
+	cropped := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}).SubImage(image.Rect(0, 0, 256, 224))
+	buf.Reset()
+	if err := png.Encode(&buf, cropped); err != nil {
+		t.Fatalf("failed to encode cropped image: %v", err)
+	}
+
+	if _, err := ExtractMessageDCT(buf.Bytes()); !errors.Is(err, ErrFrameTruncated) {
+		t.Fatalf("expected ErrFrameTruncated without AllowPartial, got %v", err)
+	}
+
+	result, err = ExtractMessageDCTWithResult(buf.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithResult with AllowPartial failed: %v", err)
+	}
+	if !result.Partial {
+		t.Error("expected a partial result")
+	}
+	// (896 - 24) / 3 bits hold 36 frame bytes, 18 of them message
+	if want := message[:18]; !bytes.Equal(result.Message, want) {
+		t.Errorf("expected partial message %q, got %q", want, result.Message)
+	}
+}
+
+func TestExtractMessageDCT_LengthBeyondCapacity(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	// A valid header declaring a 2 MB payload, past the old fixed 1,000,000
+	// byte ceiling: the check is against this image's capacity instead
+	ctx := context.Background()
+	opts := DefaultEmbedOptions()
+	e, err := newEmbedding(ctx, buf.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("newEmbedding failed: %v", err)
+	}
+	planes := []*ycbcr.Plane{e.y}
+	capacityBits, err := e.capacityBits(ctx, planes, opts.Config)
+	if err != nil {
+		t.Fatalf("capacityBits failed: %v", err)
+	}
+	it, _, err := e.encodeFrame(make([]byte, 2_000_000), 0, nil, 0, opts.Config)
+	if err != nil {
+		t.Fatalf("encodeFrame failed: %v", err)
+	}
+	bits := make([]bool, capacityBits)
+	for i := range bits {
+		bits[i], _ = it.NextBit()
+	}
+	if err := embedBitsIntoDCT(ctx, planes, bits, opts.Config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+	stego, err := e.encode()
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	_, err = ExtractMessageDCT(stego)
+	if !errors.Is(err, ErrFrameTruncated) {
+		t.Fatalf("expected ErrFrameTruncated, got %v", err)
+	}
+	if want := fmt.Sprintf("exceeds capacity of %d bits", capacityBits); !strings.Contains(err.Error(), want) {
+		t.Errorf("expected the error to mention %q, got %v", want, err)
+	}
+}
+
+func TestEmbedOptions_PNGSpill(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(512, 512)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	cover := buf.Bytes()
+	// Four times the 151 bytes the DCT capacity holds
+	message := bytes.Repeat([]byte("0123456789abcdefghijklm"), 26)
+
+	if _, err := EmbedMessageDCT(cover, message, nil); !errors.Is(err, ErrMessageTooLong) {
+		t.Fatalf("expected ErrMessageTooLong without PNGSpill, got %v", err)
+	}
+
+	// The HMAC covers the whole payload, spilled part included
+	key := []byte("spill key")
+	opts, err := NewEmbedOptions(WithPNGSpill(), WithMetadata(map[string]string{"k": "v"}), WithHMACKey(key), WithVerify())
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	stego, err := EmbedMessageDCT(cover, message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT with PNGSpill failed: %v", err)
+	}
+	result, err := ExtractMessageDCTWithResult(stego, &ExtractOptions{Config: DefaultDCTConfig(), HMACKey: key})
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithResult failed: %v", err)
+	}
+	if !bytes.Equal(result.Message, message) || !result.Authenticated {
+		t.Errorf("message mismatch: expected authenticated %q, got %q (authenticated %v)", message, result.Message, result.Authenticated)
+	}
+	if want := map[string]string{"k": "v"}; !maps.Equal(result.Metadata, want) {
+		t.Errorf("expected metadata %v without the spill entry, got %v", want, result.Metadata)
+	}
+
+	// Messages that fit don't spill
+	fits, err := EmbedMessageDCT(cover, message[:10], opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT of a short message failed: %v", err)
+	}
+	if _, found, _ := imgutil.ReadPNGText(fits, spillKeyword); found {
+		t.Error("expected no spill chunk for a message that fits")
+	}
+
+	// Re-encoding drops the chunk, leaving only the DCT part
+	img, _, err := imgutil.LoadImage(stego)
+	if err != nil {
+		t.Fatalf("failed to load stego image: %v", err)
+	}
+	buf.Reset()
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to re-encode stego image: %v", err)
+	}
+	if _, err := ExtractMessageDCT(buf.Bytes()); !errors.Is(err, ErrSpillMissing) {
+		t.Errorf("expected ErrSpillMissing after re-encoding, got %v", err)
+	}
+
+	jpegOpts := DefaultEmbedOptions()
+	jpegOpts.PNGSpill = true
+	jpegOpts.Config.OutputFormat = "jpeg"
+	if _, err := EmbedMessageDCT(cover, message, jpegOpts); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for JPEG output, got %v", err)
+	}
+}
+
+func TestEmbedResult_JPEGGapWarning(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	message := []byte("quantized")
+
+	// The default Delta of 10 (gap 15) is below the 8 + 12 steps of the
+	// (2,2)/(2,3) pair at quality 75
+	opts := DefaultEmbedOptions()
+	opts.Config.OutputFormat = "jpg"
+	opts.JPEGQuality = 75
+	result, err := EmbedMessageDCTWithResult(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult failed: %v", err)
+	}
+	if len(result.Warnings) != 1 || !errors.Is(result.Warnings[0], ErrDeltaTooSmallForJPEG) {
+		t.Errorf("expected an ErrDeltaTooSmallForJPEG warning at quality 75, got %v", result.Warnings)
+	}
+
+	quiet := map[string]func(*EmbedOptions){
+		"default quality":    func(o *EmbedOptions) { o.JPEGQuality = 0 },
+		"larger delta":       func(o *EmbedOptions) { o.Config.Delta = 15 },
+		"quantization aware": func(o *EmbedOptions) { o.Config.QuantizationAware = true },
+		"png output":         func(o *EmbedOptions) { o.Config.OutputFormat = "png" },
+	}
+	for name, modify := range quiet {
+		opts := DefaultEmbedOptions()
+		opts.Config.OutputFormat = "jpg"
+		opts.JPEGQuality = 75
+		modify(opts)
+		result, err := EmbedMessageDCTWithResult(buf.Bytes(), message, opts)
+		if err != nil {
+			t.Fatalf("%s: EmbedMessageDCTWithResult failed: %v", name, err)
+		}
+		if len(result.Warnings) != 0 {
+			t.Errorf("%s: expected no warnings, got %v", name, result.Warnings)
+		}
+	}
+}
+
+func TestEmbedOptions_HMACKey(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(512, 512)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	cover := buf.Bytes()
+	key := []byte("shared secret")
+	message := []byte("pay bob")
+
+	opts, err := NewEmbedOptions(WithHMACKey(key))
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+	signed, err := EmbedMessageDCT(cover, message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+
+	// Valid HMAC
+	result, err := ExtractMessageDCTWithResult(signed, &ExtractOptions{Config: DefaultDCTConfig(), HMACKey: key})
+	if err != nil {
+		t.Fatalf("extraction with the key failed: %v", err)
+	}
+	if !bytes.Equal(result.Message, message) || !result.Authenticated {
+		t.Errorf("expected authenticated %q, got %q (authenticated %v)", message, result.Message, result.Authenticated)
+	}
+	// Without a key the message is still readable, but unverified
+	result, err = ExtractMessageDCTWithResult(signed, nil)
+	if err != nil {
+		t.Fatalf("extraction without the key failed: %v", err)
+	}
+	if !bytes.Equal(result.Message, message) || result.Authenticated {
+		t.Errorf("expected unauthenticated %q, got %q (authenticated %v)", message, result.Message, result.Authenticated)
+	}
+
+	// Wrong key
+	_, err = ExtractMessageDCTWithResult(signed, &ExtractOptions{Config: DefaultDCTConfig(), HMACKey: []byte("guess")})
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("wrong key: expected ErrAuthenticationFailed, got %v", err)
+	}
+
+	// Re-embedded without a signature
+	unsigned, err := EmbedMessageDCT(cover, []byte("pay eve"), nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	_, err = ExtractMessageDCTWithResult(unsigned, &ExtractOptions{Config: DefaultDCTConfig(), HMACKey: key})
+	if !errors.Is(err, ErrAuthenticationFailed) {
+		t.Errorf("unsigned: expected ErrAuthenticationFailed, got %v", err)
+	}
+
+	// Frames re-embedded with the original HMAC and fresh CRCs, but with
+	// the payload, metadata or a header field changed, all fail
+	config := DefaultDCTConfig()
+	scheme, err := ecc.GetScheme(config.ECC)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	embedFrame := func(frame []byte) []byte {
+		t.Helper()
+		bits, err := scheme.EncodeFrame(frame)
+		if err != nil {
+			t.Fatalf("EncodeFrame failed: %v", err)
+		}
+		bits = append(encodePreamble(config.ECC), bits...)
+		yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(createTestImage(512, 512))
+		if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+			t.Fatalf("embedBitsIntoDCT failed: %v", err)
+		}
+		var out bytes.Buffer
+		if err := png.Encode(&out, ycbcr.YCbCrPlanesToImage(yPlane, cbPlane, crPlane)); err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+		return out.Bytes()
+	}
+	metadata := map[string]string{"to": "bob"}
+	payload, flags, err := encodePayload(message, opts)
+	if err != nil {
+		t.Fatalf("encodePayload failed: %v", err)
+	}
+	signer := &embedding{opts: opts, metadata: metadata, method: MethodDCT}
+	signedPayload, err := signer.sign(payload, flags, 0, config)
+	if err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	tampered := bytes.Clone(signedPayload)
+	tampered[4] = 'e'
+
+	tests := []struct {
+		name     string
+		payload  []byte
+		metadata map[string]string
+		stream   uint8
+		wantErr  error
+	}{
+		{"untouched", signedPayload, metadata, 0, nil},
+		{"payload", tampered, metadata, 0, ErrAuthenticationFailed},
+		{"metadata value", signedPayload, map[string]string{"to": "eve"}, 0, ErrAuthenticationFailed},
+		{"metadata added", signedPayload, map[string]string{"to": "bob", "cc": "eve"}, 0, ErrAuthenticationFailed},
+		{"metadata removed", signedPayload, nil, 0, ErrAuthenticationFailed},
+		{"stream", signedPayload, metadata, 7, ErrAuthenticationFailed},
+	}
+	for _, tt := range tests {
+		frame, err := framing.BuildFrameWithStream(tt.payload, uint8(config.ECC), flags, tt.metadata, tt.stream)
+		if err != nil {
+			t.Fatalf("%s: BuildFrameWithStream failed: %v", tt.name, err)
+		}
+		result, err := ExtractMessageDCTWithResult(embedFrame(frame), &ExtractOptions{Config: config, HMACKey: key})
+		if tt.wantErr == nil {
+			if err != nil || !result.Authenticated {
+				t.Errorf("%s: expected an authenticated message, got %v", tt.name, err)
+			}
+		} else if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+
+	if _, err := NewEmbedOptions(WithHMACKey(nil)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for an empty key, got %v", err)
+	}
+}
+
+func TestExtractMessageDCT_RepairsHeaderPrefix(t *testing.T) {
+	config := DefaultDCTConfig()
+	message := []byte("known prefix")
+	frame, err := framing.BuildFrame(message, uint8(config.ECC))
+	if err != nil {
+		t.Fatalf("BuildFrame failed: %v", err)
+	}
+	scheme, err := ecc.GetScheme(config.ECC)
+	if err != nil {
+		t.Fatalf("GetScheme failed: %v", err)
+	}
+	bits, err := scheme.EncodeFrame(frame)
+	if err != nil {
+		t.Fatalf("EncodeFrame failed: %v", err)
+	}
+	// Flip two of the three copies of a magic bit and a version bit, which
+	// majority voting then decodes wrong
+	for _, dataBit := range []int{3, 34} {
+		bits[dataBit*3] = !bits[dataBit*3]
+		bits[dataBit*3+1] = !bits[dataBit*3+1]
+	}
+	decoded, err := scheme.DecodeFrame(bits)
+	if err != nil {
+		t.Fatalf("DecodeFrame failed: %v", err)
+	}
+	if _, _, err := framing.ParseHeader(decoded); err == nil {
+		t.Fatal("expected the damaged header to fail parsing without the known prefix")
+	}
+	bits = append(encodePreamble(config.ECC), bits...)
+
+	yPlane, cbPlane, crPlane := ycbcr.ImageToYCbCrPlanes(createTestImage(256, 256))
+	if err := embedBitsIntoDCT(context.Background(), []*ycbcr.Plane{yPlane}, bits, config, 1); err != nil {
+		t.Fatalf("embedBitsIntoDCT failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, ycbcr.YCbCrPlanesToImage(yPlane, cbPlane, crPlane)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	extracted, err := ExtractMessageDCT(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(extracted, message) {
+		t.Errorf("expected %q, got %q", message, extracted)
+	}
+
+	// A cover without a message still has no header to repair
+	buf.Reset()
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	if _, err := ExtractMessageDCT(buf.Bytes()); !errors.Is(err, ErrInvalidMagic) {
+		t.Errorf("expected ErrInvalidMagic without a message, got %v", err)
+	}
+}
+
+func TestDCTConfig_CarrierQualitySurvivesJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createFlatAndTexturedImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+
+	// A gap of 11 is well below the (2,2)/(2,3) steps at quality 60 (13 + 19)
+	evaluate := func(carrierQuality int) *RobustnessReport {
+		opts := DefaultEmbedOptions()
+		opts.Config.OutputFormat = "png"
+		opts.Config.Delta = 6
+		opts.Config.CarrierQuality = carrierQuality
+		report, err := EvaluateRobustness(buf.Bytes(), opts, JPEGRecompression{Quality: 60})
+		if err != nil {
+			t.Fatalf("EvaluateRobustness failed: %v", err)
+		}
+		return report
+	}
+	fixed := evaluate(0)
+	picked := evaluate(60)
+	if picked.BitErrorRate >= fixed.BitErrorRate {
+		t.Errorf("expected fewer bit errors with CarrierQuality 60: %.3f vs %.3f fixed", picked.BitErrorRate, fixed.BitErrorRate)
+	}
+	if !picked.Survived {
+		t.Errorf("expected the message to survive quality 60 with CarrierQuality: %v", picked.Err)
+	}
+
+	// Higher qualities can afford higher, less visible frequencies
+	if low, high := quantCarrierPair(60), quantCarrierPair(90); low == high {
+		t.Errorf("expected different pairs for quality 60 and 90, got %v for both", low)
+	}
+
+	config := DefaultDCTConfig()
+	config.CarrierQuality = 60
+	config.BlockSize = 16
+	if err := config.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for 16x16 blocks, got %v", err)
+	}
+	if _, err := NewEmbedOptions(WithCarrierQuality(101)); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("expected ErrInvalidOption for quality 101, got %v", err)
+	}
+}
+
+func TestEmbedRawDCT_TinyImage(t *testing.T) {
+	// 32x32 is 16 blocks, too few for even the preamble and a frame header
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(32, 32)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	if _, err := EmbedMessageDCT(buf.Bytes(), []byte("x"), nil); !errors.Is(err, ErrMessageTooLong) {
+		t.Fatalf("expected ErrMessageTooLong for a framed message, got %v", err)
+	}
+
+	pattern := []bool{true, false, true, true, false, false, true, false, true, true, true, false, false, true, false, true}
+	output, err := EmbedRawDCT(buf.Bytes(), pattern, nil)
+	if err != nil {
+		t.Fatalf("EmbedRawDCT failed: %v", err)
+	}
+	extracted, err := ExtractRawDCT(output, len(pattern), nil)
+	if err != nil {
+		t.Fatalf("ExtractRawDCT failed: %v", err)
+	}
+	if !reflect.DeepEqual(extracted, pattern) {
+		t.Errorf("expected %v, got %v", pattern, extracted)
+	}
+
+	if _, err := EmbedRawDCT(buf.Bytes(), append(pattern, true), nil); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("expected ErrMessageTooLong for 17 bits, got %v", err)
+	}
+	if _, err := ExtractRawDCT(output, 17, nil); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("expected ErrMessageTooLong extracting 17 bits, got %v", err)
+	}
+
+	// With energy skip, the flat half's blocks carry nothing, so every
+	// block's worth of bits is more than there is
+	var mixed bytes.Buffer
+	if err := png.Encode(&mixed, createFlatAndTexturedImage(32, 32)); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	opts := DefaultExtractOptions()
+	opts.Config.UseAllBlocks = false
+	if _, err := ExtractRawDCT(mixed.Bytes(), 16, opts); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("expected ErrMessageTooLong extracting 16 bits with energy skip, got %v", err)
+	}
+}
+
+func TestEmbedMessageDCTImage_RoundTrip(t *testing.T) {
+	message := []byte("in memory")
+	opts := DefaultEmbedOptions()
+	opts.Verify = true
+	stego, err := EmbedMessageDCTImage(createTestImage(256, 256), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTImage failed: %v", err)
+	}
+	if stego.Bounds() != image.Rect(0, 0, 256, 256) {
+		t.Errorf("expected 256x256 bounds, got %v", stego.Bounds())
+	}
+
+	// Encoding with the caller's own encoder keeps the message
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, stego); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	extracted, err := ExtractMessageDCT(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(extracted, message) {
+		t.Errorf("expected %q, got %q", message, extracted)
+	}
+
+	opts = DefaultEmbedOptions()
+	opts.PNGSpill = true
+	if _, err := EmbedMessageDCTImage(createTestImage(256, 256), message, opts); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig with PNGSpill, got %v", err)
+	}
+}
+
+func TestWithChromaOnly_KeepsLumaPlane(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(256, 256)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	opts, err := NewEmbedOptions(WithChromaOnly(), WithDeblock())
+	if err != nil {
+		t.Fatalf("NewEmbedOptions failed: %v", err)
+	}
+
+	info, err := GetCapacityInfoForConfig(buf.Bytes(), opts.Config)
+	if err != nil {
+		t.Fatalf("GetCapacityInfoForConfig failed: %v", err)
+	}
+	lumaInfo, err := GetCapacityInfoForConfig(buf.Bytes(), DefaultDCTConfig())
+	if err != nil {
+		t.Fatalf("GetCapacityInfoForConfig failed: %v", err)
+	}
+	if info.Channels != 2 || info.RawCapacityBits != 2*lumaInfo.RawCapacityBits {
+		t.Errorf("chroma-only capacity: %d channels, %d bits; want 2 channels, %d bits",
+			info.Channels, info.RawCapacityBits, 2*lumaInfo.RawCapacityBits)
+	}
+
+	// The Y plane must come out of embedding (and Deblock) bit-identical
+	ctx := context.Background()
+	e, err := newEmbedding(ctx, buf.Bytes(), opts)
+	if err != nil {
+		t.Fatalf("newEmbedding failed: %v", err)
+	}
+	before := slices.Clone(e.y.Pix)
+	message := []byte("chroma only, luma untouched")
+	if _, _, err := e.embedFrame(ctx, message, 0, opts.Config); err != nil {
+		t.Fatalf("embedFrame failed: %v", err)
+	}
+	e.image()
+	if !slices.Equal(before, e.y.Pix) {
+		t.Error("Y plane changed by chroma-only embedding")
+	}
+
+	output, err := EmbedMessageDCT(buf.Bytes(), message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCT failed: %v", err)
+	}
+	extracted, err := ExtractMessageDCTWithOptions(output, &ExtractOptions{Config: opts.Config})
+	if err != nil {
+		t.Fatalf("ExtractMessageDCTWithOptions failed: %v", err)
+	}
+	if !bytes.Equal(extracted, message) {
+		t.Errorf("extracted %q, want %q", extracted, message)
+	}
+}
+
+func TestEmbedMessageDCTImage_SubImageOrigin(t *testing.T) {
+	src := createTestImage(300, 300)
+	gray := image.NewGray(src.Bounds())
+	nrgba := image.NewNRGBA(src.Bounds())
+	paletted := image.NewPaletted(src.Bounds(), palette.Plan9)
+	ycc := image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio444)
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 300; x++ {
+			c := src.RGBAAt(x, y)
+			gray.Set(x, y, c)
+			nrgba.Set(x, y, c)
+			paletted.Set(x, y, c)
+			yy, cb, cr := color.RGBToYCbCr(c.R, c.G, c.B)
+			o := ycc.COffset(x, y)
+			ycc.Y[ycc.YOffset(x, y)], ycc.Cb[o], ycc.Cr[o] = yy, cb, cr
+		}
+	}
+	type subImager interface {
+		SubImage(image.Rectangle) image.Image
+	}
+	rect := image.Rect(13, 7, 13+256, 7+256)
+	message := []byte("offset origin")
+	for name, img := range map[string]image.Image{
+		"rgba": src, "gray": gray, "nrgba": nrgba, "paletted": paletted, "ycbcr": ycc,
+	} {
+		t.Run(name, func(t *testing.T) {
+			sub := img.(subImager).SubImage(rect)
+			opts := DefaultEmbedOptions()
+			opts.Verify = true
+			output, err := EmbedMessageDCTImage(sub, message, opts)
+			if err != nil {
+				t.Fatalf("EmbedMessageDCTImage failed: %v", err)
+			}
+			if output.Bounds() != rect {
+				t.Errorf("output bounds %v, want %v", output.Bounds(), rect)
+			}
+			// The stego image read back as a sub-image of a larger canvas
+			canvas := image.NewRGBA(image.Rect(0, 0, 300, 300))
+			draw.Draw(canvas, rect, output, rect.Min, draw.Src)
+			ctx := context.Background()
+			extractOpts := DefaultExtractOptions()
+			planes, capacityBits, err := imagePlanes(ctx, canvas.SubImage(rect), extractOpts)
+			if err != nil {
+				t.Fatalf("imagePlanes failed: %v", err)
+			}
+			result, err := extractFromPlanes(ctx, planes, capacityBits, extractOpts)
+			if err != nil {
+				t.Fatalf("extraction from the sub-image failed: %v", err)
+			}
+			if !bytes.Equal(result.Message, message) {
+				t.Errorf("extracted %q, want %q", result.Message, message)
+			}
+		})
+	}
+}
+
+// namelessMagic starts images of a raw RGBA format whose decoder registers
+// no format name, as some third-party decoders do: the magic, then the
+// width and height as bytes, then the pixels
+const namelessMagic = "EMGRAW"
+
+func decodeNameless(r io.Reader) (image.Image, error) {
+	header := make([]byte, len(namelessMagic)+2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, int(header[len(namelessMagic)]), int(header[len(namelessMagic)+1])))
+	if _, err := io.ReadFull(r, img.Pix); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func TestEmbedMessageDCT_UnknownInputFormat(t *testing.T) {
+	image.RegisterFormat("", namelessMagic, decodeNameless, func(r io.Reader) (image.Config, error) {
+		img, err := decodeNameless(r)
+		if err != nil {
+			return image.Config{}, err
+		}
+		return image.Config{ColorModel: color.RGBAModel, Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}, nil
+	})
+	src := createTestImage(248, 248)
+	input := append([]byte(namelessMagic), 248, 248)
+	input = append(input, src.Pix...)
+	if _, format, err := image.Decode(bytes.NewReader(input)); err != nil || format != "" {
+		t.Fatalf("expected the test decoder to name no format, got %q, %v", format, err)
+	}
+
+	message := []byte("no name")
+	result, err := EmbedMessageDCTWithResult(input, message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult failed: %v", err)
+	}
+	if !slices.ContainsFunc(result.Warnings, func(err error) bool { return errors.Is(err, ErrPNGFallback) }) {
+		t.Errorf("expected an ErrPNGFallback warning, got %v", result.Warnings)
+	}
+	// The fallback isn't the load error of an unrecognized input
+	if slices.ContainsFunc(result.Warnings, func(err error) bool { return errors.Is(err, ErrUnsupportedFormat) }) {
+		t.Errorf("expected no ErrUnsupportedFormat warning, got %v", result.Warnings)
+	}
+	// DetectFormat can't name it either, and says so rather than returning ""
+	if format, err := DetectFormat(input); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat from DetectFormat, got %q, %v", format, err)
+	}
+	// The fallback is a lossless format the message survives
+	if format, err := DetectFormat(result.Output); err != nil || format != "png" {
+		t.Errorf("expected PNG output, got %q, %v", format, err)
+	}
+	extracted, err := ExtractMessageDCT(result.Output)
+	if err != nil {
+		t.Fatalf("ExtractMessageDCT failed: %v", err)
+	}
+	if !bytes.Equal(extracted, message) {
+		t.Errorf("extracted %q, want %q", extracted, message)
+	}
+
+	// An explicit output format is no surprise
+	opts := DefaultEmbedOptions()
+	opts.Config.OutputFormat = "bmp"
+	result, err = EmbedMessageDCTWithResult(input, message, opts)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult with bmp output failed: %v", err)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("expected no warnings with an explicit output format, got %v", result.Warnings)
+	}
+
+	// A known format keeps its name, and so its output format
+	jpegData, err := imgutil.EncodeImage(src, "jpeg", 90)
+	if err != nil {
+		t.Fatalf("EncodeImage failed: %v", err)
+	}
+	result, err = EmbedMessageDCTWithResult(jpegData, message, nil)
+	if err != nil {
+		t.Fatalf("EmbedMessageDCTWithResult with JPEG input failed: %v", err)
+	}
+	if format, _ := DetectFormat(result.Output); format != "jpeg" || len(result.Warnings) != 0 {
+		t.Errorf("JPEG input: got %q output, warnings %v", format, result.Warnings)
+	}
+}
+
+func TestEmbedOptions_PNGCompression(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, createTestImage(512, 512)); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+	message := []byte("same pixels, smaller file")
+
+	sizes := make(map[png.CompressionLevel]int)
+	var pixels image.Image
+	for _, level := range []png.CompressionLevel{png.BestSpeed, png.BestCompression} {
+		output, err := EmbedMessage(buf.Bytes(), message, WithPNGCompression(level))
+		if err != nil {
+			t.Fatalf("EmbedMessage at level %d failed: %v", level, err)
+		}
+		sizes[level] = len(output)
+
+		extracted, err := ExtractMessageDCT(output)
+		if err != nil {
+			t.Fatalf("ExtractMessageDCT at level %d failed: %v", level, err)
+		}
+		if !bytes.Equal(extracted, message) {
+			t.Errorf("level %d: extracted %q, want %q", level, extracted, message)
+		}
+
+		// The level changes only the file, not the stego pixels
+		img, err := png.Decode(bytes.NewReader(output))
+		if err != nil {
+			t.Fatalf("png.Decode failed: %v", err)
+		}
+		if pixels == nil {
+			pixels = img
+		} else if psnr, _, _ := MeasureDistortion(pixels, img); !math.IsInf(psnr, 1) {
+			t.Errorf("pixels differ between compression levels (PSNR %.1f dB)", psnr)
+		}
+	}
+	if sizes[png.BestCompression] >= sizes[png.BestSpeed] {
+		t.Errorf("BestCompression output is %d bytes, not smaller than BestSpeed's %d",
+			sizes[png.BestCompression], sizes[png.BestSpeed])
+	}
+
+	// LSB embedding writes PNG at the requested level too
+	for _, level := range []png.CompressionLevel{png.BestSpeed, png.BestCompression} {
+		opts := DefaultEmbedOptions()
+		opts.PNGCompression = level
+		output, err := EmbedMessageLSB(buf.Bytes(), message, opts)
+		if err != nil {
+			t.Fatalf("EmbedMessageLSB at level %d failed: %v", level, err)
+		}
+		sizes[level] = len(output)
+	}
+	if sizes[png.BestCompression] >= sizes[png.BestSpeed] {
+		t.Errorf("LSB BestCompression output is %d bytes, not smaller than BestSpeed's %d",
+			sizes[png.BestCompression], sizes[png.BestSpeed])
+	}
+
+	opts := DefaultEmbedOptions()
+	opts.PNGCompression = 5
+	if err := opts.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for an unknown level, got %v", err)
+	}
+}
+
+func TestSupportedFormats_AcceptedAsOutputFormat(t *testing.T) {
+	for _, format := range SupportedFormats() {
+		if _, err := NewEmbedOptions(WithOutputFormat(format)); err != nil {
+			t.Errorf("WithOutputFormat(%q) failed: %v", format, err)
+		}
+		config := DefaultDCTConfig()
+		config.OutputFormat = strings.ToUpper(format)
+		if err := config.Validate(); err != nil {
+			t.Errorf("Validate with OutputFormat %q failed: %v", config.OutputFormat, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package emganography
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// filenameKey is the metadata key recording an embedded file's name
+	filenameKey = "emg.filename"
+	// modTimeKey is the metadata key recording an embedded file's
+	// modification time (RFC 3339, UTC)
+	modTimeKey = "emg.mtime"
+)
+
+var (
+	// ErrNoFilename indicates the extracted message wasn't embedded as a file
+	// (see EmbedFileDCT), or its recorded name can't be used as one
+	ErrNoFilename = errors.New("embedded message has no usable filename")
+)
+
+// EmbedFileDCT embeds the contents of payloadFilePath into the cover image at
+// coverPath and writes the result to outputPath, like EmbedMessageDCTFile,
+// recording the file's name and modification time in the frame metadata so
+// ExtractFileDCT can restore it
+// opts is not modified; its Metadata is embedded alongside the file's
+func EmbedFileDCT(coverPath, outputPath, payloadFilePath string, opts *EmbedOptions) error {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+
+	payload, err := os.ReadFile(payloadFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read payload file: %w", err)
+	}
+	info, err := os.Stat(payloadFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to stat payload file: %w", err)
+	}
+
+	fileOpts := *opts
+	fileOpts.Metadata = make(map[string]string, len(opts.Metadata)+2)
+	maps.Copy(fileOpts.Metadata, opts.Metadata)
+	fileOpts.Metadata[filenameKey] = info.Name()
+	fileOpts.Metadata[modTimeKey] = info.ModTime().UTC().Format(time.RFC3339Nano)
+
+	return EmbedMessageDCTFile(coverPath, outputPath, payload, &fileOpts)
+}
+
+// ExtractFileDCT extracts a file embedded with EmbedFileDCT from the image at
+// stegoPath and writes it into outputDir under its original name, restoring
+// its modification time
+// Returns the path of the restored file, or ErrNoFilename if the message
+// wasn't embedded as a file
+func ExtractFileDCT(stegoPath, outputDir string) (string, error) {
+	return ExtractFileDCTWithOptions(stegoPath, outputDir, nil)
+}
+
+// ExtractFileDCTWithOptions is ExtractFileDCT with extraction options (e.g. a
+// password; nil means DefaultExtractOptions)
+// Only the base name of the recorded filename is used, so a crafted name
+// can't write outside outputDir
+func ExtractFileDCTWithOptions(stegoPath, outputDir string, opts *ExtractOptions) (string, error) {
+	data, err := os.ReadFile(stegoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	result, err := ExtractMessageDCTWithResult(data, opts)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(strings.ReplaceAll(result.Metadata[filenameKey], `\`, "/"))
+	if name == "." || name == ".." || name == "/" {
+		return "", ErrNoFilename
+	}
+	path := filepath.Join(outputDir, name)
+	if err := os.WriteFile(path, result.Message, 0644); err != nil {
+		return "", fmt.Errorf("failed to write extracted file: %w", err)
+	}
+
+	// The modification time is best effort: a malformed one is skipped
+	if modTime, err := time.Parse(time.RFC3339Nano, result.Metadata[modTimeKey]); err == nil {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			return "", fmt.Errorf("failed to restore modification time: %w", err)
+		}
+	}
+	return path, nil
+}
@@ -0,0 +1,153 @@
+package emganography
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/tuomas-lb/emganography/internal/bitstream"
+	"github.com/tuomas-lb/emganography/internal/ecc"
+	"github.com/tuomas-lb/emganography/internal/framing"
+)
+
+// EmbedMessageDCTFill embeds message like EmbedMessageDCT, but repeats the
+// whole encoded frame (preamble included) back to back for as many copies as
+// the image's capacity allows, so a complete copy can survive cropping
+// Unlike ECC repetition, which spreads each bit over neighbouring blocks,
+// every copy is independently extractable; see ExtractMessageDCTFill
+// Crop survival relies on the blocks staying in raster order, so it only
+// holds without Seed or Interleave, and for crops along the block grid
+func EmbedMessageDCTFill(input []byte, message []byte, opts *EmbedOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	e, err := newEmbedding(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	config := opts.Config
+	bits, count, err := e.frameBits(message, 0, config)
+	if err != nil {
+		return nil, err
+	}
+	planes := config.carrierPlanes(e.y, e.cb, e.cr)
+	capacityBits, err := e.capacityBits(ctx, planes, config)
+	if err != nil {
+		return nil, err
+	}
+	copies := capacityBits / count
+	if copies == 0 {
+		return nil, ErrMessageTooLong
+	}
+
+	tile := bitstream.Collect(bits)
+	filled := make([]bool, 0, copies*count)
+	for range copies {
+		filled = append(filled, tile...)
+	}
+	err = embedBitsIntoDCTQuantized(ctx, planes, bitstream.NewSliceIterator(filled), len(filled), config, e.quant, opts.OnProgress, e.workers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed bits: %w", err)
+	}
+
+	output, err := e.encode()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Verify {
+		// The first copy starts where a normal frame does
+		if err := verifyEmbedding(ctx, output, message, opts); err != nil {
+			return nil, err
+		}
+	}
+	return output, nil
+}
+
+// ExtractMessageDCTFill extracts a message embedded with EmbedMessageDCTFill
+// from what's left of the image, trying every bit offset into the block
+// order as the start of a copy and returning the first that decodes with a
+// valid header and payload CRC
+// Returns ErrInvalidMagic (wrapped in ErrFrameCorrupt) if no copy was found,
+// or the last error of a copy that was found but failed to decode
+func ExtractMessageDCTFill(input []byte, opts *ExtractOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	ctx := context.Background()
+	planes, capacityBits, err := extractionPlanes(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	soft, err := extractSoftBitsFromDCT(ctx, planes, capacityBits, opts.Config, opts.OnProgress, workerCount(opts.Parallelism))
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for offset := 0; offset+preambleBits <= len(soft); offset++ {
+		header, payload, err := decodeFrameAt(soft, offset, opts)
+		if err == nil {
+			var result *ExtractResult
+			if result, err = extractResult(header, payload, opts); err == nil {
+				return result.Message, nil
+			}
+		}
+		if !errors.Is(err, errHeaderNotFound) {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("%w: %w", ErrFrameCorrupt, ErrInvalidMagic)
+}
+
+// decodeFrameAt decodes the preamble and frame starting offset bits into
+// soft, as extractFrameDCT does from the image (checking the payload CRC
+// unless opts.SkipCRC is set)
+// Returns errHeaderNotFound (wrapped) if no frame header starts there
+func decodeFrameAt(soft []float64, offset int, opts *ExtractOptions) (*framing.Header, []byte, error) {
+	id, ok := decodePreamble(ecc.HardDecisions(soft[offset : offset+preambleBits]))
+	if !ok {
+		return nil, nil, errHeaderNotFound
+	}
+	return decodeFrameWith(soft, offset+preambleBits, id, opts)
+}
+
+// decodeFrameWith decodes the frame starting start bits into soft, just past
+// its preamble, with ECC scheme id, as decodeFrameAt does once the preamble
+// names it
+// Returns errHeaderNotFound (wrapped) if no frame header starts there
+func decodeFrameWith(soft []float64, start int, id ECCScheme, opts *ExtractOptions) (*framing.Header, []byte, error) {
+	header, headerSize, eccScheme, err := decodeHeader(soft, start, id, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	totalFrameBytes := headerSize + int(header.PayloadLength)
+	if totalFrameBytes > (len(soft)-start)/8 {
+		return nil, nil, fmt.Errorf("%w: frame of %d bytes exceeds the remaining bits", errHeaderNotFound, totalFrameBytes)
+	}
+	totalFrameBits, err := encodedBitLength(eccScheme, totalFrameBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+	if start+totalFrameBits > len(soft) {
+		return nil, nil, fmt.Errorf("%w: frame requires %d bits but only %d remain", errHeaderNotFound, totalFrameBits, len(soft)-start)
+	}
+
+	primed, err := withHeaderPrior(eccScheme, id, soft[start:start+totalFrameBits])
+	if err != nil {
+		return nil, nil, err
+	}
+	frameBytes, err := ecc.DecodeSoft(eccScheme, primed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to ECC decode full frame: %w", err)
+	}
+	return parseFrame(frameBytes, opts)
+}
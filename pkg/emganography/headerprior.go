@@ -0,0 +1,184 @@
+package emganography
+
+import (
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/tuomas-lb/emganography/internal/ecc"
+	"github.com/tuomas-lb/emganography/internal/framing"
+)
+
+// maxHeaderRepairBits is the most known header prefix bits that may decode
+// wrong for the prefix to be restored; random bits get about half of them
+// wrong, so they aren't mistaken for a damaged header
+const maxHeaderRepairBits = 4
+
+// headerPriorPadding lists the payload lengths the header is encoded with
+// to find the encoded bits its known prefix fixes: each is encoded with
+// differently filled fields, and the lengths catch schemes whose layout
+// depends on the frame length (e.g. interleaving)
+var headerPriorPadding = []int{0, 0, 1, 3, 16, 47, 100, 255}
+
+// knownHeaderPrefix returns the header bytes every frame encoded with scheme
+// id starts with (magic, version and ECC scheme) and the mask of their bits
+// that are known: every version so far fits in the version byte's low 2 bits
+func knownHeaderPrefix(id ECCScheme) (prefix, mask []byte) {
+	prefix = append([]byte(framing.Magic), 0, uint8(id))
+	mask = []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFC, 0xFF}
+	return prefix, mask
+}
+
+// headerPrefixErrors counts the known prefix bits the decoded header got wrong
+func headerPrefixErrors(header []byte, id ECCScheme) int {
+	prefix, mask := knownHeaderPrefix(id)
+	errs := 0
+	for i := range prefix {
+		if i < len(header) {
+			errs += bits.OnesCount8((header[i] ^ prefix[i]) & mask[i])
+		}
+	}
+	return errs
+}
+
+// headerBits holds the known header bits of one ECC scheme (see
+// knownHeaderBits), found on first use
+type headerBits struct {
+	once      sync.Once
+	positions []int
+	values    []bool
+	err       error
+}
+
+// headerBitsCache caches a *headerBits per built-in ECC scheme, as finding
+// them encodes several frames and every extraction attempt needs them;
+// custom schemes can be registered again under the same ID, so they aren't
+// cached
+var headerBitsCache sync.Map
+
+// knownHeaderBits returns the positions of the encoded frame bits that the
+// known header prefix fixes under scheme, whatever the rest of the frame
+// holds and however long it is, and their values
+// The slices are shared, so callers mustn't modify them
+func knownHeaderBits(scheme ecc.Scheme, id ECCScheme) ([]int, []bool, error) {
+	if !ecc.IsReserved(id) {
+		return findHeaderBits(scheme, id)
+	}
+	b, ok := headerBitsCache.Load(id)
+	if !ok {
+		b, _ = headerBitsCache.LoadOrStore(id, new(headerBits))
+	}
+	cached := b.(*headerBits)
+	cached.once.Do(func() {
+		cached.positions, cached.values, cached.err = findHeaderBits(scheme, id)
+	})
+	return cached.positions, cached.values, cached.err
+}
+
+// findHeaderBits finds the known header bits as knownHeaderBits, uncached
+// For repetition codes these are the copies of the prefix bits; bits mixing
+// in unknown fields (e.g. Reed-Solomon parity) or moving with the frame
+// length are left out, found by encoding differently filled frames
+func findHeaderBits(scheme ecc.Scheme, id ECCScheme) ([]int, []bool, error) {
+	prefix, mask := knownHeaderPrefix(id)
+	rng := rand.New(rand.NewPCG(uint64(id), uint64(len(headerPriorPadding))))
+	var reference []bool
+	var fixed []bool
+	for trial, padding := range headerPriorPadding {
+		frame := make([]byte, framing.HeaderSize+padding)
+		for i := range frame {
+			// All zeros, all ones, then random
+			switch trial {
+			case 0:
+			case 1:
+				frame[i] = 0xFF
+			default:
+				frame[i] = uint8(rng.IntN(256))
+			}
+			if i < len(prefix) {
+				frame[i] = frame[i]&^mask[i] | prefix[i]
+			}
+		}
+		encoded, err := scheme.EncodeFrame(frame)
+		if err != nil {
+			return nil, nil, err
+		}
+		if reference == nil {
+			reference = encoded
+			fixed = make([]bool, len(encoded))
+			for i := range fixed {
+				fixed[i] = true
+			}
+			continue
+		}
+		for i := range fixed {
+			fixed[i] = fixed[i] && i < len(encoded) && encoded[i] == reference[i]
+		}
+	}
+
+	var positions []int
+	var values []bool
+	for i, ok := range fixed {
+		if ok {
+			positions = append(positions, i)
+			values = append(values, reference[i])
+		}
+	}
+	return positions, values, nil
+}
+
+// withHeaderPrior returns a copy of the soft bits of a frame encoded with
+// scheme id whose known header prefix bits (see knownHeaderBits) are set to
+// the known values, as confident as the most confident bit of the header
+func withHeaderPrior(scheme ecc.Scheme, id ECCScheme, soft []float64) ([]float64, error) {
+	positions, values, err := knownHeaderBits(scheme, id)
+	if err != nil {
+		return nil, err
+	}
+	headerBits := 0
+	if len(positions) > 0 {
+		headerBits = min(positions[len(positions)-1]+1, len(soft))
+	}
+	confidence := 1.0
+	for _, v := range soft[:headerBits] {
+		confidence = max(confidence, math.Abs(v))
+	}
+
+	out := append([]float64(nil), soft...)
+	for i, pos := range positions {
+		if pos >= len(out) {
+			break
+		}
+		if values[i] {
+			out[pos] = confidence
+		} else {
+			out[pos] = -confidence
+		}
+	}
+	return out, nil
+}
+
+// repairHeader decodes a header whose known prefix came out with at most
+// maxHeaderRepairBits wrong bits again from its soft bits, with the prefix
+// restored (see withHeaderPrior)
+// Only a header with a header CRC that passes is returned, as nothing else
+// vouches for the repair; ok is false if there was none
+func repairHeader(scheme ecc.Scheme, id ECCScheme, soft []float64, decoded []byte, opts *ExtractOptions) (header *framing.Header, ok bool) {
+	if errs := headerPrefixErrors(decoded, id); errs == 0 || errs > maxHeaderRepairBits {
+		return nil, false
+	}
+	primed, err := withHeaderPrior(scheme, id, soft)
+	if err != nil {
+		return nil, false
+	}
+	frameBytes, err := ecc.DecodeSoft(scheme, primed)
+	if err != nil {
+		return nil, false
+	}
+	header, headerSize, err := opts.parser().ParseHeader(frameBytes)
+	if err != nil || headerSize != framing.HeaderSize || header.ECCScheme != uint8(id) {
+		return nil, false
+	}
+	return header, true
+}
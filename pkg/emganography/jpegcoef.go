@@ -0,0 +1,116 @@
+package emganography
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+)
+
+// validateJPEGCoefficients checks the settings DCTConfig.JPEGCoefficients
+// can't work with: anything that needs the pixels rather than the luma
+// coefficients JPEG stores
+func (c DCTConfig) validateJPEGCoefficients() error {
+	switch {
+	case c.OutputFormat != "" && !isJPEG(c.OutputFormat):
+		return errors.New("needs JPEG output")
+	case c.blockSize() != 8:
+		return errors.New("needs 8x8 blocks")
+	case c.Channels&^ChannelY != 0:
+		return errors.New("needs ChannelY only")
+	case !c.UseAllBlocks:
+		return errors.New("needs UseAllBlocks")
+	case c.Mode != ModeComparison:
+		return errors.New("needs ModeComparison")
+	case c.PadToBlockSize || c.Deblock:
+		return errors.New("can't pad or deblock")
+	}
+	return nil
+}
+
+// embedJPEGCoefficients frames message and embeds it into the quantized
+// luma coefficients of input, a JPEG, leaving every other coefficient as it
+// is (see DCTConfig.JPEGCoefficients)
+// Returns the output JPEG, the number of encoded bits written and the
+// capacity in bits
+func (e *embedding) embedJPEGCoefficients(input []byte, message []byte) ([]byte, int, int, error) {
+	config := e.opts.Config
+	if e.inputFormat != "jpeg" {
+		return nil, 0, 0, fmt.Errorf("%w: JPEGCoefficients needs JPEG input, got %s", ErrInvalidConfig, e.inputFormat)
+	}
+	coefs, err := imgutil.ReadJPEGCoefficients(input)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to read JPEG coefficients: %w", err)
+	}
+
+	// Luma must be at full resolution, so its blocks line up with the
+	// Y plane's blocks that extraction reads
+	luma := &coefs.Components[0]
+	for _, comp := range coefs.Components[1:] {
+		if comp.H > luma.H || comp.V > luma.V {
+			return nil, 0, 0, fmt.Errorf("%w: luma is subsampled", imgutil.ErrUnsupportedJPEG)
+		}
+	}
+	var quant [64]float64
+	for k, q := range luma.Quant {
+		quant[k] = float64(q)
+	}
+	pairs := config.carrierPairs()
+	for _, pair := range pairs {
+		if quant[pair[0]] == 0 || quant[pair[1]] == 0 {
+			return nil, 0, 0, fmt.Errorf("%w: zero quantization step", imgutil.ErrInvalidJPEG)
+		}
+	}
+
+	bits, count, err := e.frameBits(message, 0, config)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	order, err := blockOrder(1, e.y.Width/8, e.y.Height/8, config)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if count > len(order) {
+		return nil, 0, 0, ErrMessageTooLong
+	}
+
+	// Move each carrier pair on the quantization grid (see quantizedPair),
+	// working on the dequantized values so Delta and MinGap keep their scale
+	mask := config.carrierMask()
+	dctBlock := make([]float64, 64)
+	tracker := newProgress(e.opts.OnProgress, count)
+	rowBlocks, reported := e.y.Width/8, 0
+	for i := range count {
+		bit, ok := bits.NextBit()
+		if !ok {
+			return nil, 0, 0, fmt.Errorf("bit stream ended after %d of %d bits", i, count)
+		}
+		ref := order[i]
+		block := &luma.Blocks[ref.by*luma.BlocksAcross+ref.bx]
+		for k := range dctBlock {
+			dctBlock[k] = float64(block[k]) * quant[k]
+		}
+
+		gap := config.MinGap + config.Delta
+		if config.AdaptiveDelta {
+			gap = config.MinGap + config.adaptiveDelta(acEnergy(dctBlock, mask), 8)
+		}
+		embedPairs(dctBlock, pairs, &quant, gap, bit)
+		for _, pair := range pairs {
+			for _, k := range pair {
+				block[k] = int32(math.Round(dctBlock[k] / quant[k]))
+			}
+		}
+		if i+1-reported == rowBlocks || i == count-1 {
+			tracker.add(i + 1 - reported)
+			reported = i + 1
+		}
+	}
+
+	output, err := coefs.Encode()
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to write JPEG coefficients: %w", err)
+	}
+	return output, count, len(order), nil
+}
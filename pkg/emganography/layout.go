@@ -0,0 +1,483 @@
+package emganography
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+	"math/rand/v2"
+	"sync"
+
+	"github.com/tuomas-lb/emganography/internal/dct"
+	"github.com/tuomas-lb/emganography/internal/encryption"
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+	"github.com/tuomas-lb/emganography/internal/ycbcr"
+)
+
+// defaultInterleaveDepth is the interleave depth used when DCTConfig.InterleaveDepth is zero
+const defaultInterleaveDepth = 8
+
+// shuffleSalt is the PBKDF2 salt for the block order key, keeping it
+// distinct from an encryption key derived from the same password
+var shuffleSalt = []byte("emganography block order")
+
+// seedKeyCacheSize bounds seedKeys; past it the cache is emptied, so a
+// process going through many seeds doesn't keep every key
+const seedKeyCacheSize = 64
+
+// seedKeyID identifies a key derived from a Seed with a salt
+type seedKeyID struct {
+	seed, salt string
+}
+
+// seedKeys caches the keys derived from Seed: the block order and spread
+// pattern are rebuilt for every pass and every ECC scheme extraction tries,
+// and PBKDF2 would otherwise dominate them
+var seedKeys struct {
+	sync.Mutex
+	m map[seedKeyID][32]byte
+}
+
+// seedKey returns the key derived from seed and salt with PBKDF2, deriving
+// it only on first use
+func seedKey(seed string, salt []byte) ([32]byte, error) {
+	id := seedKeyID{seed: seed, salt: string(salt)}
+	seedKeys.Lock()
+	key, ok := seedKeys.m[id]
+	seedKeys.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	derived, err := encryption.DeriveKey(seed, salt)
+	if err != nil {
+		return key, err
+	}
+	key = [32]byte(derived)
+	seedKeys.Lock()
+	if seedKeys.m == nil || len(seedKeys.m) >= seedKeyCacheSize {
+		seedKeys.m = make(map[seedKeyID][32]byte)
+	}
+	seedKeys.m[id] = key
+	seedKeys.Unlock()
+	return key, nil
+}
+
+// blockRef identifies an 8x8 block by carrier plane index and block coordinates
+type blockRef struct {
+	plane  int
+	bx, by int
+}
+
+// carrierPlanes returns the planes selected by config.Channels in fill order (Y, Cb, Cr)
+func (c DCTConfig) carrierPlanes(y, cb, cr *ycbcr.Plane) []*ycbcr.Plane {
+	channels := c.Channels
+	if channels == 0 {
+		channels = ChannelY
+	}
+
+	var planes []*ycbcr.Plane
+	if channels&ChannelY != 0 {
+		planes = append(planes, y)
+	}
+	if channels&ChannelCb != 0 {
+		planes = append(planes, cb)
+	}
+	if channels&ChannelCr != 0 {
+		planes = append(planes, cr)
+	}
+	return planes
+}
+
+// carriesLuma reports whether config.Channels includes the Y plane
+func (c DCTConfig) carriesLuma() bool {
+	return c.Channels == 0 || c.Channels&ChannelY != 0
+}
+
+// channelCount returns the number of planes selected by config.Channels
+func (c DCTConfig) channelCount() int {
+	return len(c.carrierPlanes(nil, nil, nil))
+}
+
+// blockOrder returns the blocks of every carrier plane in the order encoded
+// bits are assigned to them: plane by plane, raster order within a plane,
+// then any configured reordering (interleaving, then the Seed shuffle) over
+// the whole sequence
+// Embedding and extraction must both use this so the bit mapping stays in sync
+// Only blocks inside config.Region (see blockRect) are included
+func blockOrder(planeCount, blocksAcross, blocksDown int, config DCTConfig) ([]blockRef, error) {
+	r := config.blockRect(blocksAcross, blocksDown)
+	order := make([]blockRef, 0, planeCount*r.Dx()*r.Dy())
+	for plane := 0; plane < planeCount; plane++ {
+		for by := r.Min.Y; by < r.Max.Y; by++ {
+			for bx := r.Min.X; bx < r.Max.X; bx++ {
+				order = append(order, blockRef{plane: plane, bx: bx, by: by})
+			}
+		}
+	}
+
+	if config.Interleave {
+		order = interleave(order, config.InterleaveDepth)
+	}
+	if config.Seed != "" {
+		if err := shuffle(order, config.Seed); err != nil {
+			return nil, err
+		}
+	}
+
+	return order, nil
+}
+
+// shuffle permutes blocks in place with a Fisher-Yates shuffle driven by a
+// ChaCha8 stream keyed from seed, so the order can't be reproduced without it
+func shuffle(blocks []blockRef, seed string) error {
+	key, err := seedKey(seed, shuffleSalt)
+	if err != nil {
+		return err
+	}
+	src := rand.NewChaCha8(key)
+	for i := len(blocks) - 1; i > 0; i-- {
+		j := uniform(src, uint64(i+1))
+		blocks[i], blocks[j] = blocks[j], blocks[i]
+	}
+	return nil
+}
+
+// uniform returns a uniformly distributed value in [0, n) from src
+// Draws in the top partial range are rejected so no value is favoured
+func uniform(src *rand.ChaCha8, n uint64) uint64 {
+	limit := math.MaxUint64 - math.MaxUint64%n
+	for {
+		if v := src.Uint64(); v < limit {
+			return v % n
+		}
+	}
+}
+
+// blockRect returns the blocks carrying data, in block coordinates, for an
+// image of blocksAcross x blocksDown blocks: all of them, or if Region is
+// set only those lying entirely inside it (the region is snapped inwards
+// to the block grid, and clipped to the image)
+func (c DCTConfig) blockRect(blocksAcross, blocksDown int) image.Rectangle {
+	all := image.Rect(0, 0, blocksAcross, blocksDown)
+	if c.Region == nil {
+		return all
+	}
+	return c.regionBlocks().Intersect(all)
+}
+
+// regionBlocks returns the blocks lying entirely inside Region, in block
+// coordinates, before clipping to an image; Region must be set
+func (c DCTConfig) regionBlocks() image.Rectangle {
+	n := c.blockSize()
+	return image.Rect(
+		(c.Region.Min.X+n-1)/n, (c.Region.Min.Y+n-1)/n,
+		c.Region.Max.X/n, c.Region.Max.Y/n,
+	)
+}
+
+// checkImageSize returns ErrImageTooSmall if a width x height image doesn't
+// fit a single block, which would leave nothing to embed into or extract
+// from, and ErrInvalidConfig if Region covers none of its whole blocks
+func (c DCTConfig) checkImageSize(width, height int) error {
+	n := c.blockSize()
+	if width < n || height < n {
+		return fmt.Errorf("%w: %dx%d image, %dx%d blocks", ErrImageTooSmall, width, height, n, n)
+	}
+	if c.Region != nil && c.blockRect(width/n, height/n).Empty() {
+		return fmt.Errorf("%w: Region contains no whole blocks of the %dx%d image", ErrInvalidConfig, width, height)
+	}
+	return nil
+}
+
+// capacityBits returns the number of blocks carrying data, i.e. the raw
+// capacity in bits, for a width x height image with the given channel count
+func (c DCTConfig) capacityBits(width, height, channels int) int {
+	n := c.blockSize()
+	r := c.blockRect(width/n, height/n)
+	return imgutil.CapacityBits(r.Dx()*n, r.Dy()*n, channels, n)
+}
+
+// interleave deals consecutive entries round-robin into depth contiguous
+// stripes of the block sequence, so neighbouring bits land roughly
+// len(blocks)/depth blocks apart and a local edit only touches one stripe
+func interleave(blocks []blockRef, depth int) []blockRef {
+	if depth <= 0 {
+		depth = defaultInterleaveDepth
+	}
+	if depth > len(blocks) {
+		depth = len(blocks)
+	}
+	if depth <= 1 {
+		return blocks
+	}
+
+	stripeLen := (len(blocks) + depth - 1) / depth
+	result := make([]blockRef, 0, len(blocks))
+	for offset := 0; offset < stripeLen; offset++ {
+		for stripe := 0; stripe < depth; stripe++ {
+			idx := stripe*stripeLen + offset
+			if idx < len(blocks) {
+				result = append(result, blocks[idx])
+			}
+		}
+	}
+	return result
+}
+
+// defaultEnergyThreshold is the AC energy below which blocks are skipped when
+// DCTConfig.EnergyThreshold is zero
+const defaultEnergyThreshold = 40.0
+
+// energyMargin is how far a block's energy must be from the threshold for
+// the skip decision to survive embedding. Only the carrier coefficients are
+// changed, and they're excluded from the energy, but rounding pixels back to
+// 8 bits moves the energy of a block by up to 4 (the L2 norm of 64 errors of
+// at most 0.5 each), so the margin leaves room for twice that
+const energyMargin = 8.0
+
+// Block size limits for DCTConfig.BlockSize
+const (
+	defaultBlockSize = 8
+	minBlockSize     = 4
+	maxBlockSize     = 32
+)
+
+// blockSize returns the configured DCT block dimension or the default
+func (c DCTConfig) blockSize() int {
+	if c.BlockSize == 0 {
+		return defaultBlockSize
+	}
+	return c.BlockSize
+}
+
+// energyThreshold returns the configured energy threshold or the default
+func (c DCTConfig) energyThreshold() float64 {
+	if c.EnergyThreshold <= 0 {
+		return defaultEnergyThreshold
+	}
+	return c.EnergyThreshold
+}
+
+// adaptiveReferenceStd is the block texture (pixel standard deviation) at
+// which AdaptiveDelta applies exactly Delta
+const adaptiveReferenceStd = 8.0
+
+// adaptiveDelta returns the adjustment AdaptiveDelta applies to an n x n
+// block with the given acEnergy: Delta scaled by the block's pixel standard
+// deviation relative to adaptiveReferenceStd, capped at MaxDelta
+func (c DCTConfig) adaptiveDelta(energy float64, n int) float64 {
+	maxDelta := c.MaxDelta
+	if maxDelta <= 0 {
+		maxDelta = 4 * c.Delta
+	}
+	// The DCT is orthonormal, so the AC norm is n times the pixel deviation
+	std := energy / float64(n)
+	return min(c.Delta*std/adaptiveReferenceStd, maxDelta)
+}
+
+const (
+	// midBandStart and midBandEnd bound the zig-zag scan positions
+	// CarrierQuality picks from: past the lowest AC coefficients, whose
+	// changes show most, and short of the high frequencies JPEG discards
+	midBandStart = 3
+	midBandEnd   = 21
+	// carrierStepLimit is the largest quantization step CarrierQuality
+	// prefers, half the default gap (MinGap + Delta), so a pair of such
+	// steps stays within it
+	carrierStepLimit = 7.5
+)
+
+// quantCarrierPair returns the row-major carrier pair CarrierQuality picks
+// for JPEG output at quality (see DCTConfig.CarrierQuality)
+func quantCarrierPair(quality int) [2]int {
+	table := imgutil.JPEGLuminanceQuantTable(quality)
+	zigZag := dct.ZigZagOrder()
+	band := zigZag[midBandStart:midBandEnd]
+
+	// The highest frequencies whose steps are fine enough, as they show least
+	var fine []int
+	for i := len(band) - 1; i >= 0 && len(fine) < 2; i-- {
+		if table[band[i]] <= carrierStepLimit {
+			fine = append(fine, band[i])
+		}
+	}
+	if len(fine) == 2 {
+		return [2]int{fine[1], fine[0]}
+	}
+
+	// Otherwise the finest steps, the lower frequency on ties
+	a, b := -1, -1
+	for _, idx := range band {
+		switch {
+		case a < 0 || table[idx] < table[a]:
+			a, b = idx, a
+		case b < 0 || table[idx] < table[b]:
+			b = idx
+		}
+	}
+	return [2]int{a, b}
+}
+
+// validateCarrierQuality checks CarrierQuality's value and that the rest of
+// the configuration can use the pair it picks
+func (c DCTConfig) validateCarrierQuality() error {
+	switch {
+	case c.CarrierQuality < 0 || c.CarrierQuality > 100:
+		return fmt.Errorf("must be in 1-100, got %d", c.CarrierQuality)
+	case c.blockSize() != 8:
+		return fmt.Errorf("needs 8x8 blocks, got %d", c.blockSize())
+	case c.Mode != ModeComparison:
+		return fmt.Errorf("needs ModeComparison")
+	case len(c.CoeffPairs) > 0:
+		return fmt.Errorf("excludes CoeffPairs")
+	}
+	return nil
+}
+
+// carrierMask marks the coefficients of a block embedding modifies: the
+// carrier pairs, or in ModeSpreadSpectrum the spread-spectrum coefficients
+func (c DCTConfig) carrierMask() []bool {
+	n := c.blockSize()
+	mask := make([]bool, n*n)
+	if c.Mode == ModeSpreadSpectrum {
+		for _, idx := range spreadCoeffs(n) {
+			mask[idx] = true
+		}
+		return mask
+	}
+	for _, pair := range c.carrierPairs() {
+		mask[pair[0]] = true
+		mask[pair[1]] = true
+	}
+	return mask
+}
+
+// acEnergy returns the L2 norm of a block's AC coefficients, ignoring the
+// carrier coefficients so that embedding a bit doesn't change the result
+func acEnergy(dctBlock []float64, mask []bool) float64 {
+	var sum float64
+	for i := 1; i < len(dctBlock); i++ {
+		if !mask[i] {
+			sum += dctBlock[i] * dctBlock[i]
+		}
+	}
+	return math.Sqrt(sum)
+}
+
+// blockEnergies returns the acEnergy of each block in order
+func blockEnergies(ctx context.Context, planes []*ycbcr.Plane, order []blockRef, config DCTConfig, workers int) ([]float64, error) {
+	n := config.blockSize()
+	mask := config.carrierMask()
+	blocksAcross := planes[0].Width / n
+	energies := make([]float64, len(order))
+
+	forEachChunk(len(order), workers, func(lo, hi int) {
+		block := make([]float64, n*n)
+		dctBlock := make([]float64, n*n)
+
+		for i := lo; i < hi; i++ {
+			if (i-lo)%blocksAcross == 0 && ctx.Err() != nil {
+				return
+			}
+
+			ref := order[i]
+			loadBlock(planes[ref.plane], n, ref.bx, ref.by, block)
+			dct.DCTNxN(n, block, dctBlock)
+			energies[i] = acEnergy(dctBlock, mask)
+		}
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return energies, nil
+}
+
+// selectExtractBlocks filters order down to the blocks whose energy reaches
+// the threshold, which are the blocks carrying bits when UseAllBlocks is false
+func selectExtractBlocks(ctx context.Context, planes []*ycbcr.Plane, order []blockRef, config DCTConfig, workers int) ([]blockRef, error) {
+	energies, err := blockEnergies(ctx, planes, order, config, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := config.energyThreshold()
+	selected := make([]blockRef, 0, len(order))
+	for i, ref := range order {
+		if energies[i] >= threshold {
+			selected = append(selected, ref)
+		}
+	}
+	return selected, nil
+}
+
+// selectEmbedBlocks picks the blocks to carry n bits when UseAllBlocks is
+// false. Blocks clearly above the threshold are used and blocks clearly below
+// it are skipped; blocks within energyMargin of it would be ambiguous to the
+// extractor, so they're flattened well below the threshold and skipped too
+// Returns fewer than n blocks if the image doesn't have enough usable ones
+func selectEmbedBlocks(ctx context.Context, planes []*ycbcr.Plane, order []blockRef, n int, config DCTConfig, workers int) ([]blockRef, error) {
+	energies, err := blockEnergies(ctx, planes, order, config, workers)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := config.energyThreshold()
+	size := config.blockSize()
+	mask := config.carrierMask()
+	selected := make([]blockRef, 0, n)
+	for i, ref := range order {
+		if len(selected) == n {
+			break
+		}
+		switch {
+		case energies[i] >= threshold+energyMargin:
+			selected = append(selected, ref)
+		case energies[i] >= threshold-energyMargin:
+			flattenBlock(planes[ref.plane], size, ref, mask, max(threshold-2*energyMargin, 0))
+		}
+	}
+	return selected, nil
+}
+
+// usableBlockCount returns how many blocks selectEmbedBlocks can use without
+// flattening, i.e. the capacity in bits when UseAllBlocks is false
+func usableBlockCount(ctx context.Context, planes []*ycbcr.Plane, order []blockRef, config DCTConfig, workers int) (int, error) {
+	energies, err := blockEnergies(ctx, planes, order, config, workers)
+	if err != nil {
+		return 0, err
+	}
+
+	threshold := config.energyThreshold()
+	count := 0
+	for _, energy := range energies {
+		if energy >= threshold+energyMargin {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// flattenBlock scales an n x n block's non-carrier AC coefficients down so
+// its acEnergy becomes target
+func flattenBlock(plane *ycbcr.Plane, n int, ref blockRef, mask []bool, target float64) {
+	block := make([]float64, n*n)
+	dctBlock := make([]float64, n*n)
+	loadBlock(plane, n, ref.bx, ref.by, block)
+	dct.DCTNxN(n, block, dctBlock)
+
+	energy := acEnergy(dctBlock, mask)
+	if energy <= target {
+		return
+	}
+	scale := target / energy
+	for i := 1; i < len(dctBlock); i++ {
+		if !mask[i] {
+			dctBlock[i] *= scale
+		}
+	}
+
+	dct.IDCTNxN(n, dctBlock, block)
+	storeBlock(plane, n, ref.bx, ref.by, block)
+}
@@ -0,0 +1,187 @@
+package emganography
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/tuomas-lb/emganography/internal/framing"
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+)
+
+// Embedding methods, as recorded in Header.Method
+const (
+	// MethodDCT marks a message embedded by EmbedMessageDCT (and frames
+	// written before the method was recorded)
+	MethodDCT = framing.MethodDCT
+	// MethodLSB marks a message embedded by EmbedMessageLSB
+	MethodLSB = framing.MethodLSB
+)
+
+// lsbChannel is the offset of the carrier channel (blue) within an NRGBA pixel
+const lsbChannel = 2
+
+// EmbedMessageLSB embeds a message into the least significant bit of each
+// pixel's blue channel, in raster order: a spatial-domain alternative to
+// EmbedMessageDCT with a capacity of one bit per pixel, but no robustness at
+// all, so the output must be lossless (PNG, BMP, TIFF or WebP)
+// The message is framed and ECC-encoded as for EmbedMessageDCT (ECC,
+// Compression, Checksum, Password, HMACKey and Metadata apply, as does
+// PNGCompression; the other DCTConfig fields don't). JPEG and GIF input is written as PNG unless
+// Config.OutputFormat says otherwise; the output has 8 bits per channel
+// Returns ErrLossyOutput if Config.OutputFormat is JPEG
+func EmbedMessageLSB(input []byte, message []byte, opts *EmbedOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	img, format, err := imgutil.LoadImage(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	outputFormat := opts.Config.OutputFormat
+	switch {
+	case outputFormat != "" && !imgutil.IsLosslessFormat(outputFormat):
+		return nil, ErrLossyOutput
+	case outputFormat == "" && !imgutil.IsLosslessFormat(format):
+		// JPEG, GIF or unknown input
+		outputFormat = "png"
+	case outputFormat == "":
+		outputFormat = format
+	}
+
+	e := &embedding{opts: opts, metadata: opts.Metadata, method: framing.MethodLSB}
+	bits, count, err := e.frameBits(message, 0, opts.Config)
+	if err != nil {
+		return nil, err
+	}
+	pixels := toNRGBA(img)
+	b := pixels.Bounds()
+	if count > b.Dx()*b.Dy() {
+		return nil, ErrMessageTooLong
+	}
+
+	for i := range count {
+		bit, _ := bits.NextBit()
+		o := pixels.PixOffset(b.Min.X+i%b.Dx(), b.Min.Y+i/b.Dx()) + lsbChannel
+		pixels.Pix[o] &^= 1
+		if bit {
+			pixels.Pix[o] |= 1
+		}
+	}
+
+	return imgutil.EncodeImageWithOptions(pixels, outputFormat, imgutil.EncodeOptions{PNGCompression: opts.PNGCompression})
+}
+
+// ExtractMessageLSB extracts a message embedded with EmbedMessageLSB
+// Only the Password and HMACKey of opts are used (nil means DefaultExtractOptions)
+// Returns ErrInvalidMagic (wrapped in ErrFrameCorrupt) if the image carries
+// no LSB message
+func ExtractMessageLSB(input []byte, opts *ExtractOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	img, _, err := imgutil.LoadImage(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	result, err := extractLSB(img, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result.Message, nil
+}
+
+// ExtractMessage extracts a message embedded with any method: frames
+// recorded as MethodLSB in the header are read from the pixel LSBs, anything
+// else is extracted as ExtractMessageDCT does
+func ExtractMessage(data []byte) ([]byte, error) {
+	return ExtractMessageWithOptions(data, nil)
+}
+
+// ExtractMessageWithOptions is ExtractMessage with extraction options (nil
+// means DefaultExtractOptions; the DCT configuration only matters for DCT
+// frames)
+func ExtractMessageWithOptions(data []byte, opts *ExtractOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	img, _, err := imgutil.LoadImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+
+	// Looking for an LSB frame costs a single scan of the pixels, far less
+	// than the DCT, so it goes first
+	result, err := extractLSB(img, opts)
+	if !errors.Is(err, ErrInvalidMagic) {
+		if err != nil {
+			return nil, err
+		}
+		return result.Message, nil
+	}
+
+	if err := opts.Config.validateCoeffs(); err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	planes, capacityBits, err := imagePlanes(ctx, img, opts)
+	if err != nil {
+		return nil, err
+	}
+	result, err = extractFromPlanes(ctx, planes, capacityBits, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result.Message, nil
+}
+
+// extractLSB extracts the frame carried by the blue channel LSBs of img
+// Returns ErrInvalidMagic (wrapped in ErrFrameCorrupt) unless a frame whose
+// header records MethodLSB starts at the first pixel
+func extractLSB(img image.Image, opts *ExtractOptions) (*ExtractResult, error) {
+	// Read the LSBs as full-confidence soft bits, so the frame decodes
+	// exactly as one extracted from DCT coefficients
+	pixels := toNRGBA(img)
+	b := pixels.Bounds()
+	soft := make([]float64, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			soft = append(soft, -1)
+			if pixels.Pix[pixels.PixOffset(x, y)+lsbChannel]&1 == 1 {
+				soft[len(soft)-1] = 1
+			}
+		}
+	}
+	if len(soft) < preambleBits {
+		return nil, fmt.Errorf("%w: %w", ErrFrameCorrupt, ErrInvalidMagic)
+	}
+
+	header, payload, err := decodeFrameAt(soft, 0, opts)
+	if err == nil && header.Method != framing.MethodLSB {
+		err = fmt.Errorf("%w: frame records method %d", errHeaderNotFound, header.Method)
+	}
+	if err != nil {
+		if errors.Is(err, errHeaderNotFound) {
+			return nil, fmt.Errorf("%w: %w (%v)", ErrFrameCorrupt, ErrInvalidMagic, err)
+		}
+		return nil, err
+	}
+	return extractResult(header, payload, opts)
+}
+
+// toNRGBA returns img as non-premultiplied 8-bit RGBA, copying it unless it
+// already is, so each channel's LSB is stored as written
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok {
+		return nrgba
+	}
+	nrgba := image.NewNRGBA(img.Bounds())
+	draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return nrgba
+}
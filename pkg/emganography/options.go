@@ -0,0 +1,390 @@
+package emganography
+
+import (
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+
+	"github.com/tuomas-lb/emganography/internal/ecc"
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+)
+
+var (
+	// ErrInvalidOption indicates an EmbedOption value or combination of options is invalid
+	ErrInvalidOption = errors.New("invalid embed option")
+)
+
+// EmbedOption configures embedding, as an alternative to filling in
+// EmbedOptions by hand (see NewEmbedOptions and EmbedMessage)
+type EmbedOption func(*EmbedOptions) error
+
+// NewEmbedOptions returns DefaultEmbedOptions with the options applied in
+// order, then checks the result as a whole
+// Returns ErrInvalidOption (wrapped) for invalid values or combinations
+func NewEmbedOptions(options ...EmbedOption) (*EmbedOptions, error) {
+	opts := DefaultEmbedOptions()
+	for _, option := range options {
+		if err := option(opts); err != nil {
+			return nil, err
+		}
+	}
+	if err := opts.validateCombination(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+// EmbedMessage embeds a message like EmbedMessageDCT, configured with
+// options instead of an EmbedOptions struct
+func EmbedMessage(input []byte, message []byte, options ...EmbedOption) ([]byte, error) {
+	opts, err := NewEmbedOptions(options...)
+	if err != nil {
+		return nil, err
+	}
+	return EmbedMessageDCT(input, message, opts)
+}
+
+// validateCombination checks the options are valid (see
+// EmbedOptions.Validate) and don't combine settings that can't work together
+func (o *EmbedOptions) validateCombination() error {
+	if err := o.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidOption, err)
+	}
+
+	jpegOutput := isJPEG(o.Config.OutputFormat)
+	if o.Config.QuantizationAware {
+		if !jpegOutput {
+			return fmt.Errorf("%w: quantization-aware embedding needs JPEG output", ErrInvalidOption)
+		}
+		if o.Config.blockSize() != 8 {
+			return fmt.Errorf("%w: quantization-aware embedding needs 8x8 blocks", ErrInvalidOption)
+		}
+	}
+	if jpegOutput && o.Config.Channels&^ChannelY != 0 {
+		// JPEG subsamples chroma, which destroys bits carried in Cb/Cr
+		return fmt.Errorf("%w: chroma channels don't survive JPEG output", ErrInvalidOption)
+	}
+	return nil
+}
+
+// WithECC selects the error correction scheme (built-in or registered)
+func WithECC(scheme ECCScheme) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if _, err := ecc.GetScheme(scheme); err != nil {
+			return fmt.Errorf("%w: ECC scheme %d: %w", ErrInvalidOption, scheme, err)
+		}
+		o.Config.ECC = scheme
+		return nil
+	}
+}
+
+// WithDelta sets the coefficient adjustment magnitude, which must be positive
+func WithDelta(delta float64) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if delta <= 0 {
+			return fmt.Errorf("%w: delta must be positive, got %g", ErrInvalidOption, delta)
+		}
+		o.Config.Delta = delta
+		return nil
+	}
+}
+
+// WithAdaptiveDelta scales the adjustment with each block's texture, capped
+// at maxDelta (0 = 4x Delta; see DCTConfig.AdaptiveDelta)
+func WithAdaptiveDelta(maxDelta float64) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if maxDelta < 0 {
+			return fmt.Errorf("%w: MaxDelta can't be negative, got %g", ErrInvalidOption, maxDelta)
+		}
+		o.Config.AdaptiveDelta = true
+		o.Config.MaxDelta = maxDelta
+		return nil
+	}
+}
+
+// WithMinGap sets the minimum coefficient gap, which can't be negative
+func WithMinGap(gap float64) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if gap < 0 {
+			return fmt.Errorf("%w: minimum gap can't be negative, got %g", ErrInvalidOption, gap)
+		}
+		o.Config.MinGap = gap
+		return nil
+	}
+}
+
+// WithOutputFormat sets the output image format, one of SupportedFormats
+// or an alias (e.g. "jpeg", "tif")
+func WithOutputFormat(format string) EmbedOption {
+	return func(o *EmbedOptions) error {
+		switch {
+		case imgutil.IsSupportedFormat(format):
+		case imgutil.IsGIFFormat(format):
+			return fmt.Errorf("%w: %w", ErrInvalidOption, ErrGIFOutput)
+		default:
+			return fmt.Errorf("%w: unsupported output format %q", ErrInvalidOption, format)
+		}
+		o.Config.OutputFormat = strings.ToLower(format)
+		return nil
+	}
+}
+
+// WithJPEGQuality sets the JPEG quality, which must be in 1-100
+func WithJPEGQuality(quality int) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if quality < 1 || quality > 100 {
+			return fmt.Errorf("%w: JPEG quality must be in 1-100, got %d", ErrInvalidOption, quality)
+		}
+		o.JPEGQuality = quality
+		return nil
+	}
+}
+
+// WithPNGCompression sets the compression level of PNG output (see
+// EmbedOptions.PNGCompression), one of the png package's levels
+func WithPNGCompression(level png.CompressionLevel) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if level < png.BestCompression || level > png.DefaultCompression {
+			return fmt.Errorf("%w: unknown PNG compression level %d", ErrInvalidOption, level)
+		}
+		o.PNGCompression = level
+		return nil
+	}
+}
+
+// WithQuantizationAware aligns embedding to the JPEG quantization grid
+// (see DCTConfig.QuantizationAware); it needs JPEG output
+func WithQuantizationAware() EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.Config.QuantizationAware = true
+		return nil
+	}
+}
+
+// WithJPEGCoefficients embeds into the JPEG's quantized coefficients
+// directly instead of re-encoding its pixels (see DCTConfig.JPEGCoefficients)
+func WithJPEGCoefficients() EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.Config.JPEGCoefficients = true
+		return nil
+	}
+}
+
+// WithChannels selects the carrier planes, which must be a non-empty subset
+// of ChannelY, ChannelCb and ChannelCr
+func WithChannels(channels Channel) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if channels == 0 {
+			return fmt.Errorf("%w: empty channel set", ErrInvalidOption)
+		}
+		if err := validateChannels(channels); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidOption, err)
+		}
+		o.Config.Channels = channels
+		return nil
+	}
+}
+
+// WithChromaOnly embeds into the Cb and Cr planes only, leaving the Y plane
+// untouched, and rounds the output with RoundLuma so its luma stays closest
+// to the original's; the output format must be lossless
+func WithChromaOnly() EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.Config.Channels = ChannelCb | ChannelCr
+		o.Config.Rounding = RoundLuma
+		return nil
+	}
+}
+
+// WithColorSpace selects the RGB <-> YCbCr conversion matrix
+func WithColorSpace(cs ColorSpace) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if err := validateColorSpace(cs); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidOption, err)
+		}
+		o.Config.ColorSpace = cs
+		return nil
+	}
+}
+
+// WithRounding selects how the planes are rounded to 8-bit RGB for the output
+// (see DCTConfig.Rounding)
+func WithRounding(r Rounding) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if r > RoundLuma {
+			return fmt.Errorf("%w: unknown rounding %d", ErrInvalidOption, r)
+		}
+		o.Config.Rounding = r
+		return nil
+	}
+}
+
+// WithBlockSize sets the DCT block dimension (see DCTConfig.BlockSize)
+func WithBlockSize(size int) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if size < minBlockSize || size > maxBlockSize {
+			return fmt.Errorf("%w: %w: %d", ErrInvalidOption, ErrInvalidBlockSize, size)
+		}
+		o.Config.BlockSize = size
+		return nil
+	}
+}
+
+// WithVerify extracts the message back from the output after embedding
+// (see EmbedOptions.Verify)
+func WithVerify() EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.Verify = true
+		return nil
+	}
+}
+
+// WithPadToBlockSize pads the image to whole blocks so its edges carry data
+// too (see DCTConfig.PadToBlockSize)
+func WithPadToBlockSize() EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.Config.PadToBlockSize = true
+		return nil
+	}
+}
+
+// WithDeblock smooths block boundaries after embedding (see DCTConfig.Deblock)
+func WithDeblock() EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.Config.Deblock = true
+		return nil
+	}
+}
+
+// WithProgress reports embedding progress to fn (see EmbedOptions.OnProgress)
+func WithProgress(fn ProgressFunc) EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.OnProgress = fn
+		return nil
+	}
+}
+
+// WithSeed shuffles the block order with a permutation keyed by seed
+// (see DCTConfig.Seed)
+func WithSeed(seed string) EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.Config.Seed = seed
+		return nil
+	}
+}
+
+// WithCompression selects how the message is compressed before framing
+func WithCompression(c Compression) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if err := validateCompression(c); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidOption, err)
+		}
+		o.Config.Compression = c
+		return nil
+	}
+}
+
+// WithChecksum selects the payload CRC algorithm
+func WithChecksum(c Checksum) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if c != ChecksumCRC32 && c != ChecksumCRC32C {
+			return fmt.Errorf("%w: unknown checksum %d", ErrInvalidOption, c)
+		}
+		o.Config.Checksum = c
+		return nil
+	}
+}
+
+// WithMode selects how each bit is embedded (see DCTConfig.Mode)
+func WithMode(m Mode) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if m != ModeComparison && m != ModeSpreadSpectrum {
+			return fmt.Errorf("%w: unknown mode %d", ErrInvalidOption, m)
+		}
+		o.Config.Mode = m
+		return nil
+	}
+}
+
+// WithCarrierQuality picks the carrier pair for JPEG output at quality
+// (1-100) from the luminance quantization table (see
+// DCTConfig.CarrierQuality)
+func WithCarrierQuality(quality int) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if quality < 1 || quality > 100 {
+			return fmt.Errorf("%w: carrier quality must be in 1-100, got %d", ErrInvalidOption, quality)
+		}
+		o.Config.CarrierQuality = quality
+		return nil
+	}
+}
+
+// WithPassword encrypts the message with a key derived from password, which
+// must not be empty
+func WithPassword(password string) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if password == "" {
+			return fmt.Errorf("%w: empty password", ErrInvalidOption)
+		}
+		o.Password = password
+		return nil
+	}
+}
+
+// WithDeterministicEncryption derives the encryption salt and nonce from the
+// message and password (see EmbedOptions.DeterministicEncryption), so
+// password-protected embedding is reproducible
+func WithDeterministicEncryption() EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.DeterministicEncryption = true
+		return nil
+	}
+}
+
+// WithHMACKey signs the message with an HMAC-SHA256 keyed with key (see
+// EmbedOptions.HMACKey), which must not be empty
+func WithHMACKey(key []byte) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if len(key) == 0 {
+			return fmt.Errorf("%w: empty HMAC key", ErrInvalidOption)
+		}
+		o.HMACKey = key
+		return nil
+	}
+}
+
+// WithMetadata adds key-value pairs to the frame's metadata section
+// Later calls add to (and override keys of) earlier ones
+func WithMetadata(metadata map[string]string) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]string, len(metadata))
+		}
+		for k, v := range metadata {
+			o.Metadata[k] = v
+		}
+		return nil
+	}
+}
+
+// WithPNGSpill lets a message too long for the DCT capacity spill its
+// remainder into a PNG metadata chunk (see EmbedOptions.PNGSpill)
+func WithPNGSpill() EmbedOption {
+	return func(o *EmbedOptions) error {
+		o.PNGSpill = true
+		return nil
+	}
+}
+
+// WithParallelism sets the number of goroutines processing blocks
+// (0 = runtime.NumCPU(), 1 = serial)
+func WithParallelism(n int) EmbedOption {
+	return func(o *EmbedOptions) error {
+		if n < 0 {
+			return fmt.Errorf("%w: parallelism can't be negative, got %d", ErrInvalidOption, n)
+		}
+		o.Parallelism = n
+		return nil
+	}
+}
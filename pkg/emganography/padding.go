@@ -0,0 +1,105 @@
+package emganography
+
+import (
+	"fmt"
+	"image"
+	"maps"
+
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+)
+
+// originalSizeKey is the frame metadata key recording the size of an image
+// before DCTConfig.PadToBlockSize padded it, as "WIDTHxHEIGHT"
+const originalSizeKey = "emg.original-size"
+
+// paddedSize returns the size a width x height image is embedded at: rounded
+// up to whole blocks if PadToBlockSize is set, else unchanged
+func (c DCTConfig) paddedSize(width, height int) (int, int) {
+	if !c.PadToBlockSize {
+		return width, height
+	}
+	n := c.blockSize()
+	return (width + n - 1) / n * n, (height + n - 1) / n * n
+}
+
+// paddingMetadata returns the metadata entry recording the original size of
+// a width x height image, or nil if embedding doesn't pad it
+func (c DCTConfig) paddingMetadata(width, height int) map[string]string {
+	if w, h := c.paddedSize(width, height); w == width && h == height {
+		return nil
+	}
+	return map[string]string{originalSizeKey: fmt.Sprintf("%dx%d", width, height)}
+}
+
+// frameMetadata returns the metadata stored in the frame for a width x height
+// image: Metadata, plus the original size if embedding pads the image
+func (o *EmbedOptions) frameMetadata(width, height int) map[string]string {
+	padding := o.Config.paddingMetadata(width, height)
+	if padding == nil {
+		return o.Metadata
+	}
+	metadata := make(map[string]string, len(o.Metadata)+1)
+	maps.Copy(metadata, o.Metadata)
+	maps.Copy(metadata, padding)
+	return metadata
+}
+
+// takeOriginalSize removes the original size entry from metadata and returns
+// it, or the zero Point if there is none (or it's malformed)
+func takeOriginalSize(metadata map[string]string) image.Point {
+	value, ok := metadata[originalSizeKey]
+	if !ok {
+		return image.Point{}
+	}
+	delete(metadata, originalSizeKey)
+
+	var size image.Point
+	if _, err := fmt.Sscanf(value, "%dx%d", &size.X, &size.Y); err != nil || size.X <= 0 || size.Y <= 0 {
+		return image.Point{}
+	}
+	return size
+}
+
+// CropToOriginal extracts the message from an image embedded with
+// DCTConfig.PadToBlockSize to learn its original size, and returns the image
+// cropped back to that size in the same format. Cropping removes the padded
+// edge blocks, so the result no longer carries the message
+// JPEG images are re-encoded at jpegQuality (1-100, 0 = default of 90),
+// keeping their chroma subsampling; other formats are written losslessly.
+// Images that weren't padded are returned unchanged
+func CropToOriginal(data []byte, opts *ExtractOptions, jpegQuality int) ([]byte, error) {
+	if jpegQuality < 0 || jpegQuality > 100 {
+		return nil, fmt.Errorf("%w: JPEG quality must be in 1-100, got %d", ErrInvalidConfig, jpegQuality)
+	}
+	if jpegQuality == 0 {
+		jpegQuality = defaultJPEGQuality
+	}
+	result, err := ExtractMessageDCTWithResult(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	if result.OriginalSize == (image.Point{}) {
+		return data, nil
+	}
+
+	img, format, err := imgutil.LoadImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	bounds := img.Bounds()
+	crop := image.Rectangle{Max: result.OriginalSize}.Add(bounds.Min)
+	if !crop.In(bounds) {
+		return nil, fmt.Errorf("%w: original size %v exceeds the image", ErrFrameCorrupt, result.OriginalSize)
+	}
+
+	sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	})
+	if !ok {
+		return nil, fmt.Errorf("cannot crop %T image", img)
+	}
+	return imgutil.EncodeImageWithOptions(sub.SubImage(crop), format, imgutil.EncodeOptions{
+		Quality:            jpegQuality,
+		JPEGSubsampleRatio: jpegSubsampling(img),
+	})
+}
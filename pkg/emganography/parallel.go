@@ -0,0 +1,72 @@
+package emganography
+
+import (
+	"runtime"
+	"sync"
+)
+
+// workerCount resolves a Parallelism option into a number of goroutines
+// 0 (or negative) means one per CPU
+func workerCount(parallelism int) int {
+	if parallelism <= 0 {
+		return runtime.NumCPU()
+	}
+	return parallelism
+}
+
+// forEachChunk splits [0, n) into contiguous ranges and calls fn for each,
+// running up to workers ranges concurrently
+// fn must only touch state owned by its own range
+func forEachChunk(n, workers int, fn func(lo, hi int)) {
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		fn(0, n)
+		return
+	}
+
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for lo := 0; lo < n; lo += chunk {
+		hi := min(lo+chunk, n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn(lo, hi)
+		}()
+	}
+	wg.Wait()
+}
+
+// ProgressFunc is called as blocks are processed with the number done so
+// far out of total; done only increases, reaching total when the pass completes
+type ProgressFunc func(done, total int)
+
+// progress serializes a ProgressFunc's calls across workers
+type progress struct {
+	mu    sync.Mutex
+	fn    ProgressFunc
+	done  int
+	total int
+}
+
+// newProgress returns a tracker reporting to fn, or nil if fn is nil
+func newProgress(fn ProgressFunc, total int) *progress {
+	if fn == nil {
+		return nil
+	}
+	return &progress{fn: fn, total: total}
+}
+
+// add records n more blocks as done and reports the new count
+// Safe to call concurrently, and on a nil tracker
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done += n
+	p.fn(p.done, p.total)
+}
@@ -0,0 +1,186 @@
+package emganography
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/tuomas-lb/emganography/internal/encryption"
+	"github.com/tuomas-lb/emganography/internal/framing"
+)
+
+// hmacSize is the size of the HMAC-SHA256 ending a signed payload
+const hmacSize = sha256.Size
+
+// encodePayload applies the optional payload transforms before framing
+// Compression runs first, since encrypted bytes don't compress. FlagHMAC is
+// set if HMACKey is, but the HMAC itself is appended by embedding.sign, once
+// the frame it covers is known
+// Returns the bytes to frame and the header flags describing the transforms
+func encodePayload(message []byte, opts *EmbedOptions) ([]byte, uint8, error) {
+	payload := message
+	var flags uint8
+
+	switch opts.Config.Compression {
+	case CompressionNone:
+	case CompressionFlate:
+		compressed, err := deflate(payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to compress payload: %w", err)
+		}
+		payload = compressed
+		flags |= framing.FlagCompressed
+	default:
+		return nil, 0, fmt.Errorf("unsupported compression: %d", opts.Config.Compression)
+	}
+
+	switch opts.Config.Checksum {
+	case ChecksumCRC32:
+	case ChecksumCRC32C:
+		flags |= framing.FlagCRC32C
+	default:
+		return nil, 0, fmt.Errorf("unsupported checksum: %d", opts.Config.Checksum)
+	}
+
+	if opts.Password != "" {
+		encrypt := encryption.Encrypt
+		if opts.DeterministicEncryption {
+			encrypt = encryption.EncryptDeterministic
+		}
+		encrypted, err := encrypt(payload, opts.Password)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to encrypt payload: %w", err)
+		}
+		payload = encrypted
+		flags |= framing.FlagEncrypted
+	}
+
+	if len(opts.HMACKey) > 0 {
+		flags |= framing.FlagHMAC
+	}
+
+	return payload, flags, nil
+}
+
+// sign appends the HMAC (see frameHMAC) to a payload encoded by
+// encodePayload, for framing with e's metadata and method and the given
+// flags, stream and ECC scheme; payloads are returned as is without HMACKey
+func (e *embedding) sign(payload []byte, flags, stream uint8, config DCTConfig) ([]byte, error) {
+	if len(e.opts.HMACKey) == 0 {
+		return payload, nil
+	}
+	header := &framing.Header{
+		Version:   framing.VersionHMAC,
+		ECCScheme: uint8(config.ECC),
+		Flags:     flags,
+		Stream:    stream,
+		Method:    e.method,
+		Metadata:  e.metadata,
+	}
+	tag, err := frameHMAC(header, payload, e.opts.HMACKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(payload[:len(payload):len(payload)], tag...), nil
+}
+
+// frameHMAC returns the HMAC-SHA256, keyed with key, of a signed frame's
+// contents: the header's version, ECC scheme, flags (but FlagMetadata, as
+// the metadata itself is covered), stream and method, the payload length,
+// the metadata section and the payload (without its HMAC, and with any
+// spilled bytes joined back on)
+// The metadata excludes the spill entry, which is only known once the
+// signed payload is split
+func frameHMAC(header *framing.Header, payload, key []byte) ([]byte, error) {
+	metadata, err := framing.EncodeMetadata(header.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	fields := []byte{header.Version, header.ECCScheme, header.Flags &^ framing.FlagMetadata, header.Stream, header.Method}
+	fields = binary.BigEndian.AppendUint32(fields, uint32(len(payload)))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(fields)
+	mac.Write(metadata)
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
+
+// checkHMAC strips the HMAC from the payload of a signed frame (FlagHMAC),
+// verifying it against the frame's header and metadata (see frameHMAC) if
+// opts.HMACKey is set; unsigned payloads are returned as is
+// Returns the payload and whether it was verified
+// Returns ErrAuthenticationFailed if opts.HMACKey is set and the frame is
+// unsigned or its HMAC doesn't match
+func checkHMAC(header *framing.Header, payload []byte, opts *ExtractOptions) ([]byte, bool, error) {
+	if header.Flags&framing.FlagHMAC == 0 {
+		if len(opts.HMACKey) > 0 {
+			// Otherwise re-embedding without a signature would pass
+			return nil, false, fmt.Errorf("%w: message is not signed", ErrAuthenticationFailed)
+		}
+		return payload, false, nil
+	}
+	if len(payload) < hmacSize {
+		return nil, false, fmt.Errorf("%w: payload shorter than its HMAC", ErrFrameCorrupt)
+	}
+	payload, tag := payload[:len(payload)-hmacSize], payload[len(payload)-hmacSize:]
+	if len(opts.HMACKey) == 0 {
+		return payload, false, nil
+	}
+	want, err := frameHMAC(header, payload, opts.HMACKey)
+	if err != nil || !hmac.Equal(tag, want) {
+		return nil, false, ErrAuthenticationFailed
+	}
+	return payload, true, nil
+}
+
+// decodePayload reverses encodePayload using the flags from a CRC-validated frame header
+func decodePayload(header *framing.Header, payload []byte, opts *ExtractOptions) ([]byte, error) {
+	if header.Flags&framing.FlagEncrypted != 0 {
+		if opts.Password == "" {
+			return nil, ErrPasswordRequired
+		}
+		decrypted, err := encryption.Decrypt(payload, opts.Password)
+		if err != nil {
+			return nil, err
+		}
+		payload = decrypted
+	}
+
+	if header.Flags&framing.FlagCompressed != 0 {
+		inflated, err := inflate(payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to decompress payload: %v", ErrFrameCorrupt, err)
+		}
+		payload = inflated
+	}
+
+	return payload, nil
+}
+
+// deflate compresses data with DEFLATE at the best compression level
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// inflate decompresses DEFLATE data
+func inflate(data []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return io.ReadAll(r)
+}
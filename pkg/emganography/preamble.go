@@ -0,0 +1,38 @@
+package emganography
+
+import (
+	"github.com/tuomas-lb/emganography/internal/ecc"
+)
+
+// The preamble is a single byte holding the ECC scheme identifier, embedded
+// ahead of the frame with a fixed code (repetition-3) that extraction always
+// knows. Decoding it first tells extraction which scheme the frame uses, so
+// the header can be read with the right expansion factor
+
+// preambleCode is the fixed code protecting the preamble
+var preambleCode ecc.Scheme = &ecc.Repetition3{}
+
+// preambleBits is the number of blocks the encoded preamble occupies
+var preambleBits = len(encodePreamble(0))
+
+// encodePreamble encodes the ECC scheme identifier with the preamble code
+func encodePreamble(id ECCScheme) []bool {
+	// Repetition-3 encoding can't fail
+	bits, _ := preambleCode.EncodeFrame([]byte{byte(id)})
+	return bits
+}
+
+// decodePreamble recovers the ECC scheme identifier from the preamble bits
+// Returns false if the bits don't decode to a supported scheme
+func decodePreamble(bits []bool) (ECCScheme, bool) {
+	decoded, err := preambleCode.DecodeFrame(bits)
+	if err != nil || len(decoded) < 1 {
+		return 0, false
+	}
+
+	id := ECCScheme(decoded[0])
+	if _, err := ecc.GetScheme(id); err != nil {
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,128 @@
+package emganography
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/tuomas-lb/emganography/internal/ycbcr"
+)
+
+// SSIM stabilizing constants for 8-bit samples: (K1*L)^2 and (K2*L)^2
+const (
+	ssimC1 = (0.01 * 255) * (0.01 * 255)
+	ssimC2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// ssimWindow is the side length of the windows SSIM is averaged over
+const ssimWindow = 8
+
+// diffAmplification scales luma differences in DiffImage, so the one- or
+// two-level changes embedding makes are visible
+const diffAmplification = 32
+
+// MeasureDistortion compares the luma (Y) planes of a cover image and its
+// modified (e.g. stego) version
+// Returns the PSNR in dB (+Inf for identical images) and the mean SSIM over
+// non-overlapping 8x8 windows (1.0 for identical images)
+// Returns ErrDimensionMismatch if the images differ in size
+func MeasureDistortion(original, modified image.Image) (psnr float64, ssim float64, err error) {
+	if original.Bounds().Dx() != modified.Bounds().Dx() || original.Bounds().Dy() != modified.Bounds().Dy() {
+		return 0, 0, ErrDimensionMismatch
+	}
+
+	a, _, _ := ycbcr.ImageToYCbCrPlanes(original)
+	b, _, _ := ycbcr.ImageToYCbCrPlanes(modified)
+	return planePSNR(a, b), planeSSIM(a, b), nil
+}
+
+// DiffImage renders the per-pixel absolute luma (Y) difference between a
+// cover image and its stego version as a grayscale heatmap, amplified by 32
+// and clamped to white, so the blocks embedding changed light up and
+// untouched pixels stay black; useful for tuning Delta
+// The result starts at (0, 0) whatever the inputs' bounds
+// Returns ErrDimensionMismatch if the images differ in size
+func DiffImage(original, stego image.Image) (*image.RGBA, error) {
+	if original.Bounds().Dx() != stego.Bounds().Dx() || original.Bounds().Dy() != stego.Bounds().Dy() {
+		return nil, ErrDimensionMismatch
+	}
+
+	a, _, _ := ycbcr.ImageToYCbCrPlanes(original)
+	b, _, _ := ycbcr.ImageToYCbCrPlanes(stego)
+	diff := image.NewRGBA(image.Rect(0, 0, a.Width, a.Height))
+	for y := 0; y < a.Height; y++ {
+		for x := 0; x < a.Width; x++ {
+			d := math.Abs(a.Pix[y*a.Stride+x]-b.Pix[y*b.Stride+x]) * diffAmplification
+			v := uint8(math.Round(min(d, 255)))
+			diff.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+		}
+	}
+	return diff, nil
+}
+
+// planePSNR returns the peak signal-to-noise ratio between two planes of equal size
+func planePSNR(a, b *ycbcr.Plane) float64 {
+	var sum float64
+	for y := 0; y < a.Height; y++ {
+		for x := 0; x < a.Width; x++ {
+			d := a.Pix[y*a.Stride+x] - b.Pix[y*b.Stride+x]
+			sum += d * d
+		}
+	}
+	if sum == 0 {
+		return math.Inf(1)
+	}
+	mse := sum / float64(a.Width*a.Height)
+	return 10 * math.Log10(255*255/mse)
+}
+
+// planeSSIM returns the mean SSIM of two planes of equal size over
+// non-overlapping windows; edge pixels that don't fill a window are ignored,
+// unless the plane is smaller than one window
+func planeSSIM(a, b *ycbcr.Plane) float64 {
+	winW := min(ssimWindow, a.Width)
+	winH := min(ssimWindow, a.Height)
+	if winW == 0 || winH == 0 {
+		return 1
+	}
+
+	var total float64
+	windows := 0
+	for wy := 0; wy+winH <= a.Height; wy += winH {
+		for wx := 0; wx+winW <= a.Width; wx += winW {
+			total += windowSSIM(a, b, wx, wy, winW, winH)
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// windowSSIM returns the SSIM of the w x h windows at (x0, y0) in both planes
+func windowSSIM(a, b *ycbcr.Plane, x0, y0, w, h int) float64 {
+	n := float64(w * h)
+	var sumA, sumB float64
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			sumA += a.Pix[y*a.Stride+x]
+			sumB += b.Pix[y*b.Stride+x]
+		}
+	}
+	meanA, meanB := sumA/n, sumB/n
+
+	var varA, varB, cov float64
+	for y := y0; y < y0+h; y++ {
+		for x := x0; x < x0+w; x++ {
+			da := a.Pix[y*a.Stride+x] - meanA
+			db := b.Pix[y*b.Stride+x] - meanB
+			varA += da * da
+			varB += db * db
+			cov += da * db
+		}
+	}
+	varA /= n
+	varB /= n
+	cov /= n
+
+	return ((2*meanA*meanB + ssimC1) * (2*cov + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
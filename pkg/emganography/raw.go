@@ -0,0 +1,83 @@
+package emganography
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tuomas-lb/emganography/internal/bitstream"
+	"github.com/tuomas-lb/emganography/internal/ecc"
+)
+
+// EmbedRawDCT embeds bits as they are, one per carrier block in embedding
+// order, below the framing layer: no preamble, header, ECC or CRC, so every
+// block carries caller data. For images too small to hold a frame header,
+// when the bit count and any error correction are agreed out-of-band
+// Only the block layout and embedding fields of opts.Config (e.g. Delta,
+// Seed, Region) and the output options apply; the message options
+// (Compression, Password, Metadata, ...) and JPEGCoefficients don't
+// Returns ErrMessageTooLong if there are more bits than carrier blocks
+func EmbedRawDCT(input []byte, bits []bool, opts *EmbedOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Config.JPEGCoefficients {
+		return nil, fmt.Errorf("%w: JPEGCoefficients needs a frame", ErrInvalidConfig)
+	}
+
+	ctx := context.Background()
+	e, err := newEmbedding(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	planes := opts.Config.carrierPlanes(e.y, e.cb, e.cr)
+	capacityBits, err := e.capacityBits(ctx, planes, opts.Config)
+	if err != nil {
+		return nil, err
+	}
+	if len(bits) > capacityBits {
+		return nil, fmt.Errorf("%w: %d bits, capacity is %d", ErrMessageTooLong, len(bits), capacityBits)
+	}
+
+	err = embedBitsIntoDCTQuantized(ctx, planes, bitstream.NewSliceIterator(bits), len(bits), opts.Config, e.quant, opts.OnProgress, e.workers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed bits: %w", err)
+	}
+	return e.encode()
+}
+
+// ExtractRawDCT reads back numBits bits embedded with EmbedRawDCT, with the
+// same DCT configuration (nil opts means DefaultExtractOptions)
+// Nothing marks where raw bits are, so any image yields bits; checking them
+// is up to the caller
+// Returns ErrMessageTooLong if numBits exceeds the carrier blocks (with
+// UseAllBlocks off, those with enough texture)
+func ExtractRawDCT(input []byte, numBits int, opts *ExtractOptions) ([]bool, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	if numBits < 0 {
+		return nil, fmt.Errorf("%w: negative bit count %d", ErrInvalidConfig, numBits)
+	}
+
+	ctx := context.Background()
+	planes, capacityBits, err := extractionPlanes(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+	if numBits > capacityBits {
+		return nil, fmt.Errorf("%w: %d bits, capacity is %d", ErrMessageTooLong, numBits, capacityBits)
+	}
+
+	soft, err := extractSoftBitsFromDCT(ctx, planes, numBits, opts.Config, opts.OnProgress, workerCount(opts.Parallelism))
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract bits: %w", err)
+	}
+	if len(soft) < numBits {
+		// Skipped low-energy blocks carry nothing, so fewer bits were there
+		return nil, fmt.Errorf("%w: %d bits, only %d blocks carry bits", ErrMessageTooLong, numBits, len(soft))
+	}
+	return ecc.HardDecisions(soft), nil
+}
@@ -0,0 +1,97 @@
+package emganography
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/tuomas-lb/emganography/internal/ecc"
+)
+
+// recoverWindow is how many bits past the preamble ExtractMessageDCTRecover
+// looks for a frame start
+const recoverWindow = 64
+
+// ExtractMessageDCTRecover is a best-effort ExtractMessageDCT for damaged
+// images: if the frame isn't where the preamble says, it tries every bit
+// offset from the start of the stream to recoverWindow bits past the
+// preamble, with the scheme a preamble just before it names and then every
+// registered ECC scheme, and returns the first frame that passes its CRC
+// checks (joined with a PNG spill chunk, if any). This recovers messages
+// whose bit stream gained or lost a few leading bits, e.g. from a slight
+// crop or shift
+func ExtractMessageDCTRecover(data []byte) ([]byte, error) {
+	return ExtractMessageDCTRecoverWithOptions(data, nil)
+}
+
+// ExtractMessageDCTRecoverWithOptions is ExtractMessageDCTRecover with
+// extraction options (the DCT configuration must match the one used for embedding)
+func ExtractMessageDCTRecoverWithOptions(data []byte, opts *ExtractOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	ctx := context.Background()
+	result, err := extractMessageDCT(ctx, data, opts)
+	if err == nil {
+		return result.Message, nil
+	}
+	if !recoverable(err) {
+		return nil, err
+	}
+
+	planes, capacityBits, err := extractionPlanes(ctx, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	if spill := readSpillChunk(data); spill != nil {
+		withSpill := *opts
+		withSpill.spill = spill
+		opts = &withSpill
+	}
+	soft, err := extractSoftBitsFromDCT(ctx, planes, capacityBits, opts.Config, opts.OnProgress, workerCount(opts.Parallelism))
+	if err != nil {
+		return nil, err
+	}
+
+	registered := ecc.RegisteredSchemes()
+	var lastErr error
+	for start := 0; start <= preambleBits+recoverWindow && start < len(soft); start++ {
+		// A preamble just before start names the likeliest scheme; any
+		// other may still follow a damaged one
+		schemes := registered
+		if start >= preambleBits {
+			if id, ok := decodePreamble(ecc.HardDecisions(soft[start-preambleBits : start])); ok && slices.Contains(registered, id) {
+				schemes = append([]ECCScheme{id}, slices.DeleteFunc(slices.Clone(registered), func(s ECCScheme) bool { return s == id })...)
+			}
+		}
+		for _, scheme := range schemes {
+			header, payload, err := decodeFrameWith(soft, start, scheme, opts)
+			if err != nil {
+				// Bits at the wrong offset decode to anything, so any
+				// failure just means no frame starts here
+				if !errors.Is(err, errHeaderNotFound) {
+					lastErr = err
+				}
+				continue
+			}
+			result, err := extractResult(header, payload, opts)
+			if err != nil {
+				return nil, err
+			}
+			return result.Message, nil
+		}
+	}
+
+	if lastErr != nil {
+		return nil, fmt.Errorf("%w: %w: no frame at any offset (%v)", ErrFrameCorrupt, ErrInvalidMagic, lastErr)
+	}
+	return nil, fmt.Errorf("%w: %w: no frame at any offset", ErrFrameCorrupt, ErrInvalidMagic)
+}
+
+// recoverable reports whether an extraction error means the frame may just
+// be somewhere else, so ExtractMessageDCTRecover should keep looking
+func recoverable(err error) bool {
+	return errors.Is(err, ErrFrameCorrupt) || errors.Is(err, ErrCRCMismatch) ||
+		errors.Is(err, ErrInvalidMagic) || errors.Is(err, ErrUnsupportedVersion)
+}
@@ -0,0 +1,220 @@
+package emganography
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+)
+
+// robustnessMessageSize is the size of the random message EvaluateRobustness
+// embeds, when the image has room for it
+const robustnessMessageSize = 64
+
+// robustnessSeed seeds the random message, so evaluations are reproducible
+const robustnessSeed = "emganography robustness message"
+
+// Degradation simulates a lossy channel by transforming an encoded image,
+// e.g. re-encoding it or adding noise
+type Degradation interface {
+	// Degrade returns a degraded copy of the encoded image data
+	Degrade(data []byte) ([]byte, error)
+}
+
+// JPEGRecompression re-encodes the image as JPEG at Quality (1-100)
+type JPEGRecompression struct {
+	Quality int
+}
+
+// Degrade re-encodes data as JPEG at the configured quality
+func (d JPEGRecompression) Degrade(data []byte) ([]byte, error) {
+	img, _, err := imgutil.LoadImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	return imgutil.EncodeImage(img, "jpeg", d.Quality)
+}
+
+// GaussianNoise adds zero-mean gaussian noise with standard deviation Sigma
+// (in 8-bit levels) to each color channel, drawn from a generator seeded
+// with Seed so results are reproducible; the output is PNG
+type GaussianNoise struct {
+	Sigma float64
+	Seed  uint64
+}
+
+// Degrade adds noise to every pixel of data
+func (d GaussianNoise) Degrade(data []byte) ([]byte, error) {
+	rng := rand.New(rand.NewPCG(d.Seed, 0))
+	return mapPixels(data, func(img *image.RGBA) *image.RGBA {
+		for i := range img.Pix {
+			if i%4 == 3 {
+				continue // alpha
+			}
+			img.Pix[i] = clampUint8(float64(img.Pix[i]) + rng.NormFloat64()*d.Sigma)
+		}
+		return img
+	})
+}
+
+// GaussianBlur blurs the image with a gaussian kernel of standard deviation
+// Sigma pixels; the output is PNG
+type GaussianBlur struct {
+	Sigma float64
+}
+
+// Degrade blurs data with a separable gaussian kernel
+func (d GaussianBlur) Degrade(data []byte) ([]byte, error) {
+	radius := int(math.Ceil(3 * d.Sigma))
+	kernel := make([]float64, 2*radius+1)
+	var sum float64
+	for i := range kernel {
+		x := float64(i - radius)
+		kernel[i] = math.Exp(-x * x / (2 * d.Sigma * d.Sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+
+	return mapPixels(data, func(img *image.RGBA) *image.RGBA {
+		b := img.Bounds()
+		// Horizontal pass, then vertical, clamping at the edges
+		for _, step := range []image.Point{{1, 0}, {0, 1}} {
+			out := image.NewRGBA(b)
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					var acc [4]float64
+					for k, w := range kernel {
+						sx := min(max(x+(k-radius)*step.X, b.Min.X), b.Max.X-1)
+						sy := min(max(y+(k-radius)*step.Y, b.Min.Y), b.Max.Y-1)
+						o := img.PixOffset(sx, sy)
+						for c := range acc {
+							acc[c] += w * float64(img.Pix[o+c])
+						}
+					}
+					o := out.PixOffset(x, y)
+					for c, v := range acc {
+						out.Pix[o+c] = clampUint8(v)
+					}
+				}
+			}
+			img = out
+		}
+		return img
+	})
+}
+
+// mapPixels decodes data, applies fn to an RGBA copy of it and encodes the
+// result as PNG
+func mapPixels(data []byte, fn func(*image.RGBA) *image.RGBA) ([]byte, error) {
+	img, _, err := imgutil.LoadImage(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			rgba.Set(x, y, color.RGBAModel.Convert(img.At(x, y)))
+		}
+	}
+	return imgutil.EncodeImage(fn(rgba), "png", 0)
+}
+
+// clampUint8 rounds v to the nearest 8-bit level
+func clampUint8(v float64) uint8 {
+	return uint8(min(max(math.Round(v), 0), 255))
+}
+
+// RobustnessReport is the outcome of EvaluateRobustness
+type RobustnessReport struct {
+	// MessageBytes is the size of the random message embedded
+	MessageBytes int
+	// BitsCompared is the number of embedded bits (preamble and ECC-encoded
+	// frame) compared before and after degradation
+	BitsCompared int
+	// BitErrors is the number of those bits the degradation flipped
+	BitErrors int
+	// BitErrorRate is BitErrors / BitsCompared, the raw error rate the ECC
+	// has to correct
+	BitErrorRate float64
+	// Survived reports whether the message was extracted intact
+	Survived bool
+	// Err is why extraction failed when the message didn't survive
+	Err error
+}
+
+// EvaluateRobustness embeds a random message into data with opts, applies
+// degradation to the output, then extracts it again, reporting the raw bit
+// error rate and whether the message survived. The message is the same for
+// every call (up to 64 bytes, less if the image can't hold that), so
+// evaluations of different parameters are comparable and reproducible
+// Bit errors are counted against the bits read back from the undegraded
+// output; with UseAllBlocks false, degradation can also change which blocks
+// carry bits, which shows up as errors too
+func EvaluateRobustness(data []byte, opts *EmbedOptions, degradation Degradation) (*RobustnessReport, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+
+	plan, err := PlanEmbedding(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	size := min(robustnessMessageSize, plan.MaxPayloadBytes)
+	if size <= 0 {
+		return nil, ErrMessageTooLong
+	}
+	var seed [32]byte
+	copy(seed[:], robustnessSeed)
+	message := make([]byte, size)
+	rand.NewChaCha8(seed).Read(message)
+
+	embedded, err := EmbedMessageDCTWithResult(data, message, opts)
+	if err != nil {
+		return nil, err
+	}
+	degraded, err := degradation.Degrade(embedded.Output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to degrade image: %w", err)
+	}
+
+	extractOpts := &ExtractOptions{
+		Config:      opts.Config,
+		Parallelism: opts.Parallelism,
+		Password:    opts.Password,
+		HMACKey:     opts.HMACKey,
+	}
+	before, _, err := ExtractRawBitsWithOptions(embedded.Output, extractOpts)
+	if err != nil {
+		return nil, err
+	}
+	after, _, err := ExtractRawBitsWithOptions(degraded, extractOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &RobustnessReport{MessageBytes: size, BitsCompared: embedded.BitsWritten}
+	for i := range embedded.BitsWritten {
+		if i >= len(after) || before[i] != after[i] {
+			report.BitErrors++
+		}
+	}
+	report.BitErrorRate = float64(report.BitErrors) / float64(report.BitsCompared)
+
+	extracted, err := ExtractMessageDCTWithOptions(degraded, extractOpts)
+	switch {
+	case err != nil:
+		report.Err = err
+	case !bytes.Equal(extracted, message):
+		report.Err = ErrVerificationFailed
+	default:
+		report.Survived = true
+	}
+	return report, nil
+}
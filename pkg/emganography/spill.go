@@ -0,0 +1,131 @@
+package emganography
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"maps"
+
+	"github.com/tuomas-lb/emganography/internal/ecc"
+	"github.com/tuomas-lb/emganography/internal/framing"
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+)
+
+const (
+	// spillKey is the frame metadata key marking a payload whose remainder
+	// spilled into a PNG chunk (see EmbedOptions.PNGSpill), as the spilled
+	// length and its CRC32, each 8 hex digits, so the entry has a fixed size
+	spillKey = "emg.spill"
+	// spillKeyword is the keyword of the zTXt chunk holding the spilled
+	// bytes, base64-encoded
+	spillKeyword = "emganography"
+)
+
+var (
+	// ErrSpillMissing indicates the message continues in a PNG metadata chunk
+	// (see EmbedOptions.PNGSpill) that is missing or damaged, e.g. because
+	// the image was re-encoded
+	ErrSpillMissing = errors.New("spilled part of the message is missing")
+)
+
+// embedFrameSpilling embeds as much of message's encoded payload as the
+// planes hold, recording the spill in the frame metadata, and returns the
+// rest for the PNG chunk, along with the bits written and the capacity
+func (e *embedding) embedFrameSpilling(ctx context.Context, message []byte) (int, int, []byte, error) {
+	if e.outputFormat != "png" {
+		return 0, 0, nil, fmt.Errorf("%w: PNGSpill needs PNG output, got %q", ErrInvalidConfig, e.outputFormat)
+	}
+	config := e.opts.Config
+	planes := config.carrierPlanes(e.y, e.cb, e.cr)
+	capacityBits, err := e.capacityBits(ctx, planes, config)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	eccScheme, err := ecc.GetScheme(config.ECC)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("failed to get ECC scheme: %w", err)
+	}
+	frameBytes, err := maxFrameBytes(eccScheme, capacityBits-preambleBits)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	payload, flags, err := encodePayload(message, e.opts)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	payload, err = e.sign(payload, flags, 0, config)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	metadata := make(map[string]string, len(e.metadata)+1)
+	maps.Copy(metadata, e.metadata)
+	metadata[spillKey] = fmt.Sprintf("%08x%08x", 0, 0)
+	kept := frameBytes - framing.HeaderSize - framing.MetadataSize(metadata)
+	if kept < 0 {
+		// Not even the header fits
+		return 0, 0, nil, ErrMessageTooLong
+	}
+	kept = min(kept, len(payload))
+	spill := payload[kept:]
+	metadata[spillKey] = fmt.Sprintf("%08x%08x", len(spill), crc32.ChecksumIEEE(spill))
+
+	bits, count, err := e.encodeFrame(payload[:kept], flags, metadata, 0, config)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	err = embedBitsIntoDCTQuantized(ctx, planes, bits, count, config, e.quant, e.opts.OnProgress, e.workers)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, 0, nil, err
+		}
+		return 0, 0, nil, fmt.Errorf("failed to embed bits: %w", err)
+	}
+	return count, capacityBits, spill, nil
+}
+
+// addSpillChunk stores spilled payload bytes in a zTXt chunk of the PNG output
+func addSpillChunk(output, spill []byte) ([]byte, error) {
+	output, err := imgutil.AddPNGText(output, spillKeyword, base64.StdEncoding.EncodeToString(spill))
+	if err != nil {
+		return nil, fmt.Errorf("failed to add spill chunk: %w", err)
+	}
+	return output, nil
+}
+
+// readSpillChunk returns the spilled payload bytes stored in a PNG's zTXt
+// chunk, or nil if input isn't a PNG or has none
+func readSpillChunk(input []byte) []byte {
+	text, found, err := imgutil.ReadPNGText(input, spillKeyword)
+	if err != nil || !found {
+		return nil
+	}
+	spill, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return nil
+	}
+	return spill
+}
+
+// joinSpill appends the spilled remainder to the payload of a frame whose
+// metadata records a spill, removing the entry; other payloads are returned
+// unchanged
+// Returns ErrSpillMissing if the spilled bytes aren't there or don't match
+func joinSpill(header *framing.Header, payload []byte, opts *ExtractOptions) ([]byte, error) {
+	value, ok := header.Metadata[spillKey]
+	if !ok {
+		return payload, nil
+	}
+	delete(header.Metadata, spillKey)
+
+	var length, crc uint32
+	if _, err := fmt.Sscanf(value, "%08x%08x", &length, &crc); err != nil {
+		return nil, fmt.Errorf("%w: malformed spill entry %q", ErrFrameCorrupt, value)
+	}
+	if uint32(len(opts.spill)) != length || crc32.ChecksumIEEE(opts.spill) != crc {
+		return nil, ErrSpillMissing
+	}
+	return append(payload[:len(payload):len(payload)], opts.spill...), nil
+}
@@ -0,0 +1,93 @@
+package emganography
+
+import "math/rand/v2"
+
+// spreadSalt is the PBKDF2 salt for the spread-spectrum pattern key, keeping
+// it distinct from the block order key derived from the same Seed
+var spreadSalt = []byte("emganography spread spectrum")
+
+// spreadChip is one coefficient of a spread-spectrum pattern and its sign
+type spreadChip struct {
+	idx  int
+	sign float64
+}
+
+// spreadCoeffs returns the mid-frequency coefficients of an n x n block
+// spread-spectrum embedding modulates: those with row+col between 3n/8 and
+// 5n/8, in raster order (15 coefficients for 8x8 blocks)
+func spreadCoeffs(n int) []int {
+	lo, hi := 3*n/8, 5*n/8
+	var coeffs []int
+	for row := 0; row < n; row++ {
+		for col := 0; col < n; col++ {
+			if sum := row + col; sum >= lo && sum <= hi {
+				coeffs = append(coeffs, row*n+col)
+			}
+		}
+	}
+	return coeffs
+}
+
+// spreadPattern returns the pseudo-random sign pattern over spreadCoeffs used
+// in ModeSpreadSpectrum, drawn from a ChaCha8 stream keyed from Seed (via
+// PBKDF2, as for the block order) or from a fixed key without one
+// Returns nil in ModeComparison
+func (c DCTConfig) spreadPattern() ([]spreadChip, error) {
+	if c.Mode != ModeSpreadSpectrum {
+		return nil, nil
+	}
+
+	var key [32]byte
+	if c.Seed != "" {
+		var err error
+		key, err = seedKey(c.Seed, spreadSalt)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		copy(key[:], spreadSalt)
+	}
+
+	src := rand.NewChaCha8(key)
+	coeffs := spreadCoeffs(c.blockSize())
+	pattern := make([]spreadChip, len(coeffs))
+	for i, idx := range coeffs {
+		pattern[i] = spreadChip{idx: idx, sign: 1}
+		if src.Uint64()&1 == 0 {
+			pattern[i].sign = -1
+		}
+	}
+	return pattern, nil
+}
+
+// embedSpread encodes bit in dctBlock by setting its mean correlation with
+// pattern to +gap/2 for a 1 or -gap/2 for a 0: the block's own correlation
+// is removed along the pattern, so the host content doesn't bias the result
+func embedSpread(dctBlock []float64, pattern []spreadChip, gap float64, bit bool) {
+	target := gap / 2
+	if !bit {
+		target = -target
+	}
+	shift := target - spreadCorrelation(dctBlock, pattern)
+	for _, chip := range pattern {
+		dctBlock[chip.idx] += shift * chip.sign
+	}
+}
+
+// spreadSoftBit returns the soft bit of a spread-spectrum block: twice its
+// mean correlation with pattern, i.e. ±gap as embedded, matching the units of
+// softBit's coefficient gap. Noise is averaged over every chip, so its effect
+// shrinks with the square root of the pattern length
+func spreadSoftBit(dctBlock []float64, pattern []spreadChip) float64 {
+	return 2 * spreadCorrelation(dctBlock, pattern)
+}
+
+// spreadCorrelation returns the mean of dctBlock's coefficients weighted by
+// the pattern's signs
+func spreadCorrelation(dctBlock []float64, pattern []spreadChip) float64 {
+	var sum float64
+	for _, chip := range pattern {
+		sum += dctBlock[chip.idx] * chip.sign
+	}
+	return sum / float64(len(pattern))
+}
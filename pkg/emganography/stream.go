@@ -0,0 +1,84 @@
+package emganography
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/tuomas-lb/emganography/internal/imgutil"
+)
+
+// EmbedMessageDCTStream embeds a message into an image read from r and writes
+// the encoded result to w
+// The image is still buffered in memory, as decoding needs the whole image
+func EmbedMessageDCTStream(r io.Reader, w io.Writer, message []byte, opts *EmbedOptions) error {
+	inputData, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read input: %w", err)
+	}
+
+	outputData, err := EmbedMessageDCT(inputData, message, opts)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(outputData); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// ExtractMessageDCTStream extracts a message from an image read from r
+func ExtractMessageDCTStream(r io.Reader) ([]byte, error) {
+	return ExtractMessageDCTStreamWithOptions(r, nil)
+}
+
+// ExtractMessageDCTStreamWithOptions extracts a message from an image read
+// from r using the given options (nil means DefaultExtractOptions)
+func ExtractMessageDCTStreamWithOptions(r io.Reader, opts *ExtractOptions) ([]byte, error) {
+	inputData, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+
+	return ExtractMessageDCTWithOptions(inputData, opts)
+}
+
+// ExtractMessageDCTFromReader extracts a message from an image decoded
+// straight from r, without reading the encoded file into memory first
+// See ExtractMessageDCTFromReaderWithOptions for the memory behavior
+func ExtractMessageDCTFromReader(r io.Reader) ([]byte, error) {
+	return ExtractMessageDCTFromReaderWithOptions(r, nil)
+}
+
+// ExtractMessageDCTFromReaderWithOptions is ExtractMessageDCTFromReader with
+// extraction options (nil means DefaultExtractOptions)
+// The encoded bytes are never held in full (except for TIFF read from
+// something other than an io.ReaderAt, which the TIFF decoder buffers; pass an
+// *os.File to avoid that), but the decoded image is, as are the float64
+// Y, Cb and Cr planes built from it: 24 bytes per pixel on top of the image
+// itself. With UseAllBlocks, only the blocks covering the preamble, header
+// and payload have their DCT computed
+func ExtractMessageDCTFromReaderWithOptions(r io.Reader, opts *ExtractOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	if err := opts.Config.validateCoeffs(); err != nil {
+		return nil, err
+	}
+
+	img, _, err := imgutil.DecodeImage(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load image: %w", err)
+	}
+	ctx := context.Background()
+	planes, capacityBits, err := imagePlanes(ctx, img, opts)
+	if err != nil {
+		return nil, err
+	}
+	result, err := extractFromPlanes(ctx, planes, capacityBits, opts)
+	if err != nil {
+		return nil, err
+	}
+	return result.Message, nil
+}
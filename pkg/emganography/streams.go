@@ -0,0 +1,117 @@
+package emganography
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+)
+
+var (
+	// ErrStreamMismatch indicates the frame found in a stream's region
+	// belongs to a different stream
+	ErrStreamMismatch = errors.New("frame belongs to a different stream")
+	// ErrOverlappingStreams indicates two streams share an ID or blocks
+	ErrOverlappingStreams = errors.New("streams overlap")
+)
+
+// Stream identifies one of several messages embedded in disjoint regions of
+// one image (e.g. one per recipient)
+type Stream struct {
+	// ID is recorded in the frame header, so extracting a stream from
+	// another stream's region fails instead of returning its message
+	ID uint8
+	// Region is the part of the image carrying the stream, snapped inwards
+	// to the block grid (see DCTConfig.Region)
+	Region image.Rectangle
+}
+
+// StreamMessage is a message to embed as a stream
+type StreamMessage struct {
+	Stream
+	Message []byte
+}
+
+// EmbedStreams embeds several messages into one image, each as its own frame
+// in the blocks of its stream's region; opts.Config.Region is ignored
+// Returns ErrOverlappingStreams if two streams share an ID or any block
+func EmbedStreams(input []byte, messages []StreamMessage, opts *EmbedOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultEmbedOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	e, err := newEmbedding(ctx, input, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	n := opts.Config.blockSize()
+	blocksAcross, blocksDown := e.y.Width/n, e.y.Height/n
+	used := make([]image.Rectangle, len(messages))
+	for i, m := range messages {
+		config := m.config(opts.Config)
+		used[i] = config.blockRect(blocksAcross, blocksDown)
+		for j := range i {
+			if messages[j].ID == m.ID {
+				return nil, fmt.Errorf("%w: ID %d used twice", ErrOverlappingStreams, m.ID)
+			}
+			if used[j].Overlaps(used[i]) {
+				return nil, fmt.Errorf("%w: streams %d and %d share blocks", ErrOverlappingStreams, messages[j].ID, m.ID)
+			}
+		}
+
+		if _, _, err := e.embedFrame(ctx, m.Message, m.ID, config); err != nil {
+			return nil, fmt.Errorf("stream %d: %w", m.ID, err)
+		}
+	}
+
+	output, err := e.encode()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Verify {
+		extractOpts := &ExtractOptions{Config: opts.Config, Parallelism: opts.Parallelism, Password: opts.Password, HMACKey: opts.HMACKey}
+		for _, m := range messages {
+			extracted, err := ExtractStream(output, m.Stream, extractOpts)
+			if err != nil {
+				return nil, fmt.Errorf("%w: stream %d: %w", ErrVerificationFailed, m.ID, err)
+			}
+			if !bytes.Equal(extracted, m.Message) {
+				return nil, fmt.Errorf("%w: stream %d", ErrVerificationFailed, m.ID)
+			}
+		}
+	}
+	return output, nil
+}
+
+// ExtractStream extracts the message embedded as the given stream, reading
+// only the blocks of its region; opts.Config.Region is ignored
+// Returns ErrStreamMismatch if the region holds a different stream's frame
+func ExtractStream(input []byte, stream Stream, opts *ExtractOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	streamOpts := *opts
+	streamOpts.Config = stream.config(opts.Config)
+
+	result, err := extractMessageDCT(context.Background(), input, &streamOpts)
+	if err != nil {
+		return nil, err
+	}
+	if result.Stream != stream.ID {
+		return nil, fmt.Errorf("%w: expected stream %d, found %d", ErrStreamMismatch, stream.ID, result.Stream)
+	}
+	return result.Message, nil
+}
+
+// config returns base with its Region set to the stream's
+func (s Stream) config(base DCTConfig) DCTConfig {
+	region := s.Region
+	base.Region = &region
+	return base
+}
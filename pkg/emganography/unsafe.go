@@ -0,0 +1,50 @@
+package emganography
+
+import (
+	"context"
+	"fmt"
+)
+
+// ExtractMessageDCTUnsafe extracts a message like ExtractMessageDCT, but
+// returns the payload even when it fails the payload CRC, with crcOK false,
+// for recovering mostly intact messages from degraded images
+// An unverified payload may contain any number of wrong bytes. The frame
+// header must still be valid, and an unverified payload that is encrypted
+// or compressed usually fails to decode, in which case the error is returned
+func ExtractMessageDCTUnsafe(data []byte) (payload []byte, crcOK bool, err error) {
+	return ExtractMessageDCTUnsafeWithOptions(data, nil)
+}
+
+// ExtractMessageDCTUnsafeWithOptions is ExtractMessageDCTUnsafe with
+// extraction options (nil means DefaultExtractOptions)
+func ExtractMessageDCTUnsafeWithOptions(data []byte, opts *ExtractOptions) (payload []byte, crcOK bool, err error) {
+	if opts == nil {
+		opts = DefaultExtractOptions()
+	}
+	ctx := context.Background()
+	planes, capacityBits, err := extractionPlanes(ctx, data, opts)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = findFrame(ctx, planes, opts, func(offset int, scheme ECCScheme) error {
+		frameBytes, err := extractFrameBytesDCT(ctx, planes, offset, capacityBits, scheme, opts)
+		if err != nil {
+			return err
+		}
+		header, raw, ok, err := opts.parser().ParseFrameUnverified(frameBytes)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrFrameCorrupt, err)
+		}
+		result, err := extractResult(header, raw, opts)
+		if err != nil {
+			return err
+		}
+		payload, crcOK = result.Message, ok
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return payload, crcOK, nil
+}